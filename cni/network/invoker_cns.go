@@ -23,6 +23,9 @@ const (
 type CNSIPAMInvoker struct {
 	podName              string
 	podNamespace         string
+	podUID               string
+	containerID          string
+	interfaceName        string
 	primaryInterfaceName string
 	cnsClient            *cnsclient.CNSClient
 }
@@ -37,17 +40,32 @@ type IPv4ResultInfo struct {
 	hostGateway        string
 }
 
-func NewCNSInvoker(podName, namespace string) (*CNSIPAMInvoker, error) {
+// NewCNSInvoker creates the invoker of the CNS IPAM for the pod, CNS allocates its IP to the pod UID,
+// container ID and interface name, so a recreated pod or sandbox doesn't get or release the IP of the old one.
+func NewCNSInvoker(podName, namespace, podUID, containerID, interfaceName string) (*CNSIPAMInvoker, error) {
 	cnsURL := "http://localhost:" + strconv.Itoa(cnsPort)
 	cnsClient, err := cnsclient.InitCnsClient(cnsURL)
 
 	return &CNSIPAMInvoker{
-		podName:      podName,
-		podNamespace: namespace,
-		cnsClient:    cnsClient,
+		podName:       podName,
+		podNamespace:  namespace,
+		podUID:        podUID,
+		containerID:   containerID,
+		interfaceName: interfaceName,
+		cnsClient:     cnsClient,
 	}, err
 }
 
+// getIPConfigRequest returns the request to CNS for the IP of the pod.
+func (invoker *CNSIPAMInvoker) getIPConfigRequest(orchestratorContext []byte) *cns.IPConfigRequest {
+	return &cns.IPConfigRequest{
+		OrchestratorContext: orchestratorContext,
+		PodUID:              invoker.podUID,
+		ContainerID:         invoker.containerID,
+		InterfaceName:       invoker.interfaceName,
+	}
+}
+
 //Add uses the requestipconfig API in cns, and returns ipv4 and a nil ipv6 as CNS doesn't support IPv6 yet
 func (invoker *CNSIPAMInvoker) Add(nwCfg *cni.NetworkConfig, hostSubnetPrefix *net.IPNet, options map[string]interface{}) (*cniTypesCurr.Result, *cniTypesCurr.Result, error) {
 
@@ -56,7 +74,7 @@ func (invoker *CNSIPAMInvoker) Add(nwCfg *cni.NetworkConfig, hostSubnetPrefix *n
 	orchestratorContext, err := json.Marshal(podInfo)
 
 	log.Printf("Requesting IP for pod %v", podInfo)
-	response, err := invoker.cnsClient.RequestIPAddress(orchestratorContext)
+	response, err := invoker.cnsClient.RequestIPAddress(invoker.getIPConfigRequest(orchestratorContext))
 	if err != nil {
 		log.Printf("Failed to get IP address from CNS with error %v, response: %v", err, response)
 		return nil, nil, err
@@ -176,5 +194,5 @@ func (invoker *CNSIPAMInvoker) Delete(address *net.IPNet, nwCfg *cni.NetworkConf
 		return err
 	}
 
-	return invoker.cnsClient.ReleaseIPAddress(orchestratorContext)
+	return invoker.cnsClient.ReleaseIPAddress(invoker.getIPConfigRequest(orchestratorContext))
 }
@@ -195,6 +195,16 @@ func (plugin *netPlugin) getPodInfo(args string) (string, string, error) {
 	return k8sPodName, k8sNamespace, nil
 }
 
+// getPodUID returns the POD UID by parsing the CNI args, empty if the container runtime doesn't pass it.
+func getPodUID(args string) string {
+	podCfg, err := cni.ParseCniArgs(args)
+	if err != nil {
+		return ""
+	}
+
+	return string(podCfg.K8S_POD_UID)
+}
+
 func SetCustomDimensions(cniMetric *telemetry.AIMetric, nwCfg *cni.NetworkConfig, err error) {
 	if cniMetric == nil {
 		log.Errorf("[CNI] Unable to set custom dimension. Report is nil")
@@ -420,7 +430,7 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 
 	switch nwCfg.Ipam.Type {
 	case network.AzureCNS:
-		plugin.ipamInvoker, err = NewCNSInvoker(k8sPodName, k8sNamespace)
+		plugin.ipamInvoker, err = NewCNSInvoker(k8sPodName, k8sNamespace, getPodUID(args.Args), args.ContainerID, args.IfName)
 		if err != nil {
 			log.Printf("[cni-net] Creating network %v, failed with err %v", networkId, err)
 			return err
@@ -799,7 +809,7 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 
 	switch nwCfg.Ipam.Type {
 	case network.AzureCNS:
-		plugin.ipamInvoker, err = NewCNSInvoker(k8sPodName, k8sNamespace)
+		plugin.ipamInvoker, err = NewCNSInvoker(k8sPodName, k8sNamespace, getPodUID(args.Args), args.ContainerID, args.IfName)
 		if err != nil {
 			log.Printf("[cni-net] Creating network %v failed with err %v.", networkId, err)
 			return err
@@ -111,7 +111,7 @@ type KubernetesPodInfo struct {
 }
 
 // GetOrchestratorContext will return the orchestratorcontext as a string
-// TODO - should use a hashed name or can this be PODUid?
+// The allocations of requests carrying a PodUID or ContainerID are keyed on those instead, see IPConfigRequest.
 func (podinfo *KubernetesPodInfo) GetOrchestratorContextKey() string {
 	return podinfo.PodName + ":" + podinfo.PodNamespace
 }
@@ -214,14 +214,21 @@ type HostIPInfo struct {
 	Subnet    string
 }
 
+// IPConfigRequest is used in CNS IPAM mode to request or release the IPConfig of a pod.
+// PodUID, ContainerID and InterfaceName are optional, older CNI clients don't set them. When set, a request
+// for a recreated pod or sandbox doesn't get the IP of the old one, and a release for the old one doesn't
+// free the IP of the new one.
 type IPConfigRequest struct {
 	DesiredIPAddress    string
 	OrchestratorContext json.RawMessage
+	PodUID              string
+	ContainerID         string
+	InterfaceName       string
 }
 
 func (i IPConfigRequest) String() string {
-	return fmt.Sprintf("[IPConfigRequest: DesiredIPAddress %s, OrchestratorContext %s]",
-		i.DesiredIPAddress, string(i.OrchestratorContext))
+	return fmt.Sprintf("[IPConfigRequest: DesiredIPAddress %s, OrchestratorContext %s, PodUID %s, ContainerID %s, InterfaceName %s]",
+		i.DesiredIPAddress, string(i.OrchestratorContext), i.PodUID, i.ContainerID, i.InterfaceName)
 }
 
 // IPConfigResponse is used in CNS IPAM mode as a response to CNI ADD
@@ -29,6 +29,7 @@ const (
 	CreateHostNCApipaEndpointPath = "/network/createhostncapipaendpoint"
 	DeleteHostNCApipaEndpointPath = "/network/deletehostncapipaendpoint"
 	NmAgentSupportedApisPath      = "/network/nmagentsupportedapis"
+	MetricsPath                   = "/metrics"
 	V1Prefix                      = "/v0.1"
 	V2Prefix                      = "/v0.2"
 )
@@ -49,17 +50,22 @@ type HTTPService interface {
 
 // This is used for KubernetesCRD orchestrator Type where NC has multiple ips.
 // This struct captures the state for SecondaryIPs associated to a given NC
+// PodUID, ContainerID and InterfaceName are those of the IP config request which got the IP allocated,
+// empty if the IP is not allocated or the request didn't carry them.
 type IPConfigurationStatus struct {
 	NCID                string
 	ID                  string //uuid
 	IPAddress           string
 	State               string
 	OrchestratorContext json.RawMessage
+	PodUID              string
+	ContainerID         string
+	InterfaceName       string
 }
 
 func (i IPConfigurationStatus) String() string {
-	return fmt.Sprintf("IPConfigurationStatus: Id: [%s], NcId: [%s], IpAddress: [%s], State: [%s], OrchestratorContext: [%s]",
-		i.ID, i.NCID, i.IPAddress, i.State, string(i.OrchestratorContext))
+	return fmt.Sprintf("IPConfigurationStatus: Id: [%s], NcId: [%s], IpAddress: [%s], State: [%s], OrchestratorContext: [%s], PodUID: [%s], ContainerID: [%s], InterfaceName: [%s]",
+		i.ID, i.NCID, i.IPAddress, i.State, string(i.OrchestratorContext), i.PodUID, i.ContainerID, i.InterfaceName)
 }
 
 // SetEnvironmentRequest describes the Request to set the environment in CNS.
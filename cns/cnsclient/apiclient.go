@@ -7,6 +7,8 @@ import (
 
 // APIClient interface to update cns state
 type APIClient interface {
-	ReconcileNCState(nc *cns.CreateNetworkContainerRequest, pods map[string]cns.KubernetesPodInfo, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error
+	ReconcileNCState(ncs []cns.CreateNetworkContainerRequest, pods map[string]cns.KubernetesPodInfo, livePods map[string]string, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error
 	CreateOrUpdateNC(nc cns.CreateNetworkContainerRequest, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error
+	GetNCIDs() []string
+	DeleteNC(ncID string) error
 }
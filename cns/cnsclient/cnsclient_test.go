@@ -191,14 +191,21 @@ func TestCNSClientRequestAndRelease(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	ipconfig := &cns.IPConfigRequest{
+		OrchestratorContext: orchestratorContext,
+		PodUID:              "a7a18b1c-1e2f-4a3b-9c4d-5e6f7a8b9c0d",
+		ContainerID:         "container1",
+		InterfaceName:       "eth0",
+	}
+
 	// no IP reservation found with that context, expect no failure.
-	err = cnsClient.ReleaseIPAddress(orchestratorContext)
+	err = cnsClient.ReleaseIPAddress(ipconfig)
 	if err != nil {
 		t.Fatalf("Release ip idempotent call failed: %+v", err)
 	}
 
 	// request IP address
-	resp, err := cnsClient.RequestIPAddress(orchestratorContext)
+	resp, err := cnsClient.RequestIPAddress(ipconfig)
 	if err != nil {
 		t.Fatalf("get IP from CNS failed with %+v", err)
 	}
@@ -228,7 +235,7 @@ func TestCNSClientRequestAndRelease(t *testing.T) {
 	}
 
 	// release requested IP address, expect success
-	err = cnsClient.ReleaseIPAddress(orchestratorContext)
+	err = cnsClient.ReleaseIPAddress(ipconfig)
 	if err != nil {
 		t.Fatalf("Expected to not fail when releasing IP reservation found with context: %+v", err)
 	}
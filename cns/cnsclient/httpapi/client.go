@@ -24,12 +24,28 @@ func (client *Client) CreateOrUpdateNC(ncRequest cns.CreateNetworkContainerReque
 	return nil
 }
 
-// ReconcileNCState initializes cns state
-func (client *Client) ReconcileNCState(ncRequest *cns.CreateNetworkContainerRequest, podInfoByIP map[string]cns.KubernetesPodInfo, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error {
-	returnCode := client.RestService.ReconcileNCState(ncRequest, podInfoByIP, scalar, spec)
+// GetNCIDs returns the ids of the ncs in cns state
+func (client *Client) GetNCIDs() []string {
+	return client.RestService.GetNetworkContainerIDs()
+}
+
+// DeleteNC deletes an nc and its ips from cns state
+func (client *Client) DeleteNC(ncID string) error {
+	returnCode := client.RestService.DeleteNetworkContainerInternal(ncID)
+
+	if returnCode != 0 {
+		return fmt.Errorf("Failed to Delete NC %s, errorCode: %d", ncID, returnCode)
+	}
+
+	return nil
+}
+
+// ReconcileNCState initializes cns state with the ncs of the node
+func (client *Client) ReconcileNCState(ncRequests []cns.CreateNetworkContainerRequest, podInfoByIP map[string]cns.KubernetesPodInfo, livePods map[string]string, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error {
+	returnCode := client.RestService.ReconcileNCState(ncRequests, podInfoByIP, livePods, scalar, spec)
 
 	if returnCode != 0 {
-		return fmt.Errorf("Failed to Reconcile ncState: ncRequest %+v, podInfoMap: %+v, errorCode: %d", *ncRequest, podInfoByIP, returnCode)
+		return fmt.Errorf("Failed to Reconcile ncState: ncRequests %+v, podInfoMap: %+v, errorCode: %d", ncRequests, podInfoByIP, returnCode)
 	}
 
 	return nil
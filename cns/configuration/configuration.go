@@ -20,6 +20,7 @@ const (
 type CNSConfig struct {
 	TelemetrySettings           TelemetrySettings
 	ManagedSettings             ManagedSettings
+	PredictiveScalingSettings   PredictiveScalingSettings
 	ChannelMode                 string
 	UseHTTPS                    bool
 	TLSSubjectName              string
@@ -63,6 +64,20 @@ type ManagedSettings struct {
 	NodeSyncIntervalInSeconds int
 }
 
+// PredictiveScalingSettings configure the optional predictive scaling of the IPAM pool in CRD mode
+type PredictiveScalingSettings struct {
+	// Flag to enable the predictive scaling.
+	Enable bool
+	// Window over which the IP allocation rate is measured.
+	AllocationRateWindowInSecs int
+	// How far ahead the pool is sized for the allocation rate, about the time an NNC update takes.
+	ProvisioningHorizonInSecs int
+	// Time after a scale up during which the pool is not scaled down.
+	ScaleDownCooldownInSecs int
+	// Maximum number of batches requested in one NNC update.
+	MaxBatchesPerUpdate int
+}
+
 // This functions reads cns config file and save it in a structure
 func ReadConfig() (CNSConfig, error) {
 	var cnsConfig CNSConfig
@@ -125,10 +140,30 @@ func setManagedSettingDefaults(managedSettings *ManagedSettings) {
 	}
 }
 
+// set predictive scaling setting defaults
+func setPredictiveScalingSettingDefaults(predictiveScalingSettings *PredictiveScalingSettings) {
+	if predictiveScalingSettings.AllocationRateWindowInSecs == 0 {
+		predictiveScalingSettings.AllocationRateWindowInSecs = 60
+	}
+
+	if predictiveScalingSettings.ProvisioningHorizonInSecs == 0 {
+		predictiveScalingSettings.ProvisioningHorizonInSecs = 30
+	}
+
+	if predictiveScalingSettings.ScaleDownCooldownInSecs == 0 {
+		predictiveScalingSettings.ScaleDownCooldownInSecs = 120
+	}
+
+	if predictiveScalingSettings.MaxBatchesPerUpdate == 0 {
+		predictiveScalingSettings.MaxBatchesPerUpdate = 5
+	}
+}
+
 // SetCNSConfigDefaults set default values of CNS config if not specified
 func SetCNSConfigDefaults(config *CNSConfig) {
 	setTelemetrySettingDefaults(&config.TelemetrySettings)
 	setManagedSettingDefaults(&config.ManagedSettings)
+	setPredictiveScalingSettingDefaults(&config.PredictiveScalingSettings)
 	if config.ChannelMode == "" {
 		config.ChannelMode = cns.Direct
 	}
@@ -0,0 +1,16 @@
+package fakes
+
+// PendingPodCounterFake reports a set number of pending pods
+type PendingPodCounterFake struct {
+	PendingPods int
+}
+
+func NewPendingPodCounterFake(pendingPods int) *PendingPodCounterFake {
+	return &PendingPodCounterFake{
+		PendingPods: pendingPods,
+	}
+}
+
+func (counter *PendingPodCounterFake) PendingPodCount() int {
+	return counter.PendingPods
+}
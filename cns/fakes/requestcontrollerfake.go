@@ -57,7 +57,7 @@ func (rc *RequestControllerFake) CarveIPConfigsAndAddToStatusAndCNS(numberOfIPCo
 	}
 
 	rc.fakecns.IPStateManager.AddIPConfigs(cnsIPConfigs)
-	rc.cachedCRD.Spec.RequestedIPCount = int64(len(cnsIPConfigs))
+	rc.cachedCRD.Spec.RequestedIPCount = int64(len(rc.cachedCRD.Status.NetworkContainers[0].IPAssignments))
 
 	return cnsIPConfigs
 }
@@ -3,11 +3,14 @@ package ipampoolmonitor
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/metrics"
 	"github.com/Azure/azure-container-networking/cns/requestcontroller"
 	nnc "github.com/Azure/azure-container-networking/nodenetworkconfig/api/v1alpha"
 )
@@ -24,6 +27,9 @@ type CNSIPAMPoolMonitor struct {
 	MinimumFreeIps int64
 	MaximumFreeIps int64
 
+	// predictiveScaler is optional, without it the pool grows and shrinks by one batch at a time
+	predictiveScaler *PredictiveScaler
+
 	mu sync.RWMutex
 }
 
@@ -36,6 +42,16 @@ func NewCNSIPAMPoolMonitor(httpService cns.HTTPService, rc requestcontroller.Req
 	}
 }
 
+// EnablePredictiveScaling makes the pool monitor size the pool for the demand the scaler expects
+func (pm *CNSIPAMPoolMonitor) EnablePredictiveScaling(scaler *PredictiveScaler) {
+	defer pm.mu.Unlock()
+	pm.mu.Lock()
+
+	logger.Printf("[ipam-pool-monitor] Enabling predictive scaling, window %v, horizon %v, cooldown %v, max batches %d",
+		scaler.window, scaler.horizon, scaler.cooldown, scaler.maxBatches)
+	pm.predictiveScaler = scaler
+}
+
 func stopReconcile(ch <-chan struct{}) bool {
 	select {
 	case <-ch:
@@ -64,6 +80,10 @@ func (pm *CNSIPAMPoolMonitor) Start(ctx context.Context, poolMonitorRefreshMilli
 	}
 }
 
+// Reconcile scales the pool of the node. The thresholds apply to the free IPs of the node, not of each NC: the CRD
+// spec has a single RequestedIPCount for the node and DNC picks the NC which serves a batch, so an NC running out of
+// IPs can't be scaled on its own. CNS keeps the pools of the NCs even instead, it allocates from the NC with the most
+// Available IPs and releases from the NC with the most releasable IPs.
 func (pm *CNSIPAMPoolMonitor) Reconcile() error {
 	cnsPodIPConfigCount := len(pm.httpService.GetPodIPConfigState())
 	pendingProgramCount := len(pm.httpService.GetPendingProgramIPConfigs()) // TODO: add pending program count to real cns
@@ -72,17 +92,26 @@ func (pm *CNSIPAMPoolMonitor) Reconcile() error {
 	availableIPConfigCount := len(pm.httpService.GetAvailableIPConfigs()) // TODO: add pending allocation count to real cns
 	freeIPConfigCount := pm.cachedNNC.Spec.RequestedIPCount - int64(allocatedPodIPCount)
 
-	msg := fmt.Sprintf("[ipam-pool-monitor] Pool Size: %v, Goal Size: %v, BatchSize: %v, MinFree: %v, MaxFree:%v, Allocated: %v, Available: %v, Pending Release: %v, Free: %v, Pending Program: %v",
-		cnsPodIPConfigCount, pm.cachedNNC.Spec.RequestedIPCount, pm.scalarUnits.BatchSize, pm.MinimumFreeIps, pm.MaximumFreeIps, allocatedPodIPCount, availableIPConfigCount, pendingReleaseIPCount, freeIPConfigCount, pendingProgramCount)
+	// the IPs the predictive scaler expects to be allocated soon, none without it
+	var expectedDemand int64
+	pm.mu.RLock()
+	if pm.predictiveScaler != nil {
+		pm.predictiveScaler.observe(allocatedPodIPCount)
+		expectedDemand = pm.predictiveScaler.expectedDemand()
+	}
+	pm.mu.RUnlock()
+
+	msg := fmt.Sprintf("[ipam-pool-monitor] Pool Size: %v, Goal Size: %v, BatchSize: %v, MinFree: %v, MaxFree:%v, Allocated: %v, Available: %v, Pending Release: %v, Free: %v, Pending Program: %v, Expected Demand: %v, NC Pools: [%s]",
+		cnsPodIPConfigCount, pm.cachedNNC.Spec.RequestedIPCount, pm.scalarUnits.BatchSize, pm.MinimumFreeIps, pm.MaximumFreeIps, allocatedPodIPCount, availableIPConfigCount, pendingReleaseIPCount, freeIPConfigCount, pendingProgramCount, expectedDemand, ncPoolSummary(pm.httpService.GetPodIPConfigState()))
 
 	switch {
 	// pod count is increasing
-	case freeIPConfigCount < pm.MinimumFreeIps:
+	case freeIPConfigCount < pm.MinimumFreeIps+expectedDemand:
 		logger.Printf("[ipam-pool-monitor] Increasing pool size...%s ", msg)
-		return pm.increasePoolSize()
+		return pm.increasePoolSize(pm.batchesToRequest(freeIPConfigCount, expectedDemand))
 
 	// pod count is decreasing
-	case freeIPConfigCount > pm.MaximumFreeIps:
+	case freeIPConfigCount > pm.MaximumFreeIps && pm.allowsScaleDown(freeIPConfigCount, expectedDemand):
 		logger.Printf("[ipam-pool-monitor] Decreasing pool size...%s ", msg)
 		return pm.decreasePoolSize(pendingReleaseIPCount)
 
@@ -101,7 +130,60 @@ func (pm *CNSIPAMPoolMonitor) Reconcile() error {
 	return nil
 }
 
-func (pm *CNSIPAMPoolMonitor) increasePoolSize() error {
+// ncPoolSummary describes the pool of each NC of the node, with the IPs free for the pods to come: the Available and
+// the Pending Program ones. The free IPs of an NC are only logged, they are not checked against thresholds of the NC,
+// see Reconcile.
+func ncPoolSummary(ipConfigs map[string]cns.IPConfigurationStatus) string {
+	stateCountsByNC := make(map[string]map[string]int)
+	for _, ipConfig := range ipConfigs {
+		if _, exists := stateCountsByNC[ipConfig.NCID]; !exists {
+			stateCountsByNC[ipConfig.NCID] = make(map[string]int)
+		}
+		stateCountsByNC[ipConfig.NCID][ipConfig.State]++
+	}
+
+	ncIDs := make([]string, 0, len(stateCountsByNC))
+	for ncID := range stateCountsByNC {
+		ncIDs = append(ncIDs, ncID)
+	}
+	sort.Strings(ncIDs)
+
+	summaries := make([]string, 0, len(ncIDs))
+	for _, ncID := range ncIDs {
+		stateCounts := stateCountsByNC[ncID]
+		summaries = append(summaries, fmt.Sprintf("NC: %s, Allocated: %v, Available: %v, Pending Release: %v, Pending Program: %v, Free: %v",
+			ncID, stateCounts[cns.Allocated], stateCounts[cns.Available], stateCounts[cns.PendingRelease], stateCounts[cns.PendingProgramming],
+			stateCounts[cns.Available]+stateCounts[cns.PendingProgramming]))
+	}
+
+	return strings.Join(summaries, "; ")
+}
+
+// batchesToRequest returns the number of batches to request in one NNC update, one without the predictive scaler
+func (pm *CNSIPAMPoolMonitor) batchesToRequest(freeIPConfigCount, expectedDemand int64) int64 {
+	defer pm.mu.RUnlock()
+	pm.mu.RLock()
+
+	if pm.predictiveScaler == nil {
+		return 1
+	}
+
+	return pm.predictiveScaler.batchesToRequest(freeIPConfigCount, pm.MinimumFreeIps, pm.scalarUnits.BatchSize, expectedDemand)
+}
+
+// allowsScaleDown returns true if the predictive scaler, if any, lets the pool shrink
+func (pm *CNSIPAMPoolMonitor) allowsScaleDown(freeIPConfigCount, expectedDemand int64) bool {
+	defer pm.mu.RUnlock()
+	pm.mu.RLock()
+
+	if pm.predictiveScaler == nil {
+		return true
+	}
+
+	return pm.predictiveScaler.allowsScaleDown(freeIPConfigCount, pm.MaximumFreeIps, expectedDemand)
+}
+
+func (pm *CNSIPAMPoolMonitor) increasePoolSize(batches int64) error {
 	defer pm.mu.Unlock()
 	pm.mu.Lock()
 
@@ -112,10 +194,10 @@ func (pm *CNSIPAMPoolMonitor) increasePoolSize() error {
 		return err
 	}
 
-	tempNNCSpec.RequestedIPCount += pm.scalarUnits.BatchSize
-	logger.Printf("[ipam-pool-monitor] Increasing pool size, Current Pool Size: %v, Updated Requested IP Count: %v, Pods with IP's:%v, ToBeDeleted Count: %v", len(pm.httpService.GetPodIPConfigState()), tempNNCSpec.RequestedIPCount, len(pm.httpService.GetAllocatedIPConfigs()), len(tempNNCSpec.IPsNotInUse))
+	tempNNCSpec.RequestedIPCount += batches * pm.scalarUnits.BatchSize
+	logger.Printf("[ipam-pool-monitor] Increasing pool size, Current Pool Size: %v, Updated Requested IP Count: %v, Batches: %v, Pods with IP's:%v, ToBeDeleted Count: %v", len(pm.httpService.GetPodIPConfigState()), tempNNCSpec.RequestedIPCount, batches, len(pm.httpService.GetAllocatedIPConfigs()), len(tempNNCSpec.IPsNotInUse))
 
-	err = pm.rc.UpdateCRDSpec(context.Background(), tempNNCSpec)
+	err = pm.updateCRDSpec(tempNNCSpec)
 	if err != nil {
 		// caller will retry to update the CRD again
 		return err
@@ -124,6 +206,9 @@ func (pm *CNSIPAMPoolMonitor) increasePoolSize() error {
 	logger.Printf("[ipam-pool-monitor] Increasing pool size: UpdateCRDSpec succeeded for spec %+v", tempNNCSpec)
 	// save the updated state to cachedSpec
 	pm.cachedNNC.Spec = tempNNCSpec
+	if pm.predictiveScaler != nil {
+		pm.predictiveScaler.scaledUp()
+	}
 	return nil
 }
 
@@ -162,7 +247,7 @@ func (pm *CNSIPAMPoolMonitor) decreasePoolSize(existingPendingReleaseIPCount int
 	tempNNCSpec.RequestedIPCount -= int64(len(pendingIpAddresses))
 	logger.Printf("[ipam-pool-monitor] Decreasing pool size, Current Pool Size: %v, Requested IP Count: %v, Pods with IP's: %v, ToBeDeleted Count: %v", len(pm.httpService.GetPodIPConfigState()), tempNNCSpec.RequestedIPCount, len(pm.httpService.GetAllocatedIPConfigs()), len(tempNNCSpec.IPsNotInUse))
 
-	err = pm.rc.UpdateCRDSpec(context.Background(), tempNNCSpec)
+	err = pm.updateCRDSpec(tempNNCSpec)
 	if err != nil {
 		// caller will retry to update the CRD again
 		return err
@@ -194,7 +279,7 @@ func (pm *CNSIPAMPoolMonitor) cleanPendingRelease() error {
 		return err
 	}
 
-	err = pm.rc.UpdateCRDSpec(context.Background(), tempNNCSpec)
+	err = pm.updateCRDSpec(tempNNCSpec)
 	if err != nil {
 		// caller will retry to update the CRD again
 		return err
@@ -209,6 +294,17 @@ func (pm *CNSIPAMPoolMonitor) cleanPendingRelease() error {
 	return nil
 }
 
+// updateCRDSpec sends the spec to the request controller, it counts the failed updates and publishes the pool state of the others
+func (pm *CNSIPAMPoolMonitor) updateCRDSpec(spec nnc.NodeNetworkConfigSpec) error {
+	if err := pm.rc.UpdateCRDSpec(context.Background(), spec); err != nil {
+		metrics.NNCSpecUpdateErrors.Inc()
+		return err
+	}
+
+	metrics.SetPoolState(spec.RequestedIPCount, pm.scalarUnits.BatchSize, pm.MinimumFreeIps, pm.MaximumFreeIps)
+	return nil
+}
+
 // CNSToCRDSpec translates CNS's map of Ips to be released and requested ip count into a CRD Spec
 func (pm *CNSIPAMPoolMonitor) createNNCSpecForCRD(resetNotInUseList bool) (nnc.NodeNetworkConfigSpec, error) {
 	var (
@@ -246,6 +342,7 @@ func (pm *CNSIPAMPoolMonitor) Update(scalar nnc.Scaler, spec nnc.NodeNetworkConf
 
 	logger.Printf("[ipam-pool-monitor] Update spec %+v, pm.MinimumFreeIps %d, pm.MaximumFreeIps %d",
 		pm.cachedNNC.Spec, pm.MinimumFreeIps, pm.MaximumFreeIps)
+	metrics.SetPoolState(pm.cachedNNC.Spec.RequestedIPCount, pm.scalarUnits.BatchSize, pm.MinimumFreeIps, pm.MaximumFreeIps)
 
 	return nil
 }
@@ -4,9 +4,13 @@ import (
 	"log"
 	"testing"
 
+	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/fakes"
 	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/metrics"
 	nnc "github.com/Azure/azure-container-networking/nodenetworkconfig/api/v1alpha"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func initFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent int) (*fakes.HTTPServiceFake, *fakes.RequestControllerFake, *CNSIPAMPoolMonitor) {
@@ -81,9 +85,27 @@ func TestPoolSizeIncrease(t *testing.T) {
 		t.Fatalf("CNS Pod IPConfig state count doesn't match, expected: %v, actual %v", len(fakecns.GetPodIPConfigState()), initialIPConfigCount+(1*batchSize))
 	}
 
+	// the metrics publish the goal size and the thresholds of the pool
+	if goalSize := getGaugeValue(t, metrics.PoolGoalSize); goalSize != float64(initialIPConfigCount+(1*batchSize)) {
+		t.Fatalf("Pool goal size metric doesn't match, expected: %v, actual %v", initialIPConfigCount+(1*batchSize), goalSize)
+	}
+
+	if minFree := getGaugeValue(t, metrics.PoolMinFreeIPs); minFree != float64(poolmonitor.MinimumFreeIps) {
+		t.Fatalf("Pool min free IPs metric doesn't match, expected: %v, actual %v", poolmonitor.MinimumFreeIps, minFree)
+	}
+
 	t.Logf("Pool size %v, Target pool size %v, Allocated IP's %v, ", len(fakecns.GetPodIPConfigState()), poolmonitor.cachedNNC.Spec.RequestedIPCount, len(fakecns.GetAllocatedIPConfigs()))
 }
 
+func getGaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	metric := &dto.Metric{}
+	if err := gauge.Write(metric); err != nil {
+		t.Fatalf("Failed to read gauge: %v", err)
+	}
+
+	return metric.Gauge.GetValue()
+}
+
 func TestPoolIncreaseDoesntChangeWhenIncreaseIsAlreadyInProgress(t *testing.T) {
 	var (
 		batchSize               = 10
@@ -395,3 +417,19 @@ func TestPoolSizeDecreaseToReallyLow(t *testing.T) {
 		t.Fatalf("Expected IP's not in use to be 0 after reconcile, expected %v, actual %v", (initialIPConfigCount - batchSize), len(poolmonitor.cachedNNC.Spec.IPsNotInUse))
 	}
 }
+
+func TestNCPoolSummary(t *testing.T) {
+	ipConfigs := map[string]cns.IPConfigurationStatus{
+		"1": {ID: "1", NCID: "nc2", State: cns.Allocated},
+		"2": {ID: "2", NCID: "nc2", State: cns.Available},
+		"3": {ID: "3", NCID: "nc1", State: cns.PendingRelease},
+		"4": {ID: "4", NCID: "nc2", State: cns.Allocated},
+		"5": {ID: "5", NCID: "nc2", State: cns.PendingProgramming},
+	}
+
+	expected := "NC: nc1, Allocated: 0, Available: 0, Pending Release: 1, Pending Program: 0, Free: 0; " +
+		"NC: nc2, Allocated: 2, Available: 1, Pending Release: 0, Pending Program: 1, Free: 2"
+	if summary := ncPoolSummary(ipConfigs); summary != expected {
+		t.Fatalf("Unexpected NC pool summary, expected: %s, actual: %s", expected, summary)
+	}
+}
@@ -0,0 +1,133 @@
+package ipampoolmonitor
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// PendingPodCounter counts the pods scheduled to the node which don't have an IP yet
+type PendingPodCounter interface {
+	PendingPodCount() int
+}
+
+// PredictiveScaler is an optional scaling policy of the pool monitor. It tracks the IP allocation rate over a
+// recent window and the pods pending on the node, and sizes the pool for the demand expected within the
+// provisioning horizon, so a burst of pods is served by one NNC update of several batches.
+// After a scale up, the pool is not scaled down for the cooldown, and only if the free IPs exceed the maximum
+// with the expected demand set aside, so the pool doesn't flap between the two thresholds.
+type PredictiveScaler struct {
+	pendingPods PendingPodCounter
+	window      time.Duration
+	horizon     time.Duration
+	cooldown    time.Duration
+	maxBatches  int64
+
+	// now is the clock of the scaler, tests replace it
+	now         func() time.Time
+	samples     []allocationSample
+	lastScaleUp time.Time
+
+	mu sync.Mutex
+}
+
+// allocationSample is the allocated IP count at a reconcile of the pool monitor
+type allocationSample struct {
+	time      time.Time
+	allocated int
+}
+
+func NewPredictiveScaler(pendingPods PendingPodCounter, window, horizon, cooldown time.Duration, maxBatches int64) *PredictiveScaler {
+	if maxBatches < 1 {
+		maxBatches = 1
+	}
+
+	return &PredictiveScaler{
+		pendingPods: pendingPods,
+		window:      window,
+		horizon:     horizon,
+		cooldown:    cooldown,
+		maxBatches:  maxBatches,
+		now:         time.Now,
+	}
+}
+
+// observe records the allocated IP count and drops the samples older than the window
+func (ps *PredictiveScaler) observe(allocated int) {
+	defer ps.mu.Unlock()
+	ps.mu.Lock()
+
+	now := ps.now()
+	ps.samples = append(ps.samples, allocationSample{time: now, allocated: allocated})
+
+	i := 0
+	for i < len(ps.samples)-1 && now.Sub(ps.samples[i].time) > ps.window {
+		i++
+	}
+	ps.samples = ps.samples[i:]
+}
+
+// allocationRate returns the IPs allocated per second over the window, released IPs don't lower the demand
+func (ps *PredictiveScaler) allocationRate() float64 {
+	if len(ps.samples) < 2 {
+		return 0
+	}
+
+	first, last := ps.samples[0], ps.samples[len(ps.samples)-1]
+	elapsed := last.time.Sub(first.time).Seconds()
+	if elapsed <= 0 || last.allocated <= first.allocated {
+		return 0
+	}
+
+	return float64(last.allocated-first.allocated) / elapsed
+}
+
+// expectedDemand returns the number of IPs expected to be allocated within the horizon
+func (ps *PredictiveScaler) expectedDemand() int64 {
+	defer ps.mu.Unlock()
+	ps.mu.Lock()
+
+	demand := int64(math.Ceil(ps.allocationRate() * ps.horizon.Seconds()))
+	if ps.pendingPods != nil {
+		demand += int64(ps.pendingPods.PendingPodCount())
+	}
+
+	return demand
+}
+
+// batchesToRequest returns the number of batches which bring the free IPs back above the minimum
+// with the expected demand served, at least one and at most the maximum batches of an update
+func (ps *PredictiveScaler) batchesToRequest(freeIPCount, minFreeIPs, batchSize, expectedDemand int64) int64 {
+	if batchSize <= 0 {
+		return 1
+	}
+
+	missing := minFreeIPs + expectedDemand - freeIPCount
+	batches := (missing + batchSize - 1) / batchSize
+	switch {
+	case batches < 1:
+		return 1
+	case batches > ps.maxBatches:
+		return ps.maxBatches
+	}
+
+	return batches
+}
+
+// scaledUp records a successful scale up, which starts the cooldown
+func (ps *PredictiveScaler) scaledUp() {
+	defer ps.mu.Unlock()
+	ps.mu.Lock()
+
+	ps.lastScaleUp = ps.now()
+}
+
+// allowsScaleDown returns true if the cooldown of the last scale up is over
+// and the free IPs still exceed the maximum once the expected demand is set aside
+func (ps *PredictiveScaler) allowsScaleDown(freeIPCount, maxFreeIPs, expectedDemand int64) bool {
+	ps.mu.Lock()
+	inCooldown := !ps.lastScaleUp.IsZero() && ps.now().Sub(ps.lastScaleUp) < ps.cooldown
+	ps.mu.Unlock()
+
+	return !inCooldown && freeIPCount-expectedDemand > maxFreeIPs
+}
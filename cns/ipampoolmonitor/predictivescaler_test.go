@@ -0,0 +1,204 @@
+package ipampoolmonitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns/fakes"
+)
+
+const (
+	testAllocationRateWindow = time.Minute
+	testProvisioningHorizon  = 30 * time.Second
+	testScaleDownCooldown    = 2 * time.Minute
+	testMaxBatches           = 5
+)
+
+// fakeClock is the clock of the predictive scaler in tests, it only moves when advanced
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+func initPredictiveFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent int) (*fakes.HTTPServiceFake, *fakes.RequestControllerFake, *CNSIPAMPoolMonitor, *fakes.PendingPodCounterFake, *fakeClock) {
+	fakecns, fakerc, poolmonitor := initFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent)
+
+	pendingPods := fakes.NewPendingPodCounterFake(0)
+	clock := &fakeClock{now: time.Now()}
+	scaler := NewPredictiveScaler(pendingPods, testAllocationRateWindow, testProvisioningHorizon, testScaleDownCooldown, testMaxBatches)
+	scaler.now = clock.Now
+	poolmonitor.EnablePredictiveScaling(scaler)
+
+	return fakecns, fakerc, poolmonitor, pendingPods, clock
+}
+
+func TestPredictiveScalingRequestsBatchesForPendingPods(t *testing.T) {
+	var (
+		batchSize               = 10
+		initialIPConfigCount    = 10
+		requestThresholdPercent = 30
+		releaseThresholdPercent = 150
+	)
+
+	fakecns, _, poolmonitor, pendingPods, _ := initPredictiveFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent)
+
+	// 2 free IPs and 20 pods pending on the node
+	if err := fakecns.SetNumberOfAllocatedIPs(8); err != nil {
+		t.Fatalf("Failed to allocate test ipconfigs with err: %v", err)
+	}
+	pendingPods.PendingPods = 20
+
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	// one update requests enough batches for the pending pods and the minimum free IPs
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != int64(initialIPConfigCount+(3*batchSize)) {
+		t.Fatalf("Expected the pool monitor to request 3 batches, expected %v, actual %v", initialIPConfigCount+(3*batchSize), poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+}
+
+func TestPredictiveScalingCapsBatchesPerUpdate(t *testing.T) {
+	var (
+		batchSize               = 10
+		initialIPConfigCount    = 10
+		requestThresholdPercent = 30
+		releaseThresholdPercent = 150
+	)
+
+	fakecns, _, poolmonitor, pendingPods, _ := initPredictiveFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent)
+
+	if err := fakecns.SetNumberOfAllocatedIPs(8); err != nil {
+		t.Fatalf("Failed to allocate test ipconfigs with err: %v", err)
+	}
+	pendingPods.PendingPods = 200
+
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != int64(initialIPConfigCount+(testMaxBatches*batchSize)) {
+		t.Fatalf("Expected the pool monitor to request at most %v batches, expected %v, actual %v", testMaxBatches, initialIPConfigCount+(testMaxBatches*batchSize), poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+}
+
+func TestPredictiveScalingFollowsAllocationRate(t *testing.T) {
+	var (
+		batchSize               = 10
+		initialIPConfigCount    = 30
+		requestThresholdPercent = 30
+		releaseThresholdPercent = 150
+	)
+
+	fakecns, _, poolmonitor, _, clock := initPredictiveFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent)
+
+	// 14 free IPs, within the thresholds
+	if err := fakecns.SetNumberOfAllocatedIPs(16); err != nil {
+		t.Fatalf("Failed to allocate test ipconfigs with err: %v", err)
+	}
+
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != int64(initialIPConfigCount) {
+		t.Fatalf("Expected the pool size to stay the same, expected %v, actual %v", initialIPConfigCount, poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+
+	// 10 IPs allocated in 10 seconds, the horizon of 30 seconds expects 30 more
+	clock.Advance(10 * time.Second)
+	if err := fakecns.SetNumberOfAllocatedIPs(26); err != nil {
+		t.Fatalf("Failed to allocate test ipconfigs with err: %v", err)
+	}
+
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	// 4 free IPs have to serve 30 expected and 3 minimum free IPs, 29 missing
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != int64(initialIPConfigCount+(3*batchSize)) {
+		t.Fatalf("Expected the pool monitor to request 3 batches, expected %v, actual %v", initialIPConfigCount+(3*batchSize), poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+}
+
+func TestPredictiveScalingHysteresis(t *testing.T) {
+	var (
+		batchSize               = 10
+		initialIPConfigCount    = 10
+		requestThresholdPercent = 30
+		releaseThresholdPercent = 150
+	)
+
+	fakecns, fakerc, poolmonitor, pendingPods, clock := initPredictiveFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent)
+
+	if err := fakecns.SetNumberOfAllocatedIPs(8); err != nil {
+		t.Fatalf("Failed to allocate test ipconfigs with err: %v", err)
+	}
+	pendingPods.PendingPods = 20
+
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	scaledUpIPCount := int64(initialIPConfigCount + (3 * batchSize))
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != scaledUpIPCount {
+		t.Fatalf("Expected the pool monitor to request 3 batches, expected %v, actual %v", scaledUpIPCount, poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+
+	if err := fakerc.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile fake requestcontroller with err: %v", err)
+	}
+
+	// the pending pods go away, 32 free IPs are above the maximum but the cooldown holds the pool
+	pendingPods.PendingPods = 0
+	clock.Advance(testScaleDownCooldown / 2)
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != scaledUpIPCount {
+		t.Fatalf("Expected the pool size to stay the same during the cooldown, expected %v, actual %v", scaledUpIPCount, poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+
+	// after the cooldown the pool shrinks by a batch
+	clock.Advance(testScaleDownCooldown)
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != scaledUpIPCount-int64(batchSize) {
+		t.Fatalf("Expected the pool to shrink by a batch after the cooldown, expected %v, actual %v", scaledUpIPCount-int64(batchSize), poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+}
+
+func TestPredictiveScalingHoldsPoolForExpectedDemand(t *testing.T) {
+	var (
+		batchSize               = 10
+		initialIPConfigCount    = 40
+		requestThresholdPercent = 30
+		releaseThresholdPercent = 150
+	)
+
+	fakecns, _, poolmonitor, pendingPods, _ := initPredictiveFakes(batchSize, initialIPConfigCount, requestThresholdPercent, releaseThresholdPercent)
+
+	// 20 free IPs are above the maximum, but 10 pods are pending
+	if err := fakecns.SetNumberOfAllocatedIPs(20); err != nil {
+		t.Fatalf("Failed to allocate test ipconfigs with err: %v", err)
+	}
+	pendingPods.PendingPods = 10
+
+	if err := poolmonitor.Reconcile(); err != nil {
+		t.Fatalf("Failed to reconcile pool monitor with err: %v", err)
+	}
+
+	if poolmonitor.cachedNNC.Spec.RequestedIPCount != int64(initialIPConfigCount) {
+		t.Fatalf("Expected the pool size to stay the same for the pending pods, expected %v, actual %v", initialIPConfigCount, poolmonitor.cachedNNC.Spec.RequestedIPCount)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Microsoft. All rights reserved.
+// MIT License
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ipConfigStates = []string{cns.Available, cns.Allocated, cns.PendingRelease, cns.PendingProgramming}
+
+var (
+	ipConfigCountsLock sync.Mutex
+	// getIPConfigCounts returns the number of secondary IPs by NC and state, nil until SetIPConfigCountsSource.
+	getIPConfigCounts func() map[string]map[string]int
+)
+
+func init() {
+	register(newIPConfigCollector(), ipConfigsName)
+}
+
+// SetIPConfigCountsSource sets where the ipconfigs metric reads the number of secondary IPs of each NC in each state
+// when the metrics are scraped. getCounts must take the locks of the state it counts.
+func SetIPConfigCountsSource(getCounts func() map[string]map[string]int) {
+	ipConfigCountsLock.Lock()
+	defer ipConfigCountsLock.Unlock()
+
+	getIPConfigCounts = getCounts
+}
+
+// SetPoolState records the goal size and the scaling thresholds of the IPAM pool monitor.
+func SetPoolState(goalSize, batchSize, minFreeIPs, maxFreeIPs int64) {
+	PoolGoalSize.Set(float64(goalSize))
+	PoolBatchSize.Set(float64(batchSize))
+	PoolMinFreeIPs.Set(float64(minFreeIPs))
+	PoolMaxFreeIPs.Set(float64(maxFreeIPs))
+}
+
+// ObserveIPConfigRequest records the latency of an IP config request handled since start.
+func ObserveIPConfigRequest(start time.Time, returnCode string) {
+	IPConfigRequestLatency.With(prometheus.Labels{ReturnCodeLabel: returnCode}).Observe(time.Since(start).Seconds())
+}
+
+// ObserveIPConfigRelease records the latency of an IP config release handled since start.
+func ObserveIPConfigRelease(start time.Time, returnCode string) {
+	IPConfigReleaseLatency.With(prometheus.Labels{ReturnCodeLabel: returnCode}).Observe(time.Since(start).Seconds())
+}
+
+// ipConfigCollector reports the secondary IPs of each NC in each state when the metrics are scraped,
+// so the NCs and states which are gone don't linger.
+type ipConfigCollector struct {
+	desc *prometheus.Desc
+}
+
+func newIPConfigCollector() *ipConfigCollector {
+	return &ipConfigCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", ipConfigsName), ipConfigsHelp, []string{NCIDLabel, StateLabel}, nil),
+	}
+}
+
+func (c *ipConfigCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *ipConfigCollector) Collect(ch chan<- prometheus.Metric) {
+	ipConfigCountsLock.Lock()
+	getCounts := getIPConfigCounts
+	ipConfigCountsLock.Unlock()
+
+	if getCounts == nil {
+		return
+	}
+
+	// every NC reports all the states, a state without IPs is 0 rather than missing.
+	for ncID, counts := range getCounts() {
+		for _, state := range ipConfigStates {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(counts[state]), ncID, state)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Microsoft. All rights reserved.
+// MIT License
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cns"
+
+// Prometheus Metrics served on the CNS listener under cns.MetricsPath
+// Gauge metrics have the methods Inc(), Dec(), and Set(float64)
+// Histogram metrics have the method Observe(float64)
+// Counter metrics have the methods Inc() and Add(float64)
+// For any Vector metric, you can call With(prometheus.Labels) before the above methods
+var (
+	// The pool metrics are set by the IPAM pool monitor, see SetPoolState
+	PoolGoalSize   = createGauge(poolGoalSizeName, poolGoalSizeHelp)
+	PoolBatchSize  = createGauge(poolBatchSizeName, poolBatchSizeHelp)
+	PoolMinFreeIPs = createGauge(poolMinFreeIPsName, poolMinFreeIPsHelp)
+	PoolMaxFreeIPs = createGauge(poolMaxFreeIPsName, poolMaxFreeIPsHelp)
+
+	// The handler latencies are observed by ObserveIPConfigRequest and ObserveIPConfigRelease
+	IPConfigRequestLatency = createHistogramVec(ipConfigRequestLatencyName, ipConfigRequestLatencyHelp, ReturnCodeLabel)
+	IPConfigReleaseLatency = createHistogramVec(ipConfigReleaseLatencyName, ipConfigReleaseLatencyHelp, ReturnCodeLabel)
+
+	NNCSpecUpdateErrors = createCounter(nncSpecUpdateErrorsName, nncSpecUpdateErrorsHelp)
+	IPAMStateSaveErrors = createCounter(ipamStateSaveErrorsName, ipamStateSaveErrorsHelp)
+)
+
+// Constants for metric names and descriptions as well as exported labels for Vector metrics
+const (
+	ipConfigsName = "ipconfigs"
+	ipConfigsHelp = "The number of secondary IPs of each network container in each state"
+	NCIDLabel     = "nc_id"
+	StateLabel    = "state"
+
+	poolGoalSizeName   = "ipam_pool_goal_size"
+	poolGoalSizeHelp   = "The number of IPs the IPAM pool monitor requested in the NodeNetworkConfig spec"
+	poolBatchSizeName  = "ipam_pool_batch_size"
+	poolBatchSizeHelp  = "The number of IPs the IPAM pool monitor requests or releases at once"
+	poolMinFreeIPsName = "ipam_pool_min_free_ips"
+	poolMinFreeIPsHelp = "The number of free IPs below which the IPAM pool monitor requests a batch of IPs"
+	poolMaxFreeIPsName = "ipam_pool_max_free_ips"
+	poolMaxFreeIPsHelp = "The number of free IPs above which the IPAM pool monitor releases a batch of IPs"
+
+	ipConfigRequestLatencyName = "ipconfig_request_latency_seconds"
+	ipConfigRequestLatencyHelp = "Time in seconds for handling an IP config request, by return code"
+	ipConfigReleaseLatencyName = "ipconfig_release_latency_seconds"
+	ipConfigReleaseLatencyHelp = "Time in seconds for handling an IP config release, by return code"
+	ReturnCodeLabel            = "return_code"
+
+	nncSpecUpdateErrorsName = "nnc_spec_update_errors_total"
+	nncSpecUpdateErrorsHelp = "The number of failed updates of the NodeNetworkConfig spec"
+	ipamStateSaveErrorsName = "ipam_state_save_errors_total"
+	ipamStateSaveErrorsHelp = "The number of failed writes of the IPAM state to the store or its journal"
+)
+
+// latencyBuckets range from 1ms to about 4s, the IP config handlers only take longer when CNS is stuck.
+var latencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 13)
+
+var registry = prometheus.NewRegistry()
+
+// GetHandler returns the HTTP handler for the metrics endpoint
+func GetHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// GetRegistry returns the registry of the CNS metrics
+func GetRegistry() *prometheus.Registry {
+	return registry
+}
+
+func register(collector prometheus.Collector, name string) {
+	if err := registry.Register(collector); err != nil {
+		logger.Errorf("Error creating metric %s: %v", name, err)
+	}
+}
+
+func createGauge(name string, helpMessage string) prometheus.Gauge {
+	gauge := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      helpMessage,
+		},
+	)
+	register(gauge, name)
+	return gauge
+}
+
+func createCounter(name string, helpMessage string) prometheus.Counter {
+	counter := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      helpMessage,
+		},
+	)
+	register(counter, name)
+	return counter
+}
+
+func createHistogramVec(name string, helpMessage string, labels ...string) *prometheus.HistogramVec {
+	histogramVec := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      helpMessage,
+			Buckets:   latencyBuckets,
+		},
+		labels,
+	)
+	register(histogramVec, name)
+	return histogramVec
+}
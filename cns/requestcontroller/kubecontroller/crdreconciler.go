@@ -22,15 +22,15 @@ type CrdReconciler struct {
 }
 
 // Reconcile is called on CRD status changes
-func (r *CrdReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+func (r *CrdReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	var (
 		nodeNetConfig nnc.NodeNetworkConfig
-		ncRequest     cns.CreateNetworkContainerRequest
+		ncRequests    []cns.CreateNetworkContainerRequest
 		err           error
 	)
 
 	//Get the CRD object
-	if err = r.KubeClient.Get(context.TODO(), request.NamespacedName, &nodeNetConfig); err != nil {
+	if err = r.KubeClient.Get(ctx, request.NamespacedName, &nodeNetConfig); err != nil {
 		if apierrors.IsNotFound(err) {
 			logger.Printf("[cns-rc] CRD not found, ignoring %v", err)
 			return reconcile.Result{}, client.IgnoreNotFound(err)
@@ -43,40 +43,67 @@ func (r *CrdReconciler) Reconcile(request reconcile.Request) (reconcile.Result,
 	logger.Printf("[cns-rc] CRD Spec: %v", nodeNetConfig.Spec)
 
 
-	// If there are no network containers, don't hand it off to CNS
+	// If there are no network containers, don't hand it off to CNS, the status isn't published yet
 	if len(nodeNetConfig.Status.NetworkContainers) == 0 {
 		logger.Errorf("[cns-rc] Empty NetworkContainers")
 		return reconcile.Result{}, nil
 	}
 
-	networkContainer := nodeNetConfig.Status.NetworkContainers[0]
-	logger.Printf("[cns-rc] CRD Status: NcId: [%s], Version: [%d],  podSubnet: [%s], Subnet CIDR: [%s], " +
-		"Gateway Addr: [%s], Primary IP: [%s], SecondaryIpsCount: [%d]",
-		networkContainer.ID,
-		networkContainer.Version,
-		networkContainer.SubnetName,
-		networkContainer.SubnetAddressSpace,
-		networkContainer.DefaultGateway,
-		networkContainer.PrimaryIP,
-		len(networkContainer.IPAssignments))
-
-	// Otherwise, create NC request and hand it off to CNS
-	ncRequest, err = CRDStatusToNCRequest(nodeNetConfig.Status)
+	for _, networkContainer := range nodeNetConfig.Status.NetworkContainers {
+		logger.Printf("[cns-rc] CRD Status: NcId: [%s], Version: [%d],  podSubnet: [%s], Subnet CIDR: [%s], " +
+			"Gateway Addr: [%s], Primary IP: [%s], SecondaryIpsCount: [%d]",
+			networkContainer.ID,
+			networkContainer.Version,
+			networkContainer.SubnetName,
+			networkContainer.SubnetAddressSpace,
+			networkContainer.DefaultGateway,
+			networkContainer.PrimaryIP,
+			len(networkContainer.IPAssignments))
+	}
+
+	// Otherwise, create NC requests and hand them off to CNS
+	ncRequests, err = CRDStatusToNCRequest(nodeNetConfig.Status)
 	if err != nil {
 		logger.Errorf("[cns-rc] Error translating crd status to nc request %v", err)
 		//requeue
 		return reconcile.Result{}, err
 	}
 
-	if err = r.CNSClient.CreateOrUpdateNC(ncRequest, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec); err != nil {
-		logger.Errorf("[cns-rc] Error creating or updating NC in reconcile: %v", err)
-		// requeue
-		return reconcile.Result{}, err
+	for _, ncRequest := range ncRequests {
+		if err = r.CNSClient.CreateOrUpdateNC(ncRequest, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec); err != nil {
+			logger.Errorf("[cns-rc] Error creating or updating NC %s in reconcile: %v", ncRequest.NetworkContainerid, err)
+			// requeue
+			return reconcile.Result{}, err
+		}
 	}
 
+	// Delete the NCs which were removed from the CRD status
+	r.deleteRemovedNCs(ncRequests)
+
 	return reconcile.Result{}, err
 }
 
+// deleteRemovedNCs deletes the NCs in CNS which have no request in ncRequests. CNS refuses to delete an NC while
+// its IPs are allocated, such an NC is left in CNS until a later reconcile, once its pods are gone, without holding
+// up the NCs in the CRD status.
+func (r *CrdReconciler) deleteRemovedNCs(ncRequests []cns.CreateNetworkContainerRequest) {
+	ncIDs := make(map[string]struct{}, len(ncRequests))
+	for _, ncRequest := range ncRequests {
+		ncIDs[ncRequest.NetworkContainerid] = struct{}{}
+	}
+
+	for _, ncID := range r.CNSClient.GetNCIDs() {
+		if _, exists := ncIDs[ncID]; exists {
+			continue
+		}
+
+		logger.Printf("[cns-rc] NC %s was removed from the CRD status, deleting it", ncID)
+		if err := r.CNSClient.DeleteNC(ncID); err != nil {
+			logger.Errorf("[cns-rc] Error deleting NC %s in reconcile, retrying on a later reconcile: %v", ncID, err)
+		}
+	}
+}
+
 // SetupWithManager Sets up the reconciler with a new manager, filtering using NodeNetworkConfigFilter
 func (r *CrdReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
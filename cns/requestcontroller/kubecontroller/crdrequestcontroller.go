@@ -175,8 +175,19 @@ func (crdRC *crdRequestController) StartRequestController(exitChan <-chan struct
 	crdRC.Started = true
 	crdRC.lock.Unlock()
 
+	// The manager stops when its context is done, cancel it when exitChan is notified
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-exitChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	logger.Printf("Starting reconcile loop")
-	if err := crdRC.mgr.Start(exitChan); err != nil {
+	if err := crdRC.mgr.Start(ctx); err != nil {
 		if crdRC.isNotDefined(err) {
 			logger.Errorf("[cns-rc] CRD is not defined on cluster, starting reconcile loop failed: %v", err)
 			os.Exit(1)
@@ -203,8 +214,9 @@ func (crdRC *crdRequestController) initCNS() error {
 		podInfo       cns.KubernetesPodInfo
 		nodeNetConfig *nnc.NodeNetworkConfig
 		podInfoByIP   map[string]cns.KubernetesPodInfo
+		livePods      = make(map[string]string)
 		cntxt         context.Context
-		ncRequest     cns.CreateNetworkContainerRequest
+		ncRequests    []cns.CreateNetworkContainerRequest
 		err           error
 	)
 
@@ -225,7 +237,7 @@ func (crdRC *crdRequestController) initCNS() error {
 
 		// If instance of crd is not found, pass nil to CNSClient
 		if client.IgnoreNotFound(err) == nil {
-			return crdRC.CNSClient.ReconcileNCState(nil, nil, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec)
+			return crdRC.CNSClient.ReconcileNCState(nil, nil, nil, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec)
 		}
 
 		// If it's any other error, log it and return
@@ -235,11 +247,11 @@ func (crdRC *crdRequestController) initCNS() error {
 
 	// If there are no NCs, pass nil to CNSClient
 	if len(nodeNetConfig.Status.NetworkContainers) == 0 {
-		return crdRC.CNSClient.ReconcileNCState(nil, nil, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec)
+		return crdRC.CNSClient.ReconcileNCState(nil, nil, nil, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec)
 	}
 
-	// Convert to CreateNetworkContainerRequest
-	if ncRequest, err = CRDStatusToNCRequest(nodeNetConfig.Status); err != nil {
+	// Convert to CreateNetworkContainerRequests, one per NC
+	if ncRequests, err = CRDStatusToNCRequest(nodeNetConfig.Status); err != nil {
 		logger.Errorf("Error when converting nodeNetConfig status into CreateNetworkContainerRequest: %v", err)
 		return err
 	}
@@ -261,12 +273,14 @@ func (crdRC *crdRequestController) initCNS() error {
 					PodNamespace: pod.Namespace,
 				}
 				podInfoByIP[pod.Status.PodIP] = podInfo
+				// a pod is live even before it has an IP, its CNI ADD may be in progress
+				livePods[podInfo.GetOrchestratorContextKey()] = string(pod.UID)
 			}
 		}
 	}
 
 	// Call cnsclient init cns passing those two things
-	return crdRC.CNSClient.ReconcileNCState(&ncRequest, podInfoByIP, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec)
+	return crdRC.CNSClient.ReconcileNCState(ncRequests, podInfoByIP, livePods, nodeNetConfig.Status.Scaler, nodeNetConfig.Spec)
 
 }
 
@@ -15,8 +15,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 const (
@@ -27,12 +28,16 @@ const (
 	allocatedUUID        = "539970a2-c2dd-11ea-b3de-0242ac130004"
 	allocatedUUID2       = "01a5dd00-cd5d-11ea-87d0-0242ac130003"
 	networkContainerID   = "24fcd232-0364-41b0-8027-6e6ef9aeabc6"
+	networkContainerID2  = "6e5b5f4e-37f2-4c5c-a2b7-1ee5b0b6c0d1"
 	existingNamespace    = k8sNamespace
 	nonexistingNNCName   = "nodenetconfig_nonexisting"
 	nonexistingPodName   = "pod_nonexisting"
 	nonexistingNamespace = "namespace_nonexisting"
 	ncPrimaryIP          = "10.0.0.1"
 	subnetRange          = "10.0.0.0/24"
+	allocatedPodIP2      = "10.0.1.2"
+	ncPrimaryIP2         = "10.0.1.1"
+	subnetRange2         = "10.0.1.0/24"
 )
 
 // MockAPI is a mock of kubernete's API server
@@ -54,7 +59,7 @@ type MockKubeClient struct {
 
 // Mock implementation of the KubeClient interface Get method
 // Mimics that of controller-runtime's client.Client
-func (mc MockKubeClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+func (mc MockKubeClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
 	mockKey := MockKey{
 		Namespace: key.Namespace,
 		Name:      key.Name,
@@ -71,7 +76,7 @@ func (mc MockKubeClient) Get(ctx context.Context, key client.ObjectKey, obj runt
 
 //Mock implementation of the KubeClient interface Update method
 //Mimics that of controller-runtime's client.Client
-func (mc MockKubeClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+func (mc MockKubeClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
 	nodeNetConfig := obj.(*nnc.NodeNetworkConfig)
 
 	mockKey := MockKey{
@@ -95,19 +100,42 @@ type MockCNSClient struct {
 	MockCNSUpdated     bool
 	MockCNSInitialized bool
 	Pods               map[string]cns.KubernetesPodInfo
-	NCRequest          *cns.CreateNetworkContainerRequest
+	LivePods           map[string]string
+	NCRequests         []cns.CreateNetworkContainerRequest
+	UpdatedNCIDs       []string
+	NCIDs              []string
+	DeletedNCIDs       []string
+	// NCIDs CNS refuses to delete, like an NC with allocated IPs
+	UndeletableNCIDs []string
 }
 
 // we're just testing that reconciler interacts with CNS on Reconcile().
 func (mi *MockCNSClient) CreateOrUpdateNC(ncRequest cns.CreateNetworkContainerRequest, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error {
 	mi.MockCNSUpdated = true
+	mi.UpdatedNCIDs = append(mi.UpdatedNCIDs, ncRequest.NetworkContainerid)
 	return nil
 }
 
-func (mi *MockCNSClient) ReconcileNCState(ncRequest *cns.CreateNetworkContainerRequest, podInfoByIP map[string]cns.KubernetesPodInfo, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error {
+func (mi *MockCNSClient) GetNCIDs() []string {
+	return mi.NCIDs
+}
+
+func (mi *MockCNSClient) DeleteNC(ncID string) error {
+	for _, undeletableNCID := range mi.UndeletableNCIDs {
+		if ncID == undeletableNCID {
+			return fmt.Errorf("NC %s has allocated IPs", ncID)
+		}
+	}
+
+	mi.DeletedNCIDs = append(mi.DeletedNCIDs, ncID)
+	return nil
+}
+
+func (mi *MockCNSClient) ReconcileNCState(ncRequests []cns.CreateNetworkContainerRequest, podInfoByIP map[string]cns.KubernetesPodInfo, livePods map[string]string, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) error {
 	mi.MockCNSInitialized = true
 	mi.Pods = podInfoByIP
-	mi.NCRequest = ncRequest
+	mi.LivePods = livePods
+	mi.NCRequests = ncRequests
 	return nil
 }
 
@@ -594,6 +622,18 @@ func TestInitRequestController(t *testing.T) {
 					SubnetAddressSpace: subnetRange,
 					Version:            1,
 				},
+				{
+					PrimaryIP: ncPrimaryIP2,
+					ID:        networkContainerID2,
+					IPAssignments: []nnc.IPAssignment{
+						{
+							Name: allocatedUUID2,
+							IP:   allocatedPodIP2,
+						},
+					},
+					SubnetAddressSpace: subnetRange2,
+					Version:            1,
+				},
 			},
 		},
 	}
@@ -671,8 +711,165 @@ func TestInitRequestController(t *testing.T) {
 		t.Fatalf("Init should pass cns pods that aren't part of host network")
 	}
 
-	if _, ok := mockCNSClient.NCRequest.SecondaryIPConfigs[allocatedUUID]; !ok {
+	if _, ok := mockCNSClient.LivePods[mockPod.Name+":"+mockPod.Namespace]; !ok || len(mockCNSClient.LivePods) != 1 {
+		t.Fatalf("Init should pass cns the live pods that aren't part of host network, got %+v", mockCNSClient.LivePods)
+	}
+
+	if len(mockCNSClient.NCRequests) != 2 {
+		t.Fatalf("Expected an ncrequest per network container, got %+v", mockCNSClient.NCRequests)
+	}
+
+	if _, ok := mockCNSClient.NCRequests[0].SecondaryIPConfigs[allocatedUUID]; !ok {
 		t.Fatalf("Expected secondary ip config to be in ncrequest")
 	}
 
+	if _, ok := mockCNSClient.NCRequests[1].SecondaryIPConfigs[allocatedUUID2]; !ok {
+		t.Fatalf("Expected secondary ip config of the second network container to be in its ncrequest")
+	}
+}
+
+func TestReconcileMultipleNetworkContainers(t *testing.T) {
+	nodeNetConfig := &nnc.NodeNetworkConfig{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      existingNNCName,
+			Namespace: existingNamespace,
+		},
+		Status: nnc.NodeNetworkConfigStatus{
+			NetworkContainers: []nnc.NetworkContainer{
+				{
+					PrimaryIP:          ncPrimaryIP,
+					ID:                 networkContainerID,
+					SubnetAddressSpace: subnetRange,
+				},
+				{
+					PrimaryIP:          ncPrimaryIP2,
+					ID:                 networkContainerID2,
+					SubnetAddressSpace: subnetRange2,
+				},
+			},
+		},
+	}
+	mockNNCKey := MockKey{
+		Namespace: existingNamespace,
+		Name:      existingNNCName,
+	}
+	mockAPI := &MockAPI{
+		nodeNetConfigs: map[MockKey]*nnc.NodeNetworkConfig{
+			mockNNCKey: nodeNetConfig,
+		},
+	}
+	mockCNSClient := &MockCNSClient{}
+	reconciler := &CrdReconciler{
+		KubeClient: MockKubeClient{mockAPI: mockAPI},
+		NodeName:   existingNNCName,
+		CNSClient:  mockCNSClient,
+	}
+	logger.InitLogger("Azure CNS RequestController", 0, 0, "")
+
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: existingNamespace, Name: existingNNCName}}
+	if _, err := reconciler.Reconcile(context.Background(), request); err != nil {
+		t.Fatalf("Expected no error when reconciling two network containers: %+v", err)
+	}
+
+	if !reflect.DeepEqual(mockCNSClient.UpdatedNCIDs, []string{networkContainerID, networkContainerID2}) {
+		t.Fatalf("Expected both network containers to be created or updated in CNS, got %v", mockCNSClient.UpdatedNCIDs)
+	}
+}
+
+func TestReconcileDeletesRemovedNetworkContainers(t *testing.T) {
+	nodeNetConfig := &nnc.NodeNetworkConfig{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      existingNNCName,
+			Namespace: existingNamespace,
+		},
+		Status: nnc.NodeNetworkConfigStatus{
+			NetworkContainers: []nnc.NetworkContainer{
+				{
+					PrimaryIP:          ncPrimaryIP,
+					ID:                 networkContainerID,
+					SubnetAddressSpace: subnetRange,
+				},
+			},
+		},
+	}
+	mockNNCKey := MockKey{
+		Namespace: existingNamespace,
+		Name:      existingNNCName,
+	}
+	mockAPI := &MockAPI{
+		nodeNetConfigs: map[MockKey]*nnc.NodeNetworkConfig{
+			mockNNCKey: nodeNetConfig,
+		},
+	}
+	// the second network container was removed from the status
+	mockCNSClient := &MockCNSClient{
+		NCIDs: []string{networkContainerID, networkContainerID2},
+	}
+	reconciler := &CrdReconciler{
+		KubeClient: MockKubeClient{mockAPI: mockAPI},
+		NodeName:   existingNNCName,
+		CNSClient:  mockCNSClient,
+	}
+	logger.InitLogger("Azure CNS RequestController", 0, 0, "")
+
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: existingNamespace, Name: existingNNCName}}
+	if _, err := reconciler.Reconcile(context.Background(), request); err != nil {
+		t.Fatalf("Expected no error when reconciling a removed network container: %+v", err)
+	}
+
+	if !reflect.DeepEqual(mockCNSClient.UpdatedNCIDs, []string{networkContainerID}) {
+		t.Fatalf("Expected the remaining network container to be updated in CNS, got %v", mockCNSClient.UpdatedNCIDs)
+	}
+
+	if !reflect.DeepEqual(mockCNSClient.DeletedNCIDs, []string{networkContainerID2}) {
+		t.Fatalf("Expected the removed network container to be deleted from CNS, got %v", mockCNSClient.DeletedNCIDs)
+	}
+}
+
+func TestReconcileKeepsRemovedNetworkContainersWithAllocatedIPs(t *testing.T) {
+	nodeNetConfig := &nnc.NodeNetworkConfig{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      existingNNCName,
+			Namespace: existingNamespace,
+		},
+		Status: nnc.NodeNetworkConfigStatus{
+			NetworkContainers: []nnc.NetworkContainer{
+				{
+					PrimaryIP:          ncPrimaryIP,
+					ID:                 networkContainerID,
+					SubnetAddressSpace: subnetRange,
+				},
+			},
+		},
+	}
+	mockNNCKey := MockKey{
+		Namespace: existingNamespace,
+		Name:      existingNNCName,
+	}
+	mockAPI := &MockAPI{
+		nodeNetConfigs: map[MockKey]*nnc.NodeNetworkConfig{
+			mockNNCKey: nodeNetConfig,
+		},
+	}
+	// both removed network containers are tried, though CNS refuses to delete the first one
+	removedNetworkContainerID := "removed-" + networkContainerID
+	mockCNSClient := &MockCNSClient{
+		NCIDs:            []string{networkContainerID, networkContainerID2, removedNetworkContainerID},
+		UndeletableNCIDs: []string{networkContainerID2},
+	}
+	reconciler := &CrdReconciler{
+		KubeClient: MockKubeClient{mockAPI: mockAPI},
+		NodeName:   existingNNCName,
+		CNSClient:  mockCNSClient,
+	}
+	logger.InitLogger("Azure CNS RequestController", 0, 0, "")
+
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: existingNamespace, Name: existingNNCName}}
+	if _, err := reconciler.Reconcile(context.Background(), request); err != nil {
+		t.Fatalf("Expected no error when CNS refuses to delete a removed network container: %+v", err)
+	}
+
+	if !reflect.DeepEqual(mockCNSClient.DeletedNCIDs, []string{removedNetworkContainerID}) {
+		t.Fatalf("Expected the other removed network container to be deleted from CNS, got %v", mockCNSClient.DeletedNCIDs)
+	}
 }
@@ -8,16 +8,23 @@ import (
 )
 
 const (
-	ncID               = "160005ba-cd02-11ea-87d0-0242ac130003"
-	primaryIp          = "10.0.0.1"
-	ipInCIDR           = "10.0.0.1/32"
-	ipMalformed        = "10.0.0.0.0"
-	defaultGateway     = "10.0.0.2"
-	subnetName         = "subnet1"
-	subnetAddressSpace = "10.0.0.0/24"
-	subnetPrefixLen    = 24
-	testSecIp1         = "10.0.0.2"
-	version            = 1
+	ncID                = "160005ba-cd02-11ea-87d0-0242ac130003"
+	primaryIp           = "10.0.0.1"
+	ipInCIDR            = "10.0.0.1/32"
+	ipMalformed         = "10.0.0.0.0"
+	defaultGateway      = "10.0.0.2"
+	subnetName          = "subnet1"
+	subnetAddressSpace  = "10.0.0.0/24"
+	subnetPrefixLen     = 24
+	testSecIp1          = "10.0.0.2"
+	ncID2               = "3f0b5c62-8c1f-4d8e-9c39-5a2a5fd3ad6b"
+	primaryIp2          = "10.1.0.1"
+	defaultGateway2     = "10.1.0.2"
+	subnetAddressSpace2 = "10.1.0.0/16"
+	subnetPrefixLen2    = 16
+	testSecIp2          = "10.1.0.4"
+	secIpUUID2          = "8a2a1f38-1b4c-4a16-a0b6-6f0dbe1e52a1"
+	version             = 1
 )
 
 func TestStatusToNCRequestMalformedPrimaryIP(t *testing.T) {
@@ -173,6 +180,7 @@ func TestStatusToNCRequestWithIncorrectSubnetAddressSpace(t *testing.T) {
 func TestStatusToNCRequestSuccess(t *testing.T) {
 	var (
 		status       nnc.NodeNetworkConfigStatus
+		ncRequests   []cns.CreateNetworkContainerRequest
 		ncRequest    cns.CreateNetworkContainerRequest
 		secondaryIPs map[string]cns.SecondaryIPConfig
 		secondaryIP  cns.SecondaryIPConfig
@@ -200,12 +208,18 @@ func TestStatusToNCRequestSuccess(t *testing.T) {
 	}
 
 	// Test with ips formed correctly as CIDRs
-	ncRequest, err = CRDStatusToNCRequest(status)
+	ncRequests, err = CRDStatusToNCRequest(status)
 
 	if err != nil {
 		t.Fatalf("Expected translation of CRD status to succeed, got error :%v", err)
 	}
 
+	if len(ncRequests) != 1 {
+		t.Fatalf("Expected one ncRequest but got %v", len(ncRequests))
+	}
+
+	ncRequest = ncRequests[0]
+
 	if ncRequest.IPConfiguration.IPSubnet.IPAddress != primaryIp {
 		t.Fatalf("Expected ncRequest's ipconfiguration to have the ip %v but got %v", primaryIp, ncRequest.IPConfiguration.IPSubnet.IPAddress)
 	}
@@ -240,3 +254,84 @@ func TestStatusToNCRequestSuccess(t *testing.T) {
 		t.Fatalf("Expected %d as the secondary IP config NC version but got %v", version, secondaryIP.NCVersion)
 	}
 }
+
+func TestStatusToNCRequestMultipleNCs(t *testing.T) {
+	var (
+		status     nnc.NodeNetworkConfigStatus
+		ncRequests []cns.CreateNetworkContainerRequest
+		err        error
+	)
+
+	status = nnc.NodeNetworkConfigStatus{
+		NetworkContainers: []nnc.NetworkContainer{
+			{
+				PrimaryIP: primaryIp,
+				ID:        ncID,
+				IPAssignments: []nnc.IPAssignment{
+					{
+						Name: allocatedUUID,
+						IP:   testSecIp1,
+					},
+				},
+				DefaultGateway:     defaultGateway,
+				SubnetAddressSpace: subnetAddressSpace,
+				Version:            version,
+			},
+			{
+				PrimaryIP: primaryIp2,
+				ID:        ncID2,
+				IPAssignments: []nnc.IPAssignment{
+					{
+						Name: secIpUUID2,
+						IP:   testSecIp2,
+					},
+				},
+				DefaultGateway:     defaultGateway2,
+				SubnetAddressSpace: subnetAddressSpace2,
+				Version:            version + 1,
+			},
+		},
+	}
+
+	if ncRequests, err = CRDStatusToNCRequest(status); err != nil {
+		t.Fatalf("Expected translation of CRD status with two ncs to succeed, got error :%v", err)
+	}
+
+	if len(ncRequests) != 2 {
+		t.Fatalf("Expected an ncRequest per nc but got %v", len(ncRequests))
+	}
+
+	// each nc keeps its own subnet, gateway, version and secondary ips
+	ncRequest := ncRequests[1]
+	if ncRequest.NetworkContainerid != ncID2 || ncRequest.Version != "2" {
+		t.Fatalf("Expected the second ncRequest to be nc %v version 2 but got %v version %v", ncID2, ncRequest.NetworkContainerid, ncRequest.Version)
+	}
+
+	if ncRequest.IPConfiguration.IPSubnet.IPAddress != primaryIp2 || ncRequest.IPConfiguration.IPSubnet.PrefixLength != uint8(subnetPrefixLen2) {
+		t.Fatalf("Expected the second ncRequest's ipconfiguration to be %v/%v but got %+v", primaryIp2, subnetPrefixLen2, ncRequest.IPConfiguration.IPSubnet)
+	}
+
+	if ncRequest.IPConfiguration.GatewayIPAddress != defaultGateway2 {
+		t.Fatalf("Expected the second ncRequest's gateway to be %s but got %s", defaultGateway2, ncRequest.IPConfiguration.GatewayIPAddress)
+	}
+
+	if _, ok := ncRequest.SecondaryIPConfigs[allocatedUUID]; ok || len(ncRequest.SecondaryIPConfigs) != 1 {
+		t.Fatalf("Expected the second ncRequest to only have its own secondary ip but got %+v", ncRequest.SecondaryIPConfigs)
+	}
+
+	if ncRequest.SecondaryIPConfigs[secIpUUID2].NCVersion != version+1 {
+		t.Fatalf("Expected %d as the secondary IP config NC version but got %v", version+1, ncRequest.SecondaryIPConfigs[secIpUUID2].NCVersion)
+	}
+
+	// the same secondary ip id in two ncs would collide in CNS state
+	status.NetworkContainers[1].IPAssignments[0].Name = allocatedUUID
+	if _, err = CRDStatusToNCRequest(status); err == nil {
+		t.Fatalf("Expected translation of CRD status with a secondary ip in two ncs to fail.")
+	}
+
+	status.NetworkContainers[1].IPAssignments[0].Name = secIpUUID2
+	status.NetworkContainers[1].ID = ncID
+	if _, err = CRDStatusToNCRequest(status); err == nil {
+		t.Fatalf("Expected translation of CRD status with a duplicate nc to fail.")
+	}
+}
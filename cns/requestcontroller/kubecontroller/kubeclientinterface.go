@@ -6,14 +6,13 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	nnc "github.com/Azure/azure-container-networking/nodenetworkconfig/api/v1alpha"
-	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // KubeClient is an interface that talks to the API server
 type KubeClient interface {
-	Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error
-	Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error
+	Get(ctx context.Context, key client.ObjectKey, obj client.Object) error
+	Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error
 }
 
 // DirectCRDClient is an interface to get CRDs directly, without cache
@@ -0,0 +1,114 @@
+package kubecontroller
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podInformerResyncPeriod is the resync period of the pod informer, the pods are counted from its cache on demand
+const podInformerResyncPeriod = 10 * time.Minute
+
+// PodInformerPendingPodCounter counts the pods scheduled to this node which don't have an IP yet,
+// from a pod informer watching the pods of the node.
+type PodInformerPendingPodCounter struct {
+	informerFactory informers.SharedInformerFactory
+	podInformer     cache.SharedIndexInformer
+	cnsService      cns.HTTPService
+}
+
+// NewPodInformerPendingPodCounter creates a pending pod counter for the node named by the NODENAME environment variable.
+// The pods cnsService already allocated an IP to are not counted.
+func NewPodInformerPendingPodCounter(kubeconfig *rest.Config, cnsService cns.HTTPService) (*PodInformerPendingPodCounter, error) {
+	nodeName := os.Getenv(nodeNameEnvVar)
+	if nodeName == "" {
+		return nil, errors.New("Must declare " + nodeNameEnvVar + " environment variable.")
+	}
+
+	clientset, err := kubernetes.NewForConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPodInformerPendingPodCounter(clientset, nodeName, cnsService), nil
+}
+
+func newPodInformerPendingPodCounter(clientset kubernetes.Interface, nodeName string, cnsService cns.HTTPService) *PodInformerPendingPodCounter {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, podInformerResyncPeriod,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = "spec.nodeName=" + nodeName
+		}))
+
+	return &PodInformerPendingPodCounter{
+		informerFactory: informerFactory,
+		podInformer:     informerFactory.Core().V1().Pods().Informer(),
+		cnsService:      cnsService,
+	}
+}
+
+// Start starts the pod informer and waits for its cache to sync
+func (counter *PodInformerPendingPodCounter) Start(stopCh <-chan struct{}) error {
+	logger.Printf("[cns-rc] Starting pod informer for pending pods")
+	counter.informerFactory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, counter.podInformer.HasSynced) {
+		return errors.New("Timed out waiting for the pod informer cache to sync")
+	}
+
+	return nil
+}
+
+// PendingPodCount returns the number of pods of the node which are pending without an IP.
+// Host network pods don't get an IP from CNS and aren't counted. Neither are the pods CNS allocated an IP to
+// before their status was updated, the pool monitor counts their IPs as allocated already.
+func (counter *PodInformerPendingPodCounter) PendingPodCount() int {
+	allocatedPods := counter.getAllocatedPods()
+	count := 0
+	for _, obj := range counter.podInformer.GetStore().List() {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			continue
+		}
+
+		if pod.Spec.HostNetwork || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		podInfo := cns.KubernetesPodInfo{PodName: pod.Name, PodNamespace: pod.Namespace}
+		if allocatedPods[podInfo.GetOrchestratorContextKey()] {
+			continue
+		}
+
+		if pod.Status.Phase == corev1.PodPending && pod.Status.PodIP == "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// getAllocatedPods returns the orchestrator context keys of the pods CNS allocated an IP to.
+func (counter *PodInformerPendingPodCounter) getAllocatedPods() map[string]bool {
+	allocatedPods := make(map[string]bool)
+	for _, ipconfig := range counter.cnsService.GetAllocatedIPConfigs() {
+		var podInfo cns.KubernetesPodInfo
+		if err := json.Unmarshal(ipconfig.OrchestratorContext, &podInfo); err != nil {
+			logger.Errorf("[cns-rc] Failed to unmarshal the orchestrator context of IP %s, err: %v", ipconfig.IPAddress, err)
+			continue
+		}
+
+		allocatedPods[podInfo.GetOrchestratorContextKey()] = true
+	}
+
+	return allocatedPods
+}
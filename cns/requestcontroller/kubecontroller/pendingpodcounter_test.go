@@ -0,0 +1,65 @@
+package kubecontroller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/fakes"
+	"github.com/Azure/azure-container-networking/cns/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestPod(name string, phase corev1.PodPhase, podIP string, hostNetwork bool) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			NodeName:    existingNNCName,
+			HostNetwork: hostNetwork,
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+			PodIP: podIP,
+		},
+	}
+}
+
+func TestPendingPodCount(t *testing.T) {
+	logger.InitLogger("Azure CNS RequestController", 0, 0, "")
+
+	clientset := fake.NewSimpleClientset(
+		newTestPod("pending1", corev1.PodPending, "", false),
+		newTestPod("pending2", corev1.PodPending, "", false),
+		// has its IP, the containers are still starting
+		newTestPod("starting", corev1.PodPending, "10.0.0.5", false),
+		newTestPod("running", corev1.PodRunning, "10.0.0.6", false),
+		// doesn't get an IP from CNS
+		newTestPod("hostnetwork", corev1.PodPending, "", true),
+		// CNS allocated its IP, the pod status isn't updated yet
+		newTestPod("allocated", corev1.PodPending, "", false),
+	)
+
+	orchestratorContext, _ := json.Marshal(cns.KubernetesPodInfo{PodName: "allocated", PodNamespace: "default"})
+	cnsService := fakes.NewHTTPServiceFake()
+	cnsService.IPStateManager.AddIPConfigs([]cns.IPConfigurationStatus{
+		{ID: "allocated", IPAddress: "10.0.0.7", State: cns.Allocated, OrchestratorContext: orchestratorContext},
+	})
+
+	counter := newPodInformerPendingPodCounter(clientset, existingNNCName, cnsService)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := counter.Start(stopCh); err != nil {
+		t.Fatalf("Expected pod informer to start, err: %v", err)
+	}
+
+	if count := counter.PendingPodCount(); count != 2 {
+		t.Fatalf("Expected 2 pending pods, actual %d", count)
+	}
+}
@@ -43,11 +43,16 @@ const (
 )
 
 const (
-	// Key against which CNS state is persisted.
+	// Keys against which CNS state and CNS IPAM state are persisted.
 	storeKey        = "ContainerNetworkService"
+	ipamStoreKey    = "ContainerNetworkServiceIPAM"
 	swiftAPIVersion = "1"
 	attach          = "Attach"
 	detach          = "Detach"
+	// The IPAM state transitions are journaled next to the store, see ipamJournal, and written
+	// to the store once the journal holds ipamJournalMaxEntries entries.
+	ipamJournalSuffix     = "-ipam.journal"
+	ipamJournalMaxEntries = 1000
 	// Rest service state identifier for named lock
 	stateJoinedNetworks = "JoinedNetworks"
 	dncApiVersion       = "?api-version=2018-03-01"
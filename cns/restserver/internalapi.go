@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
@@ -207,45 +208,68 @@ func (service *HTTPRestService) SyncHostNCVersion(ctx context.Context, channelMo
 }
 
 // This API will be called by CNS RequestController on CRD update.
-func (service *HTTPRestService) ReconcileNCState(ncRequest *cns.CreateNetworkContainerRequest, podInfoByIp map[string]cns.KubernetesPodInfo, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) int {
-	// check if ncRequest is null, then return as there is no CRD state yet
-	if ncRequest == nil {
+// ncRequests holds one request per NC of the node, the pods may have IPs of any of them.
+// livePods holds the UID of every pod of the node by orchestrator context key, the IPs restored as allocated
+// to other pods are released. It is nil when the pods are unknown, then the restored allocations are kept.
+func (service *HTTPRestService) ReconcileNCState(ncRequests []cns.CreateNetworkContainerRequest, podInfoByIp map[string]cns.KubernetesPodInfo, livePods map[string]string, scalar nnc.Scaler, spec nnc.NodeNetworkConfigSpec) int {
+	// check if there is no NC, then return as there is no CRD state yet
+	if len(ncRequests) == 0 {
 		logger.Printf("CNS starting with no NC state, podInfoMap count %d", len(podInfoByIp))
 		return Success
 	}
 
-	returnCode := service.CreateOrUpdateNetworkContainerInternal(*ncRequest, scalar, spec)
+	// create all the NCs first, so every secondary IP is known before the pods get theirs back
+	for _, ncRequest := range ncRequests {
+		returnCode := service.CreateOrUpdateNetworkContainerInternal(ncRequest, scalar, spec)
 
-	// If the NC was created successfully, then reconcile the allocated pod state
-	if returnCode != Success {
-		return returnCode
+		// If the NC was created successfully, then reconcile the allocated pod state
+		if returnCode != Success {
+			return returnCode
+		}
 	}
 
-	// now parse the secondaryIP list, if it exists in PodInfo list, then allocate that ip
-	for _, secIpConfig := range ncRequest.SecondaryIPConfigs {
-		if podInfo, exists := podInfoByIp[secIpConfig.IPAddress]; exists {
-			logger.Printf("SecondaryIP %+v is allocated to Pod. %+v, ncId: %s", secIpConfig, podInfo, ncRequest.NetworkContainerid)
+	// now parse the secondaryIP lists, if it exists in PodInfo list, then allocate that ip
+	for _, ncRequest := range ncRequests {
+		for _, secIpConfig := range ncRequest.SecondaryIPConfigs {
+			if podInfo, exists := podInfoByIp[secIpConfig.IPAddress]; exists {
+				logger.Printf("SecondaryIP %+v is allocated to Pod. %+v, ncId: %s", secIpConfig, podInfo, ncRequest.NetworkContainerid)
 
-			kubernetesPodInfo := cns.KubernetesPodInfo{
-				PodName:      podInfo.PodName,
-				PodNamespace: podInfo.PodNamespace,
-			}
-			jsonContext, _ := json.Marshal(kubernetesPodInfo)
+				kubernetesPodInfo := cns.KubernetesPodInfo{
+					PodName:      podInfo.PodName,
+					PodNamespace: podInfo.PodNamespace,
+				}
+				jsonContext, _ := json.Marshal(kubernetesPodInfo)
+				// the pod list doesn't tell the sandbox, CNI tells it on its next request for the pod
+				podUID := livePods[kubernetesPodInfo.GetOrchestratorContextKey()]
+
+				// the allocations restored from the store are kept, unless the pod list says otherwise
+				if err := service.releaseStaleAllocations(kubernetesPodInfo, podUID, secIpConfig.IPAddress); err != nil {
+					logger.Errorf("Failed to release stale allocations of SecondaryIP %+v, podInfo %+v, ncId %s, error: %v", secIpConfig, podInfo, ncRequest.NetworkContainerid, err)
+					return FailedToAllocateIpConfig
+				}
 
-			ipconfigRequest := cns.IPConfigRequest{
-				DesiredIPAddress:    secIpConfig.IPAddress,
-				OrchestratorContext: jsonContext,
-			}
+				ipconfigRequest := cns.IPConfigRequest{
+					DesiredIPAddress:    secIpConfig.IPAddress,
+					OrchestratorContext: jsonContext,
+					PodUID:              podUID,
+				}
 
-			if _, err := requestIPConfigHelper(service, ipconfigRequest); err != nil {
-				logger.Errorf("AllocateIPConfig failed for SecondaryIP %+v, podInfo %+v, ncId %s, error: %v", secIpConfig, podInfo, ncRequest.NetworkContainerid, err)
-				return FailedToAllocateIpConfig
+				if _, err := requestIPConfigHelper(service, ipconfigRequest); err != nil {
+					logger.Errorf("AllocateIPConfig failed for SecondaryIP %+v, podInfo %+v, ncId %s, error: %v", secIpConfig, podInfo, ncRequest.NetworkContainerid, err)
+					return FailedToAllocateIpConfig
+				}
+			} else {
+				logger.Printf("SecondaryIP %+v is not allocated. ncId: %s", secIpConfig, ncRequest.NetworkContainerid)
 			}
-		} else {
-			logger.Printf("SecondaryIP %+v is not allocated. ncId: %s", secIpConfig, ncRequest.NetworkContainerid)
 		}
 	}
 
+	// the pods which left the node while CNS was down never release their IPs
+	if err := service.releaseDeadAllocations(livePods); err != nil {
+		logger.Errorf("Failed to release the IPs of the pods which are not on the node anymore, error: %v", err)
+		return UnexpectedError
+	}
+
 	err := service.MarkExistingIPsAsPending(spec.IPsNotInUse)
 	if err != nil {
 		logger.Errorf("[Azure CNS] Error. Failed to mark IP's as pending %v", spec.IPsNotInUse)
@@ -285,6 +309,12 @@ func (service *HTTPRestService) CreateOrUpdateNetworkContainerInternal(req cns.C
 		}
 	}
 
+	// Validate the SecondaryIPConfigs don't belong to another NC, the ipconfigs of all NCs share one state
+	if ipConfigStatus, exists := service.getIPConfigOfOtherNC(req); exists {
+		logger.Errorf("[Azure CNS] Error. SecondaryIP %+v of NC %s already belongs to NC %s", ipConfigStatus, req.NetworkContainerid, ipConfigStatus.NCID)
+		return InvalidSecondaryIPConfig
+	}
+
 	// Validate if state exists already
 	existingNCInfo, ok := service.getNetworkContainerDetails(req.NetworkContainerid)
 
@@ -314,3 +344,76 @@ func (service *HTTPRestService) CreateOrUpdateNetworkContainerInternal(req cns.C
 
 	return returnCode
 }
+
+// getIPConfigOfOtherNC returns an ipconfig in the state with the id of a secondary IP of req, but belonging to another NC.
+func (service *HTTPRestService) getIPConfigOfOtherNC(req cns.CreateNetworkContainerRequest) (cns.IPConfigurationStatus, bool) {
+	service.RLock()
+	defer service.RUnlock()
+
+	for ipId := range req.SecondaryIPConfigs {
+		if ipConfigStatus, exists := service.PodIPConfigState[ipId]; exists && ipConfigStatus.NCID != req.NetworkContainerid {
+			return ipConfigStatus, true
+		}
+	}
+
+	return cns.IPConfigurationStatus{}, false
+}
+
+// GetNetworkContainerIDs returns the ids of the NCs in the state.
+func (service *HTTPRestService) GetNetworkContainerIDs() []string {
+	service.RLock()
+	defer service.RUnlock()
+
+	ncIDs := make([]string, 0, len(service.state.ContainerStatus))
+	for ncID := range service.state.ContainerStatus {
+		ncIDs = append(ncIDs, ncID)
+	}
+
+	sort.Strings(ncIDs)
+	return ncIDs
+}
+
+// This API will be called by CNS RequestController when an NC is removed from the CRD status.
+// The NC is deleted with its secondary IPs, it fails while any of them is still allocated to a pod.
+func (service *HTTPRestService) DeleteNetworkContainerInternal(ncID string) int {
+	if ncID == "" {
+		logger.Errorf("[Azure CNS] Error. NetworkContainerid is empty")
+		return NetworkContainerNotSpecified
+	}
+
+	service.Lock()
+	defer service.Unlock()
+
+	if _, exists := service.state.ContainerStatus[ncID]; !exists {
+		logger.Printf("[Azure CNS] NC %s to delete doesn't exist", ncID)
+		return Success
+	}
+
+	var ipIDs []string
+	for ipID, ipConfigStatus := range service.PodIPConfigState {
+		if ipConfigStatus.NCID != ncID {
+			continue
+		}
+
+		if ipConfigStatus.State == cns.Allocated {
+			logger.Errorf("[Azure CNS] Error. Failed to delete NC %s with an Allocated IP %+v", ncID, ipConfigStatus)
+			return InconsistentIPConfigState
+		}
+
+		ipIDs = append(ipIDs, ipID)
+	}
+
+	for _, ipID := range ipIDs {
+		if returnCode, errMsg := service.removeToBeDeletedIpsStateUntransacted(ipID, true); returnCode != Success {
+			logger.Errorf(errMsg)
+			return returnCode
+		}
+	}
+
+	delete(service.state.ContainerStatus, ncID)
+	logger.Printf("[Azure CNS] Deleted NC %s with %d IPs", ncID, len(ipIDs))
+
+	service.saveIPAMStateUntransacted()
+	service.saveState()
+	return Success
+}
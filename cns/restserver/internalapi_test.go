@@ -14,7 +14,9 @@ import (
 	"time"
 
 	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/common"
 	"github.com/Azure/azure-container-networking/cns/fakes"
+	"github.com/Azure/azure-container-networking/store"
 	"github.com/google/uuid"
 )
 
@@ -210,7 +212,7 @@ func TestReconcileNCWithEmptyState(t *testing.T) {
 
 	expectedNcCount := len(svc.state.ContainerStatus)
 	expectedAllocatedPods := make(map[string]cns.KubernetesPodInfo)
-	returnCode := svc.ReconcileNCState(nil, expectedAllocatedPods, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	returnCode := svc.ReconcileNCState(nil, expectedAllocatedPods, nil, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
 	if returnCode != Success {
 		t.Errorf("Unexpected failure on reconcile with no state %d", returnCode)
 	}
@@ -247,7 +249,7 @@ func TestReconcileNCWithExistingState(t *testing.T) {
 	}
 
 	expectedNcCount := len(svc.state.ContainerStatus)
-	returnCode := svc.ReconcileNCState(&req, expectedAllocatedPods, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	returnCode := svc.ReconcileNCState([]cns.CreateNetworkContainerRequest{req}, expectedAllocatedPods, nil, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
 	if returnCode != Success {
 		t.Errorf("Unexpected failure on reconcile with no state %d", returnCode)
 	}
@@ -285,7 +287,7 @@ func TestReconcileNCWithSystemPods(t *testing.T) {
 	}
 
 	expectedNcCount := len(svc.state.ContainerStatus)
-	returnCode := svc.ReconcileNCState(&req, expectedAllocatedPods, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	returnCode := svc.ReconcileNCState([]cns.CreateNetworkContainerRequest{req}, expectedAllocatedPods, nil, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
 	if returnCode != Success {
 		t.Errorf("Unexpected failure on reconcile with no state %d", returnCode)
 	}
@@ -294,6 +296,129 @@ func TestReconcileNCWithSystemPods(t *testing.T) {
 	validateNCStateAfterReconcile(t, &req, expectedNcCount, expectedAllocatedPods)
 }
 
+func TestReconcileNCWithMultipleNCs(t *testing.T) {
+	restartService()
+	setEnv(t)
+	setOrchestratorTypeInternal(cns.KubernetesCRD)
+
+	secondaryIPConfigs1 := make(map[string]cns.SecondaryIPConfig)
+	secondaryIPConfigs2 := make(map[string]cns.SecondaryIPConfig)
+	for i := 6; i < 8; i++ {
+		secondaryIPConfigs1[uuid.New().String()] = newSecondaryIPConfig("10.0.0."+strconv.Itoa(i), -1)
+		secondaryIPConfigs2[uuid.New().String()] = newSecondaryIPConfig("10.0.1."+strconv.Itoa(i), -1)
+	}
+	req1 := generateNetworkContainerRequest(secondaryIPConfigs1, "reconcileNc1", "-1")
+	req2 := generateNetworkContainerRequest(secondaryIPConfigs2, "reconcileNc2", "-1")
+	req2.IPConfiguration.IPSubnet.IPAddress = "10.0.1.5"
+	req2.IPConfiguration.GatewayIPAddress = "10.0.1.1"
+
+	// a pod of each NC
+	expectedAllocatedPods := map[string]cns.KubernetesPodInfo{
+		"10.0.0.6": {PodName: "reconcilePod1", PodNamespace: "PodNS1"},
+		"10.0.1.7": {PodName: "reconcilePod2", PodNamespace: "PodNS1"},
+	}
+
+	returnCode := svc.ReconcileNCState([]cns.CreateNetworkContainerRequest{req1, req2}, expectedAllocatedPods, nil, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	if returnCode != Success {
+		t.Fatalf("Unexpected failure on reconcile with two NCs %d", returnCode)
+	}
+
+	if len(svc.PodIPConfigState) != len(secondaryIPConfigs1)+len(secondaryIPConfigs2) {
+		t.Fatalf("Expected the secondary IPs of both NCs in PodIPConfigState, actual %+v", svc.PodIPConfigState)
+	}
+
+	for _, req := range []cns.CreateNetworkContainerRequest{req1, req2} {
+		containerStatus := svc.state.ContainerStatus[req.NetworkContainerid]
+		if !reflect.DeepEqual(containerStatus.CreateNetworkContainerRequest.IPConfiguration, req.IPConfiguration) {
+			t.Fatalf("Expected NC %s to keep its own IPConfiguration %+v, actual %+v", req.NetworkContainerid, req.IPConfiguration, containerStatus.CreateNetworkContainerRequest.IPConfiguration)
+		}
+
+		for ipId, secIpConfig := range req.SecondaryIPConfigs {
+			ipConfigState := svc.PodIPConfigState[ipId]
+			if ipConfigState.NCID != req.NetworkContainerid {
+				t.Fatalf("IPId: %s belongs to NC %s, expected %s", ipId, ipConfigState.NCID, req.NetworkContainerid)
+			}
+
+			expectedState := cns.Available
+			if _, allocated := expectedAllocatedPods[secIpConfig.IPAddress]; allocated {
+				expectedState = cns.Allocated
+			}
+			if ipConfigState.State != expectedState {
+				t.Fatalf("IPId: %s State is not %s, ipStatus: %+v", ipId, expectedState, ipConfigState)
+			}
+		}
+	}
+
+	if len(svc.PodIPIDByOrchestratorContext) != len(expectedAllocatedPods) {
+		t.Fatalf("Unexpected allocated pods, actual: %d, expected: %d", len(svc.PodIPIDByOrchestratorContext), len(expectedAllocatedPods))
+	}
+
+	// the secondary IPs of one NC can't be claimed by another
+	returnCode = svc.CreateOrUpdateNetworkContainerInternal(generateNetworkContainerRequest(secondaryIPConfigs1, "reconcileNc3", "-1"), fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	if returnCode != InvalidSecondaryIPConfig {
+		t.Fatalf("Expected NC with the secondary IPs of another NC to fail with %d, actual %d", InvalidSecondaryIPConfig, returnCode)
+	}
+}
+
+func TestDeleteNetworkContainerInternal(t *testing.T) {
+	restartService()
+	setEnv(t)
+	setOrchestratorTypeInternal(cns.KubernetesCRD)
+
+	secondaryIPConfigs1 := make(map[string]cns.SecondaryIPConfig)
+	secondaryIPConfigs2 := make(map[string]cns.SecondaryIPConfig)
+	for i := 6; i < 8; i++ {
+		secondaryIPConfigs1[uuid.New().String()] = newSecondaryIPConfig("10.0.0."+strconv.Itoa(i), -1)
+		secondaryIPConfigs2[uuid.New().String()] = newSecondaryIPConfig("10.0.1."+strconv.Itoa(i), -1)
+	}
+	req1 := generateNetworkContainerRequest(secondaryIPConfigs1, "deleteNc1", "-1")
+	req2 := generateNetworkContainerRequest(secondaryIPConfigs2, "deleteNc2", "-1")
+	req2.IPConfiguration.IPSubnet.IPAddress = "10.0.1.5"
+	req2.IPConfiguration.GatewayIPAddress = "10.0.1.1"
+
+	// a pod of the second NC only
+	allocatedPods := map[string]cns.KubernetesPodInfo{
+		"10.0.1.7": {PodName: "deletePod1", PodNamespace: "PodNS1"},
+	}
+
+	returnCode := svc.ReconcileNCState([]cns.CreateNetworkContainerRequest{req1, req2}, allocatedPods, nil, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	if returnCode != Success {
+		t.Fatalf("Unexpected failure on reconcile with two NCs %d", returnCode)
+	}
+
+	if ncIDs := svc.GetNetworkContainerIDs(); !reflect.DeepEqual(ncIDs, []string{"deleteNc1", "deleteNc2"}) {
+		t.Fatalf("Expected both NCs in the state, actual %v", ncIDs)
+	}
+
+	// the NC with an allocated IP can't be deleted
+	if returnCode = svc.DeleteNetworkContainerInternal(req2.NetworkContainerid); returnCode != InconsistentIPConfigState {
+		t.Fatalf("Expected deleting NC with an allocated IP to fail with %d, actual %d", InconsistentIPConfigState, returnCode)
+	}
+
+	if returnCode = svc.DeleteNetworkContainerInternal(req1.NetworkContainerid); returnCode != Success {
+		t.Fatalf("Unexpected failure deleting NC %s: %d", req1.NetworkContainerid, returnCode)
+	}
+
+	if ncIDs := svc.GetNetworkContainerIDs(); !reflect.DeepEqual(ncIDs, []string{"deleteNc2"}) {
+		t.Fatalf("Expected only the NC with an allocated IP in the state, actual %v", ncIDs)
+	}
+
+	for ipId := range secondaryIPConfigs1 {
+		if ipConfigState, exists := svc.PodIPConfigState[ipId]; exists {
+			t.Fatalf("Expected the IPs of the deleted NC to be removed, actual %+v", ipConfigState)
+		}
+	}
+
+	if len(svc.PodIPConfigState) != len(secondaryIPConfigs2) {
+		t.Fatalf("Expected the IPs of the remaining NC to be kept, actual %+v", svc.PodIPConfigState)
+	}
+
+	// deleting an NC which doesn't exist is idempotent
+	if returnCode = svc.DeleteNetworkContainerInternal(req1.NetworkContainerid); returnCode != Success {
+		t.Fatalf("Expected deleting a deleted NC to succeed, actual %d", returnCode)
+	}
+}
+
 func setOrchestratorTypeInternal(orchestratorType string) {
 	fmt.Println("setOrchestratorTypeInternal")
 	svc.state.OrchestratorType = orchestratorType
@@ -473,6 +598,95 @@ func generateNetworkContainerRequest(secondaryIps map[string]cns.SecondaryIPConf
 	return req
 }
 
+func TestReconcileNCWithRestoredIPAMState(t *testing.T) {
+	restartService()
+	setEnv(t)
+	setOrchestratorTypeInternal(cns.KubernetesCRD)
+
+	secondaryIPConfigs := make(map[string]cns.SecondaryIPConfig)
+	for i := 6; i < 10; i++ {
+		secondaryIPConfigs[uuid.New().String()] = newSecondaryIPConfig("10.0.0."+strconv.Itoa(i), -1)
+	}
+	req := generateNetworkContainerRequest(secondaryIPConfigs, "reconcileNc1", "-1")
+
+	allocatedPods := map[string]cns.KubernetesPodInfo{
+		"10.0.0.6": {PodName: "reconcilePod1", PodNamespace: "PodNS1"},
+		"10.0.0.7": {PodName: "reconcilePod2", PodNamespace: "PodNS1"},
+		"10.0.0.8": {PodName: "reconcilePod4", PodNamespace: "PodNS1"},
+	}
+
+	returnCode := svc.ReconcileNCState([]cns.CreateNetworkContainerRequest{req}, allocatedPods, nil, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	if returnCode != Success {
+		t.Fatalf("Unexpected failure on reconcile %d", returnCode)
+	}
+
+	pendingReleasedIps, err := svc.MarkIPAsPendingRelease(1)
+	if err != nil || len(pendingReleasedIps) != 1 {
+		t.Fatalf("Failed to mark an IP as pending release, pendingReleasedIps: %+v, err: %v", pendingReleasedIps, err)
+	}
+
+	// a new service restores the state CNS persisted
+	var config common.ServiceConfig
+	if config.Store, err = store.NewJsonFileStore(cnsJsonFileName); err != nil {
+		t.Fatalf("Failed to create store, err: %v", err)
+	}
+	httpsvc, err := NewHTTPRestService(&config, fakes.NewFakeImdsClient(), fakes.NewFakeNMAgentClient())
+	if err != nil {
+		t.Fatalf("Failed to create service, err: %v", err)
+	}
+	restoredSvc := httpsvc.(*HTTPRestService)
+	restoredSvc.IPAMPoolMonitor = fakes.NewIPAMPoolMonitorFake()
+	restoredSvc.restoreState()
+	restoredSvc.restoreIPAMState()
+
+	if !reflect.DeepEqual(restoredSvc.PodIPConfigState, svc.PodIPConfigState) {
+		t.Fatalf("Restored PodIPConfigState is not same, expected: %+v, actual: %+v", svc.PodIPConfigState, restoredSvc.PodIPConfigState)
+	}
+	if !reflect.DeepEqual(restoredSvc.PodIPIDByOrchestratorContext, svc.PodIPIDByOrchestratorContext) {
+		t.Fatalf("Restored PodIPIDByOrchestratorContext is not same, expected: %+v, actual: %+v", svc.PodIPIDByOrchestratorContext, restoredSvc.PodIPIDByOrchestratorContext)
+	}
+
+	// reconcile releases the IP of reconcilePod1 which left the node, keeps the pending release IP and the IP
+	// of reconcilePod4 which is on the node without IP yet, and hands an IP restored as allocated to another pod
+	// over to the listed pod
+	podList := map[string]cns.KubernetesPodInfo{
+		"10.0.0.7": {PodName: "reconcilePod3", PodNamespace: "PodNS1"},
+	}
+	livePods := map[string]string{
+		"reconcilePod3:PodNS1": "uid-3",
+		"reconcilePod4:PodNS1": "uid-4",
+	}
+	returnCode = restoredSvc.ReconcileNCState([]cns.CreateNetworkContainerRequest{req}, podList, livePods, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+	if returnCode != Success {
+		t.Fatalf("Unexpected failure on reconcile with restored state %d", returnCode)
+	}
+
+	expectedAllocatedPods := map[string]cns.KubernetesPodInfo{
+		"10.0.0.7": podList["10.0.0.7"],
+		"10.0.0.8": allocatedPods["10.0.0.8"],
+	}
+	if len(restoredSvc.PodIPIDByOrchestratorContext) != len(expectedAllocatedPods) {
+		t.Fatalf("Unexpected allocated pods, actual: %+v, expected: %+v", restoredSvc.PodIPIDByOrchestratorContext, expectedAllocatedPods)
+	}
+	for ipaddress, podInfo := range expectedAllocatedPods {
+		key, _ := restoredSvc.findIPConfigKeyUntransacted(podInfo, cns.IPConfigRequest{})
+		ipConfigState := restoredSvc.PodIPConfigState[restoredSvc.PodIPIDByOrchestratorContext[key]]
+		if ipConfigState.State != cns.Allocated || ipConfigState.IPAddress != ipaddress {
+			t.Fatalf("IpAddress %s is not allocated to Pod: %+v, ipState: %+v", ipaddress, podInfo, ipConfigState)
+		}
+	}
+	for ipId := range pendingReleasedIps {
+		if restoredSvc.PodIPConfigState[ipId].State != cns.PendingRelease {
+			t.Fatalf("IPId: %s State is not PendingRelease after reconcile, ipStatus: %+v", ipId, restoredSvc.PodIPConfigState[ipId])
+		}
+	}
+	for _, ipConfigState := range restoredSvc.PodIPConfigState {
+		if ipConfigState.IPAddress == "10.0.0.6" && ipConfigState.State != cns.Available {
+			t.Fatalf("IpAddress 10.0.0.6 of a pod not on the node anymore is not released, ipState: %+v", ipConfigState)
+		}
+	}
+}
+
 func validateNCStateAfterReconcile(t *testing.T, ncRequest *cns.CreateNetworkContainerRequest, expectedNcCount int, expectedAllocatedPods map[string]cns.KubernetesPodInfo) {
 	if ncRequest == nil {
 		// check svc ContainerStatus will be empty
@@ -9,9 +9,11 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/metrics"
 )
 
 // used to request an IPConfig from the CNS state
@@ -22,6 +24,7 @@ func (service *HTTPRestService) requestIPConfigHandler(w http.ResponseWriter, r
 		podIpInfo       cns.PodIpInfo
 		returnCode      int
 		returnMessage   string
+		start           = time.Now()
 	)
 
 	err = service.Listener.Decode(w, r, &ipconfigRequest)
@@ -52,6 +55,7 @@ func (service *HTTPRestService) requestIPConfigHandler(w http.ResponseWriter, r
 
 	err = service.Listener.Encode(w, &reserveResp)
 	logger.ResponseEx(service.Name+operationName, ipconfigRequest, reserveResp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+	metrics.ObserveIPConfigRequest(start, ReturnCodeToString(resp.ReturnCode))
 }
 
 func (service *HTTPRestService) releaseIPConfigHandler(w http.ResponseWriter, r *http.Request) {
@@ -60,6 +64,7 @@ func (service *HTTPRestService) releaseIPConfigHandler(w http.ResponseWriter, r
 		statusCode    int
 		returnMessage string
 		err           error
+		start         = time.Now()
 	)
 
 	statusCode = UnexpectedError
@@ -75,6 +80,7 @@ func (service *HTTPRestService) releaseIPConfigHandler(w http.ResponseWriter, r
 
 		err = service.Listener.Encode(w, &resp)
 		logger.ResponseEx(service.Name, req, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+		metrics.ObserveIPConfigRelease(start, ReturnCodeToString(resp.ReturnCode))
 	}()
 
 	err = service.Listener.Decode(w, r, &req)
@@ -88,7 +94,7 @@ func (service *HTTPRestService) releaseIPConfigHandler(w http.ResponseWriter, r
 
 	podInfo, statusCode, returnMessage := service.validateIpConfigRequest(req)
 
-	if err = service.releaseIPConfig(podInfo); err != nil {
+	if err = service.releaseIPConfig(podInfo, req); err != nil {
 		statusCode = NotFound
 		returnMessage = err.Error()
 		logger.Errorf("releaseIPConfigHandler releaseIPConfig failed because %v, release IP config info %s", returnMessage, req)
@@ -98,46 +104,74 @@ func (service *HTTPRestService) releaseIPConfigHandler(w http.ResponseWriter, r
 }
 
 // MarkIPAsPendingRelease will set the IPs which are in PendingProgramming or Available to PendingRelease state
-// It will try to update [totalIpsToRelease]  number of ips.
+// It will try to update [totalIpsToRelease]  number of ips. The IPs are taken from the NCs with the most
+// releasable IPs first, so the pools of the NCs of the node shrink evenly.
 func (service *HTTPRestService) MarkIPAsPendingRelease(totalIpsToRelease int) (map[string]cns.IPConfigurationStatus, error) {
 	pendingReleasedIps := make(map[string]cns.IPConfigurationStatus)
 	service.Lock()
 	defer service.Unlock()
+	defer func() {
+		ipIDs := make([]string, 0, len(pendingReleasedIps))
+		for uuid := range pendingReleasedIps {
+			ipIDs = append(ipIDs, uuid)
+		}
+		service.journalIPConfigsUntransacted(ipIDs...)
+	}()
 
-	for uuid, existingIpConfig := range service.PodIPConfigState {
-		if existingIpConfig.State == cns.PendingProgramming {
-			updatedIpConfig, err := service.updateIPConfigState(uuid, cns.PendingRelease, existingIpConfig.OrchestratorContext)
-			if err != nil {
-				return nil, err
+	// PendingProgramming IPs are released before the Available ones
+	for _, state := range []string{cns.PendingProgramming, cns.Available} {
+		releasableIpIdsByNC := make(map[string][]string)
+		releasableIpCount := 0
+		for uuid, existingIpConfig := range service.PodIPConfigState {
+			if existingIpConfig.State == state {
+				releasableIpIdsByNC[existingIpConfig.NCID] = append(releasableIpIdsByNC[existingIpConfig.NCID], uuid)
+				releasableIpCount++
 			}
+		}
 
-			pendingReleasedIps[uuid] = updatedIpConfig
+		for ; releasableIpCount > 0; releasableIpCount-- {
 			if len(pendingReleasedIps) == totalIpsToRelease {
 				return pendingReleasedIps, nil
 			}
-		}
-	}
 
-	// if not all expected IPs are set to PendingRelease, then check the Available IPs
-	for uuid, existingIpConfig := range service.PodIPConfigState {
-		if existingIpConfig.State == cns.Available {
-			updatedIpConfig, err := service.updateIPConfigState(uuid, cns.PendingRelease, existingIpConfig.OrchestratorContext)
+			ncID := ncWithMostIpIds(releasableIpIdsByNC)
+			uuid := releasableIpIdsByNC[ncID][0]
+			releasableIpIdsByNC[ncID] = releasableIpIdsByNC[ncID][1:]
+
+			updatedIpConfig, err := service.updateIPConfigState(uuid, cns.PendingRelease, service.PodIPConfigState[uuid].OrchestratorContext)
 			if err != nil {
 				return nil, err
 			}
 
 			pendingReleasedIps[uuid] = updatedIpConfig
-
-			if len(pendingReleasedIps) == totalIpsToRelease {
-				return pendingReleasedIps, nil
-			}
 		}
 	}
 
+	if len(pendingReleasedIps) == totalIpsToRelease {
+		return pendingReleasedIps, nil
+	}
+
 	logger.Printf("[MarkIPAsPendingRelease] Set total ips to PendingRelease %d, expected %d", len(pendingReleasedIps), totalIpsToRelease)
 	return pendingReleasedIps, nil
 }
 
+// ncWithMostIpIds returns the NC with the most IP ids, the lowest NC id on a tie.
+func ncWithMostIpIds(ipIdsByNC map[string][]string) string {
+	var (
+		ncWithMost string
+		found      bool
+	)
+	for ncID, ipIds := range ipIdsByNC {
+		if !found || len(ipIds) > len(ipIdsByNC[ncWithMost]) ||
+			(len(ipIds) == len(ipIdsByNC[ncWithMost]) && ncID < ncWithMost) {
+			ncWithMost = ncID
+			found = true
+		}
+	}
+
+	return ncWithMost
+}
+
 func (service *HTTPRestService) updateIPConfigState(ipId string, updatedState string, orchestratorContext json.RawMessage) (cns.IPConfigurationStatus, error) {
 	if ipConfig, found := service.PodIPConfigState[ipId]; found {
 		logger.Printf("[updateIPConfigState] Changing IpId [%s] state to [%s], orchestratorContext [%s]. Current config [%+v]", ipId, updatedState, string(orchestratorContext), ipConfig)
@@ -164,6 +198,10 @@ func (service *HTTPRestService) MarkIpsAsAvailableUntransacted(ncID string, newH
 		}
 		// We only need to handle the situation when dnc nc version is larger than programmed nc version
 		if previousHostNCVersion < newHostNCVersion {
+			var availableIPIDs []string
+			defer func() {
+				service.journalIPConfigsUntransacted(availableIPIDs...)
+			}()
 			for uuid, secondaryIPConfigs := range ncInfo.CreateNetworkContainerRequest.SecondaryIPConfigs {
 				if ipConfigStatus, exist := service.PodIPConfigState[uuid]; !exist {
 					logger.Errorf("IP %s with uuid as %s exist in service state Secondary IP list but can't find in PodIPConfigState", ipConfigStatus.IPAddress, uuid)
@@ -171,6 +209,8 @@ func (service *HTTPRestService) MarkIpsAsAvailableUntransacted(ncID string, newH
 					_, err := service.updateIPConfigState(uuid, cns.Available, nil)
 					if err != nil {
 						logger.Errorf("Error updating IPConfig [%+v] state to Available, err: %+v", ipConfigStatus, err)
+					} else {
+						availableIPIDs = append(availableIPIDs, uuid)
 					}
 
 					// Following 2 sentence assign new host version to secondary ip config.
@@ -289,6 +329,22 @@ func (service *HTTPRestService) GetPendingReleaseIPConfigs() []cns.IPConfigurati
 	})
 }
 
+// getIPConfigCountsByNC returns the number of secondary IPs of each NC in each state
+func (service *HTTPRestService) getIPConfigCountsByNC() map[string]map[string]int {
+	service.RLock()
+	defer service.RUnlock()
+
+	countsByNC := make(map[string]map[string]int)
+	for _, ipConfig := range service.PodIPConfigState {
+		if _, exists := countsByNC[ipConfig.NCID]; !exists {
+			countsByNC[ipConfig.NCID] = make(map[string]int)
+		}
+		countsByNC[ipConfig.NCID][ipConfig.State]++
+	}
+
+	return countsByNC
+}
+
 func filterIPConfigMap(toBeAdded map[string]cns.IPConfigurationStatus, f func(cns.IPConfigurationStatus) bool) []cns.IPConfigurationStatus {
 	vsf := make([]cns.IPConfigurationStatus, 0)
 	for _, v := range toBeAdded {
@@ -299,55 +355,64 @@ func filterIPConfigMap(toBeAdded map[string]cns.IPConfigurationStatus, f func(cn
 	return vsf
 }
 
-//SetIPConfigAsAllocated takes a lock of the service, and sets the ipconfig in the CNS state as allocated, does not take a lock
-func (service *HTTPRestService) setIPConfigAsAllocated(ipconfig cns.IPConfigurationStatus, podInfo cns.KubernetesPodInfo, marshalledOrchestratorContext json.RawMessage) (cns.IPConfigurationStatus, error) {
-	ipconfig, err := service.updateIPConfigState(ipconfig.ID, cns.Allocated, marshalledOrchestratorContext)
+//SetIPConfigAsAllocated takes a lock of the service, and sets the ipconfig in the CNS state as allocated to the owner of req, does not take a lock
+func (service *HTTPRestService) setIPConfigAsAllocated(ipconfig cns.IPConfigurationStatus, podInfo cns.KubernetesPodInfo, req cns.IPConfigRequest) (cns.IPConfigurationStatus, error) {
+	ipconfig, err := service.updateIPConfigState(ipconfig.ID, cns.Allocated, req.OrchestratorContext)
 	if err != nil {
 		return cns.IPConfigurationStatus{}, err
 	}
 
-	service.PodIPIDByOrchestratorContext[podInfo.GetOrchestratorContextKey()] = ipconfig.ID
+	ipconfig.PodUID, ipconfig.ContainerID, ipconfig.InterfaceName = req.PodUID, req.ContainerID, req.InterfaceName
+	service.PodIPConfigState[ipconfig.ID] = ipconfig
+	service.PodIPIDByOrchestratorContext[ipConfigOwnerKey(podInfo, req.PodUID, req.ContainerID, req.InterfaceName)] = ipconfig.ID
+	service.journalIPConfigsUntransacted(ipconfig.ID)
 	return ipconfig, nil
 }
 
-//SetIPConfigAsAllocated and sets the ipconfig in the CNS state as allocated, does not take a lock
-func (service *HTTPRestService) setIPConfigAsAvailable(ipconfig cns.IPConfigurationStatus, podInfo cns.KubernetesPodInfo) (cns.IPConfigurationStatus, error) {
+//SetIPConfigAsAllocated and sets the ipconfig allocated under orchestratorContextKey in the CNS state as available, does not take a lock
+func (service *HTTPRestService) setIPConfigAsAvailable(ipconfig cns.IPConfigurationStatus, orchestratorContextKey string) (cns.IPConfigurationStatus, error) {
 	ipconfig, err := service.updateIPConfigState(ipconfig.ID, cns.Available, nil)
 	if err != nil {
 		return cns.IPConfigurationStatus{}, err
 	}
 
-	delete(service.PodIPIDByOrchestratorContext, podInfo.GetOrchestratorContextKey())
+	ipconfig.PodUID, ipconfig.ContainerID, ipconfig.InterfaceName = "", "", ""
+	service.PodIPConfigState[ipconfig.ID] = ipconfig
+	delete(service.PodIPIDByOrchestratorContext, orchestratorContextKey)
 	logger.Printf("[setIPConfigAsAvailable] Deleted outdated pod info %s from PodIPIDByOrchestratorContext since IP %s with ID %s will be released and set as Available",
-		podInfo.GetOrchestratorContextKey(), ipconfig.IPAddress, ipconfig.ID)
+		orchestratorContextKey, ipconfig.IPAddress, ipconfig.ID)
+	service.journalIPConfigsUntransacted(ipconfig.ID)
 	return ipconfig, nil
 }
 
 ////SetIPConfigAsAllocated takes a lock of the service, and sets the ipconfig in the CNS stateas Available
 // Todo - CNI should also pass the IPAddress which needs to be released to validate if that is the right IP allcoated
 // in the first place.
-func (service *HTTPRestService) releaseIPConfig(podInfo cns.KubernetesPodInfo) error {
+// A release of another pod or sandbox than the one the IP is allocated to, e.g. the late release of a recreated pod,
+// only releases the IP of the old pod or sandbox, see findIPConfigKeyUntransacted.
+func (service *HTTPRestService) releaseIPConfig(podInfo cns.KubernetesPodInfo, req cns.IPConfigRequest) error {
 	service.Lock()
 	defer service.Unlock()
 
-	ipID := service.PodIPIDByOrchestratorContext[podInfo.GetOrchestratorContextKey()]
-	if ipID != "" {
-		if ipconfig, isExist := service.PodIPConfigState[ipID]; isExist {
-			logger.Printf("[releaseIPConfig] Releasing IP %+v for pod %+v", ipconfig.IPAddress, podInfo)
-			_, err := service.setIPConfigAsAvailable(ipconfig, podInfo)
-			if err != nil {
-				return fmt.Errorf("[releaseIPConfig] failed to mark IPConfig [%+v] as Available. err: %v", ipconfig, err)
-			}
-			logger.Printf("[releaseIPConfig] Released IP %+v for pod %+v", ipconfig.IPAddress, podInfo)
-		} else {
-			logger.Errorf("[releaseIPConfig] Failed to get release ipconfig %+v and pod info is %+v. Pod to IPID exists, but IPID to IPConfig doesn't exist, CNS State potentially corrupt",
-				ipconfig.IPAddress, podInfo)
-			return fmt.Errorf("[releaseIPConfig] releaseIPConfig failed. IPconfig %+v and pod info is %+v. Pod to IPID exists, but IPID to IPConfig doesn't exist, CNS State potentially corrupt",
-				ipconfig.IPAddress, podInfo)
+	orchestratorContextKey, found := service.findIPConfigKeyUntransacted(podInfo, req)
+	if !found {
+		logger.Errorf("[releaseIPConfig] SetIPConfigAsAvailable failed to release, no allocation found for pod [%+v], request %s", podInfo, req)
+		return nil
+	}
+
+	ipID := service.PodIPIDByOrchestratorContext[orchestratorContextKey]
+	if ipconfig, isExist := service.PodIPConfigState[ipID]; isExist {
+		logger.Printf("[releaseIPConfig] Releasing IP %+v for pod %+v", ipconfig.IPAddress, podInfo)
+		_, err := service.setIPConfigAsAvailable(ipconfig, orchestratorContextKey)
+		if err != nil {
+			return fmt.Errorf("[releaseIPConfig] failed to mark IPConfig [%+v] as Available. err: %v", ipconfig, err)
 		}
+		logger.Printf("[releaseIPConfig] Released IP %+v for pod %+v", ipconfig.IPAddress, podInfo)
 	} else {
-		logger.Errorf("[releaseIPConfig] SetIPConfigAsAvailable failed to release, no allocation found for pod [%+v]", podInfo)
-		return nil
+		logger.Errorf("[releaseIPConfig] Failed to get release ipconfig %+v and pod info is %+v. Pod to IPID exists, but IPID to IPConfig doesn't exist, CNS State potentially corrupt",
+			ipconfig.IPAddress, podInfo)
+		return fmt.Errorf("[releaseIPConfig] releaseIPConfig failed. IPconfig %+v and pod info is %+v. Pod to IPID exists, but IPID to IPConfig doesn't exist, CNS State potentially corrupt",
+			ipconfig.IPAddress, podInfo)
 	}
 	return nil
 }
@@ -357,6 +422,11 @@ func (service *HTTPRestService) MarkExistingIPsAsPending(pendingIPIDs []string)
 	service.Lock()
 	defer service.Unlock()
 
+	var markedIPIDs []string
+	defer func() {
+		service.journalIPConfigsUntransacted(markedIPIDs...)
+	}()
+
 	for _, id := range pendingIPIDs {
 		if ipconfig, exists := service.PodIPConfigState[id]; exists {
 			if ipconfig.State == cns.Allocated {
@@ -366,14 +436,88 @@ func (service *HTTPRestService) MarkExistingIPsAsPending(pendingIPIDs []string)
 			logger.Printf("[MarkExistingIPsAsPending]: Marking IP [%+v] to PendingRelease", ipconfig)
 			ipconfig.State = cns.PendingRelease
 			service.PodIPConfigState[id] = ipconfig
+			markedIPIDs = append(markedIPIDs, id)
 		} else {
 			logger.Errorf("Inconsistent state, ipconfig with ID [%v] marked as pending release, but does not exist in state", id)
 		}
 	}
+
+	return nil
+}
+
+// releaseStaleAllocations releases the allocations restored from the store which conflict with the pod list:
+// the IP allocated to another pod, and any other IP allocated to the pod itself. podUID is the UID of the pod
+// in the pod list, empty when unknown. Called on reconcile before the IP of the pod is allocated again.
+func (service *HTTPRestService) releaseStaleAllocations(podInfo cns.KubernetesPodInfo, podUID string, ipAddress string) error {
+	service.Lock()
+	defer service.Unlock()
+
+	podKey := podInfo.GetOrchestratorContextKey()
+	for key, ipID := range service.PodIPIDByOrchestratorContext {
+		ipconfig, isExist := service.PodIPConfigState[ipID]
+		if !isExist {
+			continue
+		}
+
+		owner, err := ipConfigPodKey(ipconfig)
+		if err != nil {
+			logger.Errorf("[releaseStaleAllocations] %v", err)
+			continue
+		}
+
+		isPodIPConfig := owner == podKey && (ipconfig.PodUID == "" || podUID == "" || ipconfig.PodUID == podUID)
+		if isPodIPConfig == (ipconfig.IPAddress == ipAddress) {
+			continue
+		}
+
+		logger.Printf("[releaseStaleAllocations] Pod %+v with UID %s has IP %s, releasing the stale allocation %+v under %s", podInfo, podUID, ipAddress, ipconfig, key)
+		if _, err := service.setIPConfigAsAvailable(ipconfig, key); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (service *HTTPRestService) GetExistingIPConfig(podInfo cns.KubernetesPodInfo) (cns.PodIpInfo, bool, error) {
+// releaseDeadAllocations releases the IPConfigs allocated to pods missing from livePods, which holds the UID of every
+// pod of the node by orchestrator context key. An IPConfig allocated to an older pod UID, like the one kept by the old
+// instance of a recreated pod, is released too. A pod whose CNI ADD is in progress is listed before it has an IP,
+// so its allocation is kept. Nothing is released when livePods is nil.
+func (service *HTTPRestService) releaseDeadAllocations(livePods map[string]string) error {
+	if livePods == nil {
+		return nil
+	}
+
+	service.Lock()
+	defer service.Unlock()
+
+	for key, ipID := range service.PodIPIDByOrchestratorContext {
+		ipconfig, isExist := service.PodIPConfigState[ipID]
+		if !isExist || ipconfig.State != cns.Allocated {
+			continue
+		}
+
+		owner, err := ipConfigPodKey(ipconfig)
+		if err != nil {
+			logger.Errorf("[releaseDeadAllocations] %v", err)
+			continue
+		}
+
+		// an IP allocated for an older CNI, or with an unknown UID, belongs to any pod with the name
+		if uid, live := livePods[owner]; live && (ipconfig.PodUID == "" || uid == "" || ipconfig.PodUID == uid) {
+			continue
+		}
+
+		logger.Printf("[releaseDeadAllocations] Pod %s with UID %s is not on the node anymore, releasing its IP %+v", owner, ipconfig.PodUID, ipconfig)
+		if _, err := service.setIPConfigAsAvailable(ipconfig, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (service *HTTPRestService) GetExistingIPConfig(podInfo cns.KubernetesPodInfo, req cns.IPConfigRequest) (cns.PodIpInfo, bool, error) {
 	var (
 		podIpInfo cns.PodIpInfo
 		isExist   bool
@@ -382,8 +526,8 @@ func (service *HTTPRestService) GetExistingIPConfig(podInfo cns.KubernetesPodInf
 	service.RLock()
 	defer service.RUnlock()
 
-	ipID := service.PodIPIDByOrchestratorContext[podInfo.GetOrchestratorContextKey()]
-	if ipID != "" {
+	if key, found := service.findIPConfigKeyUntransacted(podInfo, req); found {
+		ipID := service.PodIPIDByOrchestratorContext[key]
 		if ipState, isExist := service.PodIPConfigState[ipID]; isExist {
 			err := service.populateIpConfigInfoUntransacted(ipState, &podIpInfo)
 			return podIpInfo, isExist, err
@@ -396,18 +540,18 @@ func (service *HTTPRestService) GetExistingIPConfig(podInfo cns.KubernetesPodInf
 	return podIpInfo, isExist, nil
 }
 
-func (service *HTTPRestService) AllocateDesiredIPConfig(podInfo cns.KubernetesPodInfo, desiredIPAddress string, orchestratorContext json.RawMessage) (cns.PodIpInfo, error) {
+func (service *HTTPRestService) AllocateDesiredIPConfig(podInfo cns.KubernetesPodInfo, req cns.IPConfigRequest) (cns.PodIpInfo, error) {
 	var podIpInfo cns.PodIpInfo
 	service.Lock()
 	defer service.Unlock()
 
 	found := false
 	for _, ipConfig := range service.PodIPConfigState {
-		if ipConfig.IPAddress == desiredIPAddress {
+		if ipConfig.IPAddress == req.DesiredIPAddress {
 			if ipConfig.State == cns.Allocated {
 				// This IP has already been allocated, if it is allocated to same pod, then return the same
 				// IPconfiguration
-				if bytes.Equal(orchestratorContext, ipConfig.OrchestratorContext) == true {
+				if bytes.Equal(req.OrchestratorContext, ipConfig.OrchestratorContext) == true && isIPConfigOwner(ipConfig, req) {
 					logger.Printf("[AllocateDesiredIPConfig]: IP Config [%+v] is already allocated to this Pod [%+v]", ipConfig, podInfo)
 					found = true
 				} else {
@@ -421,7 +565,7 @@ func (service *HTTPRestService) AllocateDesiredIPConfig(podInfo cns.KubernetesPo
 			} else if ipConfig.State == cns.Available || ipConfig.State == cns.PendingProgramming {
 				// This race can happen during restart, where CNS state is lost and thus we have lost the NC programmed version
 				// As part of reconcile, we mark IPs as Allocated which are already allocated to PODs (listed from APIServer)
-				_, err := service.setIPConfigAsAllocated(ipConfig, podInfo, orchestratorContext)
+				_, err := service.setIPConfigAsAllocated(ipConfig, podInfo, req)
 				if err != nil {
 					return podIpInfo, err
 				}
@@ -439,29 +583,46 @@ func (service *HTTPRestService) AllocateDesiredIPConfig(podInfo cns.KubernetesPo
 	return podIpInfo, fmt.Errorf("Requested IP not found in pool")
 }
 
-func (service *HTTPRestService) AllocateAnyAvailableIPConfig(podInfo cns.KubernetesPodInfo, orchestratorContext json.RawMessage) (cns.PodIpInfo, error) {
+// AllocateAnyAvailableIPConfig allocates an Available IP of the NC which has the most Available IPs left,
+// so the pods spread over the NCs of the node and none of their pools runs dry ahead of the others.
+func (service *HTTPRestService) AllocateAnyAvailableIPConfig(podInfo cns.KubernetesPodInfo, req cns.IPConfigRequest) (cns.PodIpInfo, error) {
 	var podIpInfo cns.PodIpInfo
 
 	service.Lock()
 	defer service.Unlock()
 
+	availableIPCountByNC := make(map[string]int)
+	availableIPStateByNC := make(map[string]cns.IPConfigurationStatus)
 	for _, ipState := range service.PodIPConfigState {
 		if ipState.State == cns.Available {
-			_, err := service.setIPConfigAsAllocated(ipState, podInfo, orchestratorContext)
-			if err != nil {
-				return podIpInfo, err
-			}
-
-			err = service.populateIpConfigInfoUntransacted(ipState, &podIpInfo)
-			if err != nil {
-				return podIpInfo, err
-			}
+			availableIPCountByNC[ipState.NCID]++
+			availableIPStateByNC[ipState.NCID] = ipState
+		}
+	}
 
-			return podIpInfo, err
+	var (
+		ipState cns.IPConfigurationStatus
+		found   bool
+	)
+	for ncID, count := range availableIPCountByNC {
+		if !found || count > availableIPCountByNC[ipState.NCID] ||
+			(count == availableIPCountByNC[ipState.NCID] && ncID < ipState.NCID) {
+			ipState = availableIPStateByNC[ncID]
+			found = true
 		}
 	}
 
-	return podIpInfo, fmt.Errorf("No more free IP's available, waiting on Azure CNS to allocated more IP's...")
+	if !found {
+		return podIpInfo, fmt.Errorf("No more free IP's available, waiting on Azure CNS to allocated more IP's...")
+	}
+
+	_, err := service.setIPConfigAsAllocated(ipState, podInfo, req)
+	if err != nil {
+		return podIpInfo, err
+	}
+
+	err = service.populateIpConfigInfoUntransacted(ipState, &podIpInfo)
+	return podIpInfo, err
 }
 
 // If IPConfig is already allocated for pod, it returns that else it returns one of the available ipconfigs.
@@ -476,15 +637,117 @@ func requestIPConfigHelper(service *HTTPRestService, req cns.IPConfigRequest) (c
 	// check if ipconfig already allocated for this pod and return if exists or error
 	// if error, ipstate is nil, if exists, ipstate is not nil and error is nil
 	json.Unmarshal(req.OrchestratorContext, &podInfo)
-	if podIpInfo, isExist, err = service.GetExistingIPConfig(podInfo); err != nil || isExist {
+
+	// an IPConfig allocated to a part of the owner of req only, e.g. on reconcile, gets the rest of it
+	service.claimIPConfig(podInfo, req)
+
+	if podIpInfo, isExist, err = service.GetExistingIPConfig(podInfo, req); err != nil || isExist {
 		return podIpInfo, err
 	}
 
 	// return desired IPConfig
 	if req.DesiredIPAddress != "" {
-		return service.AllocateDesiredIPConfig(podInfo, req.DesiredIPAddress, req.OrchestratorContext)
+		return service.AllocateDesiredIPConfig(podInfo, req)
 	}
 
 	// return any free IPConfig
-	return service.AllocateAnyAvailableIPConfig(podInfo, req.OrchestratorContext)
+	return service.AllocateAnyAvailableIPConfig(podInfo, req)
+}
+
+// claimIPConfig fills in the owner of the IPConfig allocated to req, see findIPConfigKeyUntransacted, with the parts
+// of the owner only req tells, and keys the IPConfig on the full owner.
+func (service *HTTPRestService) claimIPConfig(podInfo cns.KubernetesPodInfo, req cns.IPConfigRequest) {
+	service.Lock()
+	defer service.Unlock()
+
+	key, found := service.findIPConfigKeyUntransacted(podInfo, req)
+	if !found {
+		return
+	}
+
+	ipID := service.PodIPIDByOrchestratorContext[key]
+	ipconfig, isExist := service.PodIPConfigState[ipID]
+	if !isExist {
+		return
+	}
+
+	if ipconfig.PodUID == "" {
+		ipconfig.PodUID = req.PodUID
+	}
+	if ipconfig.ContainerID == "" {
+		ipconfig.ContainerID = req.ContainerID
+	}
+	if ipconfig.InterfaceName == "" {
+		ipconfig.InterfaceName = req.InterfaceName
+	}
+
+	ownerKey := ipConfigOwnerKey(podInfo, ipconfig.PodUID, ipconfig.ContainerID, ipconfig.InterfaceName)
+	if ownerKey == key {
+		return
+	}
+
+	logger.Printf("[claimIPConfig] IP %+v of pod %+v allocated under %s is claimed by the request %s", ipconfig, podInfo, key, req)
+	service.PodIPConfigState[ipID] = ipconfig
+	delete(service.PodIPIDByOrchestratorContext, key)
+	service.PodIPIDByOrchestratorContext[ownerKey] = ipID
+	service.journalIPConfigsUntransacted(ipID)
+}
+
+// findIPConfigKeyUntransacted returns the key of the IPConfig allocated to the pod, sandbox and interface of req.
+// An IPConfig allocated with a part of the owner only, on reconcile, which only knows the pod UID, or for an older
+// CNI, or a request telling a part of the owner only, like the release of a sandbox without the pod UID, falls back
+// to the IPConfigs of the pod whose owner matches req. The IPConfig of another sandbox or of an older instance of the
+// pod, with a different ContainerID or PodUID, is never returned.
+func (service *HTTPRestService) findIPConfigKeyUntransacted(podInfo cns.KubernetesPodInfo, req cns.IPConfigRequest) (string, bool) {
+	key := ipConfigOwnerKey(podInfo, req.PodUID, req.ContainerID, req.InterfaceName)
+	if _, exists := service.PodIPIDByOrchestratorContext[key]; exists {
+		return key, true
+	}
+
+	podKey := podInfo.GetOrchestratorContextKey()
+	for key, ipID := range service.PodIPIDByOrchestratorContext {
+		ipconfig, isExist := service.PodIPConfigState[ipID]
+		if !isExist || !isIPConfigOwner(ipconfig, req) {
+			continue
+		}
+
+		if owner, err := ipConfigPodKey(ipconfig); err == nil && owner == podKey {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// isIPConfigOwner returns true if the IPConfig is allocated to the pod, sandbox and interface of req.
+// An owner unknown on either side matches, as older CNI clients don't tell it.
+func isIPConfigOwner(ipconfig cns.IPConfigurationStatus, req cns.IPConfigRequest) bool {
+	ownerMatches := func(allocated, requested string) bool {
+		return allocated == "" || requested == "" || allocated == requested
+	}
+
+	return ownerMatches(ipconfig.PodUID, req.PodUID) &&
+		ownerMatches(ipconfig.ContainerID, req.ContainerID) &&
+		ownerMatches(ipconfig.InterfaceName, req.InterfaceName)
+}
+
+// ipConfigOwnerKey returns the key in PodIPIDByOrchestratorContext of the IPConfig allocated to the pod UID,
+// container ID and interface name. Older CNI clients tell neither the pod UID nor the container ID, their
+// IPConfigs are keyed on the pod name and namespace.
+func ipConfigOwnerKey(podInfo cns.KubernetesPodInfo, podUID, containerID, interfaceName string) string {
+	if podUID == "" && containerID == "" {
+		return podInfo.GetOrchestratorContextKey()
+	}
+
+	return podUID + ":" + containerID + ":" + interfaceName
+}
+
+// ipConfigPodKey returns the orchestrator context key of the pod the IPConfig is allocated to.
+func ipConfigPodKey(ipconfig cns.IPConfigurationStatus) (string, error) {
+	var owner cns.KubernetesPodInfo
+	if err := json.Unmarshal(ipconfig.OrchestratorContext, &owner); err != nil {
+		return "", fmt.Errorf("Failed to unmarshal the orchestrator context of IP %+v, err: %v", ipconfig, err)
+	}
+
+	return owner.GetOrchestratorContextKey(), nil
 }
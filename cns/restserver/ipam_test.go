@@ -4,10 +4,14 @@
 package restserver
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/cns"
@@ -41,6 +45,13 @@ var (
 
 	testIP4      = "10.0.0.4"
 	testPod4GUID = "718e04ac-5a13-4dce-84b3-040accaa9b42"
+
+	testNCID2  = "2f4f5c5e-9e0d-4b7c-8a55-3c2c4f9f6e11"
+	testNC2IPs = map[string]string{
+		"4a0bd9b4-9b9a-4d43-9f62-3f6f09b7c2a1": "10.1.0.6",
+		"4a0bd9b4-9b9a-4d43-9f62-3f6f09b7c2a2": "10.1.0.7",
+		"4a0bd9b4-9b9a-4d43-9f62-3f6f09b7c2a3": "10.1.0.8",
+	}
 )
 
 func getTestService() *HTTPRestService {
@@ -118,8 +129,8 @@ func requestIpAddressAndGetState(t *testing.T, req cns.IPConfigRequest) (cns.IPC
 		return ipState, err
 	}
 
-	ipId := svc.PodIPIDByOrchestratorContext[podInfo.GetOrchestratorContextKey()]
-	ipState = svc.PodIPConfigState[ipId]
+	key, _ := svc.findIPConfigKeyUntransacted(podInfo, req)
+	ipState = svc.PodIPConfigState[svc.PodIPIDByOrchestratorContext[key]]
 
 	return ipState, err
 }
@@ -399,7 +410,7 @@ func TestIPAMRequestThenReleaseThenRequestAgain(t *testing.T) {
 	}
 
 	// Release Test Pod 1
-	err = svc.releaseIPConfig(testPod1Info)
+	err = svc.releaseIPConfig(testPod1Info, cns.IPConfigRequest{})
 	if err != nil {
 		t.Fatalf("Unexpected failure releasing IP: %+v", err)
 	}
@@ -439,13 +450,13 @@ func TestIPAMReleaseIPIdempotency(t *testing.T) {
 	}
 
 	// Release Test Pod 1
-	err = svc.releaseIPConfig(testPod1Info)
+	err = svc.releaseIPConfig(testPod1Info, cns.IPConfigRequest{})
 	if err != nil {
 		t.Fatalf("Unexpected failure releasing IP: %+v", err)
 	}
 
 	// Call release again, should be fine
-	err = svc.releaseIPConfig(testPod1Info)
+	err = svc.releaseIPConfig(testPod1Info, cns.IPConfigRequest{})
 	if err != nil {
 		t.Fatalf("Unexpected failure releasing IP: %+v", err)
 	}
@@ -471,6 +482,282 @@ func TestIPAMAllocateIPIdempotency(t *testing.T) {
 	}
 }
 
+// newIPConfigRequestOfContainer returns the request of a CNI which tells the pod UID, container ID and interface name.
+func newIPConfigRequestOfContainer(t *testing.T, podInfo cns.KubernetesPodInfo, podUID, containerID string) cns.IPConfigRequest {
+	b, err := json.Marshal(podInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cns.IPConfigRequest{
+		OrchestratorContext: b,
+		PodUID:              podUID,
+		ContainerID:         containerID,
+		InterfaceName:       "eth0",
+	}
+}
+
+func TestIPAMRequestIPConfigForRecreatedPod(t *testing.T) {
+	svc := getTestService()
+
+	state1 := NewPodState(testIP1, 24, testPod1GUID, testNCID, cns.Available, 0)
+	state2 := NewPodState(testIP2, 24, testPod2GUID, testNCID, cns.Available, 0)
+	ipconfigs := map[string]cns.IPConfigurationStatus{
+		state1.ID: state1,
+		state2.ID: state2,
+	}
+	UpdatePodIpConfigState(t, svc, ipconfigs)
+
+	oldPodReq := newIPConfigRequestOfContainer(t, testPod1Info, "old-pod-uid", "old-container")
+	oldPodState, err := requestIpAddressAndGetState(t, oldPodReq)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed for the old pod: %v", err)
+	}
+
+	// the recreated pod requests its IP before the old pod is released
+	newPodReq := newIPConfigRequestOfContainer(t, testPod1Info, "new-pod-uid", "new-container")
+	newPodState, err := requestIpAddressAndGetState(t, newPodReq)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed for the recreated pod: %v", err)
+	}
+
+	if newPodState.IPAddress == oldPodState.IPAddress {
+		t.Fatalf("Expected the recreated pod to get a new IP, got the IP %s of the old pod", newPodState.IPAddress)
+	}
+
+	if newPodState.PodUID != newPodReq.PodUID || newPodState.ContainerID != newPodReq.ContainerID || newPodState.InterfaceName != newPodReq.InterfaceName {
+		t.Fatalf("Expected the IP to be allocated to the request %s, actual %+v", newPodReq, newPodState)
+	}
+
+	if svc.PodIPConfigState[oldPodState.ID].State != cns.Allocated {
+		t.Fatalf("Expected the IP of the old pod to stay allocated until released, actual %+v", svc.PodIPConfigState[oldPodState.ID])
+	}
+
+	// the late release of the old pod only frees the IP of the old pod
+	if err = svc.releaseIPConfig(testPod1Info, oldPodReq); err != nil {
+		t.Fatalf("Unexpected failure releasing IP of the old pod: %+v", err)
+	}
+
+	if svc.PodIPConfigState[oldPodState.ID].State != cns.Available {
+		t.Fatalf("Expected the IP of the old pod to be released, actual %+v", svc.PodIPConfigState[oldPodState.ID])
+	}
+
+	if svc.PodIPConfigState[newPodState.ID].State != cns.Allocated {
+		t.Fatalf("Expected the IP of the recreated pod to stay allocated, actual %+v", svc.PodIPConfigState[newPodState.ID])
+	}
+
+	// a retried request of the recreated pod is idempotent
+	actualstate, err := requestIpAddressAndGetState(t, newPodReq)
+	if err != nil || actualstate.ID != newPodState.ID {
+		t.Fatalf("Expected the retried request to get IP %+v, actual %+v, err: %v", newPodState, actualstate, err)
+	}
+
+	if err = svc.releaseIPConfig(testPod1Info, newPodReq); err != nil {
+		t.Fatalf("Unexpected failure releasing IP of the recreated pod: %+v", err)
+	}
+
+	if len(svc.PodIPIDByOrchestratorContext) != 0 {
+		t.Fatalf("Expected no allocations left, actual %+v", svc.PodIPIDByOrchestratorContext)
+	}
+}
+
+func TestIPAMReleaseIPConfigOfRecreatedSandbox(t *testing.T) {
+	svc := getTestService()
+
+	state1 := NewPodState(testIP1, 24, testPod1GUID, testNCID, cns.Available, 0)
+	state2 := NewPodState(testIP2, 24, testPod2GUID, testNCID, cns.Available, 0)
+	ipconfigs := map[string]cns.IPConfigurationStatus{
+		state1.ID: state1,
+		state2.ID: state2,
+	}
+	UpdatePodIpConfigState(t, svc, ipconfigs)
+
+	oldSandboxReq := newIPConfigRequestOfContainer(t, testPod1Info, "pod-uid", "old-container")
+	oldSandboxState, err := requestIpAddressAndGetState(t, oldSandboxReq)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed for the old sandbox: %v", err)
+	}
+
+	// a new sandbox of the same pod requests its IP before the old sandbox is released
+	newSandboxReq := newIPConfigRequestOfContainer(t, testPod1Info, "pod-uid", "new-container")
+	newSandboxState, err := requestIpAddressAndGetState(t, newSandboxReq)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed for the new sandbox: %v", err)
+	}
+
+	if newSandboxState.ID == oldSandboxState.ID || newSandboxState.ContainerID != newSandboxReq.ContainerID {
+		t.Fatalf("Expected the new sandbox to get a new IP, old sandbox %+v, actual %+v", oldSandboxState, newSandboxState)
+	}
+
+	// the late release of the old sandbox, without the pod UID, only frees the IP of the old sandbox
+	oldSandboxReq.PodUID = ""
+	if err = svc.releaseIPConfig(testPod1Info, oldSandboxReq); err != nil {
+		t.Fatalf("Unexpected failure releasing IP of the old sandbox: %+v", err)
+	}
+
+	if svc.PodIPConfigState[oldSandboxState.ID].State != cns.Available {
+		t.Fatalf("Expected the IP of the old sandbox to be released, actual %+v", svc.PodIPConfigState[oldSandboxState.ID])
+	}
+
+	if svc.PodIPConfigState[newSandboxState.ID].State != cns.Allocated {
+		t.Fatalf("Expected the IP of the new sandbox to stay allocated, actual %+v", svc.PodIPConfigState[newSandboxState.ID])
+	}
+
+	// a retried release of the old sandbox doesn't free the IP of the new one either
+	if err = svc.releaseIPConfig(testPod1Info, oldSandboxReq); err != nil {
+		t.Fatalf("Unexpected failure releasing IP of the old sandbox again: %+v", err)
+	}
+
+	if svc.PodIPConfigState[newSandboxState.ID].State != cns.Allocated {
+		t.Fatalf("Expected the IP of the new sandbox to stay allocated, actual %+v", svc.PodIPConfigState[newSandboxState.ID])
+	}
+
+	if err = svc.releaseIPConfig(testPod1Info, newSandboxReq); err != nil {
+		t.Fatalf("Unexpected failure releasing IP of the new sandbox: %+v", err)
+	}
+
+	if svc.PodIPConfigState[newSandboxState.ID].State != cns.Available {
+		t.Fatalf("Expected the IP of the new sandbox to be released, actual %+v", svc.PodIPConfigState[newSandboxState.ID])
+	}
+}
+
+func TestIPAMReleaseDeadAllocationsOfRecreatedPod(t *testing.T) {
+	svc := getTestService()
+
+	state1 := NewPodState(testIP1, 24, testPod1GUID, testNCID, cns.Available, 0)
+	state2 := NewPodState(testIP2, 24, testPod2GUID, testNCID, cns.Available, 0)
+	ipconfigs := map[string]cns.IPConfigurationStatus{
+		state1.ID: state1,
+		state2.ID: state2,
+	}
+	UpdatePodIpConfigState(t, svc, ipconfigs)
+
+	oldPodReq := newIPConfigRequestOfContainer(t, testPod1Info, "old-pod-uid", "old-container")
+	oldPodState, err := requestIpAddressAndGetState(t, oldPodReq)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed for the old pod: %v", err)
+	}
+
+	// the old pod is never released
+	newPodReq := newIPConfigRequestOfContainer(t, testPod1Info, "new-pod-uid", "new-container")
+	newPodState, err := requestIpAddressAndGetState(t, newPodReq)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed for the recreated pod: %v", err)
+	}
+
+	oldPodKey := ipConfigOwnerKey(testPod1Info, oldPodReq.PodUID, oldPodReq.ContainerID, oldPodReq.InterfaceName)
+	newPodKey := ipConfigOwnerKey(testPod1Info, newPodReq.PodUID, newPodReq.ContainerID, newPodReq.InterfaceName)
+	if svc.PodIPIDByOrchestratorContext[oldPodKey] != oldPodState.ID || svc.PodIPIDByOrchestratorContext[newPodKey] != newPodState.ID {
+		t.Fatalf("Expected the IPs of the old and the recreated pod under %s and %s, actual %+v", oldPodKey, newPodKey, svc.PodIPIDByOrchestratorContext)
+	}
+
+	livePods := map[string]string{
+		testPod1Info.GetOrchestratorContextKey(): newPodReq.PodUID,
+	}
+	if err = svc.releaseDeadAllocations(livePods); err != nil {
+		t.Fatalf("Unexpected failure releasing dead allocations: %+v", err)
+	}
+
+	if _, exists := svc.PodIPIDByOrchestratorContext[oldPodKey]; exists {
+		t.Fatalf("Expected the key %s of the old pod to be removed, actual %+v", oldPodKey, svc.PodIPIDByOrchestratorContext)
+	}
+
+	if svc.PodIPConfigState[oldPodState.ID].State != cns.Available {
+		t.Fatalf("Expected the IP of the old pod to be released, actual %+v", svc.PodIPConfigState[oldPodState.ID])
+	}
+
+	if svc.PodIPConfigState[newPodState.ID].State != cns.Allocated || svc.PodIPIDByOrchestratorContext[newPodKey] != newPodState.ID {
+		t.Fatalf("Expected the IP of the recreated pod to stay allocated, actual %+v", svc.PodIPConfigState[newPodState.ID])
+	}
+}
+
+func TestIPAMRequestIPConfigOfOlderCNI(t *testing.T) {
+	svc := getTestService()
+
+	// allocated for an older CNI, or on reconcile, so the owner is unknown
+	state1, _ := NewPodStateWithOrchestratorContext(testIP1, testPod1GUID, testNCID, cns.Allocated, 24, 0, testPod1Info)
+	state2 := NewPodState(testIP2, 24, testPod2GUID, testNCID, cns.Available, 0)
+	ipconfigs := map[string]cns.IPConfigurationStatus{
+		state1.ID: state1,
+		state2.ID: state2,
+	}
+	UpdatePodIpConfigState(t, svc, ipconfigs)
+
+	req := newIPConfigRequestOfContainer(t, testPod1Info, "pod-uid", "container")
+	actualstate, err := requestIpAddressAndGetState(t, req)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed: %v", err)
+	}
+
+	if actualstate.ID != state1.ID || actualstate.PodUID != req.PodUID || actualstate.ContainerID != req.ContainerID {
+		t.Fatalf("Expected the request to adopt IP %+v, actual %+v", state1, actualstate)
+	}
+
+	// a release of an older CNI still frees the IP
+	if err = svc.releaseIPConfig(testPod1Info, cns.IPConfigRequest{OrchestratorContext: req.OrchestratorContext}); err != nil {
+		t.Fatalf("Unexpected failure releasing IP: %+v", err)
+	}
+
+	if svc.PodIPConfigState[state1.ID].State != cns.Available || svc.PodIPConfigState[state1.ID].PodUID != "" {
+		t.Fatalf("Expected the IP to be released, actual %+v", svc.PodIPConfigState[state1.ID])
+	}
+}
+
+func TestIPAMRequestIPConfigAllocatedOnReconcile(t *testing.T) {
+	svc := getTestService()
+
+	state1 := NewPodState(testIP1, 24, testPod1GUID, testNCID, cns.Available, 0)
+	state2 := NewPodState(testIP2, 24, testPod2GUID, testNCID, cns.Available, 0)
+	ipconfigs := map[string]cns.IPConfigurationStatus{
+		state1.ID: state1,
+		state2.ID: state2,
+	}
+	UpdatePodIpConfigState(t, svc, ipconfigs)
+
+	// reconcile knows the pod UID from the pod list, but not the sandbox
+	reconcileReq := newIPConfigRequestOfContainer(t, testPod1Info, "pod-uid", "")
+	reconcileReq.InterfaceName = ""
+	reconcileReq.DesiredIPAddress = testIP1
+	if _, err := requestIPConfigHelper(svc, reconcileReq); err != nil {
+		t.Fatalf("Expected IP allocation on reconcile to succeed: %v", err)
+	}
+
+	// a request of a recreated pod with the same name doesn't get the IP
+	newPodReq := newIPConfigRequestOfContainer(t, testPod1Info, "new-pod-uid", "new-container")
+	newPodState, err := requestIpAddressAndGetState(t, newPodReq)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed for the recreated pod: %v", err)
+	}
+
+	if newPodState.ID == state1.ID {
+		t.Fatalf("Expected the recreated pod to get a new IP, got the IP %+v allocated on reconcile", newPodState)
+	}
+
+	// the request of the sandbox of the pod claims the IP allocated on reconcile
+	req := newIPConfigRequestOfContainer(t, testPod1Info, "pod-uid", "container")
+	actualstate, err := requestIpAddressAndGetState(t, req)
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed: %v", err)
+	}
+
+	if actualstate.ID != state1.ID || actualstate.PodUID != req.PodUID || actualstate.ContainerID != req.ContainerID || actualstate.InterfaceName != req.InterfaceName {
+		t.Fatalf("Expected the request to claim IP %+v, actual %+v", state1, actualstate)
+	}
+
+	key := ipConfigOwnerKey(testPod1Info, req.PodUID, req.ContainerID, req.InterfaceName)
+	if svc.PodIPIDByOrchestratorContext[key] != state1.ID {
+		t.Fatalf("Expected the IP to be keyed on %s, actual %+v", key, svc.PodIPIDByOrchestratorContext)
+	}
+
+	if err = svc.releaseIPConfig(testPod1Info, req); err != nil {
+		t.Fatalf("Unexpected failure releasing IP: %+v", err)
+	}
+
+	if svc.PodIPConfigState[state1.ID].State != cns.Available || svc.PodIPConfigState[newPodState.ID].State != cns.Allocated {
+		t.Fatalf("Expected only the claimed IP to be released, actual %+v", svc.PodIPConfigState)
+	}
+}
+
 func TestAvailableIPConfigs(t *testing.T) {
 	svc := getTestService()
 
@@ -575,7 +862,7 @@ func TestIPAMMarkIPCountAsPending(t *testing.T) {
 	}
 
 	// Call release again, should be fine
-	err = svc.releaseIPConfig(testPod1Info)
+	err = svc.releaseIPConfig(testPod1Info, cns.IPConfigRequest{})
 	if err != nil {
 		t.Fatalf("Unexpected failure releasing IP: %+v", err)
 	}
@@ -635,7 +922,7 @@ func TestIPAMMarkIPAsPendingWithPendingProgrammingIPs(t *testing.T) {
 	}
 
 	// Call release again, should be fine
-	err = svc.releaseIPConfig(testPod1Info)
+	err = svc.releaseIPConfig(testPod1Info, cns.IPConfigRequest{})
 	if err != nil {
 		t.Fatalf("Unexpected failure releasing IP: %+v", err)
 	}
@@ -660,6 +947,99 @@ func TestIPAMMarkIPAsPendingWithPendingProgrammingIPs(t *testing.T) {
 	}
 }
 
+// createTwoNCs creates NC testNCID with testIP1 and NC testNCID2 with the testNC2IPs, all Available
+func createTwoNCs(t *testing.T, svc *HTTPRestService) cns.CreateNetworkContainerRequest {
+	secondaryIPConfigs1 := make(map[string]cns.SecondaryIPConfig)
+	constructSecondaryIPConfigs(testIP1, testPod1GUID, -1, secondaryIPConfigs1)
+	req1 := generateNetworkContainerRequest(secondaryIPConfigs1, testNCID, "-1")
+
+	secondaryIPConfigs2 := make(map[string]cns.SecondaryIPConfig)
+	for uuid, ipAddress := range testNC2IPs {
+		constructSecondaryIPConfigs(ipAddress, uuid, -1, secondaryIPConfigs2)
+	}
+	req2 := generateNetworkContainerRequest(secondaryIPConfigs2, testNCID2, "-1")
+	req2.IPConfiguration.IPSubnet.IPAddress = "10.1.0.5"
+	req2.IPConfiguration.GatewayIPAddress = "10.1.0.1"
+
+	for _, req := range []cns.CreateNetworkContainerRequest{req1, req2} {
+		returnCode := svc.CreateOrUpdateNetworkContainerInternal(req, fakes.NewFakeScalar(releasePercent, requestPercent, batchSize), fakes.NewFakeNodeNetworkConfigSpec(initPoolSize))
+		if returnCode != 0 {
+			t.Fatalf("Failed to createNetworkContainerRequest, req: %+v, err: %d", req, returnCode)
+		}
+	}
+
+	return req2
+}
+
+func TestIPAMAllocateIPConfigAcrossNCs(t *testing.T) {
+	svc := getTestService()
+	req2 := createTwoNCs(t, svc)
+
+	// the second NC has the most Available IPs
+	b, _ := json.Marshal(testPod1Info)
+	podIpInfo, err := requestIPConfigHelper(svc, cns.IPConfigRequest{OrchestratorContext: b})
+	if err != nil {
+		t.Fatalf("Expected IP retrieval to succeed: %+v", err)
+	}
+
+	if _, exists := req2.SecondaryIPConfigs[svc.PodIPIDByOrchestratorContext[testPod1Info.GetOrchestratorContextKey()]]; !exists {
+		t.Fatalf("Expected an IP of NC %s, got %+v", testNCID2, podIpInfo.PodIPConfig)
+	}
+
+	if !reflect.DeepEqual(podIpInfo.NetworkContainerPrimaryIPConfig, req2.IPConfiguration) {
+		t.Fatalf("Expected the IPConfiguration of NC %s %+v, got %+v", testNCID2, req2.IPConfiguration, podIpInfo.NetworkContainerPrimaryIPConfig)
+	}
+
+	// the remaining IPs of both NCs are allocated before the pool runs dry
+	for i := 0; i < len(testNC2IPs); i++ {
+		podInfo := cns.KubernetesPodInfo{PodName: "acrossncs" + strconv.Itoa(i), PodNamespace: "acrossncsnamespace"}
+		b, _ = json.Marshal(podInfo)
+		if _, err = requestIPConfigHelper(svc, cns.IPConfigRequest{OrchestratorContext: b}); err != nil {
+			t.Fatalf("Expected IP retrieval %d to succeed: %+v", i, err)
+		}
+	}
+
+	if available := svc.GetAvailableIPConfigs(); len(available) != 0 {
+		t.Fatalf("Expected the IPs of both NCs to be allocated, available: %+v", available)
+	}
+
+	b, _ = json.Marshal(testPod2Info)
+	if _, err = requestIPConfigHelper(svc, cns.IPConfigRequest{OrchestratorContext: b}); err == nil {
+		t.Fatalf("Expected IP retrieval to fail when the IPs of all NCs are allocated")
+	}
+}
+
+func TestIPAMMarkIPAsPendingReleaseAcrossNCs(t *testing.T) {
+	svc := getTestService()
+	createTwoNCs(t, svc)
+
+	// the second NC has 3 Available IPs against 1, it releases first
+	ips, err := svc.MarkIPAsPendingRelease(2)
+	if err != nil || len(ips) != 2 {
+		t.Fatalf("Expected 2 IPs marked as pending, got %+v, err: %v", ips, err)
+	}
+
+	for uuid, ipConfig := range ips {
+		if ipConfig.NCID != testNCID2 || ipConfig.State != cns.PendingRelease {
+			t.Fatalf("Expected IP %s marked as pending of NC %s, got %+v", uuid, testNCID2, ipConfig)
+		}
+	}
+
+	// both NCs have 1 Available IP left
+	ips, err = svc.MarkIPAsPendingRelease(2)
+	if err != nil || len(ips) != 2 {
+		t.Fatalf("Expected 2 IPs marked as pending, got %+v, err: %v", ips, err)
+	}
+
+	if _, exists := ips[testPod1GUID]; !exists {
+		t.Fatalf("Expected the IP of NC %s marked as pending, got %+v", testNCID, ips)
+	}
+
+	if available := svc.GetAvailableIPConfigs(); len(available) != 0 {
+		t.Fatalf("Expected no Available IPs left, got %+v", available)
+	}
+}
+
 func constructSecondaryIPConfigs(ipAddress, uuid string, ncVersion int, secondaryIPConfigs map[string]cns.SecondaryIPConfig) {
 	secIpConfig := cns.SecondaryIPConfig{
 		IPAddress: ipAddress,
@@ -709,3 +1089,50 @@ func TestIPAMMarkExistingIPConfigAsPending(t *testing.T) {
 		t.Fatalf("Expected to see ID %v in pending release ipconfigs, actual %+v", testPod1GUID, allocatedIPConfigs)
 	}
 }
+
+func TestIPAMMetrics(t *testing.T) {
+	restartService()
+	setOrchestratorTypeInternal(cns.KubernetesCRD)
+
+	state1 := NewPodState(testIP1, 24, testPod1GUID, testNCID, cns.Available, 0)
+	state2 := NewPodState(testIP2, 24, testPod2GUID, testNCID, cns.Available, 0)
+	ipconfigs := map[string]cns.IPConfigurationStatus{
+		state1.ID: state1,
+		state2.ID: state2,
+	}
+	if err := UpdatePodIpConfigState(t, svc, ipconfigs); err != nil {
+		t.Fatalf("Expected to not fail adding IP's to state: %+v", err)
+	}
+
+	b, _ := json.Marshal(testPod1Info)
+	body := new(bytes.Buffer)
+	json.NewEncoder(body).Encode(cns.IPConfigRequest{OrchestratorContext: b})
+	req, err := http.NewRequest(http.MethodPost, cns.RequestIPConfig, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	req, err = http.NewRequest(http.MethodGet, cns.MetricsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected metrics to be served, status code %d", w.Code)
+	}
+
+	for _, expected := range []string{
+		`cns_ipconfigs{nc_id="` + testNCID + `",state="Allocated"} 1`,
+		`cns_ipconfigs{nc_id="` + testNCID + `",state="Available"} 1`,
+		`cns_ipconfigs{nc_id="` + testNCID + `",state="PendingRelease"} 0`,
+		`cns_ipconfig_request_latency_seconds_count{return_code="Success"}`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("Expected metrics to contain %s, actual:\n%s", expected, w.Body.String())
+		}
+	}
+}
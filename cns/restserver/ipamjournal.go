@@ -0,0 +1,127 @@
+// Copyright 2020 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/store"
+)
+
+// ipamJournal holds the IPConfigs changed by the IP config state transitions since the IPAM state was last written
+// to the store. A transition appends the IPConfigs it changed to the journal rather than rewriting the whole store.
+// restoreIPAMState replays the journal over the IPAM state of the store, and saveIPAMStateUntransacted empties it.
+type ipamJournal struct {
+	fileName string
+	file     *os.File
+	entries  int
+}
+
+// ipamJournalEntry is an IPConfig after a transition, with the keys it is allocated under in
+// PodIPIDByOrchestratorContext. IPConfig is nil once the IPConfig was removed.
+type ipamJournalEntry struct {
+	ID       string
+	IPConfig *cns.IPConfigurationStatus
+	Keys     []string
+}
+
+// newIPAMJournal returns the journal of the IPAM state written to kvs, kept in a file next to the file of kvs.
+func newIPAMJournal(kvs store.KeyValueStore) *ipamJournal {
+	storeFileName := strings.TrimSuffix(kvs.GetLockFileName(), ".lock")
+	return &ipamJournal{
+		fileName: strings.TrimSuffix(storeFileName, filepath.Ext(storeFileName)) + ipamJournalSuffix,
+	}
+}
+
+// append writes the entries at the end of the journal.
+func (journal *ipamJournal) append(entries []ipamJournalEntry) error {
+	if journal.file == nil {
+		file, err := os.OpenFile(journal.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		journal.file = file
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if _, err := journal.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	journal.entries += len(entries)
+	return nil
+}
+
+// replay applies the entries of the journal to state and returns how many it applied. It stops at the first entry
+// it can't decode, CNS may have stopped while writing it.
+func (journal *ipamJournal) replay(state *ipamState) (int, error) {
+	file, err := os.Open(journal.fileName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for applied := 0; ; applied++ {
+		var entry ipamJournalEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return applied, nil
+		} else if err != nil {
+			return applied, fmt.Errorf("failed to decode entry %d of %s: %v", applied+1, journal.fileName, err)
+		}
+
+		entry.apply(state)
+	}
+}
+
+// truncate empties the journal, once the IPAM state is written to the store.
+func (journal *ipamJournal) truncate() error {
+	if journal.file != nil {
+		journal.file.Close()
+		journal.file = nil
+	}
+
+	journal.entries = 0
+	if err := os.Remove(journal.fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// apply sets the IPConfig of the entry and its keys in state, replacing the previous ones.
+func (entry ipamJournalEntry) apply(state *ipamState) {
+	for key, ipID := range state.PodIPIDByOrchestratorContext {
+		if ipID == entry.ID {
+			delete(state.PodIPIDByOrchestratorContext, key)
+		}
+	}
+
+	if entry.IPConfig == nil {
+		delete(state.PodIPConfigState, entry.ID)
+		return
+	}
+
+	state.PodIPConfigState[entry.ID] = *entry.IPConfig
+	for _, key := range entry.Keys {
+		state.PodIPIDByOrchestratorContext[key] = entry.ID
+	}
+}
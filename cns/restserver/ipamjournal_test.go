@@ -0,0 +1,123 @@
+// Copyright 2020 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/common"
+	"github.com/Azure/azure-container-networking/cns/fakes"
+	"github.com/Azure/azure-container-networking/store"
+)
+
+// newServiceWithStore returns a service persisting its state to a store in dir.
+func newServiceWithStore(t *testing.T, dir string) *HTTPRestService {
+	var (
+		config common.ServiceConfig
+		err    error
+	)
+	if config.Store, err = store.NewJsonFileStore(filepath.Join(dir, cnsJsonFileName)); err != nil {
+		t.Fatalf("Failed to create store, err: %v", err)
+	}
+
+	httpsvc, err := NewHTTPRestService(&config, fakes.NewFakeImdsClient(), fakes.NewFakeNMAgentClient())
+	if err != nil {
+		t.Fatalf("Failed to create service, err: %v", err)
+	}
+
+	return httpsvc.(*HTTPRestService)
+}
+
+func TestIPAMJournalRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cns-ipam-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	service := newServiceWithStore(t, dir)
+	state1 := NewPodState(testIP1, 24, testPod1GUID, testNCID, cns.Available, 0)
+	state2 := NewPodState(testIP2, 24, testPod2GUID, testNCID, cns.Available, 0)
+	service.PodIPConfigState[state1.ID] = state1
+	service.PodIPConfigState[state2.ID] = state2
+	if err = service.saveIPAMStateUntransacted(); err != nil {
+		t.Fatalf("Failed to save IPAM state, err: %v", err)
+	}
+
+	// the transitions are journaled, the store keeps the state saved above
+	req := newIPConfigRequestOfContainer(t, testPod1Info, "pod-uid", "container")
+	if _, err = service.setIPConfigAsAllocated(state1, testPod1Info, req); err != nil {
+		t.Fatalf("Failed to allocate IP, err: %v", err)
+	}
+	if _, err = service.MarkIPAsPendingRelease(1); err != nil {
+		t.Fatalf("Failed to mark an IP as pending release, err: %v", err)
+	}
+
+	if service.ipamJournal.entries != 2 {
+		t.Fatalf("Expected 2 journaled IPConfigs, actual %d", service.ipamJournal.entries)
+	}
+
+	// CNS stopped while writing the next entry
+	file, err := os.OpenFile(service.ipamJournal.fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"ID":"` + state1.ID + `","IPConf`)
+	file.Close()
+
+	restoredService := newServiceWithStore(t, dir)
+	restoredService.restoreIPAMState()
+
+	if !reflect.DeepEqual(restoredService.PodIPConfigState, service.PodIPConfigState) {
+		t.Fatalf("Restored PodIPConfigState is not same, expected: %+v, actual: %+v", service.PodIPConfigState, restoredService.PodIPConfigState)
+	}
+	if !reflect.DeepEqual(restoredService.PodIPIDByOrchestratorContext, service.PodIPIDByOrchestratorContext) {
+		t.Fatalf("Restored PodIPIDByOrchestratorContext is not same, expected: %+v, actual: %+v", service.PodIPIDByOrchestratorContext, restoredService.PodIPIDByOrchestratorContext)
+	}
+
+	// the restored state is saved, so the journal starts over
+	if _, err = os.Stat(restoredService.ipamJournal.fileName); !os.IsNotExist(err) {
+		t.Fatalf("Expected the journal to be emptied after restore, err: %v", err)
+	}
+}
+
+func TestIPAMJournalSavesStateWhenFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cns-ipam-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	service := newServiceWithStore(t, dir)
+	state1 := NewPodState(testIP1, 24, testPod1GUID, testNCID, cns.Available, 0)
+	service.PodIPConfigState[state1.ID] = state1
+
+	for i := 0; i < ipamJournalMaxEntries; i++ {
+		if err = service.journalIPConfigsUntransacted(state1.ID); err != nil {
+			t.Fatalf("Failed to journal IPConfig, err: %v", err)
+		}
+	}
+
+	if service.ipamJournal.entries != ipamJournalMaxEntries {
+		t.Fatalf("Expected %d journaled IPConfigs, actual %d", ipamJournalMaxEntries, service.ipamJournal.entries)
+	}
+
+	if err = service.journalIPConfigsUntransacted(state1.ID); err != nil {
+		t.Fatalf("Failed to journal IPConfig, err: %v", err)
+	}
+
+	if service.ipamJournal.entries != 0 {
+		t.Fatalf("Expected the full journal to be emptied, actual %d entries", service.ipamJournal.entries)
+	}
+
+	var state ipamState
+	if err = service.store.Read(ipamStoreKey, &state); err != nil || state.PodIPConfigState[state1.ID].IPAddress != testIP1 {
+		t.Fatalf("Expected the IPAM state to be saved, actual %+v, err: %v", state, err)
+	}
+}
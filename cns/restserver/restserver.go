@@ -13,6 +13,7 @@ import (
 	"github.com/Azure/azure-container-networking/cns/imdsclient"
 	"github.com/Azure/azure-container-networking/cns/ipamclient"
 	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/metrics"
 	"github.com/Azure/azure-container-networking/cns/networkcontainers"
 	"github.com/Azure/azure-container-networking/cns/nmagentclient"
 	"github.com/Azure/azure-container-networking/cns/routes"
@@ -41,12 +42,13 @@ type HTTPRestService struct {
 	ipamClient                   *ipamclient.IpamClient
 	nmagentClient                nmagentclient.NMAgentClientInterface
 	networkContainer             *networkcontainers.NetworkContainers
-	PodIPIDByOrchestratorContext map[string]string                    // OrchestratorContext is key and value is Pod IP uuid.
+	PodIPIDByOrchestratorContext map[string]string                    // owner of the IP is key, see ipConfigOwnerKey, and value is Pod IP uuid.
 	PodIPConfigState             map[string]cns.IPConfigurationStatus // seondaryipid(uuid) is key
 	AllocatedIPCount             map[string]allocatedIPCount          // key - ncid
 	IPAMPoolMonitor              cns.IPAMPoolMonitor
 	routingTable                 *routes.RoutingTable
 	store                        store.KeyValueStore
+	ipamJournal                  *ipamJournal
 	state                        *httpRestServiceState
 	sync.RWMutex
 	dncPartitionKey string
@@ -79,6 +81,14 @@ type httpRestServiceState struct {
 	joinedNetworks                   map[string]struct{}
 }
 
+// ipamState is the IPAM state we persist, along with the journal of the IP config state transitions,
+// so a restarted CNS knows the IPs it allocated and the IPs pending release.
+type ipamState struct {
+	PodIPConfigState             map[string]cns.IPConfigurationStatus
+	PodIPIDByOrchestratorContext map[string]string
+	TimeStamp                    time.Time
+}
+
 type networkInfo struct {
 	NetworkName string
 	NicInfo     *imdsclient.InterfaceInfo
@@ -114,9 +124,15 @@ func NewHTTPRestService(config *common.ServiceConfig, imdsClientInterface imdscl
 	podIPConfigState := make(map[string]cns.IPConfigurationStatus)
 	allocatedIPCount := make(map[string]allocatedIPCount) // key - ncid
 
+	var journal *ipamJournal
+	if service.Service.Store != nil {
+		journal = newIPAMJournal(service.Service.Store)
+	}
+
 	return &HTTPRestService{
 		Service:                      service,
 		store:                        service.Service.Store,
+		ipamJournal:                  journal,
 		dockerClient:                 dc,
 		imdsClient:                   imdsClient,
 		ipamClient:                   ic,
@@ -139,6 +155,7 @@ func (service *HTTPRestService) Init(config *common.ServiceConfig) error {
 	}
 
 	service.restoreState()
+	service.restoreIPAMState()
 	err = service.restoreNetworkState()
 	if err != nil {
 		logger.Errorf("[Azure CNS]  Failed to restore network state, err:%v.", err)
@@ -174,6 +191,7 @@ func (service *HTTPRestService) Init(config *common.ServiceConfig) error {
 	listener.AddHandler(cns.ReleaseIPConfig, service.releaseIPConfigHandler)
 	listener.AddHandler(cns.NmAgentSupportedApisPath, service.nmAgentSupportedApisHandler)
 	listener.AddHandler(cns.GetIPAddresses, service.getIPAddressesHandler)
+	listener.AddHandler(cns.MetricsPath, metrics.GetHandler().ServeHTTP)
 
 	// handlers for v0.2
 	listener.AddHandler(cns.V2Prefix+cns.SetEnvironmentPath, service.setEnvironment)
@@ -198,6 +216,9 @@ func (service *HTTPRestService) Init(config *common.ServiceConfig) error {
 	listener.AddHandler(cns.V2Prefix+cns.DeleteHostNCApipaEndpointPath, service.deleteHostNCApipaEndpoint)
 	listener.AddHandler(cns.V2Prefix+cns.NmAgentSupportedApisPath, service.nmAgentSupportedApisHandler)
 
+	// the metrics count the IPs of the NCs when they are scraped
+	metrics.SetIPConfigCountsSource(service.getIPConfigCountsByNC)
+
 	// Initialize HTTP client to be reused in CNS
 	connectionTimeout, _ := service.GetOption(acn.OptHttpConnectionTimeout).(int)
 	responseHeaderTimeout, _ := service.GetOption(acn.OptHttpResponseHeaderTimeout).(int)
@@ -4,6 +4,7 @@
 package restserver
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -15,6 +16,7 @@ import (
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/dockerclient"
 	"github.com/Azure/azure-container-networking/cns/logger"
+	"github.com/Azure/azure-container-networking/cns/metrics"
 	"github.com/Azure/azure-container-networking/cns/networkcontainers"
 	"github.com/Azure/azure-container-networking/cns/nmagentclient"
 	acn "github.com/Azure/azure-container-networking/common"
@@ -101,6 +103,149 @@ func (service *HTTPRestService) restoreState() {
 	return
 }
 
+// saveIPAMStateUntransacted writes the IP config states and the pods they are allocated to, to persistent store,
+// and empties the journal. The IP config state transitions journal the IPConfigs they change instead, see
+// journalIPConfigsUntransacted, the whole state is only written when the NCs change or the journal is full.
+// Note: this func is an untransacted API as the caller will take a Service lock
+func (service *HTTPRestService) saveIPAMStateUntransacted() error {
+	// Skip if a store is not provided.
+	if service.store == nil {
+		return nil
+	}
+
+	err := service.store.Write(ipamStoreKey, &ipamState{
+		PodIPConfigState:             service.PodIPConfigState,
+		PodIPIDByOrchestratorContext: service.PodIPIDByOrchestratorContext,
+		TimeStamp:                    time.Now(),
+	})
+	if err == nil {
+		err = service.ipamJournal.truncate()
+	}
+
+	if err != nil {
+		logger.Errorf("[Azure CNS]  Failed to save IPAM state, err:%v", err)
+		metrics.IPAMStateSaveErrors.Inc()
+	}
+
+	return err
+}
+
+// journalIPConfigsUntransacted writes the IPConfigs with ipIDs, changed by an IP config state transition, and the
+// keys they are allocated under to the journal of the IPAM state. Once the journal is full, or when it can't be
+// written, the whole IPAM state is saved instead.
+// Note: this func is an untransacted API as the caller will take a Service lock
+func (service *HTTPRestService) journalIPConfigsUntransacted(ipIDs ...string) error {
+	// Skip if a store is not provided.
+	if service.store == nil || len(ipIDs) == 0 {
+		return nil
+	}
+
+	if service.ipamJournal.entries+len(ipIDs) > ipamJournalMaxEntries {
+		return service.saveIPAMStateUntransacted()
+	}
+
+	entries := make([]ipamJournalEntry, len(ipIDs))
+	entryIndexByID := make(map[string]int, len(ipIDs))
+	for i, ipID := range ipIDs {
+		entries[i].ID = ipID
+		if ipConfig, exists := service.PodIPConfigState[ipID]; exists {
+			entries[i].IPConfig = &ipConfig
+		}
+		entryIndexByID[ipID] = i
+	}
+
+	for key, ipID := range service.PodIPIDByOrchestratorContext {
+		if i, exists := entryIndexByID[ipID]; exists {
+			entries[i].Keys = append(entries[i].Keys, key)
+		}
+	}
+
+	if err := service.ipamJournal.append(entries); err != nil {
+		logger.Errorf("[Azure CNS]  Failed to journal IPConfigs %v, saving the IPAM state, err:%v", ipIDs, err)
+		metrics.IPAMStateSaveErrors.Inc()
+		// the journal may end in a partial entry, which ends its replay, the saved state empties it
+		return service.saveIPAMStateUntransacted()
+	}
+
+	return nil
+}
+
+// restoreIPAMState restores the IP config states and the pods they are allocated to, from persistent store and
+// the journal of the transitions since. The request controller reconciles them against the NodeNetworkConfig and
+// the pods of the node afterwards.
+func (service *HTTPRestService) restoreIPAMState() {
+	// Skip if a store is not provided.
+	if service.store == nil {
+		return
+	}
+
+	var state ipamState
+	if err := service.store.Read(ipamStoreKey, &state); err != nil {
+		if err == store.ErrKeyNotFound {
+			logger.Printf("[Azure CNS]  No IPAM state to restore.")
+		} else {
+			logger.Errorf("[Azure CNS]  Failed to restore IPAM state, err:%v", err)
+		}
+
+		// the journal only holds the transitions since the state was saved
+		if err = service.ipamJournal.truncate(); err != nil {
+			logger.Errorf("[Azure CNS]  Failed to remove the IPAM journal, err:%v", err)
+		}
+
+		return
+	}
+
+	if state.PodIPConfigState == nil {
+		state.PodIPConfigState = make(map[string]cns.IPConfigurationStatus)
+	}
+
+	if state.PodIPIDByOrchestratorContext == nil {
+		state.PodIPIDByOrchestratorContext = make(map[string]string)
+	}
+
+	replayed, err := service.ipamJournal.replay(&state)
+	if err != nil {
+		logger.Errorf("[Azure CNS]  Failed to replay the IPAM journal after %d entries, err:%v", replayed, err)
+	}
+
+	service.Lock()
+	defer service.Unlock()
+
+	// The store indents the raw orchestrator contexts, compact them again so they compare equal
+	// to the ones of the IP config requests.
+	for ipId, ipConfig := range state.PodIPConfigState {
+		ipConfig.OrchestratorContext = compactOrchestratorContext(ipConfig.OrchestratorContext)
+		state.PodIPConfigState[ipId] = ipConfig
+	}
+
+	service.PodIPConfigState = state.PodIPConfigState
+	service.PodIPIDByOrchestratorContext = state.PodIPIDByOrchestratorContext
+
+	logger.Printf("[Azure CNS]  Restored IPAM state saved at %v with %d journaled IPConfigs, IPConfigs: %d, allocated: %d",
+		state.TimeStamp, replayed, len(service.PodIPConfigState), len(service.PodIPIDByOrchestratorContext))
+
+	// the journal starts over from the restored state, without a partial entry new entries would follow
+	if replayed > 0 || err != nil {
+		service.saveIPAMStateUntransacted()
+	}
+}
+
+// compactOrchestratorContext returns the orchestrator context restored from the store without the indentation,
+// nil if there is no orchestrator context.
+func compactOrchestratorContext(orchestratorContext json.RawMessage) json.RawMessage {
+	if len(orchestratorContext) == 0 || string(orchestratorContext) == "null" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, orchestratorContext); err != nil {
+		logger.Errorf("[Azure CNS]  Failed to compact orchestrator context %s, err:%v", string(orchestratorContext), err)
+		return orchestratorContext
+	}
+
+	return buf.Bytes()
+}
+
 func (service *HTTPRestService) saveNetworkContainerGoalState(req cns.CreateNetworkContainerRequest) (int, string) {
 	// we don't want to overwrite what other calls may have written
 	service.Lock()
@@ -186,6 +331,8 @@ func (service *HTTPRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 			if returnCode != 0 {
 				return returnCode, returnMesage
 			}
+
+			service.saveIPAMStateUntransacted()
 		default:
 			errMsg := fmt.Sprintf("Unsupported orchestrator type: %s", service.state.OrchestratorType)
 			logger.Errorf(errMsg)
@@ -699,7 +699,28 @@ func IniitalizeCRDState(httpRestService cns.HTTPService, cnsconfig configuration
 	}
 
     // initialize the ipam pool monitor
-	httpRestServiceImplementation.IPAMPoolMonitor = ipampoolmonitor.NewCNSIPAMPoolMonitor(httpRestServiceImplementation, requestController)
+	poolMonitor := ipampoolmonitor.NewCNSIPAMPoolMonitor(httpRestServiceImplementation, requestController)
+	httpRestServiceImplementation.IPAMPoolMonitor = poolMonitor
+
+	if cnsconfig.PredictiveScalingSettings.Enable {
+		settings := cnsconfig.PredictiveScalingSettings
+		pendingPodCounter, err := kubecontroller.NewPodInformerPendingPodCounter(kubeConfig, httpRestServiceImplementation)
+		if err != nil {
+			logger.Errorf("[Azure CNS] Failed to create pending pod counter, predictive scaling is disabled: %v", err)
+		} else {
+			go func() {
+				if err := pendingPodCounter.Start(exitChan); err != nil {
+					logger.Errorf("[Azure CNS] Failed to start pending pod counter: %v", err)
+				}
+			}()
+
+			poolMonitor.EnablePredictiveScaling(ipampoolmonitor.NewPredictiveScaler(pendingPodCounter,
+				time.Duration(settings.AllocationRateWindowInSecs)*time.Second,
+				time.Duration(settings.ProvisioningHorizonInSecs)*time.Second,
+				time.Duration(settings.ScaleDownCooldownInSecs)*time.Second,
+				int64(settings.MaxBatchesPerUpdate)))
+		}
+	}
 
 	err = requestController.InitRequestController()
 	if err != nil {
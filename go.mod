@@ -10,33 +10,23 @@ require (
 	github.com/billgraziano/dpapi v0.3.0
 	github.com/containernetworking/cni v0.7.0-rc2
 	github.com/docker/libnetwork v0.5.6
-	github.com/golang/groupcache v0.0.0-20191027212112-611e8accdfc9 // indirect
-	github.com/google/uuid v1.1.1
+	github.com/google/nftables v0.1.0
+	github.com/google/uuid v1.1.2
 	github.com/gorilla/mux v1.8.0
-	github.com/gregjones/httpcache v0.0.0-20190611155906-901d90724c79 // indirect
-	github.com/hashicorp/golang-lru v0.5.3 // indirect
-	github.com/imdario/mergo v0.3.8 // indirect
-	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
+	github.com/mdlayher/netlink v1.6.0 // indirect
 	github.com/microsoft/ApplicationInsights-Go v0.4.3
-	github.com/nxadm/tail v1.4.5
-	github.com/onsi/ginkgo v1.12.0
-	github.com/onsi/gomega v1.9.0
-	github.com/petar/GoLLRB v0.0.0-20190514000832-33fb24c13b99 // indirect
-	github.com/prometheus/client_golang v1.0.0
+	github.com/nxadm/tail v1.4.8
+	github.com/onsi/ginkgo v1.16.4
+	github.com/onsi/gomega v1.13.0
+	github.com/prometheus/client_golang v1.11.0
 	github.com/prometheus/client_model v0.2.0
-	github.com/spf13/cobra v0.0.5
+	github.com/spf13/cobra v1.1.1
 	github.com/spf13/pflag v1.0.5
-	github.com/spf13/viper v1.3.2
+	github.com/spf13/viper v1.7.0
 	github.com/stretchr/testify v1.7.0
-	go.opencensus.io v0.22.2 // indirect
-	golang.org/x/crypto v0.0.0-20200220183623-bac4c82f6975 // indirect
-	golang.org/x/net v0.0.0-20191112182307-2180aed22343 // indirect
-	golang.org/x/sys v0.0.0-20200828161417-c663848e9a16
-	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
-	google.golang.org/appengine v1.6.5 // indirect
-	k8s.io/api v0.18.2
-	k8s.io/apimachinery v0.18.2
-	k8s.io/client-go v0.18.2
-	sigs.k8s.io/controller-runtime v0.6.0
-	software.sslmate.com/src/go-pkcs12 v0.0.0-20201102150903-66718f75db0e // indirect
+	golang.org/x/sys v0.0.0-20220128215802-99c3d69c2c27
+	k8s.io/api v0.21.1
+	k8s.io/apimachinery v0.21.1
+	k8s.io/client-go v0.21.1
+	sigs.k8s.io/controller-runtime v0.9.0
 )
@@ -0,0 +1,79 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// clusterMetricsLeaseName is the lease of kube-system held by the NPM publishing the cluster metrics.
+	clusterMetricsLeaseName = "azure-npm-cluster-metrics"
+
+	clusterMetricsLeaseDurationInSeconds = 60
+	clusterMetricsRenewDeadlineInSeconds = 40
+	clusterMetricsRetryPeriodInSeconds   = 10
+)
+
+// newClusterMetricsLock returns the lease the NPMs of the cluster compete for, the node name identifies the holder.
+func (npMgr *NetworkPolicyManager) newClusterMetricsLock() (resourcelock.Interface, error) {
+	return resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		util.KubeSystemFlag,
+		clusterMetricsLeaseName,
+		npMgr.clientset.CoreV1(),
+		npMgr.clientset.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: npMgr.NodeName},
+	)
+}
+
+// runClusterMetricsElection elects one NPM of the cluster to publish the cluster metrics with SendClusterMetrics,
+// so the other nodes don't count the objects of the cluster too. A node losing the lease stops publishing them
+// and runs for it again, until stopCh is closed.
+func (npMgr *NetworkPolicyManager) runClusterMetricsElection(lock resourcelock.Interface, stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	for {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			Name:            clusterMetricsLeaseName,
+			LeaseDuration:   clusterMetricsLeaseDurationInSeconds * time.Second,
+			RenewDeadline:   clusterMetricsRenewDeadlineInSeconds * time.Second,
+			RetryPeriod:     clusterMetricsRetryPeriodInSeconds * time.Second,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					log.Logf("[INFO] Publishing the cluster metrics, %s holds lease %s.", lock.Identity(), clusterMetricsLeaseName)
+					npMgr.SendClusterMetrics(leaderCtx.Done())
+				},
+				OnStoppedLeading: func() {
+					log.Logf("[INFO] Stopped publishing the cluster metrics, %s lost lease %s.", lock.Identity(), clusterMetricsLeaseName)
+					metrics.ResetClusterState()
+				},
+				OnNewLeader: func(identity string) {
+					if identity != lock.Identity() {
+						log.Logf("[INFO] %s publishes the cluster metrics.", identity)
+					}
+				},
+			},
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package npm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/npm/http/api"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func TestClusterMetricsElection(t *testing.T) {
+	informerFactory := informers.NewSharedInformerFactory(nil, 0)
+	npMgr := &NetworkPolicyManager{
+		podInformer:   informerFactory.Core().V1().Pods(),
+		nsInformer:    informerFactory.Core().V1().Namespaces(),
+		npInformer:    informerFactory.Networking().V1().NetworkPolicies(),
+		NodeName:      "node-1",
+		serverVersion: &version.Info{Major: "1", Minor: "18"},
+	}
+
+	for _, name := range []string{"a", "b"} {
+		npMgr.podInformer.Informer().GetIndexer().Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "x"}})
+	}
+	npMgr.nsInformer.Informer().GetIndexer().Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "x"}})
+	npMgr.npInformer.Informer().GetIndexer().Add(&networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "deny-all", Namespace: "x"}})
+
+	if clusterState := npMgr.GetClusterState(); clusterState.PodCount != 2 || clusterState.NsCount != 1 || clusterState.NwPolicyCount != 1 {
+		t.Errorf("TestClusterMetricsElection failed @ GetClusterState, expected the counts of the informer caches. Actual: %+v", clusterState)
+	}
+
+	clientset := fake.NewSimpleClientset()
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, "kube-system", clusterMetricsLeaseName,
+		clientset.CoreV1(), clientset.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: npMgr.NodeName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, api.ClusterMetricsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getClusterMetrics := func() string {
+		rr := httptest.NewRecorder()
+		metrics.GetHandler(false).ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		npMgr.runClusterMetricsElection(lock, stopCh)
+		close(stopped)
+	}()
+
+	published := false
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		if strings.Contains(getClusterMetrics(), "npm_cluster_pods 2") {
+			published = true
+			break
+		}
+	}
+	if !published {
+		t.Fatalf("TestClusterMetricsElection failed @ runClusterMetricsElection, expected the leader to publish the cluster state:\n%s", getClusterMetrics())
+	}
+
+	record, _, err := lock.Get(context.TODO())
+	if err != nil || record.HolderIdentity != npMgr.NodeName {
+		t.Errorf("TestClusterMetricsElection failed @ runClusterMetricsElection, expected %s to hold the lease. Actual: %+v, %v", npMgr.NodeName, record, err)
+	}
+
+	close(stopCh)
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("TestClusterMetricsElection failed @ runClusterMetricsElection, expected the election to stop with stopCh")
+	}
+
+	if body := getClusterMetrics(); strings.Contains(body, "npm_cluster_pods") {
+		t.Errorf("TestClusterMetricsElection failed @ runClusterMetricsElection, expected no cluster state once the lease is released:\n%s", body)
+	}
+
+	if record, _, err := lock.Get(context.TODO()); err != nil || record.HolderIdentity != "" {
+		t.Errorf("TestClusterMetricsElection failed @ runClusterMetricsElection, expected the lease to be released. Actual: %+v, %v", record, err)
+	}
+}
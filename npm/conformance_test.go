@@ -0,0 +1,523 @@
+package npm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/fakes"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// The conformance suite programs network policies through the NetworkPolicyManager handlers into a fake dataplane
+// and probes every pair of pods, like the upstream network policy e2e tests do. Namespaces x, y and z are labeled
+// ns=<name> and hold pods a, b and c labeled pod=<name>, which serve TCP ports 80 and 81 named serve-80-tcp and serve-81-tcp.
+var (
+	conformanceNamespaces = []string{"x", "y", "z"}
+	conformancePods       = []string{"a", "b", "c"}
+)
+
+// conformancePodIP returns the ip of pod <ns>/<pod>, the pods of a namespace share a /24.
+func conformancePodIP(pod string) string {
+	parts := strings.Split(pod, "/")
+	nsIndex := strings.Index("xyz", parts[0]) + 1
+	podIndex := strings.Index("abc", parts[1]) + 1
+	return fmt.Sprintf("10.0.%d.%d", nsIndex, podIndex)
+}
+
+func newConformanceNamespace(ns string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            ns,
+			Labels:          map[string]string{"ns": ns},
+			ResourceVersion: "1",
+		},
+	}
+}
+
+func newConformancePod(ns, pod string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pod,
+			Namespace:       ns,
+			UID:             types.UID(ns + "-" + pod),
+			Labels:          map[string]string{"pod": pod},
+			ResourceVersion: "1",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Ports: []corev1.ContainerPort{
+						{Name: "serve-80-tcp", ContainerPort: 80, Protocol: corev1.ProtocolTCP},
+						{Name: "serve-81-tcp", ContainerPort: 81, Protocol: corev1.ProtocolTCP},
+					},
+				},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: conformancePodIP(ns + "/" + pod),
+		},
+	}
+}
+
+// reachability is the truth table of which pods can connect to which, keyed by <ns>/<pod>.
+// A pod can always connect to itself.
+type reachability struct {
+	pods      []string
+	connected map[string]map[string]bool
+}
+
+func newReachability(connected bool) *reachability {
+	r := &reachability{connected: make(map[string]map[string]bool)}
+	for _, ns := range conformanceNamespaces {
+		for _, pod := range conformancePods {
+			r.pods = append(r.pods, ns+"/"+pod)
+		}
+	}
+
+	for _, from := range r.pods {
+		r.connected[from] = make(map[string]bool)
+		for _, to := range r.pods {
+			r.connected[from][to] = connected || from == to
+		}
+	}
+
+	return r
+}
+
+func (r *reachability) expect(from, to string, connected bool) *reachability {
+	if from != to {
+		r.connected[from][to] = connected
+	}
+	return r
+}
+
+// expectAllIngress sets whether every other pod can connect to pod to.
+func (r *reachability) expectAllIngress(to string, connected bool) *reachability {
+	for _, from := range r.pods {
+		r.expect(from, to, connected)
+	}
+	return r
+}
+
+// expectAllEgress sets whether pod from can connect to every other pod.
+func (r *reachability) expectAllEgress(from string, connected bool) *reachability {
+	for _, to := range r.pods {
+		r.expect(from, to, connected)
+	}
+	return r
+}
+
+// conformanceProbe is the connections of a port and protocol a scenario expects.
+type conformanceProbe struct {
+	port     int
+	protocol string
+	expected *reachability
+}
+
+// conformanceScenario applies policies and checks the probes, then deletes the policies and expects all pods to connect again.
+type conformanceScenario struct {
+	name     string
+	policies []*networkingv1.NetworkPolicy
+	probes   []conformanceProbe
+}
+
+// newConformanceManager programs the namespaces and pods of the suite, newDataplane must return fake.
+func newConformanceManager(t *testing.T) *NetworkPolicyManager {
+	npMgr := &NetworkPolicyManager{
+		NsMap:                        make(map[string]*Namespace),
+		PodMap:                       make(map[string]*NpmPod),
+		RawNpMap:                     make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap:               make(map[string]*networkingv1.NetworkPolicy),
+		isSafeToCleanUpAzureNpmChain: true,
+	}
+
+	allNs, err := newNs(util.KubeAllNamespacesFlag)
+	if err != nil {
+		t.Fatalf("newConformanceManager failed @ newNs")
+	}
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	for _, ns := range conformanceNamespaces {
+		if err := npMgr.AddNamespace(newConformanceNamespace(ns)); err != nil {
+			t.Fatalf("newConformanceManager failed @ AddNamespace %s: %v", ns, err)
+		}
+
+		for _, pod := range conformancePods {
+			if err := npMgr.AddPod(newConformancePod(ns, pod)); err != nil {
+				t.Fatalf("newConformanceManager failed @ AddPod %s/%s: %v", ns, pod, err)
+			}
+		}
+	}
+
+	return npMgr
+}
+
+// checkReachability probes every pair of pods and reports the connections which differ from expected.
+func checkReachability(t *testing.T, step string, fake *fakes.DataplaneFake, probe conformanceProbe) {
+	var mismatches []string
+	for _, from := range probe.expected.pods {
+		for _, to := range probe.expected.pods {
+			if from == to {
+				continue
+			}
+
+			packet := fakes.Packet{
+				SrcIP:    conformancePodIP(from),
+				DstIP:    conformancePodIP(to),
+				Protocol: probe.protocol,
+				DstPort:  probe.port,
+			}
+			connected, trace, err := fake.Evaluate(packet)
+			if err != nil {
+				t.Fatalf("%s failed @ Evaluate %s -> %s: %v", step, from, to, err)
+			}
+
+			if connected != probe.expected.connected[from][to] {
+				mismatches = append(mismatches, fmt.Sprintf("%s -> %s: expected connected %t, rules matched:\n\t%s", from, to, probe.expected.connected[from][to], strings.Join(trace, "\n\t")))
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		t.Errorf("%s failed @ %s/%d, %d connections differ:\n%s", step, probe.protocol, probe.port, len(mismatches), strings.Join(mismatches, "\n"))
+	}
+}
+
+func newConformancePolicy(name, ns string, podSelector map[string]string, policyTypes []networkingv1.PolicyType) *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       ns,
+			ResourceVersion: "1",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: podSelector},
+			PolicyTypes: policyTypes,
+		},
+	}
+}
+
+func withIngress(npObj *networkingv1.NetworkPolicy, rules ...networkingv1.NetworkPolicyIngressRule) *networkingv1.NetworkPolicy {
+	npObj.Spec.Ingress = rules
+	return npObj
+}
+
+func withEgress(npObj *networkingv1.NetworkPolicy, rules ...networkingv1.NetworkPolicyEgressRule) *networkingv1.NetworkPolicy {
+	npObj.Spec.Egress = rules
+	return npObj
+}
+
+func tcpPort(port intstr.IntOrString) networkingv1.NetworkPolicyPort {
+	protocol := corev1.ProtocolTCP
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port}
+}
+
+func podPeer(podLabels map[string]string) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: podLabels}}
+}
+
+func nsPeer(nsSelector *metav1.LabelSelector, podLabels map[string]string) networkingv1.NetworkPolicyPeer {
+	peer := networkingv1.NetworkPolicyPeer{NamespaceSelector: nsSelector}
+	if podLabels != nil {
+		peer.PodSelector = &metav1.LabelSelector{MatchLabels: podLabels}
+	}
+	return peer
+}
+
+func ipBlockPeer(cidr string, except ...string) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr, Except: except}}
+}
+
+var (
+	ingressPolicy = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	egressPolicy  = []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}
+	podA          = map[string]string{"pod": "a"}
+)
+
+func TestConformance(t *testing.T) {
+	// clusters running kubernetes 1.11 or newer intersect the namespace and pod selectors of a peer.
+	defer func(isNewNwPolicyVer bool) { util.IsNewNwPolicyVerFlag = isNewNwPolicyVer }(util.IsNewNwPolicyVerFlag)
+	util.IsNewNwPolicyVerFlag = true
+
+	scenarios := []conformanceScenario{
+		{
+			name:     "deny all ingress to namespace x",
+			policies: []*networkingv1.NetworkPolicy{newConformancePolicy("deny-all", "x", nil, ingressPolicy)},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expectAllIngress("x/b", false).expectAllIngress("x/c", false)},
+			},
+		},
+		{
+			name:     "deny all egress from namespace x",
+			policies: []*networkingv1.NetworkPolicy{newConformancePolicy("deny-all", "x", nil, egressPolicy)},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllEgress("x/a", false).expectAllEgress("x/b", false).expectAllEgress("x/c", false)},
+			},
+		},
+		{
+			name: "allow all ingress to namespace x",
+			policies: []*networkingv1.NetworkPolicy{
+				newConformancePolicy("deny-all", "x", nil, ingressPolicy),
+				withIngress(newConformancePolicy("allow-all", "x", nil, ingressPolicy), networkingv1.NetworkPolicyIngressRule{}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true)},
+			},
+		},
+		{
+			name: "allow ingress to x/a from pod b of namespace x",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-pod-b", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{podPeer(map[string]string{"pod": "b"})},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expect("x/b", "x/a", true)},
+			},
+		},
+		{
+			name: "allow ingress to x/a from namespace y",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-ns-y", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{MatchLabels: map[string]string{"ns": "y"}}, nil)},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expect("y/a", "x/a", true).expect("y/b", "x/a", true).expect("y/c", "x/a", true)},
+			},
+		},
+		{
+			name: "allow ingress to x/a from pod c of namespace y",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-ns-y-pod-c", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{MatchLabels: map[string]string{"ns": "y"}}, map[string]string{"pod": "c"})},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expect("y/c", "x/a", true)},
+			},
+		},
+		{
+			name: "allow ingress to x/a from namespaces other than y",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-ns-not-y", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{
+						MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "ns", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"y"}}},
+					}, nil)},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expect("y/a", "x/a", false).expect("y/b", "x/a", false).expect("y/c", "x/a", false)},
+			},
+		},
+		{
+			name: "allow ingress to x/a on port 81",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-port-81", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					Ports: []networkingv1.NetworkPolicyPort{tcpPort(intstr.FromInt(81))},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false)},
+				{81, "TCP", newReachability(true)},
+				{81, "UDP", newReachability(true).expectAllIngress("x/a", false)},
+			},
+		},
+		{
+			name: "allow ingress to x/a on named port serve-81-tcp",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-named-port", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					Ports: []networkingv1.NetworkPolicyPort{tcpPort(intstr.FromString("serve-81-tcp"))},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false)},
+				{81, "TCP", newReachability(true)},
+			},
+		},
+		{
+			name: "allow ingress to x/a from namespace z on port 80",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-ns-z-port-80", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From:  []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{MatchLabels: map[string]string{"ns": "z"}}, nil)},
+					Ports: []networkingv1.NetworkPolicyPort{tcpPort(intstr.FromInt(80))},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expect("z/a", "x/a", true).expect("z/b", "x/a", true).expect("z/c", "x/a", true)},
+				{81, "TCP", newReachability(true).expectAllIngress("x/a", false)},
+			},
+		},
+		{
+			name: "allow ingress to x/a from an ipBlock of namespace y except y/b",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-cidr", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{ipBlockPeer("10.0.2.0/24", conformancePodIP("y/b")+"/32")},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expect("y/a", "x/a", true).expect("y/c", "x/a", true)},
+			},
+		},
+		{
+			name: "allow egress from x/a to namespace y on port 80",
+			policies: []*networkingv1.NetworkPolicy{
+				withEgress(newConformancePolicy("allow-egress-ns-y", "x", podA, egressPolicy), networkingv1.NetworkPolicyEgressRule{
+					To:    []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{MatchLabels: map[string]string{"ns": "y"}}, nil)},
+					Ports: []networkingv1.NetworkPolicyPort{tcpPort(intstr.FromInt(80))},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllEgress("x/a", false).expect("x/a", "y/a", true).expect("x/a", "y/b", true).expect("x/a", "y/c", true)},
+				{81, "TCP", newReachability(true).expectAllEgress("x/a", false)},
+			},
+		},
+		{
+			name: "allow egress from x/a to an ipBlock of namespace z",
+			policies: []*networkingv1.NetworkPolicy{
+				withEgress(newConformancePolicy("allow-egress-cidr", "x", podA, egressPolicy), networkingv1.NetworkPolicyEgressRule{
+					To: []networkingv1.NetworkPolicyPeer{ipBlockPeer("10.0.3.0/24")},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllEgress("x/a", false).expect("x/a", "z/a", true).expect("x/a", "z/b", true).expect("x/a", "z/c", true)},
+			},
+		},
+		{
+			name: "policies selecting the same pod allow the union of their peers",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-pod-b", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{podPeer(map[string]string{"pod": "b"})},
+				}),
+				withIngress(newConformancePolicy("allow-ns-z", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{MatchLabels: map[string]string{"ns": "z"}}, nil)},
+				}),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expect("x/b", "x/a", true).expect("z/a", "x/a", true).expect("z/b", "x/a", true).expect("z/c", "x/a", true)},
+			},
+		},
+		{
+			name: "ingress and egress policies both apply",
+			policies: []*networkingv1.NetworkPolicy{
+				withIngress(newConformancePolicy("allow-ns-y", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{MatchLabels: map[string]string{"ns": "y"}}, nil)},
+				}),
+				newConformancePolicy("deny-egress", "y", map[string]string{"pod": "b"}, egressPolicy),
+			},
+			probes: []conformanceProbe{
+				{80, "TCP", newReachability(true).expectAllIngress("x/a", false).expect("y/a", "x/a", true).expect("y/c", "x/a", true).expectAllEgress("y/b", false)},
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			fake := fakes.NewDataplaneFake()
+			defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+			newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+				return fake, fake
+			}
+			npMgr := newConformanceManager(t)
+
+			for _, npObj := range scenario.policies {
+				if err := npMgr.AddNetworkPolicy(npObj); err != nil {
+					t.Fatalf("TestConformance failed @ AddNetworkPolicy %s: %v", npObj.Name, err)
+				}
+			}
+
+			for _, probe := range scenario.probes {
+				checkReachability(t, "TestConformance", fake, probe)
+			}
+
+			for _, npObj := range scenario.policies {
+				if err := npMgr.DeleteNetworkPolicy(npObj); err != nil {
+					t.Fatalf("TestConformance failed @ DeleteNetworkPolicy %s: %v", npObj.Name, err)
+				}
+			}
+
+			for _, probe := range scenario.probes {
+				checkReachability(t, "TestConformance", fake, conformanceProbe{probe.port, probe.protocol, newReachability(true)})
+			}
+		})
+	}
+}
+
+func TestConformanceUpdates(t *testing.T) {
+	defer func(isNewNwPolicyVer bool) { util.IsNewNwPolicyVerFlag = isNewNwPolicyVer }(util.IsNewNwPolicyVerFlag)
+	util.IsNewNwPolicyVerFlag = true
+
+	fake := fakes.NewDataplaneFake()
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return fake, fake
+	}
+	npMgr := newConformanceManager(t)
+
+	allowPodB := withIngress(newConformancePolicy("allow-pod", "x", podA, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+		From: []networkingv1.NetworkPolicyPeer{podPeer(map[string]string{"pod": "b"})},
+	})
+	allowNsY := withIngress(newConformancePolicy("allow-ns-y", "x", map[string]string{"pod": "b"}, ingressPolicy), networkingv1.NetworkPolicyIngressRule{
+		From: []networkingv1.NetworkPolicyPeer{nsPeer(&metav1.LabelSelector{MatchLabels: map[string]string{"ns": "y"}}, nil)},
+	})
+	for _, npObj := range []*networkingv1.NetworkPolicy{allowPodB, allowNsY} {
+		if err := npMgr.AddNetworkPolicy(npObj); err != nil {
+			t.Fatalf("TestConformanceUpdates failed @ AddNetworkPolicy %s: %v", npObj.Name, err)
+		}
+	}
+
+	expected := newReachability(true).expectAllIngress("x/a", false).expect("x/b", "x/a", true).
+		expectAllIngress("x/b", false).expect("y/a", "x/b", true).expect("y/b", "x/b", true).expect("y/c", "x/b", true)
+	checkReachability(t, "TestConformanceUpdates", fake, conformanceProbe{80, "TCP", expected})
+
+	// the policy now allows pod c instead of pod b.
+	allowPodC := allowPodB.DeepCopy()
+	allowPodC.ObjectMeta.ResourceVersion = "2"
+	allowPodC.Spec.Ingress[0].From[0] = podPeer(map[string]string{"pod": "c"})
+	if err := npMgr.UpdateNetworkPolicy(allowPodB, allowPodC); err != nil {
+		t.Fatalf("TestConformanceUpdates failed @ UpdateNetworkPolicy: %v", err)
+	}
+
+	expected.expect("x/b", "x/a", false).expect("x/c", "x/a", true)
+	checkReachability(t, "TestConformanceUpdates", fake, conformanceProbe{80, "TCP", expected})
+
+	// namespace z is relabeled ns=y, so allow-ns-y allows its pods too.
+	relabeledNs := newConformanceNamespace("z")
+	relabeledNs.ObjectMeta.Labels = map[string]string{"ns": "y"}
+	relabeledNs.ObjectMeta.ResourceVersion = "2"
+	if err := npMgr.UpdateNamespace(newConformanceNamespace("z"), relabeledNs); err != nil {
+		t.Fatalf("TestConformanceUpdates failed @ UpdateNamespace: %v", err)
+	}
+
+	expected.expect("z/a", "x/b", true).expect("z/b", "x/b", true).expect("z/c", "x/b", true)
+	checkReachability(t, "TestConformanceUpdates", fake, conformanceProbe{80, "TCP", expected})
+
+	// pod x/b is relabeled pod=c, so allow-ns-y doesn't select it anymore and allow-pod allows it to x/a.
+	relabeledPod := newConformancePod("x", "b")
+	relabeledPod.ObjectMeta.Labels = map[string]string{"pod": "c"}
+	relabeledPod.ObjectMeta.ResourceVersion = "2"
+	if err := npMgr.UpdatePod(relabeledPod); err != nil {
+		t.Fatalf("TestConformanceUpdates failed @ UpdatePod: %v", err)
+	}
+
+	expected = newReachability(true).expectAllIngress("x/a", false).expect("x/b", "x/a", true).expect("x/c", "x/a", true)
+	checkReachability(t, "TestConformanceUpdates", fake, conformanceProbe{80, "TCP", expected})
+
+	for _, npObj := range []*networkingv1.NetworkPolicy{allowPodC, allowNsY} {
+		if err := npMgr.DeleteNetworkPolicy(npObj); err != nil {
+			t.Fatalf("TestConformanceUpdates failed @ DeleteNetworkPolicy %s: %v", npObj.Name, err)
+		}
+	}
+
+	checkReachability(t, "TestConformanceUpdates", fake, conformanceProbe{80, "TCP", newReachability(true)})
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/nftm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// newDataplane returns the ipsets and rules dataplanes selected by util.DataplaneMode.
+// The event workers of each kind call them concurrently, so every call is serialized per manager.
+// Tests replace it to run NPM against a fake dataplane.
+var newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+	if util.DataplaneMode == util.DataplaneNftables {
+		// rules reference the named sets of the same nftables table, both have to share one manager.
+		nftMgr := nftm.NewNftablesManager()
+		return dataplane.Synchronize(nftMgr, nftMgr)
+	}
+
+	iptMgr := &rulesManager{IptablesManager: iptm.NewIptablesManager()}
+	if util.IPv6Enabled {
+		iptMgr.ip6tMgr = iptm.NewIp6tablesManager()
+	}
+
+	return dataplane.Synchronize(&ipsetManager{ipsm.NewIpsetManager()}, iptMgr)
+}
+
+// ipsetManager adapts the transactions of ipsm to dataplane.Ipsets.
+type ipsetManager struct {
+	*ipsm.IpsetManager
+}
+
+func (ipsMgr *ipsetManager) NewTransaction() dataplane.IpsetTransaction {
+	return &ipsetTransaction{ipsMgr.IpsetManager.NewTransaction()}
+}
+
+func (ipsMgr *ipsetManager) GetSet(name string) (*dataplane.SetInfo, bool) {
+	for _, set := range ipsMgr.GetSets() {
+		if set.Name == name || util.GetHashedName(set.Name) == name {
+			return set, true
+		}
+	}
+
+	return nil, false
+}
+
+func (ipsMgr *ipsetManager) GetSets() []*dataplane.SetInfo {
+	var sets []*dataplane.SetInfo
+	for setName, set := range ipsMgr.SetMap {
+		sets = append(sets, &dataplane.SetInfo{Name: setName, Members: set.Elements()})
+	}
+
+	for listName, list := range ipsMgr.ListMap {
+		sets = append(sets, &dataplane.SetInfo{Name: listName, IsList: true, Members: list.Elements()})
+	}
+
+	return sets
+}
+
+func (ipsMgr *ipsetManager) ReconcileSets(expected *dataplane.State) error {
+	return ipsMgr.IpsetManager.ReconcileSets(expected.Sets, expected.SetTypes, expected.Lists)
+}
+
+func (ipsMgr *ipsetManager) RemoveStaleSets(expected *dataplane.State) error {
+	return ipsMgr.IpsetManager.RemoveStaleSets(expected.Sets, expected.Lists)
+}
+
+type ipsetTransaction struct {
+	*ipsm.Transaction
+}
+
+func (tx *ipsetTransaction) Commit() []error {
+	var errs []error
+	for _, failure := range tx.Transaction.Commit() {
+		errs = append(errs, failure)
+	}
+
+	return errs
+}
+
+// rulesManager adapts the chain reconciliation of iptm to dataplane.Rules.
+// With IPv6 enabled, every rule is programmed into ip6tables as well.
+type rulesManager struct {
+	*iptm.IptablesManager
+	ip6tMgr *iptm.IptablesManager
+}
+
+func (iptMgr *rulesManager) InitNpmChains() error {
+	if err := iptMgr.IptablesManager.InitNpmChains(); err != nil || iptMgr.ip6tMgr == nil {
+		return err
+	}
+
+	return iptMgr.ip6tMgr.InitNpmChains()
+}
+
+func (iptMgr *rulesManager) UninitNpmChains() error {
+	err := iptMgr.IptablesManager.UninitNpmChains()
+	if iptMgr.ip6tMgr == nil {
+		return err
+	}
+
+	// the chains of each family are removed even if the other family failed.
+	if ip6tErr := iptMgr.ip6tMgr.UninitNpmChains(); err == nil {
+		err = ip6tErr
+	}
+
+	return err
+}
+
+func (iptMgr *rulesManager) CheckAndAddForwardChain() error {
+	if err := iptMgr.IptablesManager.CheckAndAddForwardChain(); err != nil || iptMgr.ip6tMgr == nil {
+		return err
+	}
+
+	return iptMgr.ip6tMgr.CheckAndAddForwardChain()
+}
+
+// AddBatch adds the entries to both families or to none of them.
+func (iptMgr *rulesManager) AddBatch(entries []*iptm.IptEntry) error {
+	if err := iptMgr.IptablesManager.AddBatch(entries); err != nil || iptMgr.ip6tMgr == nil {
+		return err
+	}
+
+	if err := iptMgr.ip6tMgr.AddBatch(entries); err != nil {
+		iptMgr.IptablesManager.DeleteBatch(entries)
+		return err
+	}
+
+	return nil
+}
+
+func (iptMgr *rulesManager) DeleteBatch(entries []*iptm.IptEntry) error {
+	if err := iptMgr.IptablesManager.DeleteBatch(entries); err != nil || iptMgr.ip6tMgr == nil {
+		return err
+	}
+
+	return iptMgr.ip6tMgr.DeleteBatch(entries)
+}
+
+func (iptMgr *rulesManager) ReconcileRules(expected *dataplane.State) error {
+	err := iptMgr.ReconcileChains(expected.ChainsInitialized, expected.Chains)
+	if iptMgr.ip6tMgr == nil {
+		return err
+	}
+
+	// the chains of each family are repaired even if the other family failed.
+	if ip6tErr := iptMgr.ip6tMgr.ReconcileChains(expected.ChainsInitialized, expected.Chains); err == nil {
+		err = ip6tErr
+	}
+
+	return err
+}
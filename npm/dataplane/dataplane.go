@@ -0,0 +1,65 @@
+// Package dataplane defines how NPM programs network policies into the kernel.
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package dataplane
+
+import (
+	"github.com/Azure/azure-container-networking/npm/iptm"
+)
+
+// Ipsets programs the sets network policy rules match namespaces, pods, named ports and cidrs with.
+// Set and list names are the unhashed NPM names, e.g. "ns-x" or "app:frontend".
+type Ipsets interface {
+	// Exists reports whether val is an element of the set or list key.
+	Exists(key, val, kind string) bool
+	CreateList(listName string) error
+	AddToList(listName, setName string) error
+	DeleteFromList(listName, setName string) error
+	CreateSet(setName string, spec []string) error
+	DeleteSet(setName string) error
+	AddToSet(setName, ip, spec, podUID string) error
+	DeleteFromSet(setName, ip, podUID string) error
+	NewTransaction() IpsetTransaction
+	// GetSet returns the set or list with the NPM name or the hashed name.
+	GetSet(name string) (*SetInfo, bool)
+	// GetSets returns every set and list.
+	GetSets() []*SetInfo
+	// DestroyNpmIpsets removes every set NPM created, including the ones of a previous run.
+	DestroyNpmIpsets() error
+	// ReconcileSets makes the kernel hold the sets and lists of expected, changing only the ones which differ.
+	// Sets left by a previous run which expected doesn't have stay until RemoveStaleSets, rules may still refer to them.
+	ReconcileSets(expected *State) error
+	// RemoveStaleSets removes the sets NPM created which expected doesn't have.
+	RemoveStaleSets(expected *State) error
+}
+
+// SetInfo describes a set or a list.
+type SetInfo struct {
+	Name    string            // unhashed NPM name
+	IsList  bool              // whether Members holds sets
+	Members map[string]string // elements with the pod uid they belong to, or the unhashed names of the sets of a list
+}
+
+// IpsetTransaction stages set updates and applies them to the kernel at once.
+type IpsetTransaction interface {
+	CreateSet(setName string, spec []string)
+	CreateList(listName string)
+	AddToList(listName, setName string)
+	DeleteFromList(listName, setName string)
+	AddToSet(setName, ip, spec, podUID string)
+	DeleteFromSet(setName, ip, podUID string)
+	// Commit applies the staged updates and returns one error per update which failed.
+	Commit() []error
+}
+
+// Rules programs the NPM chains and the rules translated from network policies.
+type Rules interface {
+	InitNpmChains() error
+	UninitNpmChains() error
+	// CheckAndAddForwardChain makes sure forwarded packets go through the NPM chains.
+	CheckAndAddForwardChain() error
+	AddBatch(entries []*iptm.IptEntry) error
+	DeleteBatch(entries []*iptm.IptEntry) error
+	// ReconcileRules makes the NPM chains hold the rules of expected, changing only the chains which differ.
+	ReconcileRules(expected *State) error
+}
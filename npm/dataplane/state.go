@@ -0,0 +1,292 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package dataplane
+
+import (
+	"reflect"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// State keeps the sets and rules NPM programs in memory, in the order the kernel holds them.
+// It implements both Ipsets and Rules, NPM records the state it expects in the kernel with it while it starts.
+type State struct {
+	Sets              map[string]map[string]string // set name -> element -> pod uid
+	SetTypes          map[string]string            // set name -> set type, e.g. nethash
+	Lists             map[string]map[string]bool   // list name -> set names
+	Chains            map[string][]*iptm.IptEntry
+	ChainsInitialized bool
+}
+
+// NewState creates an empty State.
+func NewState() *State {
+	return &State{
+		Sets:     make(map[string]map[string]string),
+		SetTypes: make(map[string]string),
+		Lists:    make(map[string]map[string]bool),
+		Chains:   make(map[string][]*iptm.IptEntry),
+	}
+}
+
+// Exists reports whether val is an element of the set or list key.
+func (s *State) Exists(key, val, kind string) bool {
+	if kind == util.IpsetSetListFlag {
+		return s.Lists[key][val]
+	}
+
+	_, exists := s.Sets[key][val]
+	return exists
+}
+
+// CreateList creates an empty list.
+func (s *State) CreateList(listName string) error {
+	if _, exists := s.Lists[listName]; !exists {
+		s.Lists[listName] = make(map[string]bool)
+	}
+
+	return nil
+}
+
+// AddToList adds a set to a list, creating the list if needed.
+func (s *State) AddToList(listName, setName string) error {
+	if listName == setName {
+		return nil
+	}
+
+	s.CreateList(listName)
+	s.Lists[listName][setName] = true
+
+	return nil
+}
+
+// DeleteFromList removes a set from a list and deletes the list once it is empty.
+func (s *State) DeleteFromList(listName, setName string) error {
+	delete(s.Lists[listName], setName)
+	if len(s.Lists[listName]) == 0 {
+		delete(s.Lists, listName)
+	}
+
+	return nil
+}
+
+// CreateSet creates an empty set of the type in spec, nethash by default.
+func (s *State) CreateSet(setName string, spec []string) error {
+	if _, exists := s.Sets[setName]; exists {
+		return nil
+	}
+
+	s.Sets[setName] = make(map[string]string)
+	s.SetTypes[setName] = util.IpsetNetHashFlag
+	if len(spec) > 0 && spec[0] != "" {
+		s.SetTypes[setName] = spec[0]
+	}
+
+	return nil
+}
+
+// DeleteSet deletes a set.
+func (s *State) DeleteSet(setName string) error {
+	delete(s.Sets, setName)
+	delete(s.SetTypes, setName)
+	return nil
+}
+
+// AddToSet adds an element to a set, creating the set if needed.
+func (s *State) AddToSet(setName, ip, spec, podUID string) error {
+	s.CreateSet(setName, []string{spec})
+	s.Sets[setName][ip] = podUID
+
+	return nil
+}
+
+// DeleteFromSet removes an element unless it now belongs to another pod, and deletes the set once it is empty.
+func (s *State) DeleteFromSet(setName, ip, podUID string) error {
+	set, exists := s.Sets[setName]
+	if !exists {
+		return nil
+	}
+
+	if cachedPodUID, exists := set[ip]; exists && cachedPodUID != podUID {
+		return nil
+	}
+
+	// cidrs excepted by a policy are added with a nomatch suffix and deleted without it.
+	delete(set, ip)
+	delete(set, ip+util.IpsetNomatch)
+	if len(set) == 0 {
+		s.DeleteSet(setName)
+	}
+
+	return nil
+}
+
+// GetSet returns the set or list with the NPM name or the hashed name.
+func (s *State) GetSet(name string) (*SetInfo, bool) {
+	for _, set := range s.GetSets() {
+		if set.Name == name || util.GetHashedName(set.Name) == name {
+			return set, true
+		}
+	}
+
+	return nil, false
+}
+
+// GetSets returns every set and list.
+func (s *State) GetSets() []*SetInfo {
+	var sets []*SetInfo
+	for setName, elements := range s.Sets {
+		set := &SetInfo{Name: setName, Members: make(map[string]string)}
+		for ip, podUID := range elements {
+			set.Members[ip] = podUID
+		}
+		sets = append(sets, set)
+	}
+
+	for listName, setNames := range s.Lists {
+		list := &SetInfo{Name: listName, IsList: true, Members: make(map[string]string)}
+		for setName := range setNames {
+			list.Members[setName] = ""
+		}
+		sets = append(sets, list)
+	}
+
+	return sets
+}
+
+// NewTransaction returns a transaction which applies its updates on Commit.
+func (s *State) NewTransaction() IpsetTransaction {
+	return &stateTransaction{state: s}
+}
+
+// DestroyNpmIpsets deletes all sets and lists.
+func (s *State) DestroyNpmIpsets() error {
+	s.Sets = make(map[string]map[string]string)
+	s.SetTypes = make(map[string]string)
+	s.Lists = make(map[string]map[string]bool)
+	return nil
+}
+
+// ReconcileSets copies the sets and lists of expected.
+func (s *State) ReconcileSets(expected *State) error {
+	s.DestroyNpmIpsets()
+	for setName, elements := range expected.Sets {
+		s.CreateSet(setName, []string{expected.SetTypes[setName]})
+		for ip, podUID := range elements {
+			s.Sets[setName][ip] = podUID
+		}
+	}
+	for listName, setNames := range expected.Lists {
+		for setName := range setNames {
+			s.AddToList(listName, setName)
+		}
+	}
+
+	return nil
+}
+
+// RemoveStaleSets does nothing, ReconcileSets already dropped the sets expected doesn't have.
+func (s *State) RemoveStaleSets(expected *State) error {
+	return nil
+}
+
+// InitNpmChains creates the NPM chains with their default rules.
+func (s *State) InitNpmChains() error {
+	s.Chains = make(map[string][]*iptm.IptEntry)
+	for _, entry := range iptm.GetDefaultChainEntries() {
+		s.Chains[entry.Chain] = append(s.Chains[entry.Chain], entry)
+	}
+	s.ChainsInitialized = true
+
+	return nil
+}
+
+// UninitNpmChains deletes the NPM chains.
+func (s *State) UninitNpmChains() error {
+	s.Chains = make(map[string][]*iptm.IptEntry)
+	s.ChainsInitialized = false
+	return nil
+}
+
+// CheckAndAddForwardChain does nothing, State has no FORWARD chain.
+func (s *State) CheckAndAddForwardChain() error {
+	return nil
+}
+
+// AddBatch appends jump entries to their chain and inserts all other entries at the top, like iptables.
+func (s *State) AddBatch(entries []*iptm.IptEntry) error {
+	for _, entry := range entries {
+		if entry.IsJumpEntry {
+			s.Chains[entry.Chain] = append(s.Chains[entry.Chain], entry)
+			continue
+		}
+
+		s.Chains[entry.Chain] = append([]*iptm.IptEntry{entry}, s.Chains[entry.Chain]...)
+	}
+
+	return nil
+}
+
+// DeleteBatch removes the first rule of the chain with the specs of each entry.
+func (s *State) DeleteBatch(entries []*iptm.IptEntry) error {
+	for _, entry := range entries {
+		rules := s.Chains[entry.Chain]
+		for i, rule := range rules {
+			if reflect.DeepEqual(rule.Specs, entry.Specs) {
+				s.Chains[entry.Chain] = append(rules[:i:i], rules[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReconcileRules copies the chains of expected.
+func (s *State) ReconcileRules(expected *State) error {
+	s.Chains = make(map[string][]*iptm.IptEntry)
+	for chain, rules := range expected.Chains {
+		s.Chains[chain] = append([]*iptm.IptEntry(nil), rules...)
+	}
+	s.ChainsInitialized = expected.ChainsInitialized
+
+	return nil
+}
+
+type stateTransaction struct {
+	state      *State
+	operations []func()
+}
+
+func (tx *stateTransaction) CreateSet(setName string, spec []string) {
+	tx.operations = append(tx.operations, func() { tx.state.CreateSet(setName, spec) })
+}
+
+func (tx *stateTransaction) CreateList(listName string) {
+	tx.operations = append(tx.operations, func() { tx.state.CreateList(listName) })
+}
+
+func (tx *stateTransaction) AddToList(listName, setName string) {
+	tx.operations = append(tx.operations, func() { tx.state.AddToList(listName, setName) })
+}
+
+func (tx *stateTransaction) DeleteFromList(listName, setName string) {
+	tx.operations = append(tx.operations, func() { tx.state.DeleteFromList(listName, setName) })
+}
+
+func (tx *stateTransaction) AddToSet(setName, ip, spec, podUID string) {
+	tx.operations = append(tx.operations, func() { tx.state.AddToSet(setName, ip, spec, podUID) })
+}
+
+func (tx *stateTransaction) DeleteFromSet(setName, ip, podUID string) {
+	tx.operations = append(tx.operations, func() { tx.state.DeleteFromSet(setName, ip, podUID) })
+}
+
+func (tx *stateTransaction) Commit() []error {
+	for _, op := range tx.operations {
+		op()
+	}
+	tx.operations = nil
+
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package dataplane
+
+import (
+	"sync"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+)
+
+// Synchronize returns ipsets and rules which serialize the calls to their manager, so the event workers of
+// pods, namespaces and network policies can program them concurrently. A manager implementing both, like the
+// nftables one, has a single lock. Each call holds the lock only while it runs, not for a whole event.
+func Synchronize(ipsets Ipsets, rules Rules) (Ipsets, Rules) {
+	ipsetsLock := &sync.Mutex{}
+	rulesLock := &sync.Mutex{}
+	if interface{}(ipsets) == interface{}(rules) {
+		rulesLock = ipsetsLock
+	}
+
+	return &syncIpsets{ipsets: ipsets, lock: ipsetsLock}, &syncRules{rules: rules, lock: rulesLock}
+}
+
+type syncIpsets struct {
+	ipsets Ipsets
+	lock   *sync.Mutex
+}
+
+func (s *syncIpsets) Exists(key, val, kind string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.Exists(key, val, kind)
+}
+
+func (s *syncIpsets) CreateList(listName string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.CreateList(listName)
+}
+
+func (s *syncIpsets) AddToList(listName, setName string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.AddToList(listName, setName)
+}
+
+func (s *syncIpsets) DeleteFromList(listName, setName string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.DeleteFromList(listName, setName)
+}
+
+func (s *syncIpsets) CreateSet(setName string, spec []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.CreateSet(setName, spec)
+}
+
+func (s *syncIpsets) DeleteSet(setName string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.DeleteSet(setName)
+}
+
+func (s *syncIpsets) AddToSet(setName, ip, spec, podUID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.AddToSet(setName, ip, spec, podUID)
+}
+
+func (s *syncIpsets) DeleteFromSet(setName, ip, podUID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.DeleteFromSet(setName, ip, podUID)
+}
+
+// NewTransaction returns a transaction which stages the updates on its own. They are staged
+// into a transaction of the manager when it commits, so the sets they check don't change meanwhile.
+func (s *syncIpsets) NewTransaction() IpsetTransaction {
+	return &syncIpsetTransaction{ipsets: s}
+}
+
+func (s *syncIpsets) GetSet(name string) (*SetInfo, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.GetSet(name)
+}
+
+func (s *syncIpsets) GetSets() []*SetInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.GetSets()
+}
+
+func (s *syncIpsets) DestroyNpmIpsets() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.DestroyNpmIpsets()
+}
+
+func (s *syncIpsets) ReconcileSets(expected *State) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.ReconcileSets(expected)
+}
+
+func (s *syncIpsets) RemoveStaleSets(expected *State) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ipsets.RemoveStaleSets(expected)
+}
+
+type syncIpsetTransaction struct {
+	ipsets  *syncIpsets
+	updates []func(tx IpsetTransaction)
+}
+
+func (tx *syncIpsetTransaction) CreateSet(setName string, spec []string) {
+	tx.updates = append(tx.updates, func(inner IpsetTransaction) {
+		inner.CreateSet(setName, spec)
+	})
+}
+
+func (tx *syncIpsetTransaction) CreateList(listName string) {
+	tx.updates = append(tx.updates, func(inner IpsetTransaction) {
+		inner.CreateList(listName)
+	})
+}
+
+func (tx *syncIpsetTransaction) AddToList(listName, setName string) {
+	tx.updates = append(tx.updates, func(inner IpsetTransaction) {
+		inner.AddToList(listName, setName)
+	})
+}
+
+func (tx *syncIpsetTransaction) DeleteFromList(listName, setName string) {
+	tx.updates = append(tx.updates, func(inner IpsetTransaction) {
+		inner.DeleteFromList(listName, setName)
+	})
+}
+
+func (tx *syncIpsetTransaction) AddToSet(setName, ip, spec, podUID string) {
+	tx.updates = append(tx.updates, func(inner IpsetTransaction) {
+		inner.AddToSet(setName, ip, spec, podUID)
+	})
+}
+
+func (tx *syncIpsetTransaction) DeleteFromSet(setName, ip, podUID string) {
+	tx.updates = append(tx.updates, func(inner IpsetTransaction) {
+		inner.DeleteFromSet(setName, ip, podUID)
+	})
+}
+
+func (tx *syncIpsetTransaction) Commit() []error {
+	tx.ipsets.lock.Lock()
+	defer tx.ipsets.lock.Unlock()
+
+	inner := tx.ipsets.ipsets.NewTransaction()
+	for _, update := range tx.updates {
+		update(inner)
+	}
+
+	return inner.Commit()
+}
+
+type syncRules struct {
+	rules Rules
+	lock  *sync.Mutex
+}
+
+func (s *syncRules) InitNpmChains() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rules.InitNpmChains()
+}
+
+func (s *syncRules) UninitNpmChains() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rules.UninitNpmChains()
+}
+
+func (s *syncRules) CheckAndAddForwardChain() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rules.CheckAndAddForwardChain()
+}
+
+func (s *syncRules) AddBatch(entries []*iptm.IptEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rules.AddBatch(entries)
+}
+
+func (s *syncRules) DeleteBatch(entries []*iptm.IptEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rules.DeleteBatch(entries)
+}
+
+func (s *syncRules) ReconcileRules(expected *State) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rules.ReconcileRules(expected)
+}
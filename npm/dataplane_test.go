@@ -0,0 +1,351 @@
+package npm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/fakes"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestNetworkPolicyWithDataplaneFake(t *testing.T) {
+	fake := fakes.NewDataplaneFake()
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return fake, fake
+	}
+
+	npMgr := &NetworkPolicyManager{
+		NsMap:                        make(map[string]*Namespace),
+		PodMap:                       make(map[string]*NpmPod),
+		RawNpMap:                     make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap:               make(map[string]*networkingv1.NetworkPolicy),
+		isSafeToCleanUpAzureNpmChain: true,
+	}
+
+	allNs, err := newNs(util.KubeAllNamespacesFlag)
+	if err != nil {
+		t.Fatalf("TestNetworkPolicyWithDataplaneFake failed @ newNs")
+	}
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	policyYamls := []string{
+		"testpolicies/allow-app-backend-to-app-frontend-port-8000.yaml",
+		"testpolicies/allow-ns-y-pod-b-and-cidr.yaml",
+		"testpolicies/deny-all-policy.yaml",
+		"testpolicies/named-port.yaml",
+	}
+
+	var policies []*networkingv1.NetworkPolicy
+	for _, policyYaml := range policyYamls {
+		npObj, err := readPolicyYaml(policyYaml)
+		if err != nil {
+			t.Fatalf("TestNetworkPolicyWithDataplaneFake failed @ readPolicyYaml %s: %v", policyYaml, err)
+		}
+		policies = append(policies, npObj)
+
+		if err := npMgr.AddNetworkPolicy(npObj); err != nil {
+			t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ AddNetworkPolicy %s: %v", policyYaml, err)
+		}
+	}
+
+	if !fake.ChainsInitialized {
+		t.Fatalf("TestNetworkPolicyWithDataplaneFake failed @ AddNetworkPolicy, expected the chains to be initialized")
+	}
+
+	if _, exists := fake.Sets[util.KubeSystemFlag]; !exists {
+		t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ AddNetworkPolicy, expected the kube-system set")
+	}
+
+	for _, npObj := range policies {
+		_, _, _, _, _, iptEntries := translatePolicy(npObj)
+		for _, entry := range iptEntries {
+			if !fakeChainContains(fake, entry) {
+				t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ AddNetworkPolicy %s, missing rule %s %v", npObj.Name, entry.Chain, entry.Specs)
+			}
+		}
+
+		if footprint, exists := metrics.GetPolicyFootprint(npObj.Namespace, npObj.Name); !exists || footprint.IPTableRules != len(iptEntries) {
+			t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ AddNetworkPolicy %s, expected a footprint of %d rules. Actual: %+v", npObj.Name, len(iptEntries), footprint)
+		}
+	}
+
+	// allow-ns-y-pod-b-and-cidr refers to 3 sets, 1 list and 1 cidr set of 2 entries.
+	if footprint, _ := metrics.GetPolicyFootprint(policies[1].Namespace, policies[1].Name); footprint.IPSets != 5 || footprint.IPSetEntries != 2 {
+		t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ AddNetworkPolicy %s, expected a footprint of 5 sets and 2 entries. Actual: %+v", policies[1].Name, footprint)
+	}
+
+	set, err := npMgr.DescribeIPSet(util.GetHashedName("app:backend"))
+	if err != nil || set.Name != "app:backend" || len(set.Policies) != 1 || set.Policies[0] != GetNetworkPolicyKey(policies[0]) {
+		t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ DescribeIPSet, expected app:backend to be referenced by %s. Actual: %+v, %v", policies[0].Name, set, err)
+	}
+
+	for _, npObj := range policies {
+		if err := npMgr.DeleteNetworkPolicy(npObj); err != nil {
+			t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ DeleteNetworkPolicy %s: %v", npObj.Name, err)
+		}
+	}
+
+	if fake.ChainsInitialized {
+		t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ DeleteNetworkPolicy, expected the chains to be removed with the last policy")
+	}
+
+	if _, exists := metrics.GetPolicyFootprint(policies[0].Namespace, policies[0].Name); exists {
+		t.Errorf("TestNetworkPolicyWithDataplaneFake failed @ DeleteNetworkPolicy, expected the footprint of %s to be removed", policies[0].Name)
+	}
+}
+
+func TestMergedPolicyFootprints(t *testing.T) {
+	fake := fakes.NewDataplaneFake()
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return fake, fake
+	}
+
+	npMgr := &NetworkPolicyManager{
+		NsMap:                        make(map[string]*Namespace),
+		PodMap:                       make(map[string]*NpmPod),
+		RawNpMap:                     make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap:               make(map[string]*networkingv1.NetworkPolicy),
+		isSafeToCleanUpAzureNpmChain: true,
+	}
+
+	allNs, err := newNs(util.KubeAllNamespacesFlag)
+	if err != nil {
+		t.Fatalf("TestMergedPolicyFootprints failed @ newNs")
+	}
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	// both policies select app:frontend in testnamespace, they are merged under the name of the first one.
+	var policies []*networkingv1.NetworkPolicy
+	for _, policyYaml := range []string{"testpolicies/deny-all-to-app-frontend.yaml", "testpolicies/allow-all-to-app-frontend.yaml"} {
+		npObj, err := readPolicyYaml(policyYaml)
+		if err != nil {
+			t.Fatalf("TestMergedPolicyFootprints failed @ readPolicyYaml %s: %v", policyYaml, err)
+		}
+		policies = append(policies, npObj)
+
+		if err := npMgr.AddNetworkPolicy(npObj); err != nil {
+			t.Errorf("TestMergedPolicyFootprints failed @ AddNetworkPolicy %s: %v", policyYaml, err)
+		}
+	}
+
+	if len(npMgr.ProcessedNpMap) != 1 {
+		t.Fatalf("TestMergedPolicyFootprints failed @ AddNetworkPolicy, expected one merged policy. Actual: %d", len(npMgr.ProcessedNpMap))
+	}
+
+	// every policy has the footprint of its own translation.
+	for _, npObj := range policies {
+		_, _, _, _, _, iptEntries := translatePolicy(npObj)
+		if footprint, exists := metrics.GetPolicyFootprint(npObj.Namespace, npObj.Name); !exists || footprint.IPTableRules != len(iptEntries) {
+			t.Errorf("TestMergedPolicyFootprints failed @ AddNetworkPolicy %s, expected a footprint of %d rules. Actual: %+v", npObj.Name, len(iptEntries), footprint)
+		}
+	}
+
+	if err := npMgr.DeleteNetworkPolicy(policies[0]); err != nil {
+		t.Errorf("TestMergedPolicyFootprints failed @ DeleteNetworkPolicy: %v", err)
+	}
+
+	if _, exists := metrics.GetPolicyFootprint(policies[0].Namespace, policies[0].Name); exists {
+		t.Errorf("TestMergedPolicyFootprints failed @ DeleteNetworkPolicy, expected the footprint of %s to be removed", policies[0].Name)
+	}
+
+	if _, exists := metrics.GetPolicyFootprint(policies[1].Namespace, policies[1].Name); !exists {
+		t.Errorf("TestMergedPolicyFootprints failed @ DeleteNetworkPolicy, expected the footprint of %s to be kept", policies[1].Name)
+	}
+
+	npMgr.DeleteNetworkPolicy(policies[1])
+}
+
+func TestDualStackPodWithDataplaneFake(t *testing.T) {
+	fake := fakes.NewDataplaneFake()
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return fake, fake
+	}
+	defer func(ipv6Enabled bool) { util.IPv6Enabled = ipv6Enabled }(util.IPv6Enabled)
+
+	npMgr := &NetworkPolicyManager{
+		NsMap:          make(map[string]*Namespace),
+		PodMap:         make(map[string]*NpmPod),
+		RawNpMap:       make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap: make(map[string]*networkingv1.NetworkPolicy),
+	}
+
+	allNs, err := newNs(util.KubeAllNamespacesFlag)
+	if err != nil {
+		t.Fatalf("TestDualStackPodWithDataplaneFake failed @ newNs")
+	}
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-pod",
+			Namespace:       "test-ns",
+			UID:             "12345",
+			Labels:          map[string]string{"app": "frontend"},
+			ResourceVersion: "1",
+		},
+		Status: corev1.PodStatus{
+			Phase:  corev1.PodRunning,
+			PodIP:  "10.0.0.1",
+			PodIPs: []corev1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+		},
+	}
+
+	util.IPv6Enabled = false
+	if err := npMgr.AddPod(podObj); err != nil {
+		t.Fatalf("TestDualStackPodWithDataplaneFake failed @ AddPod with IPv6 disabled: %v", err)
+	}
+
+	if !fake.Exists("app:frontend", "10.0.0.1", util.IpsetNetHashFlag) || fake.Exists("app:frontend", "fd00::1", util.IpsetNetHashFlag) {
+		t.Errorf("TestDualStackPodWithDataplaneFake failed @ AddPod, expected only the IPv4 address with IPv6 disabled. Sets: %v", fake.Sets)
+	}
+
+	if err := npMgr.DeletePod(podObj); err != nil {
+		t.Fatalf("TestDualStackPodWithDataplaneFake failed @ DeletePod: %v", err)
+	}
+
+	util.IPv6Enabled = true
+	if err := npMgr.AddPod(podObj); err != nil {
+		t.Fatalf("TestDualStackPodWithDataplaneFake failed @ AddPod with IPv6 enabled: %v", err)
+	}
+
+	for _, podIP := range []string{"10.0.0.1", "fd00::1"} {
+		if !fake.Exists("ns-test-ns", podIP, util.IpsetNetHashFlag) || !fake.Exists("app:frontend", podIP, util.IpsetNetHashFlag) {
+			t.Errorf("TestDualStackPodWithDataplaneFake failed @ AddPod, expected %s in the pod's sets. Sets: %v", podIP, fake.Sets)
+		}
+	}
+
+	newPodObj := podObj.DeepCopy()
+	newPodObj.ObjectMeta.ResourceVersion = "2"
+	newPodObj.Status.PodIPs = []corev1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::2"}}
+	if err := npMgr.UpdatePod(newPodObj); err != nil {
+		t.Fatalf("TestDualStackPodWithDataplaneFake failed @ UpdatePod: %v", err)
+	}
+
+	if fake.Exists("app:frontend", "fd00::1", util.IpsetNetHashFlag) || !fake.Exists("app:frontend", "fd00::2", util.IpsetNetHashFlag) || !fake.Exists("app:frontend", "10.0.0.1", util.IpsetNetHashFlag) {
+		t.Errorf("TestDualStackPodWithDataplaneFake failed @ UpdatePod, expected fd00::1 to be replaced by fd00::2. Sets: %v", fake.Sets)
+	}
+
+	if err := npMgr.DeletePod(newPodObj); err != nil {
+		t.Fatalf("TestDualStackPodWithDataplaneFake failed @ DeletePod: %v", err)
+	}
+
+	for _, podIP := range []string{"10.0.0.1", "fd00::2"} {
+		if fake.Exists("app:frontend", podIP, util.IpsetNetHashFlag) {
+			t.Errorf("TestDualStackPodWithDataplaneFake failed @ DeletePod, expected %s to be removed. Sets: %v", podIP, fake.Sets)
+		}
+	}
+}
+
+func TestReconcileDataplane(t *testing.T) {
+	fake := fakes.NewDataplaneFake()
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return fake, fake
+	}
+
+	// a set of the previous run, which must be gone once NPM reconciled the dataplane.
+	fake.AddToSet("ns-old", "10.0.0.9", util.IpsetNetHashFlag, "")
+
+	informerFactory := informers.NewSharedInformerFactory(nil, 0)
+	npMgr := &NetworkPolicyManager{
+		podInformer:    informerFactory.Core().V1().Pods(),
+		nsInformer:     informerFactory.Core().V1().Namespaces(),
+		npInformer:     informerFactory.Networking().V1().NetworkPolicies(),
+		NsMap:          make(map[string]*Namespace),
+		PodMap:         make(map[string]*NpmPod),
+		RawNpMap:       make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap: make(map[string]*networkingv1.NetworkPolicy),
+	}
+
+	allNs, _ := newNs(util.KubeAllNamespacesFlag)
+	expected := dataplane.NewState()
+	allNs.IpsMgr, allNs.iptMgr = expected, expected
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	nsObj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-ns",
+			Labels:          map[string]string{"app": "test"},
+			ResourceVersion: "1",
+		},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-ns",
+			UID:       "12345",
+			Labels:    map[string]string{"app": "frontend"},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: "10.0.0.1",
+		},
+	}
+	npObj, err := readPolicyYaml("testpolicies/deny-all-policy.yaml")
+	if err != nil {
+		t.Fatalf("TestReconcileDataplane failed @ readPolicyYaml: %v", err)
+	}
+
+	npMgr.nsInformer.Informer().GetIndexer().Add(nsObj)
+	npMgr.podInformer.Informer().GetIndexer().Add(podObj)
+	npMgr.npInformer.Informer().GetIndexer().Add(npObj)
+
+	// the namespace event was handled before the caches synced, the others weren't.
+	if err := npMgr.AddNamespace(nsObj); err != nil {
+		t.Errorf("TestReconcileDataplane failed @ AddNamespace: %v", err)
+	}
+
+	if len(fake.Sets["ns-test-ns"]) != 0 || fake.Exists(util.KubeAllNamespacesFlag, "ns-test-ns", util.IpsetSetListFlag) {
+		t.Errorf("TestReconcileDataplane failed @ AddNamespace, expected the dataplane to be unchanged before reconciliation")
+	}
+
+	if err := npMgr.reconcileDataplane(); err != nil {
+		t.Fatalf("TestReconcileDataplane failed @ reconcileDataplane: %v", err)
+	}
+
+	if allNs.IpsMgr != dataplane.Ipsets(fake) || allNs.iptMgr != dataplane.Rules(fake) {
+		t.Errorf("TestReconcileDataplane failed @ reconcileDataplane, expected all-namespaces to use the dataplane")
+	}
+
+	if !fake.Exists(util.KubeAllNamespacesFlag, "ns-test-ns", util.IpsetSetListFlag) {
+		t.Errorf("TestReconcileDataplane failed @ reconcileDataplane, expected the namespace in the all-namespaces list")
+	}
+
+	if !fake.Exists("ns-test-ns", "10.0.0.1", util.IpsetNetHashFlag) || !fake.Exists("app:frontend", "10.0.0.1", util.IpsetNetHashFlag) {
+		t.Errorf("TestReconcileDataplane failed @ reconcileDataplane, expected the replayed pod in its sets. Sets: %v", fake.Sets)
+	}
+
+	if _, exists := npMgr.RawNpMap[GetNetworkPolicyKey(npObj)]; !exists || !fake.ChainsInitialized {
+		t.Errorf("TestReconcileDataplane failed @ reconcileDataplane, expected the replayed network policy in the chains")
+	}
+
+	if _, exists := fake.Sets["ns-old"]; exists {
+		t.Errorf("TestReconcileDataplane failed @ reconcileDataplane, expected the stale set to be removed")
+	}
+
+	// the add event of the namespace arrives again after reconciliation.
+	ns := npMgr.NsMap["ns-test-ns"]
+	if err := npMgr.AddNamespace(nsObj); err != nil || npMgr.NsMap["ns-test-ns"] != ns {
+		t.Errorf("TestReconcileDataplane failed @ AddNamespace, expected the late add event to be ignored")
+	}
+}
+
+func fakeChainContains(fake *fakes.DataplaneFake, entry *iptm.IptEntry) bool {
+	for _, rule := range fake.Chains[entry.Chain] {
+		if reflect.DeepEqual(rule.Specs, entry.Specs) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,486 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/http/api"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DescribeIPSet returns the set or list with the NPM name or the hashed name, e.g. app:frontend or azure-npm-123456,
+// along with the keys of the network policies whose rules match packets with it.
+func (npMgr *NetworkPolicyManager) DescribeIPSet(name string) (*api.DescribeIPSetResponse, error) {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	set, exists := npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr.GetSet(name)
+	if !exists {
+		return nil, fmt.Errorf("ipset %s not found", name)
+	}
+
+	resp := &api.DescribeIPSetResponse{
+		IPSet:    newIPSet(set),
+		Policies: []string{},
+	}
+
+	for npKey, npObj := range npMgr.RawNpMap {
+		_, _, _, _, _, iptEntries := translatePolicy(npObj)
+		if npMgr.referencesSet(iptEntries, set.Name) {
+			resp.Policies = append(resp.Policies, npKey)
+		}
+	}
+	sort.Strings(resp.Policies)
+
+	return resp, nil
+}
+
+// newIPSet returns the debug representation of a set or a list.
+func newIPSet(set *dataplane.SetInfo) api.IPSet {
+	ipset := api.IPSet{
+		Name:       set.Name,
+		HashedName: util.GetHashedName(set.Name),
+		Kind:       api.IPSetKindSet,
+	}
+
+	if set.IsList {
+		ipset.Kind = api.IPSetKindList
+		for setName := range set.Members {
+			ipset.Sets = append(ipset.Sets, setName)
+		}
+		sort.Strings(ipset.Sets)
+
+		return ipset
+	}
+
+	for ip, podUID := range set.Members {
+		ipset.Members = append(ipset.Members, api.IPSetMember{IP: ip, PodUID: podUID})
+	}
+	sort.Slice(ipset.Members, func(i, j int) bool {
+		return ipset.Members[i].IP < ipset.Members[j].IP
+	})
+
+	return ipset
+}
+
+// referencesSet reports whether entries match packets with the set, directly or through a list holding it.
+func (npMgr *NetworkPolicyManager) referencesSet(entries []*iptm.IptEntry, setName string) bool {
+	var (
+		ipsMgr     = npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
+		hashedName = util.GetHashedName(setName)
+	)
+
+	for _, entry := range entries {
+		for i := 0; i+1 < len(entry.Specs); i++ {
+			if entry.Specs[i] != util.IptablesMatchSetFlag {
+				continue
+			}
+
+			matched := entry.Specs[i+1]
+			if matched == hashedName {
+				return true
+			}
+
+			if list, exists := ipsMgr.GetSet(matched); exists && list.IsList {
+				if _, isMember := list.Members[setName]; isMember {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// GetPodPolicies returns the network policies whose podSelector targets the pod, and the ones whose ingress or egress peers include it.
+func (npMgr *NetworkPolicyManager) GetPodPolicies(namespace, name string) (*api.PodPoliciesResponse, error) {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	var pod *NpmPod
+	for _, npmPod := range npMgr.PodMap {
+		if npmPod.Namespace == namespace && npmPod.Name == name {
+			pod = npmPod
+			break
+		}
+	}
+	if pod == nil {
+		return nil, fmt.Errorf("pod %s/%s not found", namespace, name)
+	}
+
+	var nsLabels map[string]string
+	if ns, exists := npMgr.NsMap[util.GetNSNameWithPrefix(namespace)]; exists {
+		nsLabels = ns.LabelsMap
+	}
+
+	resp := &api.PodPoliciesResponse{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		PodIP:     pod.PodIP,
+		Labels:    pod.Labels,
+		Selecting: []api.PodPolicy{},
+		Peers:     []api.PodPolicy{},
+	}
+
+	for _, npKey := range getSortedKeys(npMgr.RawNpMap) {
+		npObj := npMgr.RawNpMap[npKey]
+
+		var selecting bool
+		if npObj.Namespace == pod.Namespace {
+			selecting = selectorMatches(&npObj.Spec.PodSelector, pod.Labels)
+		}
+
+		var peerDirections []string
+		for _, rule := range npObj.Spec.Ingress {
+			if peersInclude(rule.From, npObj.Namespace, pod, nsLabels) {
+				peerDirections = append(peerDirections, string(networkingv1.PolicyTypeIngress))
+				break
+			}
+		}
+		for _, rule := range npObj.Spec.Egress {
+			if peersInclude(rule.To, npObj.Namespace, pod, nsLabels) {
+				peerDirections = append(peerDirections, string(networkingv1.PolicyTypeEgress))
+				break
+			}
+		}
+
+		if !selecting && len(peerDirections) == 0 {
+			continue
+		}
+
+		_, _, _, _, _, iptEntries := translatePolicy(npObj)
+		ipsets, chains := npMgr.getPodRules(iptEntries, pod)
+
+		if selecting {
+			resp.Selecting = append(resp.Selecting, api.PodPolicy{
+				Policy:     npKey,
+				Directions: getPolicyTypes(npObj),
+				IPSets:     ipsets,
+				Chains:     chains,
+			})
+		}
+
+		if len(peerDirections) > 0 {
+			resp.Peers = append(resp.Peers, api.PodPolicy{
+				Policy:     npKey,
+				Directions: peerDirections,
+				IPSets:     ipsets,
+				Chains:     chains,
+			})
+		}
+	}
+
+	return resp, nil
+}
+
+// getPodRules returns the ipsets holding the pod which entries match packets with, directly or through the list
+// of the pod namespace, and the chains of these entries.
+func (npMgr *NetworkPolicyManager) getPodRules(entries []*iptm.IptEntry, pod *NpmPod) ([]string, []string) {
+	var (
+		ipsMgr   = npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
+		podNs    = util.GetNSNameWithPrefix(pod.Namespace)
+		setNames = make(map[string]bool)
+		chains   = make(map[string]bool)
+		holdsPod = make(map[string]bool)
+		checked  = make(map[string]bool)
+	)
+
+	for _, entry := range entries {
+		for i := 0; i+1 < len(entry.Specs); i++ {
+			if entry.Specs[i] != util.IptablesMatchSetFlag {
+				continue
+			}
+
+			hashedName := entry.Specs[i+1]
+			if !checked[hashedName] {
+				checked[hashedName] = true
+				if set, exists := ipsMgr.GetSet(hashedName); exists {
+					if set.IsList {
+						_, holdsPod[hashedName] = set.Members[podNs]
+					} else {
+						holdsPod[hashedName] = setHoldsIP(set.Members, pod.PodIP)
+					}
+					if holdsPod[hashedName] {
+						setNames[set.Name] = true
+					}
+				}
+			}
+
+			if holdsPod[hashedName] {
+				chains[entry.Chain] = true
+			}
+		}
+	}
+
+	return getSortedKeys(setNames), getSortedKeys(chains)
+}
+
+// setHoldsIP reports whether ip or a cidr holding it is an element of a set. Elements excepted with nomatch are ignored.
+func setHoldsIP(members map[string]string, ip string) bool {
+	if _, exists := members[ip]; exists {
+		return true
+	}
+
+	podIP := net.ParseIP(ip)
+	for member := range members {
+		if _, cidr, err := net.ParseCIDR(member); err == nil && cidr.Contains(podIP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peersInclude reports whether the peers of an ingress or egress rule of a policy in policyNs include the pod.
+// A rule without peers applies to every pod.
+func peersInclude(peers []networkingv1.NetworkPolicyPeer, policyNs string, pod *NpmPod, nsLabels map[string]string) bool {
+	if len(peers) == 0 {
+		return true
+	}
+
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			if ipBlockIncludes(peer.IPBlock, pod.PodIP) {
+				return true
+			}
+			continue
+		}
+
+		if peer.PodSelector == nil && peer.NamespaceSelector == nil {
+			continue
+		}
+
+		if peer.NamespaceSelector == nil && pod.Namespace != policyNs ||
+			peer.NamespaceSelector != nil && !selectorMatches(peer.NamespaceSelector, nsLabels) {
+			continue
+		}
+
+		if peer.PodSelector == nil || selectorMatches(peer.PodSelector, pod.Labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ipBlockIncludes(ipBlock *networkingv1.IPBlock, ip string) bool {
+	podIP := net.ParseIP(ip)
+	if _, cidr, err := net.ParseCIDR(ipBlock.CIDR); err != nil || !cidr.Contains(podIP) {
+		return false
+	}
+
+	for _, except := range ipBlock.Except {
+		if _, cidr, err := net.ParseCIDR(except); err == nil && cidr.Contains(podIP) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func selectorMatches(selector *metav1.LabelSelector, objLabels map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+
+	return s.Matches(labels.Set(objLabels))
+}
+
+// getPolicyTypes returns the directions a policy isolates the pods it selects in, defaulted like kubernetes does.
+func getPolicyTypes(npObj *networkingv1.NetworkPolicy) []string {
+	var types []string
+	for _, policyType := range npObj.Spec.PolicyTypes {
+		types = append(types, string(policyType))
+	}
+
+	if len(types) == 0 {
+		types = append(types, string(networkingv1.PolicyTypeIngress))
+		if len(npObj.Spec.Egress) > 0 {
+			types = append(types, string(networkingv1.PolicyTypeEgress))
+		}
+	}
+
+	return types
+}
+
+func getSortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]bool:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*networkingv1.NetworkPolicy:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*Namespace:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// GetDebugSnapshot returns the state of NPM, restricted to namespace unless it is empty.
+// Pods are sorted by namespace and name, and paginated with limit, 0 for all of them,
+// and cont, the Continue of the previous page. The other lists are only part of the first page.
+// The snapshot is a copy, it can be encoded once npMgr is unlocked.
+func (npMgr *NetworkPolicyManager) GetDebugSnapshot(namespace string, limit int, cont string) (*api.DebugSnapshot, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("invalid limit %d", limit)
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	ipsMgr := npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
+	sets := ipsMgr.GetSets()
+
+	snapshot := &api.DebugSnapshot{
+		Version:           api.DebugSnapshotVersion,
+		NodeName:          npMgr.NodeName,
+		Namespaces:        []api.SnapshotNamespace{},
+		Pods:              []api.SnapshotPod{},
+		Policies:          []*networkingv1.NetworkPolicy{},
+		ProcessedPolicies: []*networkingv1.NetworkPolicy{},
+		IPSets:            []api.IPSet{},
+		Summary: api.SnapshotSummary{
+			// all-namespaces is not a namespace.
+			Namespaces:        len(npMgr.NsMap) - 1,
+			Pods:              len(npMgr.PodMap),
+			Policies:          len(npMgr.RawNpMap),
+			ProcessedPolicies: len(npMgr.ProcessedNpMap),
+		},
+	}
+
+	for _, set := range sets {
+		if set.IsList {
+			snapshot.Summary.IPSetLists++
+		} else {
+			snapshot.Summary.IPSets++
+		}
+	}
+
+	var pods []*NpmPod
+	for _, npmPod := range npMgr.PodMap {
+		if namespace == "" || npmPod.Namespace == namespace {
+			pods = append(pods, npmPod)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return getSnapshotPodKey(pods[i]) < getSnapshotPodKey(pods[j])
+	})
+
+	var (
+		podIPs  []string
+		lastKey string
+	)
+	for _, npmPod := range pods {
+		podIPs = append(podIPs, npmPod.PodIP)
+
+		key := getSnapshotPodKey(npmPod)
+		if cont != "" && key <= cont || snapshot.Continue != "" {
+			continue
+		}
+
+		if limit > 0 && len(snapshot.Pods) == limit {
+			snapshot.Continue = lastKey
+			continue
+		}
+
+		snapshot.Pods = append(snapshot.Pods, newSnapshotPod(npmPod))
+		lastKey = key
+	}
+
+	if cont != "" {
+		return snapshot, nil
+	}
+
+	nsName := util.GetNSNameWithPrefix(namespace)
+	for _, name := range getSortedKeys(npMgr.NsMap) {
+		if name == util.KubeAllNamespacesFlag || namespace != "" && name != nsName {
+			continue
+		}
+
+		snapshot.Namespaces = append(snapshot.Namespaces, api.SnapshotNamespace{
+			Name:   strings.TrimPrefix(name, util.NamespacePrefix),
+			Labels: util.AppendMap(make(map[string]string), npMgr.NsMap[name].LabelsMap),
+		})
+	}
+
+	for _, policies := range []struct {
+		m    map[string]*networkingv1.NetworkPolicy
+		list *[]*networkingv1.NetworkPolicy
+	}{
+		{npMgr.RawNpMap, &snapshot.Policies},
+		{npMgr.ProcessedNpMap, &snapshot.ProcessedPolicies},
+	} {
+		for _, npKey := range getSortedKeys(policies.m) {
+			if npObj := policies.m[npKey]; namespace == "" || npObj.Namespace == namespace {
+				*policies.list = append(*policies.list, npObj.DeepCopy())
+			}
+		}
+	}
+
+	// a namespace only has the sets holding its pods and the lists holding the namespace.
+	for _, set := range sets {
+		if namespace != "" {
+			if set.IsList {
+				if _, holdsNs := set.Members[nsName]; !holdsNs {
+					continue
+				}
+			} else if set.Name != nsName && !setHoldsAnyIP(set.Members, podIPs) {
+				continue
+			}
+		}
+
+		snapshot.IPSets = append(snapshot.IPSets, newIPSet(set))
+	}
+	sort.Slice(snapshot.IPSets, func(i, j int) bool {
+		return snapshot.IPSets[i].Name < snapshot.IPSets[j].Name
+	})
+
+	return snapshot, nil
+}
+
+func getSnapshotPodKey(npmPod *NpmPod) string {
+	return npmPod.Namespace + "/" + npmPod.Name
+}
+
+func newSnapshotPod(npmPod *NpmPod) api.SnapshotPod {
+	return api.SnapshotPod{
+		Namespace:      npmPod.Namespace,
+		Name:           npmPod.Name,
+		UID:            npmPod.PodUID,
+		NodeName:       npmPod.NodeName,
+		IP:             npmPod.PodIP,
+		HostNetwork:    npmPod.IsHostNetwork,
+		Phase:          npmPod.Phase,
+		Labels:         util.AppendMap(make(map[string]string), npmPod.Labels),
+		ContainerPorts: append([]corev1.ContainerPort(nil), npmPod.ContainerPorts...),
+	}
+}
+
+func setHoldsAnyIP(members map[string]string, ips []string) bool {
+	for _, ip := range ips {
+		if setHoldsIP(members, ip) {
+			return true
+		}
+	}
+
+	return false
+}
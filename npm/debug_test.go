@@ -0,0 +1,89 @@
+package npm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/fakes"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGetPodPolicies(t *testing.T) {
+	fake := fakes.NewDataplaneFake()
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return fake, fake
+	}
+
+	npMgr := &NetworkPolicyManager{
+		NsMap:          make(map[string]*Namespace),
+		PodMap:         make(map[string]*NpmPod),
+		RawNpMap:       make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap: make(map[string]*networkingv1.NetworkPolicy),
+	}
+
+	allNs, _ := newNs(util.KubeAllNamespacesFlag)
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	for name, podInfo := range map[string][]string{"frontend": {"frontend", "10.0.0.1"}, "backend": {"backend", "10.0.0.2"}, "db": {"db", "10.0.0.3"}} {
+		podObj := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "testnamespace",
+				UID:       types.UID("uid-" + name),
+				Labels:    map[string]string{"app": podInfo[0]},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				PodIP: podInfo[1],
+			},
+		}
+		if err := npMgr.AddPod(podObj); err != nil {
+			t.Fatalf("TestGetPodPolicies failed @ AddPod %s: %v", name, err)
+		}
+	}
+
+	npObj, err := readPolicyYaml("testpolicies/allow-app-backend-to-app-frontend-port-8000.yaml")
+	if err != nil {
+		t.Fatalf("TestGetPodPolicies failed @ readPolicyYaml: %v", err)
+	}
+	if err := npMgr.AddNetworkPolicy(npObj); err != nil {
+		t.Fatalf("TestGetPodPolicies failed @ AddNetworkPolicy: %v", err)
+	}
+	npKey := GetNetworkPolicyKey(npObj)
+
+	frontend, err := npMgr.GetPodPolicies("testnamespace", "frontend")
+	if err != nil {
+		t.Fatalf("TestGetPodPolicies failed @ GetPodPolicies: %v", err)
+	}
+
+	if len(frontend.Selecting) != 1 || frontend.Selecting[0].Policy != npKey || len(frontend.Peers) != 0 {
+		t.Fatalf("TestGetPodPolicies failed @ GetPodPolicies, expected %s to select frontend. Actual: %+v", npKey, frontend)
+	}
+
+	if !reflect.DeepEqual(frontend.Selecting[0].IPSets, []string{"app:frontend", "ns-testnamespace"}) || len(frontend.Selecting[0].Chains) == 0 {
+		t.Errorf("TestGetPodPolicies failed @ GetPodPolicies, expected the rules matching app:frontend and ns-testnamespace. Actual: %+v", frontend.Selecting[0])
+	}
+
+	backend, err := npMgr.GetPodPolicies("testnamespace", "backend")
+	if err != nil {
+		t.Fatalf("TestGetPodPolicies failed @ GetPodPolicies: %v", err)
+	}
+
+	if len(backend.Selecting) != 0 || len(backend.Peers) != 1 || !reflect.DeepEqual(backend.Peers[0].Directions, []string{"Ingress"}) {
+		t.Errorf("TestGetPodPolicies failed @ GetPodPolicies, expected backend to be an ingress peer of %s. Actual: %+v", npKey, backend)
+	}
+
+	if db, err := npMgr.GetPodPolicies("testnamespace", "db"); err != nil || len(db.Selecting)+len(db.Peers) != 0 {
+		t.Errorf("TestGetPodPolicies failed @ GetPodPolicies, expected no policy for db. Actual: %+v, %v", db, err)
+	}
+
+	if _, err := npMgr.GetPodPolicies("testnamespace", "missing"); err == nil {
+		t.Errorf("TestGetPodPolicies failed @ GetPodPolicies, expected an error for a missing pod")
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/aitelemetry"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/nflog"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// SetDropLoggingMode changes how the packets dropped by network policies are logged, see util.SetDropLoggingMode.
+// The logging rules of the programmed network policies are replaced by the ones of the new mode,
+// and the packets logged by NFLOG rules are only read in nflog mode.
+func (npMgr *NetworkPolicyManager) SetDropLoggingMode(mode string) error {
+	npMgr.npLock.Lock()
+	defer npMgr.npLock.Unlock()
+
+	if mode == util.DropLoggingMode {
+		return nil
+	}
+
+	oldEntries := npMgr.getDropLoggingEntries()
+	oldMode := util.DropLoggingMode
+	if err := util.SetDropLoggingMode(mode); err != nil {
+		return err
+	}
+
+	if allNs := npMgr.getAllNs(); allNs != nil && npMgr.isAzureNpmChainCreated {
+		// the rules of the new mode are added first, so dropped packets keep being logged.
+		newEntries := npMgr.getDropLoggingEntries()
+		if err := allNs.iptMgr.AddBatch(newEntries); err != nil {
+			metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to add %d drop logging rules of mode %s with err: %v", len(newEntries), mode, err)
+			util.SetDropLoggingMode(oldMode)
+			return err
+		}
+
+		if err := allNs.iptMgr.DeleteBatch(oldEntries); err != nil {
+			metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to delete %d drop logging rules of mode %s with err: %v", len(oldEntries), oldMode, err)
+			return err
+		}
+	}
+
+	for _, npObj := range npMgr.RawNpMap {
+		sets, namedPorts, lists, ingressIPCidrs, egressIPCidrs, iptEntries := translatePolicy(npObj)
+		npMgr.setPolicyRules(npObj, iptEntries)
+		cidrIpsets := getCidrIpsets(npObj.ObjectMeta.Name, npObj.ObjectMeta.Namespace, ingressIPCidrs, egressIPCidrs)
+		metrics.SetPolicyFootprint(npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name, getPolicyFootprint(sets, namedPorts, lists, cidrIpsets, iptEntries))
+	}
+	log.Logf("Dropped packets logging changed from mode [%s] to [%s] for %d network policies.", oldMode, mode, len(npMgr.RawNpMap))
+
+	npMgr.updateDropLogReader()
+
+	return nil
+}
+
+// getDropLoggingEntries returns the drop logging rules of the programmed network policies in the current mode.
+// npMgr.npLock must be held.
+func (npMgr *NetworkPolicyManager) getDropLoggingEntries() []*iptm.IptEntry {
+	var entries []*iptm.IptEntry
+	for _, npObj := range npMgr.RawNpMap {
+		_, _, _, _, _, iptEntries := translatePolicy(npObj)
+		for _, entry := range iptEntries {
+			if isDropLoggingEntry(entry) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return entries
+}
+
+// isDropLoggingEntry returns whether an entry logs packets with the NFLOG or LOG target.
+func isDropLoggingEntry(entry *iptm.IptEntry) bool {
+	for i := 0; i+1 < len(entry.Specs); i++ {
+		if entry.Specs[i] == util.IptablesJumpFlag {
+			return entry.Specs[i+1] == util.IptablesNflog || entry.Specs[i+1] == util.IptablesLog
+		}
+	}
+
+	return false
+}
+
+// updateDropLogReader starts reading the packets logged by NFLOG rules in nflog mode once NPM started, and stops it otherwise.
+// npMgr.npLock must be held.
+func (npMgr *NetworkPolicyManager) updateDropLogReader() {
+	if util.DropLoggingMode != util.DropLoggingNflogMode || npMgr.stopCh == nil {
+		if npMgr.dropLogStopCh != nil {
+			close(npMgr.dropLogStopCh)
+			npMgr.dropLogStopCh = nil
+		}
+
+		return
+	}
+
+	if npMgr.dropLogStopCh == nil {
+		npMgr.dropLogStopCh = make(chan struct{})
+		go npMgr.logDroppedPackets(npMgr.stopCh, npMgr.dropLogStopCh)
+	}
+}
+
+// logDroppedPackets reports the packets logged by the NFLOG entries in front of the default drop entries,
+// until stopCh or readerStopCh is closed.
+func (npMgr *NetworkPolicyManager) logDroppedPackets(stopCh, readerStopCh <-chan struct{}) {
+	var (
+		reader        *nflog.Reader
+		backoff       time.Duration
+		readErrors    int
+		subscribeErrs int
+	)
+	defer func() {
+		if reader != nil {
+			reader.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-readerStopCh:
+			return
+		case <-time.After(backoff):
+		}
+
+		if reader == nil {
+			var err error
+			if reader, err = nflog.NewReader(util.DropLoggingNflogGroup); err != nil {
+				metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to subscribe to dropped packets: %v", err)
+				if subscribeErrs++; subscribeErrs >= dropLogMaxErrors {
+					return
+				}
+				backoff = getDropLogBackoff(backoff)
+				continue
+			}
+			subscribeErrs = 0
+		}
+
+		// Read returns at least every second, for the stop channels to be checked.
+		events, err := reader.Read()
+		if err != nil {
+			// the kernel drops log messages when the socket buffer is full, reading again catches up.
+			log.Logf("Error: failed to read dropped packets: %v", err)
+			backoff = getDropLogBackoff(backoff)
+			if readErrors++; readErrors >= dropLogMaxErrors {
+				metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to read dropped packets %d times in a row, subscribing again: %v", readErrors, err)
+				reader.Close()
+				reader, readErrors = nil, 0
+			}
+		} else {
+			backoff, readErrors = 0, 0
+		}
+
+		for _, event := range events {
+			report := npMgr.getDroppedPacketReport(event)
+			log.Logf(report.Message)
+			metrics.SendLog(report)
+		}
+	}
+}
+
+// getDropLogBackoff returns how long to wait after another failure to read dropped packets.
+func getDropLogBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return dropLogMinBackoff
+	}
+
+	if backoff *= 2; backoff > dropLogMaxBackoff {
+		return dropLogMaxBackoff
+	}
+
+	return backoff
+}
+
+// getDroppedPacketReport describes a dropped packet with the pods owning its addresses.
+func (npMgr *NetworkPolicyManager) getDroppedPacketReport(event *nflog.Event) aitelemetry.Report {
+	direction := "ingress"
+	if strings.HasPrefix(event.Prefix, util.DropLoggingEgressPrefix) {
+		direction = "egress"
+	}
+
+	npMgr.podLock.Lock()
+	srcPod, dstPod := npMgr.getPodKeyByIP(event.SrcIP), npMgr.getPodKeyByIP(event.DstIP)
+	npMgr.podLock.Unlock()
+
+	return aitelemetry.Report{
+		Message: fmt.Sprintf("Dropped %s packet %s from pod [%s] to pod [%s]", direction, event, srcPod, dstPod),
+		Context: strconv.Itoa(util.NpmID),
+		CustomDimensions: map[string]string{
+			"Direction": direction,
+			"Protocol":  event.Protocol,
+			"SrcIP":     event.SrcIP,
+			"SrcPort":   strconv.Itoa(event.SrcPort),
+			"SrcPod":    srcPod,
+			"DstIP":     event.DstIP,
+			"DstPort":   strconv.Itoa(event.DstPort),
+			"DstPod":    dstPod,
+		},
+	}
+}
+
+// getPodKeyByIP returns <namespace>/<name> of the pod with the ip, or an empty string.
+// npMgr.podLock must be held.
+func (npMgr *NetworkPolicyManager) getPodKeyByIP(podIP string) string {
+	pod, exists := npMgr.PodMap[npMgr.podKeysByIP[podIP]]
+	if !exists {
+		return ""
+	}
+
+	return pod.Namespace + "/" + pod.Name
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/nflog"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetDroppedPacketReport(t *testing.T) {
+	npMgr := &NetworkPolicyManager{PodMap: make(map[string]*NpmPod)}
+	npMgr.setNpmPod("ns-x/a/uid-a", &NpmPod{Name: "a", Namespace: "x", PodIP: "10.0.0.1"})
+	npMgr.setNpmPod("ns-y/b/uid-b", &NpmPod{Name: "b", Namespace: "y", PodIP: "10.0.0.2"})
+
+	report := npMgr.getDroppedPacketReport(&nflog.Event{
+		Prefix:   util.DropLoggingEgressPrefix,
+		SrcIP:    "10.0.0.1",
+		DstIP:    "10.0.0.2",
+		Protocol: "TCP",
+		SrcPort:  34567,
+		DstPort:  80,
+	})
+
+	expectedMessage := "Dropped egress packet TCP 10.0.0.1:34567 -> 10.0.0.2:80 from pod [x/a] to pod [y/b]"
+	if report.Message != expectedMessage {
+		t.Errorf("TestGetDroppedPacketReport failed @ message. Expected: %s, Actual: %s", expectedMessage, report.Message)
+	}
+
+	if report.CustomDimensions["SrcPod"] != "x/a" || report.CustomDimensions["DstPod"] != "y/b" || report.CustomDimensions["DstPort"] != "80" {
+		t.Errorf("TestGetDroppedPacketReport failed @ custom dimensions %v", report.CustomDimensions)
+	}
+
+	report = npMgr.getDroppedPacketReport(&nflog.Event{
+		Prefix:   util.DropLoggingIngressPrefix,
+		SrcIP:    "192.168.0.1",
+		DstIP:    "10.0.0.2",
+		Protocol: "ICMP",
+	})
+
+	if report.CustomDimensions["Direction"] != "ingress" || report.CustomDimensions["SrcPod"] != "" {
+		t.Errorf("TestGetDroppedPacketReport failed @ packet from outside the cluster, custom dimensions %v", report.CustomDimensions)
+	}
+
+	// the ip of a deleted pod was given to a new pod before the delete was processed.
+	npMgr.setNpmPod("ns-z/c/uid-c", &NpmPod{
+		Name:      "c",
+		Namespace: "z",
+		PodIP:     "10.0.0.1",
+		PodIPs:    []corev1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+	})
+	npMgr.deleteNpmPod("ns-x/a/uid-a")
+	if podKey := npMgr.getPodKeyByIP("10.0.0.1"); podKey != "z/c" {
+		t.Errorf("TestGetDroppedPacketReport failed @ getPodKeyByIP. Expected the new pod z/c, actual: %s", podKey)
+	}
+
+	if podKey := npMgr.getPodKeyByIP("fd00::1"); podKey != "z/c" {
+		t.Errorf("TestGetDroppedPacketReport failed @ getPodKeyByIP. Expected z/c for its IPv6 address, actual: %s", podKey)
+	}
+}
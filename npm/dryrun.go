@@ -0,0 +1,165 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// DryRunIpset is an ipset or ipset list NPM would create for a network policy.
+type DryRunIpset struct {
+	Name       string   `json:"name"`
+	HashedName string   `json:"hashedName"`
+	Type       string   `json:"type"`
+	Members    []string `json:"members,omitempty"`
+}
+
+// DryRunResult is what NPM would program on a node for a network policy.
+// Members of sets and lists are only known when pod and namespace fixtures are provided.
+type DryRunResult struct {
+	Namespace  string           `json:"namespace"`
+	Name       string           `json:"name"`
+	Sets       []*DryRunIpset   `json:"sets"`
+	NamedPorts []*DryRunIpset   `json:"namedPorts"`
+	Lists      []*DryRunIpset   `json:"lists"`
+	CidrSets   []*DryRunIpset   `json:"cidrSets"`
+	IptEntries []*iptm.IptEntry `json:"iptEntries"`
+}
+
+// DryRunPolicy runs a network policy through translatePolicy without touching the kernel.
+// pods and namespaces are used to resolve the members NPM would add to the translated ipsets.
+func DryRunPolicy(npObj *networkingv1.NetworkPolicy, pods []*corev1.Pod, namespaces []*corev1.Namespace) *DryRunResult {
+	sets, namedPorts, lists, ingressIPCidrs, egressIPCidrs, iptEntries := translatePolicy(npObj)
+	fixtures := newDryRunFixtures(pods, namespaces)
+
+	result := &DryRunResult{
+		Namespace:  npObj.ObjectMeta.Namespace,
+		Name:       npObj.ObjectMeta.Name,
+		IptEntries: iptEntries,
+	}
+
+	for _, set := range sets {
+		result.Sets = append(result.Sets, newDryRunIpset(set, util.IpsetNetHashFlag, fixtures.sets[set]))
+	}
+
+	for _, set := range namedPorts {
+		result.NamedPorts = append(result.NamedPorts, newDryRunIpset(set, util.IpsetIPPortHashFlag, fixtures.sets[set]))
+	}
+
+	for _, list := range lists {
+		result.Lists = append(result.Lists, newDryRunIpset(list, util.IpsetSetListFlag, fixtures.lists[list]))
+	}
+
+	for ingressOrEgress, ipCidrs := range map[string][][]string{"in": ingressIPCidrs, "out": egressIPCidrs} {
+		for i, ipCidrSet := range ipCidrs {
+			if len(ipCidrSet) == 0 {
+				continue
+			}
+			setName := getCidrIpsetName(npObj.ObjectMeta.Name, npObj.ObjectMeta.Namespace, i, ingressOrEgress)
+			result.CidrSets = append(result.CidrSets, newDryRunIpset(setName, util.IpsetNetHashFlag, getCidrIpsetEntries(ipCidrSet)))
+		}
+	}
+	sort.Slice(result.CidrSets, func(i, j int) bool { return result.CidrSets[i].Name < result.CidrSets[j].Name })
+
+	return result
+}
+
+func newDryRunIpset(name, setType string, members []string) *DryRunIpset {
+	members = util.UniqueStrSlice(members)
+	sort.Strings(members)
+
+	return &DryRunIpset{
+		Name:       name,
+		HashedName: util.GetHashedName(name),
+		Type:       setType,
+		Members:    members,
+	}
+}
+
+// IptablesSave renders the result in the ipset restore and iptables-restore formats NPM programs it with.
+func (result *DryRunResult) IptablesSave() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# Network policy %s/%s\n", result.Namespace, result.Name)
+	sb.WriteString("# ipsets\n")
+	for _, sets := range [][]*DryRunIpset{result.Sets, result.NamedPorts, result.CidrSets, result.Lists} {
+		for _, set := range sets {
+			fmt.Fprintf(&sb, "# %s\n", set.Name)
+			fmt.Fprintf(&sb, "%s %s %s\n", util.IpsetCreationFlag, set.HashedName, set.Type)
+			for _, member := range set.Members {
+				if set.Type == util.IpsetSetListFlag {
+					member = util.GetHashedName(member)
+				}
+				member = strings.Replace(member, util.IpsetNomatch, " "+util.IpsetNomatch, 1)
+				fmt.Fprintf(&sb, "%s %s %s\n", util.IpsetAppendFlag, set.HashedName, member)
+			}
+		}
+	}
+
+	sb.WriteString("# iptables\n")
+	sb.WriteString(iptm.CraftAddRestoreFile(result.IptEntries))
+
+	return sb.String()
+}
+
+// dryRunFixtures holds the ipset members NPM would program for a set of pods and namespaces.
+type dryRunFixtures struct {
+	sets  map[string][]string
+	lists map[string][]string
+}
+
+func newDryRunFixtures(pods []*corev1.Pod, namespaces []*corev1.Namespace) *dryRunFixtures {
+	fixtures := &dryRunFixtures{
+		sets:  make(map[string][]string),
+		lists: make(map[string][]string),
+	}
+
+	nsLabels := make(map[string]map[string]string)
+	for _, nsObj := range namespaces {
+		nsLabels[util.GetNSNameWithPrefix(nsObj.ObjectMeta.Name)] = nsObj.ObjectMeta.Labels
+	}
+
+	for _, podObj := range pods {
+		if !isValidPod(podObj) || isHostNetworkPod(podObj) ||
+			podObj.Status.Phase == corev1.PodSucceeded || podObj.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		podNs := util.GetNSNameWithPrefix(podObj.ObjectMeta.Namespace)
+		if _, exists := nsLabels[podNs]; !exists {
+			nsLabels[podNs] = nil
+		}
+
+		podIP := podObj.Status.PodIP
+		fixtures.sets[podNs] = append(fixtures.sets[podNs], podIP)
+		for _, set := range util.GetIPSetListFromLabels(podObj.ObjectMeta.Labels) {
+			fixtures.sets[set] = append(fixtures.sets[set], podIP)
+		}
+
+		for _, port := range getContainerPortList(podObj) {
+			if port.Name == "" {
+				continue
+			}
+
+			namedPortname, entry := getNamedPortIpsetEntry(podIP, port)
+			fixtures.sets[namedPortname] = append(fixtures.sets[namedPortname], entry)
+		}
+	}
+
+	for nsName, labels := range nsLabels {
+		fixtures.lists[util.KubeAllNamespacesFlag] = append(fixtures.lists[util.KubeAllNamespacesFlag], nsName)
+		for _, label := range util.GetIPSetListFromLabels(labels) {
+			list := util.GetNSNameWithPrefix(label)
+			fixtures.lists[list] = append(fixtures.lists[list], nsName)
+		}
+	}
+
+	return fixtures
+}
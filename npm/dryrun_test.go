@@ -0,0 +1,120 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDryRunPolicy(t *testing.T) {
+	policy, err := readPolicyYaml("testpolicies/allow-ns-y-pod-b-and-cidr.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods := []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "b",
+				Namespace: "y",
+				Labels:    map[string]string{"pod": "b"},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				PodIP: "10.240.0.2",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "a",
+				Namespace: "netpol-x",
+				Labels:    map[string]string{"pod": "a"},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				PodIP: "10.240.0.3",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "completed",
+				Namespace: "netpol-x",
+				Labels:    map[string]string{"pod": "a"},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodSucceeded,
+				PodIP: "10.240.0.4",
+			},
+		},
+	}
+
+	namespaces := []*corev1.Namespace{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "y",
+				Labels: map[string]string{"ns": "y"},
+			},
+		},
+	}
+
+	result := DryRunPolicy(policy, pods, namespaces)
+	if result.Namespace != "netpol-x" || result.Name != "allow-ns-y-pod-b-and-cidr" {
+		t.Errorf("TestDryRunPolicy failed @ result metadata. Got %s/%s", result.Namespace, result.Name)
+	}
+
+	members := make(map[string][]string)
+	for _, set := range append(result.Sets, result.Lists...) {
+		members[set.Name] = set.Members
+		if set.HashedName != util.GetHashedName(set.Name) {
+			t.Errorf("TestDryRunPolicy failed @ set %s hashed name %s", set.Name, set.HashedName)
+		}
+	}
+
+	expectedMembers := map[string][]string{
+		"pod:a":       {"10.240.0.3"},
+		"pod:b":       {"10.240.0.2"},
+		"ns-netpol-x": {"10.240.0.3"},
+		"ns-ns:y":     {"ns-y"},
+	}
+	for name, expected := range expectedMembers {
+		actual, exists := members[name]
+		if !exists {
+			t.Errorf("TestDryRunPolicy failed @ set %s not translated", name)
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("TestDryRunPolicy failed @ set %s members. Expected: %v, Actual: %v", name, expected, actual)
+		}
+	}
+
+	cidrSetName := getCidrIpsetName("allow-ns-y-pod-b-and-cidr", "netpol-x", 0, "in")
+	expectedCidrSets := []*DryRunIpset{newDryRunIpset(cidrSetName, util.IpsetNetHashFlag, []string{"10.0.0.0/8", "10.240.0.0/16nomatch"})}
+	if !reflect.DeepEqual(result.CidrSets, expectedCidrSets) {
+		t.Errorf("TestDryRunPolicy failed @ result.CidrSets. Expected: %+v, Actual: %+v", expectedCidrSets[0], result.CidrSets)
+	}
+
+	output := result.IptablesSave()
+	for _, expected := range []string{
+		util.IpsetCreationFlag + " " + util.GetHashedName("pod:a") + " " + util.IpsetNetHashFlag,
+		util.IpsetAppendFlag + " " + util.GetHashedName("ns-ns:y") + " " + util.GetHashedName("ns-y"),
+		util.IpsetAppendFlag + " " + util.GetHashedName(cidrSetName) + " 10.240.0.0/16 " + util.IpsetNomatch,
+		"*filter\n",
+		"COMMIT\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("TestDryRunPolicy failed @ result.IptablesSave. Expected output to contain %q, got:\n%s", expected, output)
+		}
+	}
+
+	iptables := output[strings.Index(output, "*filter"):]
+	if strings.Count(iptables, "\n-I ")+strings.Count(iptables, "\n-A ") != len(result.IptEntries) {
+		t.Errorf("TestDryRunPolicy failed @ result.IptablesSave. Expected one rule per iptables entry, got:\n%s", output)
+	}
+}
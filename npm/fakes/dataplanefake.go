@@ -0,0 +1,20 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package fakes
+
+import (
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+)
+
+// DataplaneFake keeps the sets and rules NPM programs in memory, in the order the kernel would hold them.
+// It implements both dataplane.Ipsets and dataplane.Rules, and evaluates packets against the programmed rules.
+type DataplaneFake struct {
+	*dataplane.State
+}
+
+// NewDataplaneFake creates an empty DataplaneFake.
+func NewDataplaneFake() *DataplaneFake {
+	return &DataplaneFake{
+		State: dataplane.NewState(),
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package fakes
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// maxJumpDepth bounds the chains a packet traverses, NPM rules never jump in a loop.
+const maxJumpDepth = 16
+
+// Packet is the first packet of a connection forwarded through the NPM chains.
+type Packet struct {
+	SrcIP    string
+	DstIP    string
+	Protocol string // TCP, UDP or SCTP
+	DstPort  int
+}
+
+func (p Packet) String() string {
+	return fmt.Sprintf("%s -> %s %s/%d", p.SrcIP, p.DstIP, p.Protocol, p.DstPort)
+}
+
+// packetContext is the state of a packet while it traverses the chains.
+type packetContext struct {
+	packet Packet
+	mark   uint32
+	trace  []string
+}
+
+// Evaluate runs packet through the programmed NPM chains, starting at AZURE-NPM like the FORWARD chain does.
+// It returns whether the packet is accepted, and the rules it matched for troubleshooting.
+// Packets falling through AZURE-NPM are accepted, like the FORWARD chain policy does.
+func (f *DataplaneFake) Evaluate(packet Packet) (bool, []string, error) {
+	ctx := &packetContext{packet: packet}
+
+	verdict, err := f.evaluateChain(ctx, util.IptablesAzureChain, 0)
+	if err != nil {
+		return false, ctx.trace, err
+	}
+
+	return verdict != util.IptablesDrop && verdict != util.IptablesReject, ctx.trace, nil
+}
+
+// evaluateChain returns the terminating target a chain applies to the packet, or "" when the packet returns from it.
+func (f *DataplaneFake) evaluateChain(ctx *packetContext, chain string, depth int) (string, error) {
+	if depth > maxJumpDepth {
+		return "", fmt.Errorf("jumped more than %d chains deep at %s", maxJumpDepth, chain)
+	}
+
+	for _, entry := range f.Chains[chain] {
+		rule, err := parseRule(entry)
+		if err != nil {
+			return "", err
+		}
+
+		matched, err := f.matchRule(ctx, rule)
+		if err != nil {
+			return "", fmt.Errorf("failed to evaluate rule %s %v: %v", chain, entry.Specs, err)
+		}
+		if !matched {
+			continue
+		}
+
+		ctx.trace = append(ctx.trace, fmt.Sprintf("%s %s", chain, strings.Join(entry.Specs, " ")))
+
+		switch rule.target {
+		case util.IptablesAccept, util.IptablesDrop, util.IptablesReject:
+			return rule.target, nil
+		case util.IptablesReturn:
+			return "", nil
+		case util.IptablesMark:
+			ctx.mark = (ctx.mark &^ rule.markMask) | rule.markValue
+		case util.IptablesLog, util.IptablesNflog, "":
+			// non terminating.
+		default:
+			verdict, err := f.evaluateChain(ctx, rule.target, depth+1)
+			if err != nil || verdict != "" {
+				return verdict, err
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// setMatch is a -m set [!] --match-set <name> <flags> match.
+type setMatch struct {
+	negated bool
+	name    string
+	flags   []string
+}
+
+// rule is the parsed form of the specs of an IptEntry.
+type rule struct {
+	setMatches []setMatch
+	protocol   string
+	dstPorts   []string
+	srcCidr    string
+	dstCidr    string
+	mark       string
+	hasState   bool
+	target     string
+	markValue  uint32
+	markMask   uint32
+}
+
+// parseRule parses the specs NPM programs. It fails on specs it doesn't know, so the fake never silently ignores a match.
+func parseRule(entry *iptm.IptEntry) (*rule, error) {
+	var (
+		r       = &rule{}
+		specs   = util.DropEmptyFields(entry.Specs)
+		negated bool
+	)
+
+	next := func(i int) (string, error) {
+		if i+1 >= len(specs) {
+			return "", fmt.Errorf("missing value of %s in %v", specs[i], specs)
+		}
+		return specs[i+1], nil
+	}
+
+	for i := 0; i < len(specs); i++ {
+		spec := specs[i]
+		switch spec {
+		case util.IptablesModuleFlag:
+			// the options of the module follow.
+			i++
+		case util.IptablesNotFlag:
+			negated = true
+		case util.IptablesMatchSetFlag:
+			if i+2 >= len(specs) {
+				return nil, fmt.Errorf("missing set or flags of %s in %v", spec, specs)
+			}
+			r.setMatches = append(r.setMatches, setMatch{negated: negated, name: specs[i+1], flags: strings.Split(specs[i+2], ",")})
+			negated = false
+			i += 2
+		case util.IptablesProtFlag, util.IptablesDstPortFlag, util.IptablesMultiDestportFlag, util.IptablesSFlag, util.IptablesDFlag,
+			util.IptablesMarkFlag, util.IptablesStateFlag, util.IptablesJumpFlag, util.IptablesSetMarkFlag, "--set-xmark",
+			util.IptablesCommentFlag, util.IptablesLimitFlag, util.IptablesLogPrefixFlag, util.IptablesNflogGroupFlag, util.IptablesNflogPrefixFlag:
+			value, err := next(i)
+			if err != nil {
+				return nil, err
+			}
+			i++
+
+			switch spec {
+			case util.IptablesProtFlag:
+				r.protocol = strings.ToUpper(value)
+			case util.IptablesDstPortFlag, util.IptablesMultiDestportFlag:
+				r.dstPorts = strings.Split(value, ",")
+			case util.IptablesSFlag:
+				r.srcCidr = value
+			case util.IptablesDFlag:
+				r.dstCidr = value
+			case util.IptablesMarkFlag:
+				r.mark = value
+			case util.IptablesStateFlag:
+				r.hasState = true
+			case util.IptablesJumpFlag:
+				r.target = value
+			case util.IptablesSetMarkFlag, "--set-xmark":
+				if r.markValue, r.markMask, err = parseMark(value); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown spec %s in %v", spec, specs)
+		}
+	}
+
+	return r, nil
+}
+
+// parseMark parses a <value>[/<mask>] mark.
+func parseMark(mark string) (uint32, uint32, error) {
+	parts := strings.SplitN(mark, "/", 2)
+	value, err := strconv.ParseUint(parts[0], 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid mark %s: %v", mark, err)
+	}
+
+	mask := uint64(0xffffffff)
+	if len(parts) == 2 {
+		if mask, err = strconv.ParseUint(parts[1], 0, 32); err != nil {
+			return 0, 0, fmt.Errorf("invalid mark mask %s: %v", mark, err)
+		}
+	}
+
+	return uint32(value), uint32(mask), nil
+}
+
+// matchRule reports whether the packet matches all matches of a rule.
+func (f *DataplaneFake) matchRule(ctx *packetContext, r *rule) (bool, error) {
+	// only first packets are evaluated, they never belong to an established connection.
+	if r.hasState {
+		return false, nil
+	}
+
+	if r.mark != "" {
+		mark, _, err := parseMark(r.mark)
+		if err != nil {
+			return false, err
+		}
+		if ctx.mark != mark {
+			return false, nil
+		}
+	}
+
+	if r.protocol != "" && r.protocol != strings.ToUpper(ctx.packet.Protocol) {
+		return false, nil
+	}
+
+	if len(r.dstPorts) > 0 && !matchPorts(r.dstPorts, ctx.packet.DstPort) {
+		return false, nil
+	}
+
+	if r.srcCidr != "" && !matchCidr(r.srcCidr, ctx.packet.SrcIP) {
+		return false, nil
+	}
+
+	if r.dstCidr != "" && !matchCidr(r.dstCidr, ctx.packet.DstIP) {
+		return false, nil
+	}
+
+	for _, match := range r.setMatches {
+		matched, err := f.matchSet(ctx, match.name, match.flags)
+		if err != nil {
+			return false, err
+		}
+		if matched == match.negated {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// matchSet reports whether the packet fields flags select are in the set or list with the hashed name.
+func (f *DataplaneFake) matchSet(ctx *packetContext, hashedName string, flags []string) (bool, error) {
+	setName, isList, exists := f.getSetByHashedName(hashedName)
+	if !exists {
+		// the kernel keeps an emptied set a rule refers to, the fake deletes it.
+		return false, nil
+	}
+
+	if isList {
+		for memberName := range f.Lists[setName] {
+			matched, err := f.matchSet(ctx, util.GetHashedName(memberName), flags)
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+
+	ip := ctx.packet.SrcIP
+	if flags[0] == util.IptablesDstFlag {
+		ip = ctx.packet.DstIP
+	}
+
+	if f.SetTypes[setName] == util.IpsetIPPortHashFlag {
+		if len(flags) != 2 {
+			return false, fmt.Errorf("set %s of type %s needs 2 flags, got %v", setName, util.IpsetIPPortHashFlag, flags)
+		}
+		// named ports are matched with dst,dst, i.e. the destination ip and port.
+		element := fmt.Sprintf("%s,%s:%d", ip, strings.ToLower(ctx.packet.Protocol), ctx.packet.DstPort)
+		_, exists := f.Sets[setName][element]
+		return exists, nil
+	}
+
+	return matchNetHash(f.Sets[setName], ip), nil
+}
+
+// getSetByHashedName returns the NPM name of the set or list with the hashed name.
+func (f *DataplaneFake) getSetByHashedName(hashedName string) (string, bool, bool) {
+	for setName := range f.Sets {
+		if util.GetHashedName(setName) == hashedName {
+			return setName, false, true
+		}
+	}
+
+	for listName := range f.Lists {
+		if util.GetHashedName(listName) == hashedName {
+			return listName, true, true
+		}
+	}
+
+	return "", false, false
+}
+
+// matchNetHash reports whether ip is in a nethash set. Like the kernel, the most specific cidr holding ip decides,
+// so an ip in a nomatch cidr doesn't match even though a larger cidr holds it.
+func matchNetHash(elements map[string]string, ip string) bool {
+	var (
+		matched     bool
+		matchedOnes = -1
+	)
+
+	for element := range elements {
+		cidr := strings.TrimSpace(strings.TrimSuffix(element, util.IpsetNomatch))
+		isNomatch := cidr != element
+
+		ipNet, ok := parseCidr(cidr)
+		if !ok || !ipNet.Contains(net.ParseIP(ip)) {
+			continue
+		}
+
+		if ones, _ := ipNet.Mask.Size(); ones > matchedOnes {
+			matched, matchedOnes = !isNomatch, ones
+		}
+	}
+
+	return matched
+}
+
+// matchPorts reports whether port is one of ports or in one of their <first>:<last> ranges.
+func matchPorts(ports []string, port int) bool {
+	for _, p := range ports {
+		bounds := strings.SplitN(p, ":", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+
+		if port >= first && port <= last {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchCidr reports whether ip is in cidr, which may be a single ip.
+func matchCidr(cidr, ip string) bool {
+	ipNet, ok := parseCidr(cidr)
+	return ok && ipNet.Contains(net.ParseIP(ip))
+}
+
+// parseCidr parses a cidr or a single ip, which is a cidr of one address.
+func parseCidr(cidr string) (*net.IPNet, bool) {
+	if !strings.Contains(cidr, "/") {
+		if util.IsIPv6(cidr) {
+			cidr += "/128"
+		} else {
+			cidr += "/32"
+		}
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	return ipNet, err == nil
+}
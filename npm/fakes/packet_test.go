@@ -0,0 +1,41 @@
+package fakes
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestEvaluate(t *testing.T) {
+	fake := NewDataplaneFake()
+	fake.InitNpmChains()
+	fake.AddToSet("ns-x", "10.0.0.0/16", util.IpsetNetHashFlag, "")
+	fake.AddToSet("ns-x", "10.0.1.0/24nomatch", util.IpsetNetHashFlag, "")
+	fake.AddBatch([]*iptm.IptEntry{
+		{
+			Chain: util.IptablesAzureIngressPortChain,
+			Specs: []string{
+				util.IptablesModuleFlag, util.IptablesSetModuleFlag, util.IptablesMatchSetFlag, util.GetHashedName("ns-x"), util.IptablesDstFlag,
+				util.IptablesJumpFlag, util.IptablesDrop,
+			},
+		},
+	})
+
+	testCases := map[string]bool{
+		"10.0.0.1": false, // in ns-x
+		"10.0.1.1": true,  // in the nomatch cidr of ns-x
+		"10.1.0.1": true,  // not in ns-x
+	}
+	for dstIP, expected := range testCases {
+		packet := Packet{SrcIP: "10.2.0.1", DstIP: dstIP, Protocol: "TCP", DstPort: 80}
+		if allowed, trace, err := fake.Evaluate(packet); err != nil || allowed != expected {
+			t.Errorf("TestEvaluate failed @ Evaluate %s. Expected allowed: %t, Actual: %t, %v, trace: %v", packet, expected, allowed, err, trace)
+		}
+	}
+
+	fake.AddBatch([]*iptm.IptEntry{{Chain: util.IptablesAzureIngressPortChain, Specs: []string{"--unknown-flag"}}})
+	if _, _, err := fake.Evaluate(Packet{SrcIP: "10.2.0.1", DstIP: "10.0.0.1", Protocol: "TCP", DstPort: 80}); err == nil {
+		t.Errorf("TestEvaluate failed @ Evaluate, expected an error for an unknown spec")
+	}
+}
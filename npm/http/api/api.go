@@ -5,12 +5,66 @@ const (
 	DefaultHttpPort    = "10091"
 	NodeMetricsPath    = "/node-metrics"
 	ClusterMetricsPath = "/cluster-metrics"
-	NPMMgrPath         = "/npm/v1/debug/manager"
+	NPMSnapshotPath    = "/npm/v1/debug/snapshot"
+	NPMIPSetPath       = "/npm/v1/debug/ipset"
+	NPMPodPoliciesPath = "/npm/v1/debug/pod-policies"
 )
 
-type DescribeIPSetRequest struct {
-	ipsetname string `json:"name"`
-}
+const (
+	IPSetKindSet  = "set"
+	IPSetKindList = "list"
+)
 
+// Query parameters of NPMIPSetPath.
+const (
+	// IPSetNameParam is the hashed name of the ipset, e.g. azure-npm-123456, or its NPM name, e.g. app:frontend.
+	IPSetNameParam = "name"
+)
+
+// DescribeIPSetResponse describes an ipset and the network policies which match packets with it.
 type DescribeIPSetResponse struct {
+	IPSet
+	Policies []string `json:"policies"`
+}
+
+// IPSet is a set with its members, or a list with its sets.
+type IPSet struct {
+	Name       string        `json:"name"`
+	HashedName string        `json:"hashedName"`
+	Kind       string        `json:"kind"`
+	Members    []IPSetMember `json:"members,omitempty"`
+	Sets       []string      `json:"sets,omitempty"`
+}
+
+// IPSetMember is an element of a set with the uid of the pod it was added for, if any.
+type IPSetMember struct {
+	IP     string `json:"ip"`
+	PodUID string `json:"podUid,omitempty"`
+}
+
+// Query parameters of NPMPodPoliciesPath.
+const (
+	// PodNamespaceParam is the namespace of the pod.
+	PodNamespaceParam = "namespace"
+	// PodNameParam is the name of the pod.
+	PodNameParam = "name"
+)
+
+// PodPoliciesResponse lists the network policies selecting a pod, and the ones whose ingress or egress peers include it.
+type PodPoliciesResponse struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	PodIP     string            `json:"podIP"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Selecting []PodPolicy       `json:"selecting"`
+	Peers     []PodPolicy       `json:"peers"`
+}
+
+// PodPolicy is a network policy applying to a pod, with the ipsets holding the pod its rules match packets with,
+// and the chains of these rules.
+type PodPolicy struct {
+	Policy     string   `json:"policy"`
+	Directions []string `json:"directions"`
+	IPSets     []string `json:"ipsets"`
+	Chains     []string `json:"chains"`
 }
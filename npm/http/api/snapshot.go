@@ -0,0 +1,65 @@
+package api
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// DebugSnapshotVersion is the version of the DebugSnapshot schema.
+// Fields may be added within a version, renaming or removing one requires a new version.
+const DebugSnapshotVersion = "v1"
+
+// Query parameters of NPMSnapshotPath.
+const (
+	// SnapshotNamespaceParam restricts the snapshot to a namespace.
+	SnapshotNamespaceParam = "namespace"
+	// SnapshotLimitParam is the maximum number of pods of a page, 0 for all of them.
+	SnapshotLimitParam = "limit"
+	// SnapshotContinueParam is the Continue of the previous page.
+	SnapshotContinueParam = "continue"
+)
+
+// DebugSnapshot is the state of NPM on a node, as returned by NPMSnapshotPath.
+// Pods are paginated, the other lists are only part of the first page.
+// Summary counts everything NPM holds, whatever the namespace filter and the page.
+type DebugSnapshot struct {
+	Version           string                        `json:"version"`
+	NodeName          string                        `json:"nodeName"`
+	Summary           SnapshotSummary               `json:"summary"`
+	Namespaces        []SnapshotNamespace           `json:"namespaces"`
+	Pods              []SnapshotPod                 `json:"pods"`
+	Policies          []*networkingv1.NetworkPolicy `json:"policies"`
+	ProcessedPolicies []*networkingv1.NetworkPolicy `json:"processedPolicies"`
+	IPSets            []IPSet                       `json:"ipsets"`
+	// Continue is set when more pods are left, it is the SnapshotContinueParam of the next page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// SnapshotSummary counts the objects NPM holds.
+type SnapshotSummary struct {
+	Namespaces        int `json:"namespaces"`
+	Pods              int `json:"pods"`
+	Policies          int `json:"policies"`
+	ProcessedPolicies int `json:"processedPolicies"`
+	IPSets            int `json:"ipsets"`
+	IPSetLists        int `json:"ipsetLists"`
+}
+
+// SnapshotNamespace is a namespace with the labels NPM added it to the lists of.
+type SnapshotNamespace struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// SnapshotPod is a pod with what NPM translates into ipset members.
+type SnapshotPod struct {
+	Namespace      string                 `json:"namespace"`
+	Name           string                 `json:"name"`
+	UID            string                 `json:"uid"`
+	NodeName       string                 `json:"nodeName"`
+	IP             string                 `json:"ip"`
+	HostNetwork    bool                   `json:"hostNetwork,omitempty"`
+	Phase          corev1.PodPhase        `json:"phase"`
+	Labels         map[string]string      `json:"labels,omitempty"`
+	ContainerPorts []corev1.ContainerPort `json:"containerPorts,omitempty"`
+}
@@ -2,14 +2,20 @@ package client
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-container-networking/npm/http/api"
-
-	"github.com/Azure/azure-container-networking/npm"
 )
 
+// snapshotPageSize is the number of pods GetFullDebugSnapshot requests at once.
+const snapshotPageSize = 500
+
 type NPMHttpClient struct {
 	endpoint string
 	client   *http.Client
@@ -24,23 +30,102 @@ func NewNPMHttpClient(endpoint string) *NPMHttpClient {
 	}
 }
 
-func (n *NPMHttpClient) GetNpmMgr() (*npm.NetworkPolicyManager, error) {
-	url := n.endpoint + api.NPMMgrPath
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
+// GetDebugSnapshot returns a page of the debug snapshot of NPM, restricted to namespace unless it is empty.
+// limit is the maximum number of pods of the page, 0 for all of them, and cont the Continue of the previous page.
+func (n *NPMHttpClient) GetDebugSnapshot(namespace string, limit int, cont string) (*api.DebugSnapshot, error) {
+	query := url.Values{}
+	if namespace != "" {
+		query.Set(api.SnapshotNamespaceParam, namespace)
+	}
+	if limit > 0 {
+		query.Set(api.SnapshotLimitParam, strconv.Itoa(limit))
+	}
+	if cont != "" {
+		query.Set(api.SnapshotContinueParam, cont)
+	}
+
+	var snapshot api.DebugSnapshot
+	if err := n.get(api.NPMSnapshotPath, query, &snapshot); err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	res, err := n.client.Do(req)
+
+	if snapshot.Version != api.DebugSnapshotVersion {
+		return nil, fmt.Errorf("unsupported debug snapshot version %s, expected %s", snapshot.Version, api.DebugSnapshotVersion)
+	}
+
+	return &snapshot, nil
+}
+
+// GetFullDebugSnapshot returns the debug snapshot of NPM with the pods of every page.
+func (n *NPMHttpClient) GetFullDebugSnapshot(namespace string) (*api.DebugSnapshot, error) {
+	snapshot, err := n.GetDebugSnapshot(namespace, snapshotPageSize, "")
 	if err != nil {
 		return nil, err
 	}
 
-	var ns npm.NetworkPolicyManager
-	err = json.NewDecoder(res.Body).Decode(&ns)
-	if err != nil {
+	for cont := snapshot.Continue; cont != ""; {
+		page, err := n.GetDebugSnapshot(namespace, snapshotPageSize, cont)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.Pods = append(snapshot.Pods, page.Pods...)
+		cont = page.Continue
+	}
+	snapshot.Continue = ""
+
+	return snapshot, nil
+}
+
+// DescribeIPSet returns the ipset with the hashed or NPM name, e.g. azure-npm-123456 or app:frontend.
+func (n *NPMHttpClient) DescribeIPSet(name string) (*api.DescribeIPSetResponse, error) {
+	query := url.Values{}
+	query.Set(api.IPSetNameParam, name)
+
+	var resp api.DescribeIPSetResponse
+	if err := n.get(api.NPMIPSetPath, query, &resp); err != nil {
 		return nil, err
 	}
 
-	return &ns, nil
+	return &resp, nil
+}
+
+// GetPodPolicies returns the network policies applying to the pod.
+func (n *NPMHttpClient) GetPodPolicies(namespace, name string) (*api.PodPoliciesResponse, error) {
+	query := url.Values{}
+	query.Set(api.PodNamespaceParam, namespace)
+	query.Set(api.PodNameParam, name)
+
+	var resp api.PodPoliciesResponse
+	if err := n.get(api.NPMPodPoliciesPath, query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// get requests path with the query parameters and decodes the json response into resp.
+func (n *NPMHttpClient) get(path string, query url.Values, resp interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, n.endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	return n.do(req, resp)
+}
+
+// do sends req and decodes the json response into resp.
+func (n *NPMHttpClient) do(req *http.Request, resp interface{}) error {
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(resp)
 }
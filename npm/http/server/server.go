@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/pprof"
 	_ "net/http/pprof"
+	"strconv"
 
 	"github.com/Azure/azure-container-networking/log"
 
@@ -34,7 +35,9 @@ func (n *NPMRestServer) NPMRestServerListenAndServe(npMgr *npm.NetworkPolicyMana
 	n.router.Handle(api.ClusterMetricsPath, metrics.GetHandler(false))
 
 	// ACN CLI debug handlerss
-	n.router.Handle(api.NPMMgrPath, n.GetNpmMgr(npMgr)).Methods(http.MethodGet)
+	n.router.Handle(api.NPMSnapshotPath, n.GetDebugSnapshot(npMgr)).Methods(http.MethodGet)
+	n.router.Handle(api.NPMIPSetPath, n.DescribeIPSet(npMgr)).Methods(http.MethodGet)
+	n.router.Handle(api.NPMPodPoliciesPath, n.GetPodPolicies(npMgr)).Methods(http.MethodGet)
 
 	n.router.PathPrefix("/debug/").Handler(http.DefaultServeMux)
 	n.router.HandleFunc("/debug/pprof/", pprof.Index)
@@ -63,14 +66,74 @@ func NewNpmRestServer(listeningAddress string) *NPMRestServer {
 	}
 }
 
-func (n *NPMRestServer) GetNpmMgr(npMgr *npm.NetworkPolicyManager) http.Handler {
+// GetDebugSnapshot returns the api.DebugSnapshot of the namespace, limit and continue query parameters.
+func (n *NPMRestServer) GetDebugSnapshot(npMgr *npm.NetworkPolicyManager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		npMgr.Lock()
-		err := json.NewEncoder(w).Encode(npMgr)
+		query := r.URL.Query()
+
+		limit := 0
+		if value := query.Get(api.SnapshotLimitParam); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s %s", api.SnapshotLimitParam, value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		snapshot, err := npMgr.GetDebugSnapshot(query.Get(api.SnapshotNamespaceParam), limit, query.Get(api.SnapshotContinueParam))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// DescribeIPSet returns the ipset of the name query parameter, a hashed or NPM name.
+func (n *NPMRestServer) DescribeIPSet(npMgr *npm.NetworkPolicyManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get(api.IPSetNameParam)
+		if name == "" {
+			http.Error(w, fmt.Sprintf("missing %s of the ipset", api.IPSetNameParam), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := npMgr.DescribeIPSet(name)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		npMgr.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// GetPodPolicies returns the network policies applying to the pod of the namespace and name query parameters.
+func (n *NPMRestServer) GetPodPolicies(npMgr *npm.NetworkPolicyManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		namespace, name := query.Get(api.PodNamespaceParam), query.Get(api.PodNameParam)
+		if namespace == "" || name == "" {
+			http.Error(w, fmt.Sprintf("missing %s or %s of the pod", api.PodNamespaceParam, api.PodNameParam), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := npMgr.GetPodPolicies(namespace, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 	})
 }
@@ -4,44 +4,178 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/npm/http/api"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/fakes"
+	"github.com/Azure/azure-container-networking/npm/util"
 )
 
-func TestGetNpmMgrHandler(t *testing.T) {
+func TestGetDebugSnapshotHandler(t *testing.T) {
 	assert := assert.New(t)
+	fake := fakes.NewDataplaneFake()
+	fake.AddToSet("ns-x", "10.0.0.1", util.IpsetNetHashFlag, "uid-a")
+	fake.AddToSet("ns-x", "10.0.0.2", util.IpsetNetHashFlag, "uid-b")
+	fake.AddToSet("ns-y", "10.0.0.3", util.IpsetNetHashFlag, "uid-c")
+	fake.AddToList(util.KubeAllNamespacesFlag, "ns-x")
+	fake.AddToList(util.KubeAllNamespacesFlag, "ns-y")
+
 	npMgr := &npm.NetworkPolicyManager{
+		NsMap: map[string]*npm.Namespace{
+			util.KubeAllNamespacesFlag: &npm.Namespace{IpsMgr: fake},
+			"ns-x":                     &npm.Namespace{LabelsMap: map[string]string{"team": "x"}},
+			"ns-y":                     &npm.Namespace{},
+		},
 		PodMap: map[string]*npm.NpmPod{
-			"": &npm.NpmPod{
-				Name: "testpod",
-			},
+			"ns-x/a/uid-a": &npm.NpmPod{Name: "a", Namespace: "x", PodUID: "uid-a", PodIP: "10.0.0.1"},
+			"ns-x/b/uid-b": &npm.NpmPod{Name: "b", Namespace: "x", PodUID: "uid-b", PodIP: "10.0.0.2"},
+			"ns-y/c/uid-c": &npm.NpmPod{Name: "c", Namespace: "y", PodUID: "uid-c", PodIP: "10.0.0.3"},
+		},
+	}
+	n := NewNpmRestServer("")
+	handler := n.GetDebugSnapshot(npMgr)
+
+	get := func(query string) (*httptest.ResponseRecorder, *api.DebugSnapshot) {
+		req, err := http.NewRequest(http.MethodGet, api.NPMSnapshotPath+"?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			return rr, nil
+		}
+
+		var snapshot api.DebugSnapshot
+		if err := json.NewDecoder(rr.Body).Decode(&snapshot); err != nil {
+			t.Fatal(err)
+		}
+		return rr, &snapshot
+	}
+
+	_, snapshot := get("")
+	assert.Equal(api.DebugSnapshotVersion, snapshot.Version)
+	assert.Equal(api.SnapshotSummary{Namespaces: 2, Pods: 3, IPSets: 2, IPSetLists: 1}, snapshot.Summary)
+	assert.Len(snapshot.Pods, 3)
+	assert.Len(snapshot.IPSets, 3)
+	assert.Empty(snapshot.Continue)
+
+	// pages only hold pods after the first one.
+	_, snapshot = get("limit=2")
+	assert.Len(snapshot.Pods, 2)
+	assert.Equal("x/b", snapshot.Continue)
+	assert.Len(snapshot.Namespaces, 2)
+
+	_, snapshot = get("limit=2&continue=x/b")
+	assert.Equal([]api.SnapshotPod{{Namespace: "y", Name: "c", UID: "uid-c", IP: "10.0.0.3"}}, snapshot.Pods)
+	assert.Empty(snapshot.Continue)
+	assert.Empty(snapshot.Namespaces)
+	assert.Empty(snapshot.IPSets)
+
+	// a namespace only has its pods, the sets holding them and the lists holding the namespace.
+	_, snapshot = get("namespace=x")
+	assert.Equal([]api.SnapshotNamespace{{Name: "x", Labels: map[string]string{"team": "x"}}}, snapshot.Namespaces)
+	assert.Len(snapshot.Pods, 2)
+	assert.Len(snapshot.IPSets, 2)
+	assert.Equal(3, snapshot.Summary.Pods)
+
+	rr, _ := get("limit=-1")
+	assert.Equal(http.StatusBadRequest, rr.Code)
+}
+
+func TestDescribeIPSetHandler(t *testing.T) {
+	assert := assert.New(t)
+	fake := fakes.NewDataplaneFake()
+	fake.AddToSet("app:frontend", "10.0.0.1", util.IpsetNetHashFlag, "pod-1")
+	fake.AddToList("ns-app:frontend", "ns-test")
+
+	npMgr := &npm.NetworkPolicyManager{
+		NsMap: map[string]*npm.Namespace{
+			util.KubeAllNamespacesFlag: &npm.Namespace{IpsMgr: fake},
 		},
 	}
 	n := NewNpmRestServer("")
-	handler := n.GetNpmMgr(npMgr)
+	handler := n.DescribeIPSet(npMgr)
 
-	req, err := http.NewRequest(http.MethodGet, api.NPMMgrPath, nil)
-	if err != nil {
+	describe := func(name string) *httptest.ResponseRecorder {
+		query := url.Values{}
+		query.Set(api.IPSetNameParam, name)
+		req, err := http.NewRequest(http.MethodGet, api.NPMIPSetPath+"?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := describe(util.GetHashedName("app:frontend"))
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("application/json", rr.Header().Get("Content-Type"))
+
+	var set api.DescribeIPSetResponse
+	if err := json.NewDecoder(rr.Body).Decode(&set); err != nil {
 		t.Fatal(err)
 	}
+	assert.Equal("app:frontend", set.Name)
+	assert.Equal(api.IPSetKindSet, set.Kind)
+	assert.Equal([]api.IPSetMember{{IP: "10.0.0.1", PodUID: "pod-1"}}, set.Members)
 
-	rr := httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
+	rr = describe("ns-app:frontend")
+	assert.Equal(http.StatusOK, rr.Code)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+	var list api.DescribeIPSetResponse
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
 	}
+	assert.Equal(api.IPSetKindList, list.Kind)
+	assert.Equal([]string{"ns-test"}, list.Sets)
+
+	assert.Equal(http.StatusNotFound, describe("azure-npm-0").Code)
+	assert.Equal(http.StatusBadRequest, describe("").Code)
+}
+
+func TestGetPodPoliciesHandler(t *testing.T) {
+	assert := assert.New(t)
+	npMgr := &npm.NetworkPolicyManager{
+		NsMap: map[string]*npm.Namespace{
+			util.KubeAllNamespacesFlag: &npm.Namespace{IpsMgr: fakes.NewDataplaneFake()},
+		},
+		PodMap: map[string]*npm.NpmPod{
+			"ns-x/a/uid-a": &npm.NpmPod{Name: "a", Namespace: "x", PodUID: "uid-a", PodIP: "10.0.0.1"},
+		},
+	}
+	n := NewNpmRestServer("")
+	handler := n.GetPodPolicies(npMgr)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, api.NPMPodPoliciesPath+"?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("namespace=x&name=a")
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("application/json", rr.Header().Get("Content-Type"))
 
-	var ns npm.NetworkPolicyManager
-	err = json.NewDecoder(rr.Body).Decode(&ns)
-	if err != nil {
+	var policies api.PodPoliciesResponse
+	if err := json.NewDecoder(rr.Body).Decode(&policies); err != nil {
 		t.Fatal(err)
 	}
+	assert.Equal("10.0.0.1", policies.PodIP)
+	assert.Empty(policies.Selecting)
 
-	assert.Exactly(&ns, npMgr)
+	assert.Equal(http.StatusNotFound, get("namespace=x&name=b").Code)
+	assert.Equal(http.StatusBadRequest, get("name=a").Code)
 }
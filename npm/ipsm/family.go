@@ -0,0 +1,55 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package ipsm
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// getFamilyEntries returns the ipset commands applying entry. With IPv6 enabled, every set and list has an inet6 twin
+// which is created, flushed and destroyed with it, the twin of a list holds the twins of its sets, and an ip is only
+// added to or deleted from the set of its own family.
+func getFamilyEntries(entry *ipsEntry) []*ipsEntry {
+	if !util.IPv6Enabled || entry.set == "" {
+		return []*ipsEntry{entry}
+	}
+
+	switch entry.operationFlag {
+	case util.IpsetAppendFlag, util.IpsetDeletionFlag, util.IpsetTestFlag:
+		if len(entry.spec) > 0 && !isSetMember(entry.spec[0]) {
+			if util.IsIPv6(entry.spec[0]) {
+				return []*ipsEntry{getIPv6Entry(entry)}
+			}
+			return []*ipsEntry{entry}
+		}
+	}
+
+	return []*ipsEntry{entry, getIPv6Entry(entry)}
+}
+
+// getIPv6Entry returns the ipset command applying entry to the inet6 twin of its set.
+func getIPv6Entry(entry *ipsEntry) *ipsEntry {
+	ipv6Entry := &ipsEntry{
+		name:          entry.name,
+		operationFlag: entry.operationFlag,
+		set:           util.GetIPv6HashedName(entry.set),
+		spec:          entry.spec,
+	}
+
+	switch {
+	case len(entry.spec) == 0:
+	case entry.operationFlag == util.IpsetCreationFlag && entry.spec[0] != util.IpsetSetListFlag:
+		ipv6Entry.spec = append(append([]string{}, entry.spec...), util.IpsetFamilyFlag, util.IpsetINet6Family)
+	case isSetMember(entry.spec[0]):
+		ipv6Entry.spec = append([]string{util.GetIPv6HashedName(entry.spec[0])}, entry.spec[1:]...)
+	}
+
+	return ipv6Entry
+}
+
+// isSetMember checks whether an element of a list operation is a set rather than an ip.
+func isSetMember(element string) bool {
+	return strings.HasPrefix(element, util.AzureNpmPrefix)
+}
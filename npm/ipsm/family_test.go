@@ -0,0 +1,68 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package ipsm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestGetFamilyEntries(t *testing.T) {
+	defer func(enabled bool) { util.IPv6Enabled = enabled }(util.IPv6Enabled)
+
+	var (
+		setName      = util.GetHashedName("app:frontend")
+		ipv6SetName  = util.GetIPv6HashedName(setName)
+		listName     = util.GetHashedName("ns-app:test")
+		ipv6ListName = util.GetIPv6HashedName(listName)
+	)
+
+	createSet := &ipsEntry{operationFlag: util.IpsetCreationFlag, set: setName, spec: []string{util.IpsetNetHashFlag}}
+	addIPv4 := &ipsEntry{operationFlag: util.IpsetAppendFlag, set: setName, spec: []string{"10.0.0.1"}}
+	addIPv6 := &ipsEntry{operationFlag: util.IpsetAppendFlag, set: setName, spec: []string{"fd00::/64", util.IpsetNomatch}}
+	addToList := &ipsEntry{operationFlag: util.IpsetAppendFlag, set: listName, spec: []string{setName}}
+
+	util.IPv6Enabled = false
+	for _, entry := range []*ipsEntry{createSet, addIPv4, addToList} {
+		if entries := getFamilyEntries(entry); len(entries) != 1 || entries[0] != entry {
+			t.Errorf("TestGetFamilyEntries failed @ getFamilyEntries, expected IPv4 only. Actual: %q", renderRestoreFile(entries))
+		}
+	}
+
+	util.IPv6Enabled = true
+	testCases := []struct {
+		entry    *ipsEntry
+		expected []*ipsEntry
+	}{
+		{
+			entry: createSet,
+			expected: []*ipsEntry{
+				createSet,
+				{operationFlag: util.IpsetCreationFlag, set: ipv6SetName, spec: []string{util.IpsetNetHashFlag, util.IpsetFamilyFlag, util.IpsetINet6Family}},
+			},
+		},
+		{
+			entry:    addIPv4,
+			expected: []*ipsEntry{addIPv4},
+		},
+		{
+			entry:    addIPv6,
+			expected: []*ipsEntry{{operationFlag: util.IpsetAppendFlag, set: ipv6SetName, spec: []string{"fd00::/64", util.IpsetNomatch}}},
+		},
+		{
+			entry: addToList,
+			expected: []*ipsEntry{
+				addToList,
+				{operationFlag: util.IpsetAppendFlag, set: ipv6ListName, spec: []string{ipv6SetName}},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if actual := getFamilyEntries(testCase.entry); !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("TestGetFamilyEntries failed @ getFamilyEntries. Expected:\n%s\nActual:\n%s", renderRestoreFile(testCase.expected), renderRestoreFile(actual))
+		}
+	}
+}
@@ -43,6 +43,16 @@ func NewIpset(setName string) *Ipset {
 	}
 }
 
+// Elements returns a copy of the elements of the set, or of the sets of the list, with their context.
+func (set *Ipset) Elements() map[string]string {
+	elements := make(map[string]string, len(set.elements))
+	for element, context := range set.elements {
+		elements[element] = context
+	}
+
+	return elements
+}
+
 // NewIpsetManager creates a new instance for IpsetManager object.
 func NewIpsetManager() *IpsetManager {
 	return &IpsetManager{
@@ -274,7 +284,8 @@ func (ipsMgr *IpsetManager) AddToSet(setName, ip, spec, podUid string) error {
 	}
 	var resultSpec []string
 	if strings.Contains(ip, util.IpsetNomatch) {
-		ip = strings.Trim(ip, util.IpsetNomatch)
+		// Trim would also cut the hex digits of IPv6 addresses.
+		ip = strings.TrimSpace(strings.TrimSuffix(ip, util.IpsetNomatch))
 		resultSpec = append([]string{ip, util.IpsetNomatch})
 	} else {
 		resultSpec = append([]string{ip})
@@ -396,8 +407,24 @@ func (ipsMgr *IpsetManager) Destroy() error {
 	return nil
 }
 
-// Run execute an ipset command to update ipset.
+// Run executes the ipset commands of entry to update ipset, see getFamilyEntries.
+// It returns the result of the first command which failed.
 func (ipsMgr *IpsetManager) Run(entry *ipsEntry) (int, error) {
+	var (
+		errCode int
+		err     error
+	)
+	for _, familyEntry := range getFamilyEntries(entry) {
+		if code, runErr := ipsMgr.runCommand(familyEntry); runErr != nil && err == nil {
+			errCode, err = code, runErr
+		}
+	}
+
+	return errCode, err
+}
+
+// runCommand executes a single ipset command.
+func (ipsMgr *IpsetManager) runCommand(entry *ipsEntry) (int, error) {
 	cmdName := util.Ipset
 	cmdArgs := append([]string{entry.operationFlag, util.IpsetExistFlag, entry.set}, entry.spec...)
 	cmdArgs = util.DropEmptyFields(cmdArgs)
@@ -509,13 +536,14 @@ func (ipsMgr *IpsetManager) DestroyNpmIpsets() error {
 		operationFlag: util.IpsetFlushFlag,
 	}
 
+	// the listed sets include the inet6 twins, every command applies to the named set only.
 	for _, ipsetName := range ipsetLists {
 		entry := &ipsEntry{
 			operationFlag: util.IpsetFlushFlag,
 			set:           ipsetName,
 		}
 
-		if _, err := ipsMgr.Run(entry); err != nil {
+		if _, err := ipsMgr.runCommand(entry); err != nil {
 			metrics.SendErrorLogAndMetric(util.IpsmID, "{DestroyNpmIpsets} Error: failed to flush ipset %s", ipsetName)
 		}
 	}
@@ -523,7 +551,7 @@ func (ipsMgr *IpsetManager) DestroyNpmIpsets() error {
 	for _, ipsetName := range ipsetLists {
 		entry.operationFlag = util.IpsetDestroyFlag
 		entry.set = ipsetName
-		if _, err := ipsMgr.Run(entry); err != nil {
+		if _, err := ipsMgr.runCommand(entry); err != nil {
 			metrics.SendErrorLogAndMetric(util.IpsmID, "{DestroyNpmIpsets} Error: failed to destroy ipset %s", ipsetName)
 		}
 	}
@@ -0,0 +1,329 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package ipsm
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// ipset save prints set types with their kernel names.
+var savedSetTypes = map[string]string{
+	util.IpsetNetHashFlag:    "hash:net",
+	util.IpsetSetListFlag:    "list:set",
+	util.IpsetIPPortHashFlag: util.IpsetIPPortHashFlag,
+}
+
+type savedSet struct {
+	kind     string
+	elements map[string]bool
+}
+
+// ReconcileSets makes ipset hold sets and lists, by unhashed name, and loads them into the cache.
+// setTypes gives the type of each set. With IPv6 enabled, the IPv6 elements are repaired in the inet6 twins of the sets.
+// Missing sets and elements are added and extra elements removed,
+// everything with a single ipset restore call. Sets NPM created which are neither in sets nor in lists are left to RemoveStaleSets.
+func (ipsMgr *IpsetManager) ReconcileSets(sets map[string]map[string]string, setTypes map[string]string, lists map[string]map[string]bool) error {
+	saved, err := getSavedSets()
+	if err != nil {
+		return err
+	}
+
+	var entries []*ipsEntry
+
+	// sets go first, lists can only hold existing sets.
+	for _, setName := range sortedKeys(sets) {
+		kind := setTypes[setName]
+		if kind == "" {
+			kind = util.IpsetNetHashFlag
+		}
+
+		elements, ipv6Elements := make(map[string]bool), make(map[string]bool)
+		for ip := range sets[setName] {
+			element := normalizeSetElement(ip, kind)
+			if util.IPv6Enabled && util.IsIPv6(element) {
+				ipv6Elements[element] = true
+			} else {
+				elements[element] = true
+			}
+		}
+
+		hashedName := util.GetHashedName(setName)
+		entries = append(entries, getRepairEntries(setName, hashedName, []string{kind}, elements, saved[hashedName])...)
+		if util.IPv6Enabled {
+			ipv6HashedName := util.GetIPv6HashedName(hashedName)
+			spec := []string{kind, util.IpsetFamilyFlag, util.IpsetINet6Family}
+			entries = append(entries, getRepairEntries(setName, ipv6HashedName, spec, ipv6Elements, saved[ipv6HashedName])...)
+		}
+	}
+
+	for _, listName := range sortedKeys(lists) {
+		elements, ipv6Elements := make(map[string]bool), make(map[string]bool)
+		for setName := range lists[listName] {
+			elements[util.GetHashedName(setName)] = true
+			ipv6Elements[util.GetIPv6HashedName(util.GetHashedName(setName))] = true
+		}
+
+		hashedName := util.GetHashedName(listName)
+		spec := []string{util.IpsetSetListFlag}
+		entries = append(entries, getRepairEntries(listName, hashedName, spec, elements, saved[hashedName])...)
+		if util.IPv6Enabled {
+			ipv6HashedName := util.GetIPv6HashedName(hashedName)
+			entries = append(entries, getRepairEntries(listName, ipv6HashedName, spec, ipv6Elements, saved[ipv6HashedName])...)
+		}
+	}
+
+	// ipset restore stops at the first failing line, resume after it to repair all other sets.
+	var firstErr error
+	for remaining := entries; len(remaining) > 0; {
+		failedLine, err := ipsMgr.runRestore(remaining)
+		if err == nil {
+			break
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+		if failedLine < 1 || failedLine > len(remaining) {
+			break
+		}
+		remaining = remaining[failedLine:]
+	}
+
+	if len(entries) > 0 {
+		log.Logf("Repaired ipsets with %d operations.", len(entries))
+	}
+
+	ipsMgr.loadCache(sets, lists)
+
+	return firstErr
+}
+
+// RemoveStaleSets destroys the sets NPM created which are neither in sets nor in lists, by unhashed name.
+// Lists are destroyed before sets since they may hold them.
+func (ipsMgr *IpsetManager) RemoveStaleSets(sets map[string]map[string]string, lists map[string]map[string]bool) error {
+	saved, err := getSavedSets()
+	if err != nil {
+		return err
+	}
+
+	expected := make(map[string]bool)
+	for setName := range sets {
+		expected[util.GetHashedName(setName)] = true
+	}
+	for listName := range lists {
+		expected[util.GetHashedName(listName)] = true
+	}
+
+	// the inet6 twins are stale once IPv6 is disabled.
+	if util.IPv6Enabled {
+		for hashedName := range expected {
+			expected[util.GetIPv6HashedName(hashedName)] = true
+		}
+	}
+
+	var staleLists, staleSets []string
+	for hashedName, set := range saved {
+		if expected[hashedName] {
+			continue
+		}
+
+		if set.kind == savedSetTypes[util.IpsetSetListFlag] {
+			staleLists = append(staleLists, hashedName)
+		} else {
+			staleSets = append(staleSets, hashedName)
+		}
+	}
+	sort.Strings(staleLists)
+	sort.Strings(staleSets)
+
+	for _, hashedName := range append(staleLists, staleSets...) {
+		log.Logf("Destroying stale ipset %s.", hashedName)
+		entry := &ipsEntry{
+			operationFlag: util.IpsetDestroyFlag,
+			set:           hashedName,
+		}
+		if _, err := ipsMgr.runCommand(entry); err != nil {
+			metrics.SendErrorLogAndMetric(util.IpsmID, "Error: failed to destroy stale ipset %s.", hashedName)
+		}
+	}
+
+	return nil
+}
+
+// getRepairEntries returns the ipset operations turning saved into the set hashedName created with spec, holding the elements.
+func getRepairEntries(setName, hashedName string, spec []string, elements map[string]bool, saved *savedSet) []*ipsEntry {
+	var (
+		entries []*ipsEntry
+		kind    = spec[0]
+	)
+
+	if saved == nil {
+		entries = append(entries, &ipsEntry{
+			name:          setName,
+			operationFlag: util.IpsetCreationFlag,
+			set:           hashedName,
+			spec:          spec,
+		})
+		saved = &savedSet{kind: savedSetTypes[kind], elements: make(map[string]bool)}
+	}
+
+	if saved.kind != savedSetTypes[kind] {
+		metrics.SendErrorLogAndMetric(util.IpsmID, "Error: ipset %s is a %s, expected %s.", setName, saved.kind, savedSetTypes[kind])
+		return nil
+	}
+
+	for _, element := range sortedKeys(elements) {
+		if !saved.elements[element] {
+			entries = append(entries, &ipsEntry{
+				operationFlag: util.IpsetAppendFlag,
+				set:           hashedName,
+				spec:          strings.Fields(element),
+			})
+		}
+	}
+
+	for _, element := range sortedKeys(saved.elements) {
+		if !elements[element] {
+			entries = append(entries, &ipsEntry{
+				operationFlag: util.IpsetDeletionFlag,
+				set:           hashedName,
+				spec:          strings.Fields(element)[:1],
+			})
+		}
+	}
+
+	return entries
+}
+
+// loadCache replaces the cache with sets and lists, like if they were created by this IpsetManager.
+func (ipsMgr *IpsetManager) loadCache(sets map[string]map[string]string, lists map[string]map[string]bool) {
+	ipsMgr.SetMap = make(map[string]*Ipset)
+	ipsMgr.ListMap = make(map[string]*Ipset)
+
+	numEntries := 0
+	for setName, elements := range sets {
+		set := NewIpset(setName)
+		for ip, podUID := range elements {
+			if strings.Contains(ip, util.IpsetNomatch) {
+				ip = strings.TrimSpace(strings.TrimSuffix(ip, util.IpsetNomatch))
+			}
+			set.elements[ip] = podUID
+		}
+		ipsMgr.SetMap[setName] = set
+
+		numEntries += len(set.elements)
+		metrics.SetIPSetInventory(setName, len(set.elements))
+	}
+
+	for listName, setNames := range lists {
+		list := NewIpset(listName)
+		for setName := range setNames {
+			list.elements[setName] = ""
+		}
+		ipsMgr.ListMap[listName] = list
+	}
+
+	metrics.NumIPSets.Set(float64(len(ipsMgr.SetMap)))
+	metrics.NumIPSetEntries.Set(float64(numEntries))
+}
+
+// getSavedSets returns the sets NPM created in ipset save output, by hashed name.
+func getSavedSets() (map[string]*savedSet, error) {
+	cmdName := util.Ipset
+	out, err := exec.Command(cmdName, util.IpsetSaveFlag).Output()
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.IpsmID, "Error: failed to read ipsets with [%s %s]: %v", cmdName, util.IpsetSaveFlag, err)
+		return nil, err
+	}
+
+	return parseSavedSets(string(out)), nil
+}
+
+// GetNpmIpsets returns the hashed names of the sets and lists NPM created which are left in the kernel, of both families.
+func GetNpmIpsets() ([]string, error) {
+	sets, err := getSavedSets()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedKeys(sets), nil
+}
+
+// parseSavedSets parses "create NAME TYPE ..." and "add NAME ELEMENT [nomatch]" lines of ipset save.
+func parseSavedSets(ipsetSave string) map[string]*savedSet {
+	sets := make(map[string]*savedSet)
+	for _, line := range strings.Split(ipsetSave, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[1], util.AzureNpmPrefix) {
+			continue
+		}
+
+		switch fields[0] {
+		case "create":
+			sets[fields[1]] = &savedSet{kind: fields[2], elements: make(map[string]bool)}
+		case "add":
+			if set, exists := sets[fields[1]]; exists {
+				set.elements[strings.Join(fields[2:], " ")] = true
+			}
+		}
+	}
+
+	return sets
+}
+
+// normalizeSetElement returns an element the way ipset save prints it, e.g. "10.0.0.0/28nomatch" becomes
+// "10.0.0.0/28 nomatch", "10.0.0.1/32" becomes "10.0.0.1" and "10.0.0.1,80" becomes "10.0.0.1,tcp:80".
+func normalizeSetElement(element, kind string) string {
+	nomatch := strings.HasSuffix(element, util.IpsetNomatch)
+	element = strings.TrimSpace(strings.TrimSuffix(element, util.IpsetNomatch))
+
+	switch kind {
+	case util.IpsetNetHashFlag:
+		if util.IsIPv6(element) {
+			element = strings.TrimSuffix(element, "/128")
+		} else {
+			element = strings.TrimSuffix(element, "/32")
+		}
+	case util.IpsetIPPortHashFlag:
+		if fields := strings.SplitN(element, ",", 2); len(fields) == 2 && !strings.Contains(fields[1], ":") {
+			element = fields[0] + "," + util.IpsetTCPFlag + fields[1]
+		}
+	}
+
+	if nomatch {
+		element += " " + util.IpsetNomatch
+	}
+
+	return element
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]map[string]string:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]bool:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]bool:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*savedSet:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,67 @@
+package ipsm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestParseSavedSets(t *testing.T) {
+	ipsetSave := `create azure-npm-1 hash:net family inet hashsize 1024 maxelem 65536
+add azure-npm-1 10.0.0.1
+add azure-npm-1 10.0.0.0/28 nomatch
+create azure-npm-2 list:set size 8
+add azure-npm-2 azure-npm-1
+create KUBE-1 hash:ip family inet hashsize 1024 maxelem 65536
+add KUBE-1 10.0.0.2
+`
+
+	sets := parseSavedSets(ipsetSave)
+	if len(sets) != 2 {
+		t.Errorf("TestParseSavedSets failed @ parseSavedSets. Expected 2 NPM sets, Actual: %v", sets)
+	}
+
+	set, exists := sets["azure-npm-1"]
+	if !exists || set.kind != "hash:net" || !set.elements["10.0.0.1"] || !set.elements["10.0.0.0/28 nomatch"] {
+		t.Errorf("TestParseSavedSets failed @ parseSavedSets. Unexpected set azure-npm-1: %+v", set)
+	}
+
+	list, exists := sets["azure-npm-2"]
+	if !exists || list.kind != "list:set" || !list.elements["azure-npm-1"] {
+		t.Errorf("TestParseSavedSets failed @ parseSavedSets. Unexpected list azure-npm-2: %+v", list)
+	}
+
+	elements := map[string]bool{
+		normalizeSetElement("10.0.0.1/32", util.IpsetNetHashFlag):        true,
+		normalizeSetElement("10.0.0.0/28nomatch", util.IpsetNetHashFlag): true,
+		normalizeSetElement("10.0.0.3", util.IpsetNetHashFlag):           true,
+	}
+	entries := getRepairEntries("ns-x", "azure-npm-1", []string{util.IpsetNetHashFlag}, elements, set)
+	if len(entries) != 1 || entries[0].operationFlag != util.IpsetAppendFlag || entries[0].spec[0] != "10.0.0.3" {
+		t.Errorf("TestParseSavedSets failed @ getRepairEntries. Expected to only add 10.0.0.3, Actual: %q", renderRestoreFile(entries))
+	}
+
+	if entries := getRepairEntries("ns-x", "azure-npm-1", []string{util.IpsetNetHashFlag}, nil, set); len(entries) != 2 {
+		t.Errorf("TestParseSavedSets failed @ getRepairEntries. Expected to delete 2 elements, Actual: %q", renderRestoreFile(entries))
+	}
+
+	if element := normalizeSetElement("10.0.0.1,8000", util.IpsetIPPortHashFlag); element != "10.0.0.1,tcp:8000" {
+		t.Errorf("TestParseSavedSets failed @ normalizeSetElement. Expected 10.0.0.1,tcp:8000, Actual: %s", element)
+	}
+}
+
+func TestNormalizeIPv6SetElement(t *testing.T) {
+	testCases := map[string]string{
+		"fd00::1/128":         "fd00::1",
+		"fd00::/32":           "fd00::/32",
+		"fd00::/64nomatch":    "fd00::/64 nomatch",
+		"10.0.0.1/32":         "10.0.0.1",
+		"10.0.0.0/28 nomatch": "10.0.0.0/28 nomatch",
+	}
+
+	for element, expected := range testCases {
+		if actual := normalizeSetElement(element, util.IpsetNetHashFlag); actual != expected {
+			t.Errorf("TestNormalizeIPv6SetElement failed @ normalizeSetElement %s. Expected: %s, Actual: %s", element, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package ipsm
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// ipset restore reports the first rejected command as "Error in line N: ...".
+var restoreErrLineRegex = regexp.MustCompile(`Error in line (\d+):`)
+
+// Transaction collects ipset operations and applies them with a single `ipset restore` call.
+// The IpsetManager cache is only updated for operations the kernel accepted.
+type Transaction struct {
+	ipsMgr       *IpsetManager
+	operations   []*operation
+	pendingSets  map[string]bool
+	pendingLists map[string]bool
+	emptiedSets  map[string]bool
+	emptiedLists map[string]bool
+}
+
+type operation struct {
+	setName string
+	entry   *ipsEntry
+	// the ipset commands of entry, see getFamilyEntries.
+	entries   []*ipsEntry
+	onSuccess func()
+}
+
+// OperationError describes an operation of a transaction which failed to apply.
+type OperationError struct {
+	OperationFlag string
+	SetName       string
+	Spec          []string
+	Err           error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("failed to apply ipset operation [%s %s %s]: %v", e.OperationFlag, e.SetName, strings.Join(e.Spec, " "), e.Err)
+}
+
+// NewTransaction creates an empty transaction on top of the ipset manager cache.
+func (ipsMgr *IpsetManager) NewTransaction() *Transaction {
+	return &Transaction{
+		ipsMgr:       ipsMgr,
+		pendingSets:  make(map[string]bool),
+		pendingLists: make(map[string]bool),
+		emptiedSets:  make(map[string]bool),
+		emptiedLists: make(map[string]bool),
+	}
+}
+
+// Len returns the number of pending operations.
+func (tx *Transaction) Len() int {
+	return len(tx.operations)
+}
+
+func (tx *Transaction) add(setName string, entry *ipsEntry, onSuccess func()) {
+	tx.operations = append(tx.operations, &operation{setName: setName, entry: entry, entries: getFamilyEntries(entry), onSuccess: onSuccess})
+}
+
+func (tx *Transaction) setExists(setName, kind string) bool {
+	if kind == util.IpsetSetListFlag {
+		return tx.pendingLists[setName] || tx.ipsMgr.SetExists(setName, kind)
+	}
+
+	return tx.pendingSets[setName] || tx.ipsMgr.SetExists(setName, kind)
+}
+
+// CreateList stages the creation of an ipset list.
+func (tx *Transaction) CreateList(listName string) {
+	if tx.setExists(listName, util.IpsetSetListFlag) {
+		return
+	}
+
+	entry := &ipsEntry{
+		name:          listName,
+		operationFlag: util.IpsetCreationFlag,
+		set:           util.GetHashedName(listName),
+		spec:          []string{util.IpsetSetListFlag},
+	}
+	tx.pendingLists[listName] = true
+	tx.add(listName, entry, func() {
+		if _, exists := tx.ipsMgr.ListMap[listName]; !exists {
+			tx.ipsMgr.ListMap[listName] = NewIpset(listName)
+		}
+	})
+}
+
+// AddToList stages the insertion of an ipset into an ipset list, creating the list if needed.
+func (tx *Transaction) AddToList(listName string, setName string) {
+	if listName == setName {
+		return
+	}
+
+	if tx.ipsMgr.Exists(listName, setName, util.IpsetSetListFlag) {
+		return
+	}
+
+	tx.CreateList(listName)
+
+	entry := &ipsEntry{
+		operationFlag: util.IpsetAppendFlag,
+		set:           util.GetHashedName(listName),
+		spec:          []string{util.GetHashedName(setName)},
+	}
+	tx.add(listName, entry, func() {
+		if list, exists := tx.ipsMgr.ListMap[listName]; exists {
+			list.elements[setName] = ""
+		}
+	})
+}
+
+// DeleteFromList stages the removal of an ipset from an ipset list.
+// Lists left empty once the transaction is committed are destroyed.
+func (tx *Transaction) DeleteFromList(listName string, setName string) {
+	if _, exists := tx.ipsMgr.ListMap[listName]; !exists && !tx.pendingLists[listName] {
+		log.Logf("ipset list with name %s not found", listName)
+		return
+	}
+
+	entry := &ipsEntry{
+		operationFlag: util.IpsetDeletionFlag,
+		set:           util.GetHashedName(listName),
+		spec:          []string{util.GetHashedName(setName)},
+	}
+	tx.add(listName, entry, func() {
+		list, exists := tx.ipsMgr.ListMap[listName]
+		if !exists {
+			return
+		}
+
+		delete(list.elements, setName)
+		if len(list.elements) == 0 {
+			tx.emptiedLists[listName] = true
+		}
+	})
+}
+
+// CreateSet stages the creation of an ipset.
+func (tx *Transaction) CreateSet(setName string, spec []string) {
+	if tx.setExists(setName, "") {
+		return
+	}
+
+	entry := &ipsEntry{
+		name:          setName,
+		operationFlag: util.IpsetCreationFlag,
+		set:           util.GetHashedName(setName),
+		spec:          spec,
+	}
+	tx.pendingSets[setName] = true
+	tx.add(setName, entry, func() {
+		if _, exists := tx.ipsMgr.SetMap[setName]; exists {
+			return
+		}
+
+		tx.ipsMgr.SetMap[setName] = NewIpset(setName)
+		metrics.NumIPSets.Inc()
+		metrics.SetIPSetInventory(setName, 0)
+	})
+}
+
+// AddToSet stages the insertion of an ip into an ipset, creating the set if needed.
+func (tx *Transaction) AddToSet(setName, ip, spec, podUid string) {
+	if tx.ipsMgr.Exists(setName, ip, spec) {
+		// make sure we have updated the podUid in case it gets changed
+		cachedPodUid := tx.ipsMgr.SetMap[setName].elements[ip]
+		if cachedPodUid != podUid {
+			log.Logf("AddToSet: PodOwner has changed for Ip: %s, setName:%s, Old podUid: %s, new PodUid: %s. Replace context with new PodOwner.",
+				ip, setName, cachedPodUid, podUid)
+
+			tx.ipsMgr.SetMap[setName].elements[ip] = podUid
+		}
+
+		return
+	}
+
+	if !tx.setExists(setName, spec) {
+		tx.CreateSet(setName, []string{spec})
+	}
+
+	resultSpec := []string{ip}
+	if strings.Contains(ip, util.IpsetNomatch) {
+		ip = strings.TrimSpace(strings.TrimSuffix(ip, util.IpsetNomatch))
+		resultSpec = []string{ip, util.IpsetNomatch}
+	}
+
+	entry := &ipsEntry{
+		operationFlag: util.IpsetAppendFlag,
+		set:           util.GetHashedName(setName),
+		spec:          resultSpec,
+	}
+	tx.add(setName, entry, func() {
+		set, exists := tx.ipsMgr.SetMap[setName]
+		if !exists {
+			return
+		}
+
+		// Stores the podUid as the context for this ip.
+		_, cached := set.elements[ip]
+		set.elements[ip] = podUid
+		if !cached {
+			metrics.NumIPSetEntries.Inc()
+			metrics.IncIPSetInventory(setName)
+		}
+	})
+}
+
+// DeleteFromSet stages the removal of an ip from an ipset.
+// Sets left empty once the transaction is committed are destroyed.
+func (tx *Transaction) DeleteFromSet(setName, ip, podUid string) {
+	ipSet, exists := tx.ipsMgr.SetMap[setName]
+	if !exists && !tx.pendingSets[setName] {
+		log.Logf("ipset with name %s not found", setName)
+		return
+	}
+
+	if exists {
+		// in case the IP belongs to a new Pod, then ignore this Delete call as this might be stale
+		if cachedPodUid, cached := ipSet.elements[ip]; cached && cachedPodUid != podUid {
+			log.Logf("DeleteFromSet: PodOwner has changed for Ip: %s, setName:%s, Old podUid: %s, new PodUid: %s. Ignore the delete as this is stale update",
+				ip, setName, cachedPodUid, podUid)
+
+			return
+		}
+	}
+
+	entry := &ipsEntry{
+		operationFlag: util.IpsetDeletionFlag,
+		set:           util.GetHashedName(setName),
+		spec:          []string{ip},
+	}
+	tx.add(setName, entry, func() {
+		set, exists := tx.ipsMgr.SetMap[setName]
+		if !exists {
+			return
+		}
+
+		if _, cached := set.elements[ip]; cached {
+			delete(set.elements, ip)
+			metrics.NumIPSetEntries.Dec()
+			metrics.DecIPSetInventory(setName)
+		}
+
+		if len(set.elements) == 0 {
+			tx.emptiedSets[setName] = true
+		}
+	})
+}
+
+// Commit applies all pending operations and updates the ipset manager cache for the ones that succeeded.
+// It returns one OperationError per operation that failed to apply. The transaction is empty afterwards.
+func (tx *Transaction) Commit() []*OperationError {
+	var (
+		failures  []*OperationError
+		remaining = tx.operations
+	)
+
+	tx.operations = nil
+	tx.pendingSets = make(map[string]bool)
+	tx.pendingLists = make(map[string]bool)
+
+	// ipset restore stops at the first failing line and keeps everything before it,
+	// so resume right after the failed operation until all of them are processed.
+	for len(remaining) > 0 {
+		var (
+			entries []*ipsEntry
+			// the index in remaining of the operation of each line.
+			lineOperations []int
+		)
+		for i, op := range remaining {
+			for _, entry := range op.entries {
+				entries = append(entries, entry)
+				lineOperations = append(lineOperations, i)
+			}
+		}
+
+		failedLine, err := tx.ipsMgr.runRestore(entries)
+		if err == nil {
+			for _, op := range remaining {
+				op.onSuccess()
+			}
+			break
+		}
+
+		if failedLine < 1 || failedLine > len(entries) {
+			// the failure can't be attributed to a single operation.
+			for _, op := range remaining {
+				failures = append(failures, newOperationError(op, err))
+			}
+			break
+		}
+
+		failedOperation := lineOperations[failedLine-1]
+		for _, op := range remaining[:failedOperation] {
+			op.onSuccess()
+		}
+
+		failures = append(failures, newOperationError(remaining[failedOperation], err))
+		remaining = remaining[failedOperation+1:]
+	}
+
+	for setName := range tx.emptiedSets {
+		if set, exists := tx.ipsMgr.SetMap[setName]; exists && len(set.elements) == 0 {
+			tx.ipsMgr.DeleteSet(setName)
+		}
+	}
+	tx.emptiedSets = make(map[string]bool)
+
+	for listName := range tx.emptiedLists {
+		if list, exists := tx.ipsMgr.ListMap[listName]; exists && len(list.elements) == 0 {
+			tx.ipsMgr.DeleteList(listName)
+		}
+	}
+	tx.emptiedLists = make(map[string]bool)
+
+	for _, failure := range failures {
+		metrics.SendErrorLogAndMetric(util.IpsmID, "Error: %s", failure.Error())
+	}
+
+	return failures
+}
+
+func newOperationError(op *operation, err error) *OperationError {
+	return &OperationError{
+		OperationFlag: op.entry.operationFlag,
+		SetName:       op.setName,
+		Spec:          op.entry.spec,
+		Err:           err,
+	}
+}
+
+// renderRestoreFile returns the `ipset restore` input with one command per line.
+func renderRestoreFile(entries []*ipsEntry) string {
+	var sb strings.Builder
+	for _, entry := range entries {
+		line := append([]string{entry.operationFlag, entry.set}, entry.spec...)
+		sb.WriteString(strings.Join(util.DropEmptyFields(line), " "))
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+// parseRestoreErrLine returns the 1-based line which ipset restore failed at, or 0 if unknown.
+func parseRestoreErrLine(stderr string) int {
+	match := restoreErrLineRegex.FindStringSubmatch(stderr)
+	if len(match) != 2 {
+		return 0
+	}
+
+	line, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+
+	return line
+}
+
+// runRestore applies ipset entries with one `ipset restore` call.
+// On failure, it returns the 1-based index of the entry ipset rejected, or 0 if it can't be determined.
+func (ipsMgr *IpsetManager) runRestore(entries []*ipsEntry) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	cmdName := util.Ipset
+	cmdArgs := []string{util.IpsetRestoreFlag, util.IpsetExistFlag}
+
+	log.Logf("Executing ipset command %s %v with %d entries", cmdName, cmdArgs, len(entries))
+	cmd := exec.Command(cmdName, cmdArgs...)
+	cmd.Stdin = strings.NewReader(renderRestoreFile(entries))
+	_, err := cmd.Output()
+	if msg, failed := err.(*exec.ExitError); failed {
+		stderr := strings.TrimSuffix(string(msg.Stderr), "\n")
+		metrics.SendErrorLogAndMetric(util.IpsmID, "Error: There was an error running command: [%s %v] Stderr: [%v, %s]", cmdName, strings.Join(cmdArgs, " "), err, stderr)
+		return parseRestoreErrLine(stderr), fmt.Errorf("%v: %s", err, stderr)
+	}
+
+	return 0, nil
+}
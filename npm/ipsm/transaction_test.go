@@ -0,0 +1,114 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package ipsm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestRenderRestoreFile(t *testing.T) {
+	entries := []*ipsEntry{
+		{
+			operationFlag: util.IpsetCreationFlag,
+			set:           util.GetHashedName("test-set"),
+			spec:          []string{util.IpsetNetHashFlag},
+		},
+		{
+			operationFlag: util.IpsetAppendFlag,
+			set:           util.GetHashedName("test-set"),
+			spec:          []string{"10.0.0.1", ""},
+		},
+	}
+
+	expected := util.IpsetCreationFlag + " " + util.GetHashedName("test-set") + " " + util.IpsetNetHashFlag + "\n" +
+		util.IpsetAppendFlag + " " + util.GetHashedName("test-set") + " 10.0.0.1\n"
+	if actual := renderRestoreFile(entries); actual != expected {
+		t.Errorf("TestRenderRestoreFile failed @ renderRestoreFile. Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestParseRestoreErrLine(t *testing.T) {
+	testCases := map[string]int{
+		"ipset v7.1: Error in line 3: The set with the given name does not exist":                            3,
+		"ipset v6.34: Error in line 12: Syntax error: cannot parse 10.0.0: resolving to IPv4 address failed": 12,
+		"ipset v7.1: Kernel error received: Operation not permitted":                                         0,
+		"": 0,
+	}
+
+	for stderr, expected := range testCases {
+		if actual := parseRestoreErrLine(stderr); actual != expected {
+			t.Errorf("TestParseRestoreErrLine failed @ parseRestoreErrLine(%q). Expected: %d, Actual: %d", stderr, expected, actual)
+		}
+	}
+}
+
+func TestTransactionStaging(t *testing.T) {
+	ipsMgr := NewIpsetManager()
+	tx := ipsMgr.NewTransaction()
+
+	tx.AddToSet("test-set", "10.0.0.1", util.IpsetNetHashFlag, "pod-uid")
+	tx.AddToSet("test-set", "10.0.0.2", util.IpsetNetHashFlag, "pod-uid")
+	tx.AddToList("test-list", "test-set")
+
+	// create test-set, add two ips, create test-list, add test-set to it.
+	if tx.Len() != 5 {
+		t.Errorf("TestTransactionStaging failed @ tx.Len. Expected 5 operations, got %d", tx.Len())
+	}
+
+	if ipsMgr.SetExists("test-set", util.IpsetNetHashFlag) || ipsMgr.SetExists("test-list", util.IpsetSetListFlag) {
+		t.Errorf("TestTransactionStaging failed @ ipsMgr.SetExists. Cache must not change before commit")
+	}
+
+	tx.DeleteFromSet("absent-set", "10.0.0.1", "pod-uid")
+	if tx.Len() != 5 {
+		t.Errorf("TestTransactionStaging failed @ tx.DeleteFromSet. Deleting from an unknown set must be a no-op")
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	ipsMgr := NewIpsetManager()
+	if err := ipsMgr.Save(util.IpsetTestConfigFile); err != nil {
+		t.Errorf("TestTransactionCommit failed @ ipsMgr.Save")
+	}
+
+	defer func() {
+		if err := ipsMgr.Restore(util.IpsetTestConfigFile); err != nil {
+			t.Errorf("TestTransactionCommit failed @ ipsMgr.Restore")
+		}
+	}()
+
+	tx := ipsMgr.NewTransaction()
+	tx.AddToSet("test-set", "1.2.3.4", util.IpsetNetHashFlag, "pod-uid")
+	tx.AddToSet("test-set", "invalid-ip", util.IpsetNetHashFlag, "pod-uid")
+	tx.AddToSet("test-set", "1.2.3.5", util.IpsetNetHashFlag, "pod-uid")
+	tx.AddToList("test-list", "test-set")
+
+	failures := tx.Commit()
+	if len(failures) != 1 || failures[0].Spec[0] != "invalid-ip" {
+		t.Errorf("TestTransactionCommit failed @ tx.Commit. Expected only invalid-ip to fail, got %+v", failures)
+	}
+
+	if !ipsMgr.Exists("test-set", "1.2.3.4", util.IpsetNetHashFlag) || !ipsMgr.Exists("test-set", "1.2.3.5", util.IpsetNetHashFlag) {
+		t.Errorf("TestTransactionCommit failed @ ipsMgr.Exists. Applied ips must be cached")
+	}
+
+	if ipsMgr.Exists("test-set", "invalid-ip", util.IpsetNetHashFlag) {
+		t.Errorf("TestTransactionCommit failed @ ipsMgr.Exists. Failed ip must not be cached")
+	}
+
+	if !ipsMgr.Exists("test-list", "test-set", util.IpsetSetListFlag) {
+		t.Errorf("TestTransactionCommit failed @ ipsMgr.Exists. test-set must be cached in test-list")
+	}
+
+	tx.DeleteFromSet("test-set", "1.2.3.4", "pod-uid")
+	tx.DeleteFromSet("test-set", "1.2.3.5", "pod-uid")
+	if failures := tx.Commit(); len(failures) > 0 {
+		t.Errorf("TestTransactionCommit failed @ tx.Commit with failures %+v", failures)
+	}
+
+	if ipsMgr.Exists("test-set", "1.2.3.4", util.IpsetNetHashFlag) {
+		t.Errorf("TestTransactionCommit failed @ ipsMgr.Exists. Deleted ip must not be cached")
+	}
+}
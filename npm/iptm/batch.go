@@ -0,0 +1,132 @@
+package iptm
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// AddBatch adds all entries with a single iptables-restore call.
+// Either every entry is programmed or none of them is.
+func (iptMgr *IptablesManager) AddBatch(entries []*IptEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	timer := metrics.StartNewTimer()
+
+	log.Logf("Adding %d iptables entries with iptables-restore.", len(entries))
+
+	familyEntries := iptMgr.getFamilyEntries(entries)
+	if err := iptMgr.runRestore(craftAddRestoreLines(familyEntries)); err != nil {
+		metrics.SendErrorLogAndMetric(util.IptmID, "Error: failed to create batch of %d iptables rules.", len(familyEntries))
+		return err
+	}
+
+	if !iptMgr.ipv6 {
+		metrics.NumIPTableRules.Add(float64(len(familyEntries)))
+		timer.StopAndRecord(metrics.AddIPTablesBatchExecTime)
+	}
+
+	return nil
+}
+
+// DeleteBatch removes all entries with a single iptables-restore call.
+// iptables-restore rejects the whole batch if one of the rules doesn't exist,
+// in which case the entries are deleted one by one.
+func (iptMgr *IptablesManager) DeleteBatch(entries []*IptEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	log.Logf("Deleting %d iptables entries with iptables-restore.", len(entries))
+
+	familyEntries := iptMgr.getFamilyEntries(entries)
+	lines := make([]string, 0, len(familyEntries))
+	for _, entry := range familyEntries {
+		lines = append(lines, craftRestoreLine(util.IptablesDeletionFlag, entry))
+	}
+
+	if err := iptMgr.runRestore(lines); err == nil {
+		if !iptMgr.ipv6 {
+			metrics.NumIPTableRules.Sub(float64(len(familyEntries)))
+		}
+		return nil
+	}
+
+	log.Logf("Batch deletion of iptables entries failed, falling back to deleting them one by one.")
+	for _, entry := range entries {
+		if err := iptMgr.Delete(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CraftAddRestoreFile returns the iptables-restore input AddBatch applies for the entries.
+func CraftAddRestoreFile(entries []*IptEntry) string {
+	return craftRestoreFile(craftAddRestoreLines(entries))
+}
+
+// craftAddRestoreLines appends jump entries to their chain and inserts all other entries at the top, like Add does.
+func craftAddRestoreLines(entries []*IptEntry) []string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		operationFlag := util.IptablesInsertionFlag
+		if entry.IsJumpEntry {
+			operationFlag = util.IptablesAppendFlag
+		}
+		lines[i] = craftRestoreLine(operationFlag, entry)
+	}
+
+	return lines
+}
+
+// craftRestoreLine returns the iptables-restore representation of an entry, e.g. "-I AZURE-NPM-INGRESS-PORT -p TCP ...".
+func craftRestoreLine(operationFlag string, entry *IptEntry) string {
+	fields := []string{operationFlag, entry.Chain}
+	for _, spec := range entry.Specs {
+		if strings.ContainsAny(spec, " \t\"'") {
+			spec = fmt.Sprintf("%q", spec)
+		}
+		fields = append(fields, spec)
+	}
+
+	return strings.Join(util.DropEmptyFields(fields), " ")
+}
+
+// craftRestoreFile wraps the rule lines in a filter table transaction.
+func craftRestoreFile(lines []string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + util.IptablesFilterTable + "\n")
+	for _, line := range lines {
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString(util.IptablesRestoreCommit + "\n")
+
+	return sb.String()
+}
+
+// runRestore applies the rule lines to the filter table without flushing the existing chains.
+func (iptMgr *IptablesManager) runRestore(lines []string) error {
+	cmdName := iptMgr.restoreCmd()
+	cmdArgs := []string{util.IptablesRestoreNoFlush, util.IptablesWaitFlag, defaultlockWaitTimeInSeconds}
+
+	log.Logf("Executing iptables command %s %v with %d rules", cmdName, cmdArgs, len(lines))
+
+	cmd := exec.Command(cmdName, cmdArgs...)
+	cmd.Stdin = strings.NewReader(craftRestoreFile(lines))
+	_, err := cmd.Output()
+	if msg, failed := err.(*exec.ExitError); failed {
+		msgStr := strings.TrimSuffix(string(msg.Stderr), "\n")
+		metrics.SendErrorLogAndMetric(util.IptmID, "Error: There was an error running command: [%s %v] Stderr: [%v, %s]", cmdName, strings.Join(cmdArgs, " "), err, msgStr)
+		return fmt.Errorf("%v: %s", err, msgStr)
+	}
+
+	return nil
+}
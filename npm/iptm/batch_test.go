@@ -0,0 +1,109 @@
+package iptm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/metrics/promutil"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestCraftRestoreLine(t *testing.T) {
+	entry := &IptEntry{
+		Chain: util.IptablesAzureIngressPortChain,
+		Specs: []string{
+			util.IptablesProtFlag,
+			"TCP",
+			util.IptablesDstPortFlag,
+			"8000",
+			util.IptablesJumpFlag,
+			util.IptablesMark,
+			util.IptablesSetMarkFlag,
+			util.IptablesAzureIngressMarkHex,
+			util.IptablesModuleFlag,
+			util.IptablesCommentModuleFlag,
+			util.IptablesCommentFlag,
+			"ALLOW ALL",
+		},
+	}
+
+	expected := "-I AZURE-NPM-INGRESS-PORT -p TCP --dport 8000 -j MARK --set-mark 0x2000 -m comment --comment \"ALLOW ALL\""
+	if actual := craftRestoreLine(util.IptablesInsertionFlag, entry); actual != expected {
+		t.Errorf("TestCraftRestoreLine failed @ craftRestoreLine. Expected: %s, Actual: %s", expected, actual)
+	}
+}
+
+func TestCraftRestoreFile(t *testing.T) {
+	lines := []string{
+		"-I AZURE-NPM-INGRESS-PORT -j RETURN",
+		"-A AZURE-NPM-INGRESS-PORT -j AZURE-NPM-INGRESS-DROPS",
+	}
+
+	expected := "*filter\n" +
+		"-I AZURE-NPM-INGRESS-PORT -j RETURN\n" +
+		"-A AZURE-NPM-INGRESS-PORT -j AZURE-NPM-INGRESS-DROPS\n" +
+		"COMMIT\n"
+	if actual := craftRestoreFile(lines); actual != expected {
+		t.Errorf("TestCraftRestoreFile failed @ craftRestoreFile. Expected:\n%s\nActual:\n%s", expected, actual)
+	}
+}
+
+func TestAddBatch(t *testing.T) {
+	iptMgr := &IptablesManager{}
+	if err := iptMgr.Save(util.IptablesTestConfigFile); err != nil {
+		t.Errorf("TestAddBatch failed @ iptMgr.Save")
+	}
+
+	defer func() {
+		if err := iptMgr.Restore(util.IptablesTestConfigFile); err != nil {
+			t.Errorf("TestAddBatch failed @ iptMgr.Restore")
+		}
+	}()
+
+	entries := []*IptEntry{
+		{
+			Chain: util.IptablesForwardChain,
+			Specs: []string{
+				util.IptablesJumpFlag,
+				util.IptablesReject,
+			},
+		},
+		{
+			Chain:       util.IptablesForwardChain,
+			IsJumpEntry: true,
+			Specs: []string{
+				util.IptablesJumpFlag,
+				util.IptablesAccept,
+			},
+		},
+	}
+
+	gaugeVal, err1 := promutil.GetValue(metrics.NumIPTableRules)
+	countVal, err2 := promutil.GetCountValue(metrics.AddIPTablesBatchExecTime)
+
+	if err := iptMgr.AddBatch(entries); err != nil {
+		t.Errorf("TestAddBatch failed @ iptMgr.AddBatch")
+	}
+
+	newGaugeVal, err3 := promutil.GetValue(metrics.NumIPTableRules)
+	newCountVal, err4 := promutil.GetCountValue(metrics.AddIPTablesBatchExecTime)
+	promutil.NotifyIfErrors(t, err1, err2, err3, err4)
+	if newGaugeVal != gaugeVal+2 {
+		t.Errorf("Change in iptable rule number didn't register in prometheus")
+	}
+	if newCountVal != countVal+1 {
+		t.Errorf("Execution time didn't register in prometheus")
+	}
+
+	gaugeVal, err1 = promutil.GetValue(metrics.NumIPTableRules)
+
+	if err := iptMgr.DeleteBatch(entries); err != nil {
+		t.Errorf("TestAddBatch failed @ iptMgr.DeleteBatch")
+	}
+
+	newGaugeVal, err2 = promutil.GetValue(metrics.NumIPTableRules)
+	promutil.NotifyIfErrors(t, err1, err2)
+	if newGaugeVal != gaugeVal-2 {
+		t.Errorf("Change in iptable rule number didn't register in prometheus")
+	}
+}
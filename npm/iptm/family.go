@@ -0,0 +1,76 @@
+package iptm
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// NewIp6tablesManager creates an IptablesManager programming the NPM chains of ip6tables.
+// Its rules match the inet6 twins of the ipsets, see util.GetIPv6HashedName.
+func NewIp6tablesManager() *IptablesManager {
+	iptMgr := NewIptablesManager()
+	iptMgr.ipv6 = true
+
+	return iptMgr
+}
+
+// iptablesCmd returns the iptables command of the address family of the manager.
+func (iptMgr *IptablesManager) iptablesCmd() string {
+	if iptMgr.ipv6 {
+		return util.Ip6tables
+	}
+
+	return util.Iptables
+}
+
+// saveCmd returns the iptables-save command of the address family of the manager.
+func (iptMgr *IptablesManager) saveCmd() string {
+	if iptMgr.ipv6 {
+		return util.Ip6tablesSave
+	}
+
+	return util.IptablesSave
+}
+
+// restoreCmd returns the iptables-restore command of the address family of the manager.
+func (iptMgr *IptablesManager) restoreCmd() string {
+	if iptMgr.ipv6 {
+		return util.Ip6tablesRestore
+	}
+
+	return util.IptablesRestore
+}
+
+// getFamilySpecs returns the rule specs to program, the matched ipsets are replaced by their inet6 twins for ip6tables.
+func (iptMgr *IptablesManager) getFamilySpecs(specs []string) []string {
+	if !iptMgr.ipv6 {
+		return specs
+	}
+
+	familySpecs := make([]string, len(specs))
+	for i, spec := range specs {
+		if i > 0 && specs[i-1] == util.IptablesMatchSetFlag && strings.HasPrefix(spec, util.AzureNpmPrefix) {
+			spec = util.GetIPv6HashedName(spec)
+		}
+		familySpecs[i] = spec
+	}
+
+	return familySpecs
+}
+
+// getFamilyEntries returns the entries with the rule specs to program, see getFamilySpecs.
+func (iptMgr *IptablesManager) getFamilyEntries(entries []*IptEntry) []*IptEntry {
+	if !iptMgr.ipv6 {
+		return entries
+	}
+
+	familyEntries := make([]*IptEntry, len(entries))
+	for i, entry := range entries {
+		familyEntry := *entry
+		familyEntry.Specs = iptMgr.getFamilySpecs(entry.Specs)
+		familyEntries[i] = &familyEntry
+	}
+
+	return familyEntries
+}
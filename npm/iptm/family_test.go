@@ -0,0 +1,45 @@
+package iptm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestGetFamilySpecs(t *testing.T) {
+	setName := util.GetHashedName("app:frontend")
+	specs := []string{
+		util.IptablesModuleFlag,
+		util.IptablesSetModuleFlag,
+		util.IptablesMatchSetFlag,
+		setName,
+		util.IptablesSrcFlag,
+		util.IptablesJumpFlag,
+		util.IptablesAccept,
+		util.IptablesModuleFlag,
+		util.IptablesCommentModuleFlag,
+		util.IptablesCommentFlag,
+		setName,
+	}
+
+	if actual := NewIptablesManager().getFamilySpecs(specs); !reflect.DeepEqual(actual, specs) {
+		t.Errorf("TestGetFamilySpecs failed @ getFamilySpecs, expected the IPv4 specs to be unchanged. Actual: %v", actual)
+	}
+
+	ip6tMgr := NewIp6tablesManager()
+	expected := append([]string{}, specs...)
+	expected[3] = util.GetIPv6HashedName(setName)
+	if actual := ip6tMgr.getFamilySpecs(specs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetFamilySpecs failed @ getFamilySpecs, expected the ipset to be replaced by its inet6 twin. Actual: %v", actual)
+	}
+
+	entries := ip6tMgr.getFamilyEntries([]*IptEntry{{Chain: util.IptablesAzureIngressPortChain, Specs: specs}})
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Specs, expected) || specs[3] != setName {
+		t.Errorf("TestGetFamilySpecs failed @ getFamilyEntries, expected a copy of the entry with the IPv6 specs. Actual: %+v", entries)
+	}
+
+	if ip6tMgr.iptablesCmd() != util.Ip6tables || ip6tMgr.saveCmd() != util.Ip6tablesSave || ip6tMgr.restoreCmd() != util.Ip6tablesRestore {
+		t.Errorf("TestGetFamilySpecs failed @ iptablesCmd, expected the ip6tables commands")
+	}
+}
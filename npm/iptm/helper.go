@@ -28,6 +28,21 @@ func getAllChainsAndRules() [][]string {
 	return chainsAndRules
 }
 
+// GetDefaultChainEntries returns the rules AddAllRulesToChains appends to the NPM chains, in order.
+func GetDefaultChainEntries() []*IptEntry {
+	allChainsAndRules := getAllChainsAndRules()
+	entries := make([]*IptEntry, len(allChainsAndRules))
+	for i, rule := range allChainsAndRules {
+		entries[i] = &IptEntry{
+			Chain:       rule[0],
+			IsJumpEntry: true,
+			Specs:       rule[1:],
+		}
+	}
+
+	return entries
+}
+
 // getAzureNPMChainRules returns all rules for AZURE-NPM chain
 func getAzureNPMChainRules() [][]string {
 	// Note: make sure 0th index is prent chain for logging
@@ -54,6 +54,8 @@ type IptEntry struct {
 // IptablesManager stores iptables entries.
 type IptablesManager struct {
 	OperationFlag string
+	// ipv6 makes the manager program ip6tables, see NewIp6tablesManager. Only the IPv4 rules are counted in the metrics.
+	ipv6 bool
 }
 
 // NewIptablesManager creates a new instance for IptablesManager object.
@@ -303,7 +305,7 @@ func (iptMgr *IptablesManager) GetChainLineNumber(chain string, parentChain stri
 		err    error
 	)
 
-	cmdName := util.Iptables
+	cmdName := iptMgr.iptablesCmd()
 	cmdArgs := []string{"-t", "filter", "-n", "--list", parentChain, "--line-numbers"}
 
 	iptFilterEntries := exec.Command(cmdName, cmdArgs...)
@@ -355,8 +357,6 @@ func (iptMgr *IptablesManager) DeleteChain(chain string) error {
 
 // Add adds a rule in iptables.
 func (iptMgr *IptablesManager) Add(entry *IptEntry) error {
-	timer := metrics.StartNewTimer()
-
 	log.Logf("Adding iptables entry: %+v.", entry)
 
 	if entry.IsJumpEntry {
@@ -369,8 +369,9 @@ func (iptMgr *IptablesManager) Add(entry *IptEntry) error {
 		return err
 	}
 
-	metrics.NumIPTableRules.Inc()
-	timer.StopAndRecord(metrics.AddIPTableRuleExecTime)
+	if !iptMgr.ipv6 {
+		metrics.NumIPTableRules.Inc()
+	}
 
 	return nil
 }
@@ -394,7 +395,9 @@ func (iptMgr *IptablesManager) Delete(entry *IptEntry) error {
 		return err
 	}
 
-	metrics.NumIPTableRules.Dec()
+	if !iptMgr.ipv6 {
+		metrics.NumIPTableRules.Dec()
+	}
 
 	return nil
 }
@@ -403,14 +406,14 @@ func (iptMgr *IptablesManager) Delete(entry *IptEntry) error {
 func (iptMgr *IptablesManager) Run(entry *IptEntry) (int, error) {
 	cmdName := entry.Command
 	if cmdName == "" {
-		cmdName = util.Iptables
+		cmdName = iptMgr.iptablesCmd()
 	}
 
 	if entry.LockWaitTimeInSeconds == "" {
 		entry.LockWaitTimeInSeconds = defaultlockWaitTimeInSeconds
 	}
 
-	cmdArgs := append([]string{util.IptablesWaitFlag, entry.LockWaitTimeInSeconds, iptMgr.OperationFlag, entry.Chain}, entry.Specs...)
+	cmdArgs := append([]string{util.IptablesWaitFlag, entry.LockWaitTimeInSeconds, iptMgr.OperationFlag, entry.Chain}, iptMgr.getFamilySpecs(entry.Specs)...)
 
 	if iptMgr.OperationFlag != util.IptablesCheckFlag {
 		log.Logf("Executing iptables command %s %v", cmdName, cmdArgs)
@@ -458,7 +461,7 @@ func (iptMgr *IptablesManager) Save(configFile string) error {
 	}
 	defer f.Close()
 
-	cmd := exec.Command(util.IptablesSave)
+	cmd := exec.Command(iptMgr.saveCmd())
 	cmd.Stdout = f
 	if err := cmd.Start(); err != nil {
 		metrics.SendErrorLogAndMetric(util.IptmID, "Error: failed to run iptables-save.")
@@ -494,7 +497,7 @@ func (iptMgr *IptablesManager) Restore(configFile string) error {
 	}
 	defer f.Close()
 
-	cmd := exec.Command(util.IptablesRestore)
+	cmd := exec.Command(iptMgr.restoreCmd())
 	cmd.Stdin = f
 	if err := cmd.Start(); err != nil {
 		metrics.SendErrorLogAndMetric(util.IptmID, "Error: failed to run iptables-restore.")
@@ -538,29 +541,3 @@ func grabIptablesLocks() (*os.File, error) {
 	success = true
 	return l, nil
 }
-
-// TO-DO :- Use iptables-restore to update iptables.
-// func SyncIptables(entries []*IptEntry) error {
-// 	// Ensure main chains and rules are installed.
-// 	tablesNeedServicesChain := []utiliptables.Table{utiliptables.TableFilter, utiliptables.TableNAT}
-// 	for _, table := range tablesNeedServicesChain {
-// 		if _, err := proxier.iptables.EnsureChain(table, iptablesServicesChain); err != nil {
-// 			glog.Errorf("Failed to ensure that %s chain %s exists: %v", table, iptablesServicesChain, err)
-// 			return
-// 		}
-// 	}
-
-// 	// Get iptables-save output so we can check for existing chains and rules.
-// 	// This will be a map of chain name to chain with rules as stored in iptables-save/iptables-restore
-// 	existingFilterChains := make(map[utiliptables.Chain]string)
-// 	iptablesSaveRaw, err := proxier.iptables.Save(utiliptables.TableFilter)
-// 	if err != nil { // if we failed to get any rules
-// 		glog.Errorf("Failed to execute iptables-save, syncing all rules. %s", err.Error())
-// 	} else { // otherwise parse the output
-// 		existingFilterChains = getChainLines(utiliptables.TableFilter, iptablesSaveRaw)
-// 	}
-
-// 	// Write table headers.
-// 	writeLine(filterChains, "*filter")
-
-// }
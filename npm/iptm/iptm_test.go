@@ -151,21 +151,16 @@ func TestAdd(t *testing.T) {
 	}
 
 	gaugeVal, err1 := promutil.GetValue(metrics.NumIPTableRules)
-	countVal, err2 := promutil.GetCountValue(metrics.AddIPTableRuleExecTime)
 
 	if err := iptMgr.Add(entry); err != nil {
 		t.Errorf("TestAdd failed @ iptMgr.Add")
 	}
 
-	newGaugeVal, err3 := promutil.GetValue(metrics.NumIPTableRules)
-	newCountVal, err4 := promutil.GetCountValue(metrics.AddIPTableRuleExecTime)
-	promutil.NotifyIfErrors(t, err1, err2, err3, err4)
+	newGaugeVal, err2 := promutil.GetValue(metrics.NumIPTableRules)
+	promutil.NotifyIfErrors(t, err1, err2)
 	if newGaugeVal != gaugeVal+1 {
 		t.Errorf("Change in iptable rule number didn't register in prometheus")
 	}
-	if newCountVal != countVal+1 {
-		t.Errorf("Execution time didn't register in prometheus")
-	}
 }
 
 func TestDelete(t *testing.T) {
@@ -0,0 +1,159 @@
+package iptm
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// ReconcileChains makes the NPM chains hold the entries of chains, in order, and removes them when initialized is false.
+// Only the chains whose rules differ from iptables-save are rewritten, all of them with a single iptables-restore call,
+// so packets are never evaluated against a partially repaired chain.
+func (iptMgr *IptablesManager) ReconcileChains(initialized bool, chains map[string][]*IptEntry) error {
+	cmdName := iptMgr.saveCmd()
+	cmdArgs := []string{util.IptablesTableFlag, util.IptablesFilterTable}
+	out, err := exec.Command(cmdName, cmdArgs...).Output()
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.IptmID, "Error: failed to read iptables with [%s %s]: %v", cmdName, strings.Join(cmdArgs, " "), err)
+		return err
+	}
+
+	savedChains := parseSavedChains(string(out))
+	if !initialized {
+		if len(savedChains) == 0 {
+			return nil
+		}
+
+		log.Logf("Removing AZURE-NPM chains, no network policy applies.")
+		return iptMgr.UninitNpmChains()
+	}
+
+	var (
+		declarations []string
+		rules        []string
+		numRules     int
+	)
+	for _, chain := range IptablesAzureChainList {
+		entries := iptMgr.getFamilyEntries(chains[chain])
+		numRules += len(entries)
+
+		saved, exists := savedChains[chain]
+		if exists && equalRules(saved, entries) {
+			continue
+		}
+
+		log.Logf("Repairing iptables chain %s: %d rules expected, %d found.", chain, len(entries), len(saved))
+
+		// with --noflush, declaring a chain creates it or flushes it.
+		declarations = append(declarations, ":"+chain+" - [0:0]")
+		for _, entry := range entries {
+			rules = append(rules, craftRestoreLine(util.IptablesAppendFlag, entry))
+		}
+	}
+
+	if len(declarations) > 0 {
+		if err := iptMgr.runRestore(append(declarations, rules...)); err != nil {
+			metrics.SendErrorLogAndMetric(util.IptmID, "Error: failed to repair %d iptables chains.", len(declarations))
+			return err
+		}
+	}
+	if !iptMgr.ipv6 {
+		metrics.NumIPTableRules.Set(float64(numRules))
+	}
+
+	// chains of older versions are unreferenced once AZURE-NPM is repaired.
+	for _, chain := range []string{util.IptablesAzureTargetSetsChain, util.IptablesAzureIngressWrongDropsChain} {
+		if _, exists := savedChains[chain]; !exists {
+			continue
+		}
+
+		iptMgr.OperationFlag = util.IptablesFlushFlag
+		if _, err := iptMgr.Run(&IptEntry{Chain: chain}); err != nil {
+			metrics.SendErrorLogAndMetric(util.IptmID, "Error: failed to flush iptables chain %s.", chain)
+			continue
+		}
+		iptMgr.DeleteChain(chain)
+	}
+
+	return iptMgr.CheckAndAddForwardChain()
+}
+
+// parseSavedChains returns the normalized rules of the NPM chains in iptables-save output, by chain.
+func parseSavedChains(iptablesSave string) map[string][]string {
+	chains := make(map[string][]string)
+	for _, line := range strings.Split(iptablesSave, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, ":"+util.IptablesAzureChain):
+			chain := strings.Fields(line[1:])[0]
+			if _, exists := chains[chain]; !exists {
+				chains[chain] = nil
+			}
+		case strings.HasPrefix(line, util.IptablesAppendFlag+" "+util.IptablesAzureChain):
+			fields := util.SplitRuleSpecs(line)
+			chain := fields[1]
+			chains[chain] = append(chains[chain], util.NormalizeRuleSpecs(fields[2:]))
+		}
+	}
+
+	return chains
+}
+
+// GetNpmLeftovers returns the NPM chains left in iptables, and how many rules of the FORWARD chain still jump to AZURE-NPM.
+func (iptMgr *IptablesManager) GetNpmLeftovers() ([]string, int, error) {
+	cmdName := iptMgr.saveCmd()
+	cmdArgs := []string{util.IptablesTableFlag, util.IptablesFilterTable}
+	out, err := exec.Command(cmdName, cmdArgs...).Output()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read iptables with [%s %s]: %v", cmdName, strings.Join(cmdArgs, " "), err)
+	}
+
+	var chains []string
+	for chain := range parseSavedChains(string(out)) {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+
+	return chains, countForwardJumps(string(out)), nil
+}
+
+// countForwardJumps counts the rules of the FORWARD chain jumping to AZURE-NPM in iptables-save output.
+func countForwardJumps(iptablesSave string) int {
+	jumps := 0
+	for _, line := range strings.Split(iptablesSave, "\n") {
+		fields := util.SplitRuleSpecs(strings.TrimSpace(line))
+		if len(fields) < 2 || fields[0] != util.IptablesAppendFlag || fields[1] != util.IptablesForwardChain {
+			continue
+		}
+
+		for i := 2; i+1 < len(fields); i++ {
+			if fields[i] == util.IptablesJumpFlag && fields[i+1] == util.IptablesAzureChain {
+				jumps++
+				break
+			}
+		}
+	}
+
+	return jumps
+}
+
+// equalRules compares the rules of a chain in their normalized form, see util.NormalizeRuleSpecs.
+// A rule the normalization misses is only rewritten with the same content.
+func equalRules(saved []string, entries []*IptEntry) bool {
+	if len(saved) != len(entries) {
+		return false
+	}
+
+	for i, entry := range entries {
+		if saved[i] != util.NormalizeRuleSpecs(entry.Specs) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,95 @@
+package iptm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestParseSavedChains(t *testing.T) {
+	iptablesSave := `# Generated by iptables-save v1.8.4 on Thu Jan  1 00:00:00 2020
+*filter
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [0:0]
+:AZURE-NPM - [0:0]
+:AZURE-NPM-INGRESS-PORT - [0:0]
+:AZURE-NPM-EGRESS-PORT - [0:0]
+-A FORWARD -j AZURE-NPM
+-A AZURE-NPM-INGRESS-PORT -p tcp -m tcp --dport 8000 -m set --match-set azure-npm-1 dst -m set ! --match-set azure-npm-2 src -m comment --comment "ALLOW ALL" -j MARK --set-xmark 0x2000/0xffffffff
+-A AZURE-NPM-INGRESS-PORT -s 10.0.0.1/32 -j AZURE-NPM-INGRESS-FROM
+COMMIT
+`
+
+	chains := parseSavedChains(iptablesSave)
+	if len(chains) != 3 {
+		t.Errorf("TestParseSavedChains failed @ parseSavedChains. Expected 3 NPM chains, Actual: %v", chains)
+	}
+
+	if rules, exists := chains[util.IptablesAzureChain]; !exists || len(rules) != 0 {
+		t.Errorf("TestParseSavedChains failed @ parseSavedChains. Expected an empty AZURE-NPM chain, Actual: %v", rules)
+	}
+
+	entries := []*IptEntry{
+		&IptEntry{
+			Chain: util.IptablesAzureIngressPortChain,
+			Specs: []string{
+				util.IptablesProtFlag,
+				"TCP",
+				util.IptablesDstPortFlag,
+				"8000",
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				"azure-npm-1",
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesNotFlag,
+				util.IptablesMatchSetFlag,
+				"azure-npm-2",
+				util.IptablesSrcFlag,
+				util.IptablesJumpFlag,
+				util.IptablesMark,
+				util.IptablesSetMarkFlag,
+				util.IptablesAzureIngressMarkHex,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW ALL",
+			},
+		},
+		&IptEntry{
+			Chain: util.IptablesAzureIngressPortChain,
+			Specs: []string{
+				util.IptablesSFlag,
+				"10.0.0.1",
+				util.IptablesJumpFlag,
+				util.IptablesAzureIngressFromChain,
+			},
+		},
+	}
+
+	if saved := chains[util.IptablesAzureIngressPortChain]; !equalRules(saved, entries) {
+		t.Errorf("TestParseSavedChains failed @ equalRules. Saved: %q, Expected: %q", saved, []string{util.NormalizeRuleSpecs(entries[0].Specs), util.NormalizeRuleSpecs(entries[1].Specs)})
+	}
+
+	if equalRules(chains[util.IptablesAzureIngressPortChain], entries[1:]) {
+		t.Errorf("TestParseSavedChains failed @ equalRules, expected a missing rule to be a difference")
+	}
+}
+
+func TestCountForwardJumps(t *testing.T) {
+	iptablesSave := `*filter
+:FORWARD ACCEPT [0:0]
+:AZURE-NPM - [0:0]
+-A FORWARD -j AZURE-NPM
+-A FORWARD -m conntrack --ctstate NEW -j AZURE-NPM
+-A FORWARD -j AZURE-NPM-INGRESS
+-A AZURE-NPM -j AZURE-NPM
+COMMIT
+`
+
+	if jumps := countForwardJumps(iptablesSave); jumps != 2 {
+		t.Errorf("TestCountForwardJumps failed @ countForwardJumps. Expected 2 jumps, Actual: %d", jumps)
+	}
+}
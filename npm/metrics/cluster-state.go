@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	clusterStateLock sync.Mutex
+	// the counts of the last SetClusterState, nil unless this node publishes the cluster metrics.
+	clusterState *clusterStateCounts
+)
+
+type clusterStateCounts struct {
+	pods            int
+	namespaces      int
+	networkPolicies int
+}
+
+// SetClusterState records the objects of the cluster, the cluster metrics report them until ResetClusterState.
+// Only the node elected to publish the cluster metrics calls it.
+func SetClusterState(pods, namespaces, networkPolicies int) {
+	clusterStateLock.Lock()
+	defer clusterStateLock.Unlock()
+
+	clusterState = &clusterStateCounts{pods: pods, namespaces: namespaces, networkPolicies: networkPolicies}
+}
+
+// ResetClusterState stops reporting the objects of the cluster, once another node publishes them.
+func ResetClusterState() {
+	clusterStateLock.Lock()
+	defer clusterStateLock.Unlock()
+
+	clusterState = nil
+}
+
+// clusterStateCollector reports the objects of the cluster when the metrics are scraped, only on the node publishing them.
+type clusterStateCollector struct {
+	podsDesc            *prometheus.Desc
+	namespacesDesc      *prometheus.Desc
+	networkPoliciesDesc *prometheus.Desc
+}
+
+func newClusterStateCollector() *clusterStateCollector {
+	return &clusterStateCollector{
+		podsDesc:            prometheus.NewDesc(prometheus.BuildFQName(namespace, "", clusterPodsName), clusterPodsHelp, nil, nil),
+		namespacesDesc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, "", clusterNamespacesName), clusterNamespacesHelp, nil, nil),
+		networkPoliciesDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", clusterNetworkPoliciesName), clusterNetworkPoliciesHelp, nil, nil),
+	}
+}
+
+func (c *clusterStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.podsDesc
+	ch <- c.namespacesDesc
+	ch <- c.networkPoliciesDesc
+}
+
+func (c *clusterStateCollector) Collect(ch chan<- prometheus.Metric) {
+	clusterStateLock.Lock()
+	defer clusterStateLock.Unlock()
+
+	if clusterState == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.podsDesc, prometheus.GaugeValue, float64(clusterState.pods))
+	ch <- prometheus.MustNewConstMetric(c.namespacesDesc, prometheus.GaugeValue, float64(clusterState.namespaces))
+	ch <- prometheus.MustNewConstMetric(c.networkPoliciesDesc, prometheus.GaugeValue, float64(clusterState.networkPolicies))
+}
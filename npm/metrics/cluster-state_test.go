@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/http/api"
+)
+
+func TestClusterState(t *testing.T) {
+	InitializeAll()
+
+	req, err := http.NewRequest(http.MethodGet, api.ClusterMetricsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getClusterMetrics := func() string {
+		rr := httptest.NewRecorder()
+		GetHandler(false).ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	if body := getClusterMetrics(); strings.Contains(body, clusterPodsName) {
+		t.Errorf("TestClusterState failed @ GetHandler, expected no cluster state before SetClusterState:\n%s", body)
+	}
+
+	SetClusterState(10, 3, 2)
+	body := getClusterMetrics()
+	for _, expected := range []string{
+		fmt.Sprintf("%s_%s 10", namespace, clusterPodsName),
+		fmt.Sprintf("%s_%s 3", namespace, clusterNamespacesName),
+		fmt.Sprintf("%s_%s 2", namespace, clusterNetworkPoliciesName),
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("TestClusterState failed @ SetClusterState, expected %s in:\n%s", expected, body)
+		}
+	}
+
+	ResetClusterState()
+	if body := getClusterMetrics(); strings.Contains(body, clusterPodsName) {
+		t.Errorf("TestClusterState failed @ ResetClusterState, expected no cluster state:\n%s", body)
+	}
+}
@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values of the direction and verdict labels of the policy counters.
+const (
+	IngressDirection = "ingress"
+	EgressDirection  = "egress"
+	AcceptedVerdict  = "accepted"
+	DroppedVerdict   = "dropped"
+)
+
+// iptables-save -c prints the counters of a rule as "[packets:bytes] -A CHAIN ...".
+var ruleCountersRegex = regexp.MustCompile(`^\[(\d+):(\d+)\] -A (\S+) (.*)$`)
+
+// PolicyRef identifies the network policy an iptables rule was translated from.
+type PolicyRef struct {
+	Namespace string
+	Name      string
+}
+
+// GetRuleKey returns the key of an iptables rule in the rule owners of PolicyCounters: its chain and normalized specs.
+func GetRuleKey(chain string, specs []string) string {
+	return chain + " " + util.NormalizeRuleSpecs(specs)
+}
+
+// ruleCounter holds the counters of one iptables rule of the AZURE-NPM chains.
+type ruleCounter struct {
+	rule string
+	// each policy programs its own copy of the rules it shares with other policies,
+	// copy is the index of the rule among the identical rules of its chain.
+	copy      int
+	direction string
+	verdict   string
+	packets   float64
+	bytes     float64
+}
+
+// exportedRule is what was exported for a rule copy on the last update.
+type exportedRule struct {
+	owner   PolicyRef
+	packets float64
+	bytes   float64
+}
+
+// PolicyCounters exports the packet and byte counters of the iptables rules of each network policy.
+// The n-th copy of a rule is attributed to the n-th of its owners, sorted by namespace and name.
+type PolicyCounters struct {
+	// the rule copies by rule key and copy index.
+	rules map[string]*exportedRule
+	// the label sets exported for each policy.
+	series map[PolicyRef]map[string]prometheus.Labels
+}
+
+// NewPolicyCounters returns the policy counters, with nothing exported.
+func NewPolicyCounters() *PolicyCounters {
+	return &PolicyCounters{
+		rules:  make(map[string]*exportedRule),
+		series: make(map[PolicyRef]map[string]prometheus.Labels),
+	}
+}
+
+// Update reads the counters of the AZURE-NPM chains and adds what the rules of each policy counted since the last update.
+// ruleOwners maps the key of a rule, see GetRuleKey, to the policies it was translated from.
+// Rules without owner, like the default rules of the chains, are not exported.
+func (pc *PolicyCounters) Update(ruleOwners map[string][]PolicyRef) error {
+	cmdName := util.IptablesSave
+	cmdArgs := []string{util.IptablesSaveCountersFlag, util.IptablesTableFlag, util.IptablesFilterTable}
+	out, err := exec.Command(cmdName, cmdArgs...).Output()
+	if err != nil {
+		SendErrorLogAndMetric(util.IptmID, "Error: failed to read iptables counters with [%s %s]: %v", cmdName, strings.Join(cmdArgs, " "), err)
+		return err
+	}
+
+	pc.update(string(out), ruleOwners)
+	return nil
+}
+
+func (pc *PolicyCounters) update(iptablesSave string, ruleOwners map[string][]PolicyRef) {
+	rules := make(map[string]*exportedRule)
+	for _, counter := range parseRuleCounters(iptablesSave) {
+		owners := sortedPolicies(ruleOwners[counter.rule])
+		if counter.copy >= len(owners) {
+			continue
+		}
+
+		owner := owners[counter.copy]
+		packets, bytes := counter.packets, counter.bytes
+		copyKey := fmt.Sprintf("%s#%d", counter.rule, counter.copy)
+		if last, exists := pc.rules[copyKey]; exists {
+			switch {
+			case last.owner != owner:
+				// the copies shifted, what this copy counted so far belongs to the previous owner.
+				packets, bytes = 0, 0
+			case packets >= last.packets && bytes >= last.bytes:
+				packets, bytes = packets-last.packets, bytes-last.bytes
+			}
+		}
+		rules[copyKey] = &exportedRule{owner: owner, packets: counter.packets, bytes: counter.bytes}
+
+		labels := prometheus.Labels{NamespaceLabel: owner.Namespace, PolicyLabel: owner.Name, DirectionLabel: counter.direction, VerdictLabel: counter.verdict}
+		PolicyPackets.With(labels).Add(packets)
+		PolicyBytes.With(labels).Add(bytes)
+
+		if _, exists := pc.series[owner]; !exists {
+			pc.series[owner] = make(map[string]prometheus.Labels)
+		}
+		pc.series[owner][counter.direction+"/"+counter.verdict] = labels
+	}
+	pc.rules = rules
+
+	// the counters of deleted policies are removed.
+	programmed := make(map[PolicyRef]bool)
+	for _, owners := range ruleOwners {
+		for _, owner := range owners {
+			programmed[owner] = true
+		}
+	}
+
+	for owner, series := range pc.series {
+		if programmed[owner] {
+			continue
+		}
+
+		for _, labels := range series {
+			PolicyPackets.Delete(labels)
+			PolicyBytes.Delete(labels)
+		}
+		delete(pc.series, owner)
+	}
+}
+
+// parseRuleCounters returns the counters of the accepting and dropping rules of the AZURE-NPM chains in iptables-save -c output.
+func parseRuleCounters(iptablesSave string) []*ruleCounter {
+	var (
+		counters []*ruleCounter
+		copies   = make(map[string]int)
+	)
+
+	for _, line := range strings.Split(iptablesSave, "\n") {
+		match := ruleCountersRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil || !strings.HasPrefix(match[3], util.IptablesAzureChain+"-") {
+			continue
+		}
+
+		direction := IngressDirection
+		if strings.Contains(match[3], "EGRESS") {
+			direction = EgressDirection
+		}
+
+		specs := util.SplitRuleSpecs(match[4])
+		verdict := ""
+		switch getRuleTarget(specs) {
+		case util.IptablesDrop, util.IptablesReject:
+			verdict = DroppedVerdict
+		case util.IptablesMark:
+			verdict = AcceptedVerdict
+		default:
+			// jumps are counted again by the rules of the chain they jump to.
+			continue
+		}
+
+		rule := GetRuleKey(match[3], specs)
+		packets, _ := strconv.ParseFloat(match[1], 64)
+		bytes, _ := strconv.ParseFloat(match[2], 64)
+		counters = append(counters, &ruleCounter{
+			rule:      rule,
+			copy:      copies[rule],
+			direction: direction,
+			verdict:   verdict,
+			packets:   packets,
+			bytes:     bytes,
+		})
+		copies[rule]++
+	}
+
+	return counters
+}
+
+// getRuleTarget returns the target of a rule in iptables-save format.
+func getRuleTarget(specs []string) string {
+	for i := 0; i+1 < len(specs); i++ {
+		if specs[i] == util.IptablesJumpFlag {
+			return specs[i+1]
+		}
+	}
+
+	return ""
+}
+
+func sortedPolicies(policies []PolicyRef) []PolicyRef {
+	sorted := append([]PolicyRef(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/metrics/promutil"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPolicyCounters(t *testing.T) {
+	InitializeAll()
+
+	iptablesSave := `# Generated by iptables-save
+*filter
+:AZURE-NPM - [0:0]
+:AZURE-NPM-INGRESS-PORT - [0:0]
+[500:30000] -A FORWARD -j AZURE-NPM
+[120:7200] -A AZURE-NPM -j AZURE-NPM-INGRESS
+[10:600] -A AZURE-NPM-INGRESS-PORT -p tcp -m tcp --dport 80 -m set --match-set azure-npm-1 dst -m comment --comment "ALLOW ALL-TO-app:frontend-IN-ns-x" -j MARK --set-xmark 0x2000/0xffffffff
+[7:420] -A AZURE-NPM-INGRESS-DROPS -m set --match-set azure-npm-1 dst -m comment --comment DROP-ALL-TO-app:frontend-IN-ns-x -j DROP
+[0:0] -A AZURE-NPM-INGRESS-DROPS -m set --match-set azure-npm-1 dst -m comment --comment DROP-ALL-TO-app:frontend-IN-ns-x -j DROP
+[3:180] -A AZURE-NPM-EGRESS-DROPS -m set --match-set azure-npm-3 src -m comment --comment DROP-ALL-FROM-app:db-IN-ns-x -j DROP
+[2:120] -A AZURE-NPM-INGRESS-PORT -m set --match-set azure-npm-1 dst -m comment --comment ALLOW-ALL-TO-app:frontend-IN-ns-x-TO-JUMP-TO-AZURE-NPM-INGRESS-FROM -j AZURE-NPM-INGRESS-FROM
+[9:540] -A AZURE-NPM-ACCEPT -m comment --comment Clear-AZURE-NPM-MARKS -j MARK --set-xmark 0x0/0xffffffff
+COMMIT
+`
+
+	var (
+		allowPort80  = PolicyRef{Namespace: "x", Name: "allow-port-80"}
+		allowBackend = PolicyRef{Namespace: "x", Name: "allow-backend"}
+		denyDBEgress = PolicyRef{Namespace: "x", Name: "deny-db-egress"}
+	)
+
+	// the rules as NPM programs them.
+	allowRule := GetRuleKey(util.IptablesAzureIngressPortChain, []string{
+		"-p", "TCP", "--dport", "80", "-m", "set", "--match-set", "azure-npm-1", "dst",
+		"-j", "MARK", "--set-mark", "0x2000", "-m", "comment", "--comment", "ALLOW ALL-TO-app:frontend-IN-ns-x",
+	})
+	dropRule := GetRuleKey(util.IptablesAzureIngressDropsChain, []string{
+		"-m", "set", "--match-set", "azure-npm-1", "dst", "-m", "comment", "--comment", "DROP-ALL-TO-app:frontend-IN-ns-x", "-j", "DROP",
+	})
+	egressDropRule := GetRuleKey(util.IptablesAzureEgressDropsChain, []string{
+		"-m", "set", "--match-set", "azure-npm-3", "src", "-m", "comment", "--comment", "DROP-ALL-FROM-app:db-IN-ns-x", "-j", "DROP",
+	})
+
+	ruleOwners := map[string][]PolicyRef{
+		allowRule:      {allowPort80},
+		dropRule:       {allowPort80, allowBackend},
+		egressDropRule: {denyDBEgress},
+	}
+
+	policyCounters := NewPolicyCounters()
+	policyCounters.update(iptablesSave, ruleOwners)
+
+	labels := func(policy PolicyRef, direction, verdict string) prometheus.Labels {
+		return prometheus.Labels{NamespaceLabel: policy.Namespace, PolicyLabel: policy.Name, DirectionLabel: direction, VerdictLabel: verdict}
+	}
+
+	// each copy of the shared drop rule counts for one policy, the first one drops the packets.
+	accepted, err1 := promutil.GetCounterVecValue(PolicyPackets, labels(allowPort80, IngressDirection, AcceptedVerdict))
+	acceptedBytes, err2 := promutil.GetCounterVecValue(PolicyBytes, labels(allowPort80, IngressDirection, AcceptedVerdict))
+	droppedBackend, err3 := promutil.GetCounterVecValue(PolicyPackets, labels(allowBackend, IngressDirection, DroppedVerdict))
+	droppedPort80, err4 := promutil.GetCounterVecValue(PolicyPackets, labels(allowPort80, IngressDirection, DroppedVerdict))
+	droppedEgress, err5 := promutil.GetCounterVecValue(PolicyPackets, labels(denyDBEgress, EgressDirection, DroppedVerdict))
+	promutil.NotifyIfErrors(t, err1, err2, err3, err4, err5)
+	if accepted != 10 || acceptedBytes != 600 || droppedBackend != 7 || droppedPort80 != 0 || droppedEgress != 3 {
+		t.Errorf("TestPolicyCounters failed @ update. Accepted: %d packets, %d bytes, dropped: %d (allow-backend), %d (allow-port-80), %d (deny-db-egress)",
+			accepted, acceptedBytes, droppedBackend, droppedPort80, droppedEgress)
+	}
+
+	// the allow rule was programmed again and deny-db-egress was deleted.
+	iptablesSave = `*filter
+[4:240] -A AZURE-NPM-INGRESS-PORT -p tcp -m tcp --dport 80 -m set --match-set azure-npm-1 dst -m comment --comment "ALLOW ALL-TO-app:frontend-IN-ns-x" -j MARK --set-xmark 0x2000/0xffffffff
+[9:540] -A AZURE-NPM-INGRESS-DROPS -m set --match-set azure-npm-1 dst -m comment --comment DROP-ALL-TO-app:frontend-IN-ns-x -j DROP
+[0:0] -A AZURE-NPM-INGRESS-DROPS -m set --match-set azure-npm-1 dst -m comment --comment DROP-ALL-TO-app:frontend-IN-ns-x -j DROP
+COMMIT
+`
+	delete(ruleOwners, egressDropRule)
+	policyCounters.update(iptablesSave, ruleOwners)
+
+	accepted, err1 = promutil.GetCounterVecValue(PolicyPackets, labels(allowPort80, IngressDirection, AcceptedVerdict))
+	droppedBackend, err2 = promutil.GetCounterVecValue(PolicyPackets, labels(allowBackend, IngressDirection, DroppedVerdict))
+	promutil.NotifyIfErrors(t, err1, err2)
+	if accepted != 14 || droppedBackend != 9 {
+		t.Errorf("TestPolicyCounters failed @ update. Expected the counters to keep increasing, accepted: %d, dropped: %d", accepted, droppedBackend)
+	}
+
+	if PolicyPackets.Delete(labels(denyDBEgress, EgressDirection, DroppedVerdict)) {
+		t.Errorf("TestPolicyCounters failed @ update. Expected the counters of deleted policies to be removed")
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values of the reason label of PolicyApplyFailures.
+const (
+	InvalidPolicyReason = "invalid_policy"
+	ChainsReason        = "chains"
+	MergePolicyReason   = "merge_policy"
+	IPSetReason         = "ipset"
+	IPTablesReason      = "iptables"
+)
+
+// PolicyFootprint is what a network policy programs on the node.
+type PolicyFootprint struct {
+	IPTableRules int // the iptables rules translated from the policy
+	IPSets       int // the ipsets and lists the rules refer to, they may be shared with other policies
+	IPSetEntries int // the entries of the ipBlock ipsets of the policy, the other ipsets are filled by pods and namespaces
+}
+
+type policyFootprintRecord struct {
+	ns          string
+	name        string
+	footprint   PolicyFootprint
+	lastApplied time.Time
+}
+
+var (
+	policyFootprintsLock sync.Mutex
+	// <namespace>/<name> -> footprint of the policy
+	policyFootprints = make(map[string]*policyFootprintRecord)
+	// namespace -> number of policies
+	namespacePolicyCounts = make(map[string]int)
+)
+
+// GetPolicyFootprint returns the footprint of a policy, and whether it is applied.
+func GetPolicyFootprint(ns, name string) (PolicyFootprint, bool) {
+	policyFootprintsLock.Lock()
+	defer policyFootprintsLock.Unlock()
+
+	record, exists := policyFootprints[getPolicyFootprintKey(ns, name)]
+	if !exists {
+		return PolicyFootprint{}, false
+	}
+	return record.footprint, true
+}
+
+// SetPolicyFootprint records the footprint of a policy which was just applied and updates the Prometheus metrics.
+func SetPolicyFootprint(ns, name string, footprint PolicyFootprint) {
+	policyFootprintsLock.Lock()
+	defer policyFootprintsLock.Unlock()
+
+	key := getPolicyFootprintKey(ns, name)
+	if _, exists := policyFootprints[key]; !exists {
+		namespacePolicyCounts[ns]++
+		NumPoliciesPerNamespace.With(prometheus.Labels{NamespaceLabel: ns}).Set(float64(namespacePolicyCounts[ns]))
+	}
+	policyFootprints[key] = &policyFootprintRecord{ns: ns, name: name, footprint: footprint, lastApplied: time.Now()}
+
+	labels := GetPolicyFootprintLabels(ns, name)
+	PolicyIPTableRules.With(labels).Set(float64(footprint.IPTableRules))
+	PolicyIPSets.With(labels).Set(float64(footprint.IPSets))
+	PolicyIPSetEntries.With(labels).Set(float64(footprint.IPSetEntries))
+}
+
+// DeletePolicyFootprint forgets the footprint of a deleted policy and updates the Prometheus metrics.
+func DeletePolicyFootprint(ns, name string) {
+	policyFootprintsLock.Lock()
+	defer policyFootprintsLock.Unlock()
+
+	key := getPolicyFootprintKey(ns, name)
+	if _, exists := policyFootprints[key]; !exists {
+		return
+	}
+	delete(policyFootprints, key)
+
+	namespacePolicyCounts[ns]--
+	if namespacePolicyCounts[ns] == 0 {
+		delete(namespacePolicyCounts, ns)
+		NumPoliciesPerNamespace.Delete(prometheus.Labels{NamespaceLabel: ns})
+	} else {
+		NumPoliciesPerNamespace.With(prometheus.Labels{NamespaceLabel: ns}).Set(float64(namespacePolicyCounts[ns]))
+	}
+
+	labels := GetPolicyFootprintLabels(ns, name)
+	PolicyIPTableRules.Delete(labels)
+	PolicyIPSets.Delete(labels)
+	PolicyIPSetEntries.Delete(labels)
+}
+
+// IncPolicyApplyFailures counts a policy of namespace ns which failed to be added or updated, once its event is dropped.
+func IncPolicyApplyFailures(ns, reason string) {
+	PolicyApplyFailures.With(prometheus.Labels{NamespaceLabel: ns, ReasonLabel: reason}).Inc()
+}
+
+// GetPolicyFootprintLabels returns the labels of the policy footprint metrics for a policy.
+// The policy label is the name of the policy, like the one of the policy counters.
+func GetPolicyFootprintLabels(ns, name string) prometheus.Labels {
+	return prometheus.Labels{NamespaceLabel: ns, PolicyLabel: name}
+}
+
+func getPolicyFootprintKey(ns, name string) string {
+	return ns + "/" + name
+}
+
+// policyAgeCollector reports the seconds since each policy was last applied when the metrics are scraped.
+type policyAgeCollector struct {
+	desc *prometheus.Desc
+}
+
+func newPolicyAgeCollector(name, helpMessage string) *policyAgeCollector {
+	return &policyAgeCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), helpMessage, []string{NamespaceLabel, PolicyLabel}, nil),
+	}
+}
+
+func (c *policyAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *policyAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	policyFootprintsLock.Lock()
+	defer policyFootprintsLock.Unlock()
+
+	for _, record := range policyFootprints {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(record.lastApplied).Seconds(), record.ns, record.name)
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/http/api"
+	"github.com/Azure/azure-container-networking/npm/metrics/promutil"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPolicyFootprint(t *testing.T) {
+	InitializeAll()
+
+	SetPolicyFootprint("x", "allow-backend", PolicyFootprint{IPTableRules: 6, IPSets: 4, IPSetEntries: 2})
+	SetPolicyFootprint("x", "deny-all", PolicyFootprint{IPTableRules: 1, IPSets: 1})
+	// applying a policy again replaces its footprint.
+	SetPolicyFootprint("x", "allow-backend", PolicyFootprint{IPTableRules: 8, IPSets: 5, IPSetEntries: 3})
+
+	numPolicies, err1 := promutil.GetVecValue(NumPoliciesPerNamespace, prometheus.Labels{NamespaceLabel: "x"})
+	rules, err2 := promutil.GetVecValue(PolicyIPTableRules, GetPolicyFootprintLabels("x", "allow-backend"))
+	sets, err3 := promutil.GetVecValue(PolicyIPSets, GetPolicyFootprintLabels("x", "allow-backend"))
+	entries, err4 := promutil.GetVecValue(PolicyIPSetEntries, GetPolicyFootprintLabels("x", "allow-backend"))
+	promutil.NotifyIfErrors(t, err1, err2, err3, err4)
+	if numPolicies != 2 || rules != 8 || sets != 5 || entries != 3 {
+		t.Errorf("TestPolicyFootprint failed @ SetPolicyFootprint. Policies: %d, rules: %d, sets: %d, entries: %d", numPolicies, rules, sets, entries)
+	}
+
+	IncPolicyApplyFailures("x", IPTablesReason)
+
+	req, err := http.NewRequest(http.MethodGet, api.ClusterMetricsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	GetHandler(false).ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	for _, expected := range []string{
+		fmt.Sprintf(`%s_%s{%s="x",%s="allow-backend"}`, namespace, policyAppliedAgeName, NamespaceLabel, PolicyLabel),
+		fmt.Sprintf(`%s_%s{%s="x",%s="%s"} 1`, namespace, policyApplyFailuresName, NamespaceLabel, ReasonLabel, IPTablesReason),
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("TestPolicyFootprint failed @ GetHandler, expected %s in:\n%s", expected, body)
+		}
+	}
+
+	DeletePolicyFootprint("x", "allow-backend")
+	DeletePolicyFootprint("x", "deny-all")
+	if _, exists := GetPolicyFootprint("x", "deny-all"); exists {
+		t.Errorf("TestPolicyFootprint failed @ DeletePolicyFootprint, expected the footprint to be removed")
+	}
+
+	rr = httptest.NewRecorder()
+	GetHandler(false).ServeHTTP(rr, req)
+	if body := rr.Body.String(); strings.Contains(body, policyIPTableRulesName+"{") || strings.Contains(body, numPoliciesPerNamespaceName+"{") {
+		t.Errorf("TestPolicyFootprint failed @ DeletePolicyFootprint, expected no footprint metrics left:\n%s", body)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const namespace = "npm"
@@ -13,19 +14,37 @@ const namespace = "npm"
 // Prometheus Metrics
 // Gauge metrics have the methods Inc(), Dec(), and Set(float64)
 // Summary metrics has the method Observe(float64)
+// Counter metrics have the methods Inc() and Add(float64)
 // For any Vector metric, you can call With(prometheus.Labels) before the above methods
 //   e.g. SomeGaugeVec.With(prometheus.Labels{label1: val1, label2: val2, ...).Dec()
 var (
-	NumPolicies            prometheus.Gauge
-	AddPolicyExecTime      prometheus.Summary
-	NumIPTableRules        prometheus.Gauge
-	AddIPTableRuleExecTime prometheus.Summary
-	NumIPSets              prometheus.Gauge
-	AddIPSetExecTime       prometheus.Summary
-	NumIPSetEntries        prometheus.Gauge
+	NumPolicies              prometheus.Gauge
+	AddPolicyExecTime        prometheus.Summary
+	NumIPTableRules          prometheus.Gauge
+	AddIPTablesBatchExecTime prometheus.Summary
+	NumIPSets                prometheus.Gauge
+	AddIPSetExecTime         prometheus.Summary
+	NumIPSetEntries          prometheus.Gauge
 
 	// IPSetInventory should not be referenced directly. Use the functions in ipset-inventory.go
 	IPSetInventory *prometheus.GaugeVec
+
+	// PolicyPackets and PolicyBytes are updated by PolicyCounters in policy-counters.go
+	PolicyPackets *prometheus.CounterVec
+	PolicyBytes   *prometheus.CounterVec
+
+	// The policy footprint metrics should not be referenced directly. Use the functions in policy-footprint.go
+	NumPoliciesPerNamespace *prometheus.GaugeVec
+	PolicyIPTableRules      *prometheus.GaugeVec
+	PolicyIPSets            *prometheus.GaugeVec
+	PolicyIPSetEntries      *prometheus.GaugeVec
+	PolicyApplyFailures     *prometheus.CounterVec
+
+	// The event queue metrics are updated by the workqueues of NPM, see workqueue.go
+	WorkqueueDepth        *prometheus.GaugeVec
+	WorkqueueLatency      *prometheus.SummaryVec
+	WorkqueueWorkDuration *prometheus.SummaryVec
+	WorkqueueRetries      *prometheus.CounterVec
 )
 
 // Constants for metric names and descriptions as well as exported labels for Vector metrics
@@ -39,8 +58,8 @@ const (
 	numIPTableRulesName = "num_iptables_rules"
 	numIPTableRulesHelp = "The number of current IPTable rules for this node"
 
-	addIPTableRuleExecTimeName = "add_iptables_rule_exec_time"
-	addIPTableRuleExecTimeHelp = "Execution time in milliseconds for adding an IPTable rule to a chain"
+	addIPTablesBatchExecTimeName = "add_iptables_batch_exec_time"
+	addIPTablesBatchExecTimeHelp = "Execution time in milliseconds for adding a batch of IPTable rules with iptables-restore"
 
 	numIPSetsName = "num_ipsets"
 	numIPSetsHelp = "The number of current IP sets for this node"
@@ -55,6 +74,46 @@ const (
 	ipsetInventoryHelp = "The number of entries in each individual IPSet"
 	SetNameLabel       = "set_name"
 	SetHashLabel       = "set_hash"
+
+	policyPacketsName = "policy_packets"
+	policyPacketsHelp = "The number of packets accepted or dropped by the IPTable rules of each network policy"
+	policyBytesName   = "policy_bytes"
+	policyBytesHelp   = "The number of bytes accepted or dropped by the IPTable rules of each network policy"
+	PolicyLabel       = "policy"
+	DirectionLabel    = "direction"
+	VerdictLabel      = "verdict"
+
+	numPoliciesPerNamespaceName = "num_policies_per_namespace"
+	numPoliciesPerNamespaceHelp = "The number of current network policies of each namespace"
+	policyIPTableRulesName      = "policy_iptables_rules"
+	policyIPTableRulesHelp      = "The number of IPTable rules translated from each network policy"
+	policyIPSetsName            = "policy_ipsets"
+	policyIPSetsHelp            = "The number of IP sets the IPTable rules of each network policy refer to"
+	policyIPSetEntriesName      = "policy_ipset_entries"
+	policyIPSetEntriesHelp      = "The number of entries in the ipBlock IP sets of each network policy"
+	policyAppliedAgeName        = "policy_seconds_since_applied"
+	policyAppliedAgeHelp        = "Time in seconds since each network policy was last added or updated"
+	policyApplyFailuresName     = "policy_apply_failures"
+	policyApplyFailuresHelp     = "The number of network policies which failed to be added or updated"
+	NamespaceLabel              = "namespace"
+	ReasonLabel                 = "reason"
+
+	clusterPodsName            = "cluster_pods"
+	clusterPodsHelp            = "The number of pods in the cluster, only reported by the node elected to publish the cluster metrics"
+	clusterNamespacesName      = "cluster_namespaces"
+	clusterNamespacesHelp      = "The number of namespaces in the cluster, only reported by the node elected to publish the cluster metrics"
+	clusterNetworkPoliciesName = "cluster_network_policies"
+	clusterNetworkPoliciesHelp = "The number of network policies in the cluster, only reported by the node elected to publish the cluster metrics"
+
+	workqueueDepthName        = "workqueue_depth"
+	workqueueDepthHelp        = "The number of events waiting in each event queue"
+	workqueueLatencyName      = "workqueue_latency"
+	workqueueLatencyHelp      = "Time in milliseconds an event waits in its event queue before it is processed"
+	workqueueWorkDurationName = "workqueue_work_duration"
+	workqueueWorkDurationHelp = "Execution time in milliseconds for processing an event of an event queue"
+	workqueueRetriesName      = "workqueue_retries"
+	workqueueRetriesHelp      = "The number of events requeued after failing to be processed"
+	QueueLabel                = "queue"
 )
 
 var nodeLevelRegistry = prometheus.NewRegistry()
@@ -67,11 +126,26 @@ func InitializeAll() {
 		NumPolicies = createGauge(numPoliciesName, numPoliciesHelp, false)
 		AddPolicyExecTime = createSummary(addPolicyExecTimeName, addPolicyExecTimeHelp, true)
 		NumIPTableRules = createGauge(numIPTableRulesName, numIPTableRulesHelp, false)
-		AddIPTableRuleExecTime = createSummary(addIPTableRuleExecTimeName, addIPTableRuleExecTimeHelp, true)
+		AddIPTablesBatchExecTime = createSummary(addIPTablesBatchExecTimeName, addIPTablesBatchExecTimeHelp, true)
 		NumIPSets = createGauge(numIPSetsName, numIPSetsHelp, false)
 		AddIPSetExecTime = createSummary(addIPSetExecTimeName, addIPSetExecTimeHelp, true)
 		NumIPSetEntries = createGauge(numIPSetEntriesName, numIPSetEntriesHelp, false)
 		IPSetInventory = createGaugeVec(ipsetInventoryName, ipsetInventoryHelp, false, SetNameLabel, SetHashLabel)
+		PolicyPackets = createCounterVec(policyPacketsName, policyPacketsHelp, true, NamespaceLabel, PolicyLabel, DirectionLabel, VerdictLabel)
+		PolicyBytes = createCounterVec(policyBytesName, policyBytesHelp, true, NamespaceLabel, PolicyLabel, DirectionLabel, VerdictLabel)
+		NumPoliciesPerNamespace = createGaugeVec(numPoliciesPerNamespaceName, numPoliciesPerNamespaceHelp, false, NamespaceLabel)
+		PolicyIPTableRules = createGaugeVec(policyIPTableRulesName, policyIPTableRulesHelp, false, NamespaceLabel, PolicyLabel)
+		PolicyIPSets = createGaugeVec(policyIPSetsName, policyIPSetsHelp, false, NamespaceLabel, PolicyLabel)
+		PolicyIPSetEntries = createGaugeVec(policyIPSetEntriesName, policyIPSetEntriesHelp, false, NamespaceLabel, PolicyLabel)
+		register(newPolicyAgeCollector(policyAppliedAgeName, policyAppliedAgeHelp), policyAppliedAgeName, false)
+		PolicyApplyFailures = createCounterVec(policyApplyFailuresName, policyApplyFailuresHelp, false, NamespaceLabel, ReasonLabel)
+		register(newClusterStateCollector(), clusterPodsName, false)
+		WorkqueueDepth = createGaugeVec(workqueueDepthName, workqueueDepthHelp, true, QueueLabel)
+		WorkqueueLatency = createSummaryVec(workqueueLatencyName, workqueueLatencyHelp, true, QueueLabel)
+		WorkqueueWorkDuration = createSummaryVec(workqueueWorkDurationName, workqueueWorkDurationHelp, true, QueueLabel)
+		WorkqueueRetries = createCounterVec(workqueueRetriesName, workqueueRetriesHelp, true, QueueLabel)
+		// the queues created from now on report their metrics.
+		workqueue.SetProvider(workqueueMetricsProvider{})
 		log.Logf("Finished initializing all Prometheus metrics")
 		haveInitialized = true
 	}
@@ -135,3 +209,30 @@ func createSummary(name string, helpMessage string, isNodeLevel bool) prometheus
 	register(summary, name, isNodeLevel)
 	return summary
 }
+
+func createSummaryVec(name string, helpMessage string, isNodeLevel bool, labels ...string) *prometheus.SummaryVec {
+	summaryVec := prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Namespace:  namespace,
+			Name:       name,
+			Help:       helpMessage,
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		labels,
+	)
+	register(summaryVec, name, isNodeLevel)
+	return summaryVec
+}
+
+func createCounterVec(name string, helpMessage string, isNodeLevel bool, labels ...string) *prometheus.CounterVec {
+	counterVec := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      helpMessage,
+		},
+		labels,
+	)
+	register(counterVec, name, isNodeLevel)
+	return counterVec
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/Azure/azure-container-networking/npm/http/api"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
 )
 
 func TestPrometheusNodeHandler(t *testing.T) {
@@ -40,3 +41,22 @@ func TestPrometheusClusterHandler(t *testing.T) {
 
 	//assert.Contains(string(rr.Body.Bytes()), fmt.Sprintf("%s_%s", namespace, addPolicyExecTimeName))
 }
+
+func TestWorkqueueMetrics(t *testing.T) {
+	assert := assert.New(t)
+	InitializeAll()
+	queue := workqueue.NewNamed("test-queue")
+	defer queue.ShutDown()
+	queue.Add("test-key")
+
+	handler := GetHandler(true)
+	req, err := http.NewRequest(http.MethodGet, api.NodeMetricsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Contains(string(rr.Body.Bytes()), fmt.Sprintf("%s_%s{%s=\"test-queue\"} 1", namespace, workqueueDepthName, QueueLabel))
+}
@@ -40,6 +40,15 @@ func GetVecValue(gaugeVecMetric *prometheus.GaugeVec, labels prometheus.Labels)
 	return GetValue(gaugeVecMetric.With(labels))
 }
 
+// GetCounterVecValue is used for validation. It returns a Counter Vec metric's value, or 0 if the label doesn't exist for the metric.
+func GetCounterVecValue(counterVecMetric *prometheus.CounterVec, labels prometheus.Labels) (int, error) {
+	dtoMetric, err := getDTOMetric(counterVecMetric.With(labels))
+	if err != nil {
+		return 0, err
+	}
+	return int(dtoMetric.Counter.GetValue()), nil
+}
+
 // GetCountValue is used for validation. It returns the number of times a Summary metric has recorded an observation.
 func GetCountValue(summaryMetric prometheus.Summary) (int, error) {
 	dtoMetric, err := getDTOMetric(summaryMetric)
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// workqueueMetricsProvider reports the metrics of the named event queues of NPM, labeled by queue name.
+// Queues created before InitializeAll, and the metrics NPM doesn't export, are not reported.
+type workqueueMetricsProvider struct{}
+
+// noopWorkqueueMetric is used for the metrics which are not reported.
+type noopWorkqueueMetric struct{}
+
+func (noopWorkqueueMetric) Inc()            {}
+func (noopWorkqueueMetric) Dec()            {}
+func (noopWorkqueueMetric) Set(float64)     {}
+func (noopWorkqueueMetric) Observe(float64) {}
+
+// millisecondsObserver observes the seconds the workqueue measures in milliseconds, like the other NPM summaries.
+type millisecondsObserver struct {
+	prometheus.Observer
+}
+
+func (o millisecondsObserver) Observe(seconds float64) {
+	o.Observer.Observe(seconds * 1000)
+}
+
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	if WorkqueueDepth == nil {
+		return noopWorkqueueMetric{}
+	}
+	return WorkqueueDepth.With(prometheus.Labels{QueueLabel: name})
+}
+
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return noopWorkqueueMetric{}
+}
+
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	if WorkqueueLatency == nil {
+		return noopWorkqueueMetric{}
+	}
+	return millisecondsObserver{WorkqueueLatency.With(prometheus.Labels{QueueLabel: name})}
+}
+
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	if WorkqueueWorkDuration == nil {
+		return noopWorkqueueMetric{}
+	}
+	return millisecondsObserver{WorkqueueWorkDuration.With(prometheus.Labels{QueueLabel: name})}
+}
+
+func (workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopWorkqueueMetric{}
+}
+
+func (workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopWorkqueueMetric{}
+}
+
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	if WorkqueueRetries == nil {
+		return noopWorkqueueMetric{}
+	}
+	return WorkqueueRetries.With(prometheus.Labels{QueueLabel: name})
+}
@@ -6,8 +6,7 @@ import (
 	"reflect"
 
 	"github.com/Azure/azure-container-networking/log"
-	"github.com/Azure/azure-container-networking/npm/ipsm"
-	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/dataplane"
 	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
 
@@ -18,19 +17,20 @@ type Namespace struct {
 	name            string
 	LabelsMap       map[string]string // NameSpace labels
 	SetMap          map[string]string
-	IpsMgr          *ipsm.IpsetManager
-	iptMgr          *iptm.IptablesManager
+	IpsMgr          dataplane.Ipsets `json:"-"`
+	iptMgr          dataplane.Rules
 	resourceVersion uint64 // NameSpace ResourceVersion
 }
 
 // newNS constructs a new namespace object.
 func newNs(name string) (*Namespace, error) {
+	ipsMgr, iptMgr := newDataplane()
 	ns := &Namespace{
 		name:      name,
 		LabelsMap: make(map[string]string),
 		SetMap:    make(map[string]string),
-		IpsMgr:    ipsm.NewIpsetManager(),
-		iptMgr:    iptm.NewIptablesManager(),
+		IpsMgr:    ipsMgr,
+		iptMgr:    iptMgr,
 		// resource version is converted to uint64
 		// so make sure it is initialized to "0"
 		resourceVersion: 0,
@@ -57,14 +57,74 @@ func isInvalidNamespaceUpdate(oldNsObj, newNsObj *corev1.Namespace) (isInvalidUp
 	return
 }
 
-// InitAllNsList syncs all-namespace ipset list.
-func (npMgr *NetworkPolicyManager) InitAllNsList() error {
-	allNs := npMgr.NsMap[util.KubeAllNamespacesFlag]
-	for ns := range npMgr.NsMap {
-		if ns == util.KubeAllNamespacesFlag {
-			continue
+// getNs returns the cached namespace nsName.
+func (npMgr *NetworkPolicyManager) getNs(nsName string) (*Namespace, bool) {
+	npMgr.nsMapLock.RLock()
+	defer npMgr.nsMapLock.RUnlock()
+
+	ns, exists := npMgr.NsMap[nsName]
+	return ns, exists
+}
+
+// getAllNs returns the all-namespace entry, which holds the dataplane managers.
+func (npMgr *NetworkPolicyManager) getAllNs() *Namespace {
+	allNs, _ := npMgr.getNs(util.KubeAllNamespacesFlag)
+	return allNs
+}
+
+// getOrCreateNs returns the cached namespace nsName, it caches a new one first when there is none.
+func (npMgr *NetworkPolicyManager) getOrCreateNs(nsName string) (*Namespace, error) {
+	npMgr.nsMapLock.Lock()
+	defer npMgr.nsMapLock.Unlock()
+
+	if ns, exists := npMgr.NsMap[nsName]; exists {
+		return ns, nil
+	}
+
+	ns, err := newNs(nsName)
+	if err != nil {
+		return nil, err
+	}
+	npMgr.NsMap[nsName] = ns
+
+	return ns, nil
+}
+
+// setNs caches ns as the namespace nsName.
+func (npMgr *NetworkPolicyManager) setNs(nsName string, ns *Namespace) {
+	npMgr.nsMapLock.Lock()
+	defer npMgr.nsMapLock.Unlock()
+
+	npMgr.NsMap[nsName] = ns
+}
+
+// deleteNs removes the namespace nsName from the cache.
+func (npMgr *NetworkPolicyManager) deleteNs(nsName string) {
+	npMgr.nsMapLock.Lock()
+	defer npMgr.nsMapLock.Unlock()
+
+	delete(npMgr.NsMap, nsName)
+}
+
+// getNsNames returns the names of the cached namespaces, without the all-namespace entry.
+func (npMgr *NetworkPolicyManager) getNsNames() []string {
+	npMgr.nsMapLock.RLock()
+	defer npMgr.nsMapLock.RUnlock()
+
+	nsNames := make([]string, 0, len(npMgr.NsMap))
+	for nsName := range npMgr.NsMap {
+		if nsName != util.KubeAllNamespacesFlag {
+			nsNames = append(nsNames, nsName)
 		}
+	}
 
+	return nsNames
+}
+
+// InitAllNsList syncs all-namespace ipset list.
+func (npMgr *NetworkPolicyManager) InitAllNsList() error {
+	allNs := npMgr.getAllNs()
+	for _, ns := range npMgr.getNsNames() {
 		if err := allNs.IpsMgr.AddToList(util.KubeAllNamespacesFlag, ns); err != nil {
 			metrics.SendErrorLogAndMetric(util.NSID, "[InitAllNsList] Error: failed to add namespace set %s to ipset list %s with err: %v", ns, util.KubeAllNamespacesFlag, err)
 			return err
@@ -76,12 +136,8 @@ func (npMgr *NetworkPolicyManager) InitAllNsList() error {
 
 // UninitAllNsList cleans all-namespace ipset list.
 func (npMgr *NetworkPolicyManager) UninitAllNsList() error {
-	allNs := npMgr.NsMap[util.KubeAllNamespacesFlag]
-	for ns := range npMgr.NsMap {
-		if ns == util.KubeAllNamespacesFlag {
-			continue
-		}
-
+	allNs := npMgr.getAllNs()
+	for _, ns := range npMgr.getNsNames() {
 		if err := allNs.IpsMgr.DeleteFromList(util.KubeAllNamespacesFlag, ns); err != nil {
 			metrics.SendErrorLogAndMetric(util.NSID, "[UninitAllNsList] Error: failed to delete namespace set %s from list %s with err: %v", ns, util.KubeAllNamespacesFlag, err)
 			return err
@@ -98,34 +154,34 @@ func (npMgr *NetworkPolicyManager) AddNamespace(nsObj *corev1.Namespace) error {
 	nsName, nsLabel := util.GetNSNameWithPrefix(nsObj.ObjectMeta.Name), nsObj.ObjectMeta.Labels
 	log.Logf("NAMESPACE CREATING: [%s/%v]", nsName, nsLabel)
 
-	ipsMgr := npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
-	// Create ipset for the namespace.
-	if err = ipsMgr.CreateSet(nsName, append([]string{util.IpsetNetHashFlag})); err != nil {
-		metrics.SendErrorLogAndMetric(util.NSID, "[AddNamespace] Error: failed to create ipset for namespace %s with err: %v", nsName, err)
-		return err
+	// a namespace replayed from the informer cache on start is added again by its add event.
+	if ns, exists := npMgr.getNs(nsName); exists && ns.resourceVersion != 0 &&
+		ns.resourceVersion == util.ParseResourceVersion(nsObj.GetObjectMeta().GetResourceVersion()) {
+		return nil
 	}
 
-	if err = ipsMgr.AddToList(util.KubeAllNamespacesFlag, nsName); err != nil {
-		metrics.SendErrorLogAndMetric(util.NSID, "[AddNamespace] Error: failed to add %s to all-namespace ipset list with err: %v", nsName, err)
-		return err
-	}
+	// All the namespace's ipset operations are applied with a single ipset restore.
+	tx := npMgr.getAllNs().IpsMgr.NewTransaction()
+
+	// Create ipset for the namespace.
+	tx.CreateSet(nsName, []string{util.IpsetNetHashFlag})
+	tx.AddToList(util.KubeAllNamespacesFlag, nsName)
 
 	// Add the namespace to its label's ipset list.
 	nsLabels := nsObj.ObjectMeta.Labels
 	for nsLabelKey, nsLabelVal := range nsLabels {
 		labelKey := util.GetNSNameWithPrefix(nsLabelKey)
 		log.Logf("Adding namespace %s to ipset list %s", nsName, labelKey)
-		if err = ipsMgr.AddToList(labelKey, nsName); err != nil {
-			metrics.SendErrorLogAndMetric(util.NSID, "[AddNamespace] Error: failed to add namespace %s to ipset list %s with err: %v", nsName, labelKey, err)
-			return err
-		}
+		tx.AddToList(labelKey, nsName)
 
 		label := util.GetNSNameWithPrefix(nsLabelKey + ":" + nsLabelVal)
 		log.Logf("Adding namespace %s to ipset list %s", nsName, label)
-		if err = ipsMgr.AddToList(label, nsName); err != nil {
-			metrics.SendErrorLogAndMetric(util.NSID, "[AddNamespace] Error: failed to add namespace %s to ipset list %s with err: %v", nsName, label, err)
-			return err
-		}
+		tx.AddToList(label, nsName)
+	}
+
+	if failures := tx.Commit(); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.NSID, "[AddNamespace] Error: failed to add namespace %s to %d ipsets, first err: %v", nsName, len(failures), failures[0])
+		return failures[0]
 	}
 
 	ns, err := newNs(nsName)
@@ -137,7 +193,7 @@ func (npMgr *NetworkPolicyManager) AddNamespace(nsObj *corev1.Namespace) error {
 
 	// Append all labels to the cache NS obj
 	ns.LabelsMap = util.AppendMap(ns.LabelsMap, nsLabel)
-	npMgr.NsMap[nsName] = ns
+	npMgr.setNs(nsName, ns)
 
 	return nil
 }
@@ -172,7 +228,7 @@ func (npMgr *NetworkPolicyManager) UpdateNamespace(oldNsObj *corev1.Namespace, n
 
 	// If orignal AddNamespace failed for some reason, then NS will not be found
 	// in nsMap, resulting in retry of ADD.
-	curNsObj, exists := npMgr.NsMap[newNsNs]
+	curNsObj, exists := npMgr.getNs(newNsNs)
 	if !exists {
 		if newNsObj.ObjectMeta.DeletionTimestamp == nil && newNsObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
 			if err = npMgr.AddNamespace(newNsObj); err != nil {
@@ -205,31 +261,32 @@ func (npMgr *NetworkPolicyManager) UpdateNamespace(oldNsObj *corev1.Namespace, n
 	//If the Namespace is not deleted, delete removed labels and create new labels
 	addToIPSets, deleteFromIPSets := util.GetIPSetListCompareLabels(curNsObj.LabelsMap, newNsLabel)
 
+	// All the namespace's ipset operations are applied with a single ipset restore.
+	tx := npMgr.getAllNs().IpsMgr.NewTransaction()
+
 	// Delete the namespace from its label's ipset list.
-	ipsMgr := npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
 	for _, nsLabelVal := range deleteFromIPSets {
 		labelKey := util.GetNSNameWithPrefix(nsLabelVal)
 		log.Logf("Deleting namespace %s from ipset list %s", oldNsNs, labelKey)
-		if err = ipsMgr.DeleteFromList(labelKey, oldNsNs); err != nil {
-			metrics.SendErrorLogAndMetric(util.NSID, "[UpdateNamespace] Error: failed to delete namespace %s from ipset list %s with err: %v", oldNsNs, labelKey, err)
-			return err
-		}
+		tx.DeleteFromList(labelKey, oldNsNs)
 	}
 
 	// Add the namespace to its label's ipset list.
 	for _, nsLabelVal := range addToIPSets {
 		labelKey := util.GetNSNameWithPrefix(nsLabelVal)
 		log.Logf("Adding namespace %s to ipset list %s", oldNsNs, labelKey)
-		if err = ipsMgr.AddToList(labelKey, oldNsNs); err != nil {
-			metrics.SendErrorLogAndMetric(util.NSID, "[UpdateNamespace] Error: failed to add namespace %s to ipset list %s with err: %v", oldNsNs, labelKey, err)
-			return err
-		}
+		tx.AddToList(labelKey, oldNsNs)
+	}
+
+	if failures := tx.Commit(); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.NSID, "[UpdateNamespace] Error: failed to update %d ipset lists of namespace %s, first err: %v", len(failures), oldNsNs, failures[0])
+		return failures[0]
 	}
 
 	// Append all labels to the cache NS obj
 	curNsObj.LabelsMap = util.ClearAndAppendMap(curNsObj.LabelsMap, newNsLabel)
 	setResourceVersion(curNsObj, newNsObj.GetObjectMeta().GetResourceVersion())
-	npMgr.NsMap[newNsNs] = curNsObj
+	npMgr.setNs(newNsNs, curNsObj)
 
 	return nil
 }
@@ -241,35 +298,34 @@ func (npMgr *NetworkPolicyManager) DeleteNamespace(nsObj *corev1.Namespace) erro
 	nsName, nsLabel := util.GetNSNameWithPrefix(nsObj.ObjectMeta.Name), nsObj.ObjectMeta.Labels
 	log.Logf("NAMESPACE DELETING: [%s/%v]", nsName, nsLabel)
 
-	cachedNsObj, exists := npMgr.NsMap[nsName]
+	cachedNsObj, exists := npMgr.getNs(nsName)
 	if !exists {
 		return nil
 	}
 
 	log.Logf("NAMESPACE DELETING cached labels: [%s/%v]", nsName, cachedNsObj.LabelsMap)
+	// All the namespace's ipset list operations are applied with a single ipset restore.
+	ipsMgr := npMgr.getAllNs().IpsMgr
+	tx := ipsMgr.NewTransaction()
+
 	// Delete the namespace from its label's ipset list.
-	ipsMgr := npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
 	nsLabels := cachedNsObj.LabelsMap
 	for nsLabelKey, nsLabelVal := range nsLabels {
 		labelKey := util.GetNSNameWithPrefix(nsLabelKey)
 		log.Logf("Deleting namespace %s from ipset list %s", nsName, labelKey)
-		if err = ipsMgr.DeleteFromList(labelKey, nsName); err != nil {
-			metrics.SendErrorLogAndMetric(util.NSID, "[DeleteNamespace] Error: failed to delete namespace %s from ipset list %s with err: %v", nsName, labelKey, err)
-			return err
-		}
+		tx.DeleteFromList(labelKey, nsName)
 
 		label := util.GetNSNameWithPrefix(nsLabelKey + ":" + nsLabelVal)
 		log.Logf("Deleting namespace %s from ipset list %s", nsName, label)
-		if err = ipsMgr.DeleteFromList(label, nsName); err != nil {
-			metrics.SendErrorLogAndMetric(util.NSID, "[DeleteNamespace] Error: failed to delete namespace %s from ipset list %s with err: %v", nsName, label, err)
-			return err
-		}
+		tx.DeleteFromList(label, nsName)
 	}
 
 	// Delete the namespace from all-namespace ipset list.
-	if err = ipsMgr.DeleteFromList(util.KubeAllNamespacesFlag, nsName); err != nil {
-		metrics.SendErrorLogAndMetric(util.NSID, "[DeleteNamespace] Error: failed to delete namespace %s from ipset list %s with err: %v", nsName, util.KubeAllNamespacesFlag, err)
-		return err
+	tx.DeleteFromList(util.KubeAllNamespacesFlag, nsName)
+
+	if failures := tx.Commit(); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.NSID, "[DeleteNamespace] Error: failed to delete namespace %s from %d ipset lists, first err: %v", nsName, len(failures), failures[0])
+		return failures[0]
 	}
 
 	// Delete ipset for the namespace.
@@ -278,7 +334,7 @@ func (npMgr *NetworkPolicyManager) DeleteNamespace(nsObj *corev1.Namespace) erro
 		return err
 	}
 
-	delete(npMgr.NsMap, nsName)
+	npMgr.deleteNs(nsName)
 
 	return nil
 }
@@ -0,0 +1,183 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package nflog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"unsafe"
+)
+
+// nfnetlink_log constants from include/uapi/linux/netfilter/nfnetlink_log.h.
+const (
+	nfnlSubsysUlog = 4
+
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind = 1
+	nfulnlCopyPacket = 2
+
+	// headers of the network and transport layers are enough to describe the packet.
+	copyRangeInBytes = 128
+
+	nfgenmsgLen   = 4
+	nlaHdrLen     = 4
+	nlaTypeMask   = 0x3fff
+	nlaAlignBytes = 4
+)
+
+// IP protocol numbers.
+const (
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+	protocolSCTP   = 132
+)
+
+var protocolNames = map[uint8]string{
+	protocolICMP:   "ICMP",
+	protocolTCP:    "TCP",
+	protocolUDP:    "UDP",
+	protocolICMPv6: "ICMPv6",
+	protocolSCTP:   "SCTP",
+}
+
+// Netlink headers and attributes are in host byte order.
+var encoder binary.ByteOrder
+
+func init() {
+	var x uint32 = 0x01020304
+	if *(*byte)(unsafe.Pointer(&x)) == 0x01 {
+		encoder = binary.BigEndian
+	} else {
+		encoder = binary.LittleEndian
+	}
+}
+
+// Event is a packet logged by an NFLOG rule.
+type Event struct {
+	Prefix   string
+	SrcIP    string
+	DstIP    string
+	Protocol string
+	SrcPort  int
+	DstPort  int
+}
+
+// String returns a one line description of the packet, e.g. "TCP 10.0.0.1:34567 -> 10.0.0.2:80".
+func (e *Event) String() string {
+	if e.SrcPort == 0 && e.DstPort == 0 {
+		return fmt.Sprintf("%s %s -> %s", e.Protocol, e.SrcIP, e.DstIP)
+	}
+
+	return fmt.Sprintf("%s %s:%d -> %s:%d", e.Protocol, e.SrcIP, e.SrcPort, e.DstIP, e.DstPort)
+}
+
+// parsePacketMessage parses the body of a NFULNL_MSG_PACKET message, the nfgenmsg header followed by attributes.
+func parsePacketMessage(data []byte) (*Event, error) {
+	if len(data) < nfgenmsgLen {
+		return nil, fmt.Errorf("nflog message of %d bytes is too short", len(data))
+	}
+
+	var (
+		prefix  string
+		payload []byte
+	)
+
+	for b := data[nfgenmsgLen:]; len(b) >= nlaHdrLen; {
+		attrLen := int(encoder.Uint16(b[0:2]))
+		attrType := encoder.Uint16(b[2:4]) & nlaTypeMask
+		if attrLen < nlaHdrLen || attrLen > len(b) {
+			return nil, fmt.Errorf("invalid nflog attribute length %d", attrLen)
+		}
+
+		value := b[nlaHdrLen:attrLen]
+		switch attrType {
+		case nfulaPrefix:
+			prefix = strings.TrimRight(string(value), "\x00")
+		case nfulaPayload:
+			payload = value
+		}
+
+		alignedLen := (attrLen + nlaAlignBytes - 1) &^ (nlaAlignBytes - 1)
+		if alignedLen >= len(b) {
+			break
+		}
+		b = b[alignedLen:]
+	}
+
+	if payload == nil {
+		return nil, fmt.Errorf("nflog message has no payload")
+	}
+
+	event, err := parsePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	event.Prefix = prefix
+
+	return event, nil
+}
+
+// parsePayload reads the addresses, protocol and ports of an IPv4 or IPv6 packet.
+func parsePayload(payload []byte) (*Event, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty packet")
+	}
+
+	var (
+		event     = &Event{}
+		protocol  uint8
+		transport []byte
+	)
+
+	switch payload[0] >> 4 {
+	case 4:
+		if len(payload) < 20 {
+			return nil, fmt.Errorf("IPv4 packet of %d bytes is too short", len(payload))
+		}
+		headerLen := int(payload[0]&0x0f) * 4
+		protocol = payload[9]
+		event.SrcIP = net.IP(payload[12:16]).String()
+		event.DstIP = net.IP(payload[16:20]).String()
+		if headerLen <= len(payload) {
+			transport = payload[headerLen:]
+		}
+	case 6:
+		if len(payload) < 40 {
+			return nil, fmt.Errorf("IPv6 packet of %d bytes is too short", len(payload))
+		}
+		// extension headers are not followed, their packets are logged without ports.
+		protocol = payload[6]
+		event.SrcIP = net.IP(payload[8:24]).String()
+		event.DstIP = net.IP(payload[24:40]).String()
+		transport = payload[40:]
+	default:
+		return nil, fmt.Errorf("unknown IP version %d", payload[0]>>4)
+	}
+
+	event.Protocol = protocolNames[protocol]
+	if event.Protocol == "" {
+		event.Protocol = fmt.Sprintf("%d", protocol)
+	}
+
+	switch protocol {
+	case protocolTCP, protocolUDP, protocolSCTP:
+		if len(transport) >= 4 {
+			event.SrcPort = int(binary.BigEndian.Uint16(transport[0:2]))
+			event.DstPort = int(binary.BigEndian.Uint16(transport[2:4]))
+		}
+	}
+
+	return event, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package nflog
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTimeout is how long Read waits for logged packets before it returns none.
+const readTimeout = time.Second
+
+// Reader receives the packets logged by NFLOG rules of a group.
+type Reader struct {
+	fd    int
+	group uint16
+}
+
+// NewReader subscribes to the NFLOG group.
+func NewReader(group uint16) (*Reader, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nflog socket: %v", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind nflog socket: %v", err)
+	}
+
+	r := &Reader{fd: fd, group: group}
+	if err := r.bindGroup(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	timeout := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to set the timeout of nflog socket: %v", err)
+	}
+
+	return r, nil
+}
+
+// Read blocks until packets are logged and returns them. It returns no packet once readTimeout elapsed without any.
+func (r *Reader) Read() ([]*Event, error) {
+	buffer := make([]byte, unix.Getpagesize()*4)
+	n, _, err := unix.Recvfrom(r.fd, buffer, 0)
+	if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	nlMsgs, err := syscall.ParseNetlinkMessage(buffer[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	for _, nlMsg := range nlMsgs {
+		if nlMsg.Header.Type != nfnlSubsysUlog<<8|nfulnlMsgPacket {
+			continue
+		}
+
+		event, err := parsePacketMessage(nlMsg.Data)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// Close unsubscribes from the NFLOG group.
+func (r *Reader) Close() error {
+	return unix.Close(r.fd)
+}
+
+// bindGroup sends the NFULNL_CFG_CMD_BIND command and asks the kernel to copy the packets, then waits for the ack.
+func (r *Reader) bindGroup() error {
+	cmd := []byte{nfulnlCfgCmdBind}
+
+	// struct nfulnl_msg_config_mode is a big endian copy range followed by the copy mode and padding.
+	mode := make([]byte, 6)
+	mode[0], mode[1], mode[2], mode[3] = 0, 0, 0, copyRangeInBytes
+	mode[4] = nfulnlCopyPacket
+
+	// struct nfgenmsg with AF_UNSPEC, NFNETLINK_V0 and the group as big endian resource id.
+	body := []byte{unix.AF_UNSPEC, 0, byte(r.group >> 8), byte(r.group)}
+	body = append(body, serializeAttr(nfulaCfgCmd, cmd)...)
+	body = append(body, serializeAttr(nfulaCfgMode, mode)...)
+
+	header := make([]byte, unix.NLMSG_HDRLEN)
+	encoder.PutUint32(header[0:4], uint32(unix.NLMSG_HDRLEN+len(body)))
+	encoder.PutUint16(header[4:6], nfnlSubsysUlog<<8|nfulnlMsgConfig)
+	encoder.PutUint16(header[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	encoder.PutUint32(header[8:12], 1)
+
+	if err := unix.Sendto(r.fd, append(header, body...), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to bind nflog group %d: %v", r.group, err)
+	}
+
+	buffer := make([]byte, unix.Getpagesize())
+	n, _, err := unix.Recvfrom(r.fd, buffer, 0)
+	if err != nil {
+		return fmt.Errorf("failed to receive ack of nflog group %d: %v", r.group, err)
+	}
+
+	nlMsgs, err := syscall.ParseNetlinkMessage(buffer[:n])
+	if err != nil {
+		return err
+	}
+
+	for _, nlMsg := range nlMsgs {
+		if nlMsg.Header.Type == unix.NLMSG_ERROR && len(nlMsg.Data) >= 4 {
+			if errCode := int32(encoder.Uint32(nlMsg.Data[0:4])); errCode != 0 {
+				return fmt.Errorf("failed to bind nflog group %d: %v", r.group, syscall.Errno(-errCode))
+			}
+		}
+	}
+
+	return nil
+}
+
+// serializeAttr returns a netlink attribute padded to 4 bytes.
+func serializeAttr(attrType uint16, value []byte) []byte {
+	attrLen := nlaHdrLen + len(value)
+	b := make([]byte, (attrLen+nlaAlignBytes-1)&^(nlaAlignBytes-1))
+	encoder.PutUint16(b[0:2], uint16(attrLen))
+	encoder.PutUint16(b[2:4], attrType)
+	copy(b[nlaHdrLen:], value)
+
+	return b
+}
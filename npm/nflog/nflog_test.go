@@ -0,0 +1,79 @@
+package nflog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestAttr(attrType uint16, value []byte) []byte {
+	attrLen := nlaHdrLen + len(value)
+	b := make([]byte, (attrLen+nlaAlignBytes-1)&^(nlaAlignBytes-1))
+	encoder.PutUint16(b[0:2], uint16(attrLen))
+	encoder.PutUint16(b[2:4], attrType)
+	copy(b[nlaHdrLen:], value)
+	return b
+}
+
+func TestParsePacketMessage(t *testing.T) {
+	// IPv4 TCP packet from 10.0.0.1:34567 to 10.0.0.2:80, only the headers are copied.
+	ipv4 := []byte{
+		0x45, 0, 0, 60, 0, 0, 0x40, 0, 64, protocolTCP, 0, 0,
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+		0x87, 0x07, 0, 80,
+	}
+
+	msg := []byte{2, 0, 0, 100}
+	msg = append(msg, newTestAttr(nfulaPrefix, []byte("AZURE-NPM-INGRESS-DROP: \x00"))...)
+	msg = append(msg, newTestAttr(nfulaPayload, ipv4)...)
+
+	expected := &Event{
+		Prefix:   "AZURE-NPM-INGRESS-DROP: ",
+		SrcIP:    "10.0.0.1",
+		DstIP:    "10.0.0.2",
+		Protocol: "TCP",
+		SrcPort:  34567,
+		DstPort:  80,
+	}
+
+	event, err := parsePacketMessage(msg)
+	if err != nil {
+		t.Fatalf("TestParsePacketMessage failed @ parsePacketMessage with err %v", err)
+	}
+
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("TestParsePacketMessage failed @ parsePacketMessage. Expected: %+v, Actual: %+v", expected, event)
+	}
+
+	if _, err := parsePacketMessage(msg[:4]); err == nil {
+		t.Errorf("TestParsePacketMessage failed @ parsePacketMessage. Expected an error for a message without payload")
+	}
+}
+
+func TestParsePayload(t *testing.T) {
+	ipv6 := make([]byte, 48)
+	ipv6[0] = 0x60
+	ipv6[6] = protocolUDP
+	ipv6[23] = 1
+	ipv6[39] = 2
+	ipv6[40], ipv6[41], ipv6[42], ipv6[43] = 0x30, 0x39, 0, 53
+	ipv6[8], ipv6[9], ipv6[24], ipv6[25] = 0xfd, 0x00, 0xfd, 0x00
+
+	event, err := parsePayload(ipv6)
+	if err != nil {
+		t.Fatalf("TestParsePayload failed @ parsePayload with err %v", err)
+	}
+
+	if event.String() != "UDP fd00::1:12345 -> fd00::2:53" {
+		t.Errorf("TestParsePayload failed @ parsePayload. Actual: %s", event)
+	}
+
+	icmp := []byte{0x45, 0, 0, 28, 0, 0, 0, 0, 64, protocolICMP, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2, 8, 0, 0, 0}
+	if event, err = parsePayload(icmp); err != nil || event.String() != "ICMP 10.0.0.1 -> 10.0.0.2" {
+		t.Errorf("TestParsePayload failed @ parsePayload. Actual: %v, err %v", event, err)
+	}
+
+	if _, err := parsePayload([]byte{0x45, 0}); err == nil {
+		t.Errorf("TestParsePayload failed @ parsePayload. Expected an error for a truncated packet")
+	}
+}
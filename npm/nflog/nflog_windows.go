@@ -0,0 +1,23 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package nflog
+
+import "fmt"
+
+// Reader receives the packets logged by NFLOG rules of a group.
+type Reader struct{}
+
+// NewReader is not supported on Windows, which has no NFLOG.
+func NewReader(group uint16) (*Reader, error) {
+	return nil, fmt.Errorf("nflog is not supported on windows")
+}
+
+// Read is not supported on Windows.
+func (r *Reader) Read() ([]*Event, error) {
+	return nil, fmt.Errorf("nflog is not supported on windows")
+}
+
+// Close is not supported on Windows.
+func (r *Reader) Close() error {
+	return nil
+}
@@ -0,0 +1,457 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package nftm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// offsets of the addresses in the IPv4 header and of the destination port in the TCP, UDP and SCTP headers.
+	ipSaddrOffset = 12
+	ipDaddrOffset = 16
+	thDportOffset = 2
+
+	// nft's default burst of limit statements.
+	limitBurst = 5
+
+	// ICMP port unreachable, which iptables REJECT replies with.
+	icmpPortUnreachable = 3
+
+	// type of rule comments in the rule user data, NFTNL_UDATA_RULE_COMMENT of libnftnl.
+	udataRuleComment = 0
+)
+
+var npmTable = &nftables.Table{Family: nftables.TableFamilyIPv4, Name: util.NftTable}
+
+// runNetlink applies the commands with a single netlink transaction, nftables applies all of it or nothing.
+func runNetlink(cmds []*nftCommand) error {
+	conn, err := nftables.New()
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to open nftables netlink connection: %v", err)
+		return err
+	}
+
+	log.Logf("Executing nftables transaction of %d commands.", len(cmds))
+	for _, cmd := range cmds {
+		if err := addNetlinkCommand(conn, cmd); err != nil {
+			metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to encode nftables command [%s]: %v", cmd, err)
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to apply nftables transaction of %d commands: %v", len(cmds), err)
+		log.Logf("Failed nftables transaction:\n%s", renderScript(cmds))
+		return err
+	}
+
+	return nil
+}
+
+// listNetlinkTable returns the names of the sets, maps and chains of the NPM table, by kind.
+func listNetlinkTable() (map[string][]string, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to open nftables netlink connection: %v", err)
+		return nil, err
+	}
+
+	sets, err := conn.GetSets(npmTable)
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to list the sets of nftables table %s: %v", util.NftTable, err)
+		return nil, err
+	}
+
+	chains, err := conn.ListChainsOfTableFamily(npmTable.Family)
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to list nftables chains: %v", err)
+		return nil, err
+	}
+
+	table := make(map[string][]string)
+	for _, set := range sets {
+		// anonymous sets belong to the rule they are declared in.
+		if set.Anonymous {
+			continue
+		}
+		if set.IsMap {
+			table["map"] = append(table["map"], set.Name)
+		} else {
+			table["set"] = append(table["set"], set.Name)
+		}
+	}
+	for _, chain := range chains {
+		if chain.Table.Name == util.NftTable {
+			table["chain"] = append(table["chain"], chain.Name)
+		}
+	}
+
+	return table, nil
+}
+
+// NpmTableExists reports whether the NPM table is left in nftables.
+func NpmTableExists() (bool, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		return false, fmt.Errorf("failed to open nftables netlink connection: %v", err)
+	}
+
+	tables, err := conn.ListTablesOfFamily(npmTable.Family)
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		// a kernel without nftables has no NPM table.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to list nftables tables: %v", err)
+	}
+
+	for _, table := range tables {
+		if table.Name == util.NftTable {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// addNetlinkCommand adds the netlink messages of a command to the transaction of conn.
+func addNetlinkCommand(conn *nftables.Conn, cmd *nftCommand) error {
+	switch {
+	case cmd.kind == "table" && cmd.verb == "add":
+		conn.AddTable(npmTable)
+	case cmd.kind == "table" && cmd.verb == "delete":
+		conn.DelTable(npmTable)
+	case cmd.kind == "chain" && cmd.verb == "add":
+		conn.AddChain(getNetlinkChain(cmd.name))
+	case cmd.kind == "chain" && cmd.verb == "flush":
+		conn.FlushChain(getNetlinkChain(cmd.name))
+	case cmd.kind == "chain" && cmd.verb == "delete":
+		conn.DelChain(getNetlinkChain(cmd.name))
+	case (cmd.kind == "set" || cmd.kind == "map") && cmd.verb == "add":
+		return conn.AddSet(getNetlinkSet(cmd.kind, cmd.name, cmd.obj.setKind), nil)
+	case (cmd.kind == "set" || cmd.kind == "map") && cmd.verb == "flush":
+		conn.FlushSet(&nftables.Set{Table: npmTable, Name: cmd.name})
+	case (cmd.kind == "set" || cmd.kind == "map") && cmd.verb == "delete":
+		conn.DelSet(&nftables.Set{Table: npmTable, Name: cmd.name})
+	case cmd.kind == "element" && cmd.verb == "add":
+		return conn.SetAddElements(&nftables.Set{Table: npmTable, Name: cmd.name}, getNetlinkElements(cmd.obj))
+	case cmd.kind == "rule" && cmd.verb == "add":
+		exprs, err := getRuleExprs(conn, cmd.rule)
+		if err != nil {
+			return err
+		}
+		conn.AddRule(&nftables.Rule{
+			Table:    npmTable,
+			Chain:    getNetlinkChain(cmd.name),
+			Exprs:    exprs,
+			UserData: getRuleUserData(cmd.rule.comment),
+		})
+	default:
+		return fmt.Errorf("unsupported nftables command %s", cmd)
+	}
+
+	return nil
+}
+
+// getNetlinkChain returns a chain of the NPM table, the forward chain is a base chain.
+func getNetlinkChain(name string) *nftables.Chain {
+	chain := &nftables.Chain{Table: npmTable, Name: name}
+	if name == util.NftForwardChain {
+		policy := nftables.ChainPolicyAccept
+		chain.Type = nftables.ChainTypeFilter
+		chain.Hooknum = nftables.ChainHookForward
+		chain.Priority = nftables.ChainPriorityRef(nftables.ChainPriority(util.NftForwardChainPriority))
+		chain.Policy = &policy
+	}
+
+	return chain
+}
+
+// getNetlinkSet returns the declaration of a set of the kind of setKind, or of a verdict map.
+func getNetlinkSet(kind, name, setKind string) *nftables.Set {
+	set := &nftables.Set{Table: npmTable, Name: name}
+	switch {
+	case kind == "map":
+		set.IsMap = true
+		set.Interval = true
+		set.KeyType = nftables.TypeIPAddr
+		set.DataType = nftables.TypeVerdict
+	case setKind == util.IpsetIPPortHashFlag:
+		// the concatenation flag is left unset, kernels before 5.6 reject it and only interval sets need it.
+		set.KeyType = nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeInetProto, nftables.TypeInetService)
+	default:
+		set.Interval = true
+		set.KeyType = nftables.TypeIPAddr
+	}
+
+	return set
+}
+
+// getNetlinkElements returns the elements of a set or a verdict map. A range of an interval set
+// is an element holding its first address and an interval end element holding the address after its last.
+func getNetlinkElements(obj *nftObject) []nftables.SetElement {
+	var elements []nftables.SetElement
+	addRange := func(r ipRange, verdict *expr.Verdict) {
+		elements = append(elements, nftables.SetElement{Key: []byte(uint32ToIP(r.first)), VerdictData: verdict})
+		if r.last != math.MaxUint32 {
+			elements = append(elements, nftables.SetElement{Key: []byte(uint32ToIP(r.last + 1)), IntervalEnd: true})
+		}
+	}
+
+	for _, r := range obj.ranges {
+		addRange(r, nil)
+	}
+	for _, jump := range obj.jumps {
+		addRange(jump.addrs, &expr.Verdict{Kind: expr.VerdictJump, Chain: jump.target})
+	}
+	for _, tuple := range obj.tuples {
+		// each field of a concatenation takes a whole 4 bytes register.
+		key := append([]byte(uint32ToIP(tuple.ip)), protocolNumbers[tuple.protocol], 0, 0, 0)
+		key = append(key, binaryutil.BigEndian.PutUint16(tuple.port)...)
+		elements = append(elements, nftables.SetElement{Key: append(key, 0, 0)})
+	}
+
+	return elements
+}
+
+// getRuleExprs returns the expressions of a rule. Port lists are anonymous sets, which are added to conn.
+func getRuleExprs(conn *nftables.Conn, rule *nftRule) ([]expr.Any, error) {
+	if rule.vmap != "" {
+		exprs := getLookupKeyExprs(rule.vmapDirection)
+		return append(exprs, &expr.Lookup{
+			SourceRegister: unix.NFT_REG_1,
+			DestRegister:   unix.NFT_REG_VERDICT,
+			IsDestRegSet:   true,
+			SetName:        rule.vmap,
+		}), nil
+	}
+
+	var exprs []expr.Any
+	for _, match := range rule.matches {
+		matchExprs, err := getMatchExprs(conn, match)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, matchExprs...)
+	}
+
+	if rule.limit != nil {
+		exprs = append(exprs, &expr.Limit{
+			Type:  expr.LimitTypePkts,
+			Rate:  rule.limit.rate,
+			Unit:  expr.LimitTime(rule.limit.unit),
+			Burst: limitBurst,
+		})
+	}
+
+	if rule.target != nil {
+		exprs = append(exprs, getTargetExprs(rule.target)...)
+	}
+
+	return exprs, nil
+}
+
+func getMatchExprs(conn *nftables.Conn, match *nftMatch) ([]expr.Any, error) {
+	op := expr.CmpOpEq
+	if match.negate {
+		op = expr.CmpOpNeq
+	}
+
+	switch match.kind {
+	case matchL4Proto:
+		return []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: unix.NFT_REG_1},
+			&expr.Cmp{Op: op, Register: unix.NFT_REG_1, Data: []byte{protocolNumbers[match.protocol]}},
+		}, nil
+	case matchDport:
+		exprs := []expr.Any{getPayloadExpr(expr.PayloadBaseTransportHeader, thDportOffset, 2, unix.NFT_REG_1)}
+		if len(match.ports) > 1 {
+			set := &nftables.Set{
+				Table:     npmTable,
+				Anonymous: true,
+				Constant:  true,
+				Interval:  true,
+				KeyType:   nftables.TypeInetService,
+			}
+			if err := conn.AddSet(set, getPortElements(match.ports)); err != nil {
+				return nil, err
+			}
+			return append(exprs, &expr.Lookup{SourceRegister: unix.NFT_REG_1, SetName: set.Name, SetID: set.ID, Invert: match.negate}), nil
+		}
+		ports := match.ports[0]
+		if ports.first == ports.last {
+			return append(exprs, &expr.Cmp{Op: op, Register: unix.NFT_REG_1, Data: binaryutil.BigEndian.PutUint16(ports.first)}), nil
+		}
+		return append(exprs, &expr.Range{
+			Op:       op,
+			Register: unix.NFT_REG_1,
+			FromData: binaryutil.BigEndian.PutUint16(ports.first),
+			ToData:   binaryutil.BigEndian.PutUint16(ports.last),
+		}), nil
+	case matchSet:
+		exprs := getLookupKeyExprs(match.direction)
+		return append(exprs, &expr.Lookup{SourceRegister: unix.NFT_REG_1, SetName: match.value, Invert: match.negate}), nil
+	case matchMark:
+		exprs := []expr.Any{&expr.Meta{Key: expr.MetaKeyMARK, Register: unix.NFT_REG_1}}
+		if match.maskValue != "" {
+			exprs = append(exprs, getMaskExpr(match.markMask, 0))
+		}
+		return append(exprs, &expr.Cmp{Op: op, Register: unix.NFT_REG_1, Data: binaryutil.NativeEndian.PutUint32(match.mark)}), nil
+	case matchState:
+		// a packet matches when its state is one of the bits.
+		op = expr.CmpOpNeq
+		if match.negate {
+			op = expr.CmpOpEq
+		}
+		return []expr.Any{
+			&expr.Ct{Key: expr.CtKeySTATE, Register: unix.NFT_REG_1},
+			getMaskExpr(match.states, 0),
+			&expr.Cmp{Op: op, Register: unix.NFT_REG_1, Data: binaryutil.NativeEndian.PutUint32(0)},
+		}, nil
+	case matchSaddr, matchDaddr:
+		offset := uint32(ipSaddrOffset)
+		if match.kind == matchDaddr {
+			offset = ipDaddrOffset
+		}
+		exprs := []expr.Any{getPayloadExpr(expr.PayloadBaseNetworkHeader, offset, 4, unix.NFT_REG_1)}
+		if ones, _ := match.addr.Mask.Size(); ones < 32 {
+			exprs = append(exprs, &expr.Bitwise{
+				SourceRegister: unix.NFT_REG_1,
+				DestRegister:   unix.NFT_REG_1,
+				Len:            4,
+				Mask:           []byte(match.addr.Mask),
+				Xor:            make([]byte, 4),
+			})
+		}
+		return append(exprs, &expr.Cmp{Op: op, Register: unix.NFT_REG_1, Data: []byte(match.addr.IP.To4())}), nil
+	}
+
+	return nil, fmt.Errorf("unsupported nftables match %s", match)
+}
+
+func getTargetExprs(target *nftTarget) []expr.Any {
+	switch target.name {
+	case util.IptablesAccept:
+		return []expr.Any{&expr.Verdict{Kind: expr.VerdictAccept}}
+	case util.IptablesDrop:
+		return []expr.Any{&expr.Verdict{Kind: expr.VerdictDrop}}
+	case util.IptablesReturn:
+		return []expr.Any{&expr.Verdict{Kind: expr.VerdictReturn}}
+	case util.IptablesReject:
+		return []expr.Any{&expr.Reject{Type: unix.NFT_REJECT_ICMP_UNREACH, Code: icmpPortUnreachable}}
+	case util.IptablesMark:
+		if target.maskValue == "" {
+			return []expr.Any{
+				&expr.Immediate{Register: unix.NFT_REG_1, Data: binaryutil.NativeEndian.PutUint32(target.mark)},
+				&expr.Meta{Key: expr.MetaKeyMARK, SourceRegister: true, Register: unix.NFT_REG_1},
+			}
+		}
+		return []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyMARK, Register: unix.NFT_REG_1},
+			getMaskExpr(^target.markMask, target.mark),
+			&expr.Meta{Key: expr.MetaKeyMARK, SourceRegister: true, Register: unix.NFT_REG_1},
+		}
+	case util.IptablesNflog, util.IptablesLog:
+		logExpr := &expr.Log{}
+		if target.logPrefix != nil {
+			logExpr.Key |= 1 << unix.NFTA_LOG_PREFIX
+			logExpr.Data = []byte(*target.logPrefix)
+		}
+		if target.logGroup != nil {
+			logExpr.Key |= 1 << unix.NFTA_LOG_GROUP
+			logExpr.Group = *target.logGroup
+		}
+		return []expr.Any{logExpr}
+	}
+
+	return []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: target.name}}
+}
+
+// getLookupKeyExprs loads the packet fields an ipset match with the direction flags looks up into the registers.
+func getLookupKeyExprs(direction string) []expr.Any {
+	switch direction {
+	case util.IptablesSrcFlag:
+		return []expr.Any{getPayloadExpr(expr.PayloadBaseNetworkHeader, ipSaddrOffset, 4, unix.NFT_REG_1)}
+	case util.IptablesDstFlag + "," + util.IptablesDstFlag:
+		// the fields of a concatenation are loaded in consecutive 4 bytes registers.
+		return []expr.Any{
+			getPayloadExpr(expr.PayloadBaseNetworkHeader, ipDaddrOffset, 4, unix.NFT_REG_1),
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: unix.NFT_REG32_01},
+			getPayloadExpr(expr.PayloadBaseTransportHeader, thDportOffset, 2, unix.NFT_REG32_02),
+		}
+	}
+
+	return []expr.Any{getPayloadExpr(expr.PayloadBaseNetworkHeader, ipDaddrOffset, 4, unix.NFT_REG_1)}
+}
+
+func getPayloadExpr(base expr.PayloadBase, offset, length, register uint32) *expr.Payload {
+	return &expr.Payload{DestRegister: register, Base: base, Offset: offset, Len: length}
+}
+
+// getMaskExpr returns the expression setting the 4 bytes of the first register to (reg & mask) ^ xor.
+func getMaskExpr(mask, xor uint32) *expr.Bitwise {
+	return &expr.Bitwise{
+		SourceRegister: unix.NFT_REG_1,
+		DestRegister:   unix.NFT_REG_1,
+		Len:            4,
+		Mask:           binaryutil.NativeEndian.PutUint32(mask),
+		Xor:            binaryutil.NativeEndian.PutUint32(xor),
+	}
+}
+
+// getPortElements returns the elements of an interval set of ports, the overlapping ranges are merged.
+func getPortElements(ports []portRange) []nftables.SetElement {
+	sorted := append([]portRange(nil), ports...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].first < sorted[j].first
+	})
+
+	var merged []portRange
+	for _, p := range sorted {
+		if n := len(merged); n > 0 && uint32(p.first) <= uint32(merged[n-1].last)+1 {
+			if p.last > merged[n-1].last {
+				merged[n-1].last = p.last
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+
+	var elements []nftables.SetElement
+	for _, p := range merged {
+		elements = append(elements, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(p.first)})
+		if p.last != math.MaxUint16 {
+			elements = append(elements, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(p.last + 1), IntervalEnd: true})
+		}
+	}
+
+	return elements
+}
+
+// getRuleUserData returns the user data holding the comment of a rule, which nft lists as its comment.
+func getRuleUserData(comment string) []byte {
+	if comment == "" {
+		return nil
+	}
+
+	if len(comment) > util.NftMaxCommentLength {
+		comment = comment[:util.NftMaxCommentLength]
+	}
+
+	return append(append([]byte{udataRuleComment, byte(len(comment) + 1)}, comment...), 0)
+}
@@ -0,0 +1,122 @@
+// +build linux
+
+package nftm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetNetlinkElements(t *testing.T) {
+	ranges := ipRanges{{0x0a000000, 0x0a0000ff}, {0xffffffff, 0xffffffff}}
+	expected := []nftables.SetElement{
+		{Key: []byte{10, 0, 0, 0}},
+		{Key: []byte{10, 0, 1, 0}, IntervalEnd: true},
+		// the last address has no interval end.
+		{Key: []byte{255, 255, 255, 255}},
+	}
+	if actual := getNetlinkElements(newSetObject("test-set", util.IpsetNetHashFlag, ranges, nil)); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetNetlinkElements failed @ getNetlinkElements of a nethash set. Expected: %v, Actual: %v", expected, actual)
+	}
+
+	jumps := []*verdictMapElement{{addrs: ipRange{0x0a000001, 0x0a000001}, target: util.IptablesAzureIngressFromChain}}
+	expected = []nftables.SetElement{
+		{Key: []byte{10, 0, 0, 1}, VerdictData: &expr.Verdict{Kind: expr.VerdictJump, Chain: util.IptablesAzureIngressFromChain}},
+		{Key: []byte{10, 0, 0, 2}, IntervalEnd: true},
+	}
+	if actual := getNetlinkElements(newVerdictMapObject("test-map", jumps)); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetNetlinkElements failed @ getNetlinkElements of a verdict map. Expected: %v, Actual: %v", expected, actual)
+	}
+
+	tuple, err := getIPPortElement("10.0.0.1,udp:53")
+	if err != nil {
+		t.Fatalf("TestGetNetlinkElements failed @ getIPPortElement: %v", err)
+	}
+	expected = []nftables.SetElement{{Key: []byte{10, 0, 0, 1, 17, 0, 0, 0, 0, 53, 0, 0}}}
+	if actual := getNetlinkElements(newSetObject("test-set", util.IpsetIPPortHashFlag, nil, []*ipPortElement{tuple})); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetNetlinkElements failed @ getNetlinkElements of an ip,port set. Expected: %v, Actual: %v", expected, actual)
+	}
+}
+
+func TestGetRuleExprs(t *testing.T) {
+	conn, err := nftables.New()
+	if err != nil {
+		t.Fatalf("TestGetRuleExprs failed @ nftables.New: %v", err)
+	}
+
+	rule, err := parseEntry(&iptm.IptEntry{
+		Specs: []string{
+			util.IptablesProtFlag,
+			"UDP",
+			util.IptablesDstPortFlag,
+			"53",
+			util.IptablesModuleFlag,
+			util.IptablesSetModuleFlag,
+			util.IptablesNotFlag,
+			util.IptablesMatchSetFlag,
+			"azure-npm-1",
+			util.IptablesSrcFlag,
+			util.IptablesJumpFlag,
+			util.IptablesAccept,
+		},
+	})
+	if err != nil {
+		t.Fatalf("TestGetRuleExprs failed @ parseEntry: %v", err)
+	}
+
+	expected := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: unix.NFT_REG_1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: unix.NFT_REG_1, Data: []byte{17}},
+		&expr.Payload{DestRegister: unix.NFT_REG_1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: unix.NFT_REG_1, Data: []byte{0, 53}},
+		&expr.Payload{DestRegister: unix.NFT_REG_1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+		&expr.Lookup{SourceRegister: unix.NFT_REG_1, SetName: "azure-npm-1", Invert: true},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+	actual, err := getRuleExprs(conn, rule)
+	if err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetRuleExprs failed @ getRuleExprs. Expected: %v, Actual: %v, %v", expected, actual, err)
+	}
+
+	vmapRule := &nftRule{vmap: "test-map", vmapDirection: util.IptablesDstFlag}
+	expected = []expr.Any{
+		&expr.Payload{DestRegister: unix.NFT_REG_1, Base: expr.PayloadBaseNetworkHeader, Offset: 16, Len: 4},
+		&expr.Lookup{SourceRegister: unix.NFT_REG_1, DestRegister: unix.NFT_REG_VERDICT, IsDestRegSet: true, SetName: "test-map"},
+	}
+	actual, err = getRuleExprs(conn, vmapRule)
+	if err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetRuleExprs failed @ getRuleExprs of a verdict map lookup. Expected: %v, Actual: %v, %v", expected, actual, err)
+	}
+}
+
+func TestGetPortElements(t *testing.T) {
+	ports := []portRange{{443, 443}, {80, 90}, {85, 100}, {65000, 65535}}
+	expected := []nftables.SetElement{
+		{Key: []byte{0, 80}},
+		{Key: []byte{0, 101}, IntervalEnd: true},
+		{Key: []byte{1, 187}},
+		{Key: []byte{1, 188}, IntervalEnd: true},
+		{Key: []byte{253, 232}},
+	}
+	if actual := getPortElements(ports); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetPortElements failed @ getPortElements. Expected: %v, Actual: %v", expected, actual)
+	}
+}
+
+func TestGetRuleUserData(t *testing.T) {
+	expected := []byte{0, 4, 'a', 'b', 'c', 0}
+	if actual := getRuleUserData("abc"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestGetRuleUserData failed @ getRuleUserData. Expected: %v, Actual: %v", expected, actual)
+	}
+
+	long := getRuleUserData(string(make([]byte, 200)))
+	if len(long) != util.NftMaxCommentLength+3 || int(long[1]) != util.NftMaxCommentLength+1 {
+		t.Errorf("TestGetRuleUserData failed @ getRuleUserData, expected the comment to be cut to %d bytes. Actual: %d", util.NftMaxCommentLength, len(long)-3)
+	}
+}
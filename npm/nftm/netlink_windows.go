@@ -0,0 +1,20 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package nftm
+
+import "fmt"
+
+// runNetlink is not supported on Windows, which has no nftables.
+func runNetlink(cmds []*nftCommand) error {
+	return fmt.Errorf("nftables is not supported on windows")
+}
+
+// listNetlinkTable is not supported on Windows.
+func listNetlinkTable() (map[string][]string, error) {
+	return nil, fmt.Errorf("nftables is not supported on windows")
+}
+
+// NpmTableExists is not supported on Windows.
+func NpmTableExists() (bool, error) {
+	return false, fmt.Errorf("nftables is not supported on windows")
+}
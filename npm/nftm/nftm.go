@@ -0,0 +1,815 @@
+// Package nftm programs NPM network policies with nftables.
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package nftm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// nftSet is an ipset of NPM kept as an nftables named set.
+type nftSet struct {
+	name     string // unhashed NPM name
+	kind     string // util.IpsetNetHashFlag, util.IpsetIPPortHashFlag or util.IpsetSetListFlag
+	elements map[string]*nftElement
+}
+
+type nftElement struct {
+	podUID  string
+	nomatch bool
+}
+
+// NftablesManager programs the sets and rules of NPM in a single nftables table.
+// It keeps the desired state in memory and applies every change as one netlink transaction
+// holding only the sets, maps and chains which changed since the last successful one.
+// The iptables rules NPM translates network policies to are converted to nftables rules,
+// and the jumps to the per-policy chains are looked up in verdict maps instead of evaluated one by one.
+type NftablesManager struct {
+	sets              map[string]*nftSet          // key is the hashed name the rules refer to
+	chains            map[string][]*iptm.IptEntry // rules of the chains, in order
+	chainsInitialized bool
+	applied           *nftState
+	runCommands       func(cmds []*nftCommand) error
+	listTable         func() (map[string][]string, error)
+}
+
+// NewNftablesManager creates a new instance for NftablesManager object.
+func NewNftablesManager() *NftablesManager {
+	return &NftablesManager{
+		sets:        make(map[string]*nftSet),
+		chains:      make(map[string][]*iptm.IptEntry),
+		applied:     newNftState(),
+		runCommands: runNetlink,
+		listTable:   listNetlinkTable,
+	}
+}
+
+// Exists checks if an element exists in a set or a list.
+func (nftMgr *NftablesManager) Exists(key, val, kind string) bool {
+	set, exists := nftMgr.sets[util.GetHashedName(key)]
+	if !exists || (set.kind == util.IpsetSetListFlag) != (kind == util.IpsetSetListFlag) {
+		return false
+	}
+
+	_, exists = set.elements[val]
+	return exists
+}
+
+// GetSet returns the set or list with the NPM name or the hashed name.
+func (nftMgr *NftablesManager) GetSet(name string) (*dataplane.SetInfo, bool) {
+	set, exists := nftMgr.sets[name]
+	if !exists {
+		if set, exists = nftMgr.sets[util.GetHashedName(name)]; !exists {
+			return nil, false
+		}
+	}
+
+	return getSetInfo(set), true
+}
+
+// GetSets returns every set and list.
+func (nftMgr *NftablesManager) GetSets() []*dataplane.SetInfo {
+	var sets []*dataplane.SetInfo
+	for _, set := range nftMgr.sets {
+		sets = append(sets, getSetInfo(set))
+	}
+
+	return sets
+}
+
+func getSetInfo(set *nftSet) *dataplane.SetInfo {
+	info := &dataplane.SetInfo{
+		Name:    set.name,
+		IsList:  set.kind == util.IpsetSetListFlag,
+		Members: make(map[string]string),
+	}
+	for element, e := range set.elements {
+		info.Members[element] = e.podUID
+	}
+
+	return info
+}
+
+// CreateList creates a list, which nftables holds as the union of the addresses of its sets.
+func (nftMgr *NftablesManager) CreateList(listName string) error {
+	nftMgr.createSet(listName, util.IpsetSetListFlag)
+	return nftMgr.apply()
+}
+
+// AddToList adds a set to a list and creates the list if needed.
+func (nftMgr *NftablesManager) AddToList(listName, setName string) error {
+	if !nftMgr.addToList(listName, setName) {
+		return nil
+	}
+
+	return nftMgr.apply()
+}
+
+// DeleteFromList removes a set from a list and deletes the list once it is empty.
+func (nftMgr *NftablesManager) DeleteFromList(listName, setName string) error {
+	if !nftMgr.deleteFromList(listName, setName) {
+		return nil
+	}
+
+	return nftMgr.apply()
+}
+
+// CreateSet creates a set of the kind in spec, nethash by default.
+func (nftMgr *NftablesManager) CreateSet(setName string, spec []string) error {
+	if err := nftMgr.createSetOfSpec(setName, spec); err != nil {
+		return err
+	}
+
+	return nftMgr.apply()
+}
+
+// DeleteSet deletes a set. The nftables set stays until no rule refers to it anymore.
+func (nftMgr *NftablesManager) DeleteSet(setName string) error {
+	if _, exists := nftMgr.sets[util.GetHashedName(setName)]; !exists {
+		return nil
+	}
+
+	delete(nftMgr.sets, util.GetHashedName(setName))
+	metrics.NumIPSets.Dec()
+
+	return nftMgr.apply()
+}
+
+// AddToSet adds an ip, a cidr or an ip,port to a set and creates the set if needed.
+func (nftMgr *NftablesManager) AddToSet(setName, ip, spec, podUID string) error {
+	if err := nftMgr.addToSet(setName, ip, spec, podUID); err != nil {
+		return err
+	}
+
+	return nftMgr.apply()
+}
+
+// DeleteFromSet removes an ip from a set unless it belongs to another pod, and deletes the set once it is empty.
+func (nftMgr *NftablesManager) DeleteFromSet(setName, ip, podUID string) error {
+	nftMgr.deleteFromSet(setName, ip, podUID)
+	return nftMgr.apply()
+}
+
+// DestroyNpmIpsets deletes the NPM table, with all its sets and chains.
+func (nftMgr *NftablesManager) DestroyNpmIpsets() error {
+	// nftables has no "delete if exists", creating the table first makes deleting it always succeed.
+	cmds := []*nftCommand{newNftCommand("add", "table", ""), newNftCommand("delete", "table", "")}
+	if err := nftMgr.runCommands(cmds); err != nil {
+		return err
+	}
+
+	nftMgr.sets = make(map[string]*nftSet)
+	nftMgr.chains = make(map[string][]*iptm.IptEntry)
+	nftMgr.chainsInitialized = false
+	nftMgr.applied = newNftState()
+
+	return nil
+}
+
+// NewTransaction returns a transaction which applies all its updates with a single netlink transaction.
+func (nftMgr *NftablesManager) NewTransaction() dataplane.IpsetTransaction {
+	return &nftTransaction{nftMgr: nftMgr}
+}
+
+type nftTransaction struct {
+	nftMgr     *NftablesManager
+	operations []func() error
+}
+
+func (tx *nftTransaction) CreateSet(setName string, spec []string) {
+	tx.operations = append(tx.operations, func() error {
+		return tx.nftMgr.createSetOfSpec(setName, spec)
+	})
+}
+
+func (tx *nftTransaction) CreateList(listName string) {
+	tx.operations = append(tx.operations, func() error {
+		tx.nftMgr.createSet(listName, util.IpsetSetListFlag)
+		return nil
+	})
+}
+
+func (tx *nftTransaction) AddToList(listName, setName string) {
+	tx.operations = append(tx.operations, func() error {
+		tx.nftMgr.addToList(listName, setName)
+		return nil
+	})
+}
+
+func (tx *nftTransaction) DeleteFromList(listName, setName string) {
+	tx.operations = append(tx.operations, func() error {
+		tx.nftMgr.deleteFromList(listName, setName)
+		return nil
+	})
+}
+
+func (tx *nftTransaction) AddToSet(setName, ip, spec, podUID string) {
+	tx.operations = append(tx.operations, func() error {
+		return tx.nftMgr.addToSet(setName, ip, spec, podUID)
+	})
+}
+
+func (tx *nftTransaction) DeleteFromSet(setName, ip, podUID string) {
+	tx.operations = append(tx.operations, func() error {
+		tx.nftMgr.deleteFromSet(setName, ip, podUID)
+		return nil
+	})
+}
+
+// Commit updates the sets and applies them. Updates with invalid elements are skipped and returned as errors.
+func (tx *nftTransaction) Commit() []error {
+	var errs []error
+	for _, op := range tx.operations {
+		if err := op(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	tx.operations = nil
+
+	if err := tx.nftMgr.apply(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func (nftMgr *NftablesManager) createSet(setName, kind string) *nftSet {
+	hashedName := util.GetHashedName(setName)
+	if set, exists := nftMgr.sets[hashedName]; exists {
+		return set
+	}
+
+	set := &nftSet{
+		name:     setName,
+		kind:     kind,
+		elements: make(map[string]*nftElement),
+	}
+	nftMgr.sets[hashedName] = set
+
+	if kind != util.IpsetSetListFlag {
+		metrics.NumIPSets.Inc()
+	}
+
+	return set
+}
+
+// createSetOfSpec creates a set of the kind in spec, nethash by default.
+func (nftMgr *NftablesManager) createSetOfSpec(setName string, spec []string) error {
+	kind := util.IpsetNetHashFlag
+	if len(spec) > 0 {
+		kind = spec[0]
+	}
+
+	if kind != util.IpsetNetHashFlag && kind != util.IpsetIPPortHashFlag {
+		return fmt.Errorf("unsupported set type %s of set %s", kind, setName)
+	}
+
+	nftMgr.createSet(setName, kind)
+	return nil
+}
+
+// addToList adds a set to a list and creates the list if needed. It returns false if the list already had the set.
+func (nftMgr *NftablesManager) addToList(listName, setName string) bool {
+	if listName == setName || nftMgr.Exists(listName, setName, util.IpsetSetListFlag) {
+		return false
+	}
+
+	nftMgr.createSet(listName, util.IpsetSetListFlag).elements[setName] = &nftElement{}
+	return true
+}
+
+// deleteFromList removes a set from a list and deletes the list once it is empty. It returns false if there is no such list.
+func (nftMgr *NftablesManager) deleteFromList(listName, setName string) bool {
+	list, exists := nftMgr.sets[util.GetHashedName(listName)]
+	if !exists {
+		return false
+	}
+
+	delete(list.elements, setName)
+	if len(list.elements) == 0 {
+		delete(nftMgr.sets, util.GetHashedName(listName))
+	}
+
+	return true
+}
+
+func (nftMgr *NftablesManager) addToSet(setName, ip, spec, podUID string) error {
+	element := &nftElement{podUID: podUID}
+	if strings.HasSuffix(ip, util.IpsetNomatch) {
+		ip = strings.TrimSpace(strings.TrimSuffix(ip, util.IpsetNomatch))
+		element.nomatch = true
+	}
+
+	// reject invalid elements before they reach the model, they would fail every following transaction.
+	var err error
+	if spec == util.IpsetIPPortHashFlag {
+		_, err = getIPPortElement(ip)
+	} else {
+		_, err = parseIPRange(ip)
+	}
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to add %s to set %s: %v", ip, setName, err)
+		return err
+	}
+
+	set := nftMgr.createSet(setName, spec)
+	if cached, exists := set.elements[ip]; exists && cached.podUID != podUID {
+		log.Logf("AddToSet: PodOwner has changed for Ip: %s, setName:%s, Old podUid: %s, new PodUid: %s. Replace context with new PodOwner.",
+			ip, setName, cached.podUID, podUID)
+	}
+	set.elements[ip] = element
+
+	return nil
+}
+
+func (nftMgr *NftablesManager) deleteFromSet(setName, ip, podUID string) {
+	set, exists := nftMgr.sets[util.GetHashedName(setName)]
+	if !exists {
+		return
+	}
+
+	if cached, exists := set.elements[ip]; exists && cached.podUID != podUID {
+		// the ip belongs to a new pod, the delete is stale.
+		return
+	}
+
+	delete(set.elements, ip)
+	if len(set.elements) == 0 {
+		delete(nftMgr.sets, util.GetHashedName(setName))
+		metrics.NumIPSets.Dec()
+	}
+}
+
+// InitNpmChains creates the NPM chains with their default rules, and the forward base chain jumping to AZURE-NPM.
+func (nftMgr *NftablesManager) InitNpmChains() error {
+	log.Logf("Initializing AZURE-NPM chains in nftables.")
+
+	nftMgr.chains = make(map[string][]*iptm.IptEntry)
+	for _, chain := range iptm.IptablesAzureChainList {
+		nftMgr.chains[chain] = nil
+	}
+	nftMgr.chains[util.NftForwardChain] = []*iptm.IptEntry{getForwardEntry()}
+	for _, entry := range iptm.GetDefaultChainEntries() {
+		nftMgr.chains[entry.Chain] = append(nftMgr.chains[entry.Chain], entry)
+	}
+	nftMgr.chainsInitialized = true
+
+	return nftMgr.apply()
+}
+
+// UninitNpmChains deletes the NPM chains and verdict maps, including the ones of a previous run.
+func (nftMgr *NftablesManager) UninitNpmChains() error {
+	chains := append([]string{util.NftForwardChain}, iptm.IptablesAzureChainList...)
+
+	// nftables has no "delete if exists", creating the objects first makes deleting them always succeed.
+	cmds := []*nftCommand{newNftCommand("add", "table", "")}
+	for _, chain := range chains {
+		cmds = append(cmds, &nftCommand{verb: "add", kind: "chain", name: chain, obj: newChainObject(chain, nil)})
+	}
+	for _, chain := range chains {
+		cmds = append(cmds, newNftCommand("flush", "chain", chain))
+	}
+	for _, chain := range chains {
+		cmds = append(cmds, newNftCommand("delete", "chain", chain))
+	}
+	for _, chain := range iptm.IptablesAzureChainList {
+		for _, direction := range []string{util.IptablesDstFlag, util.IptablesSrcFlag} {
+			mapName := getVerdictMapName(chain, direction)
+			cmds = append(cmds, &nftCommand{verb: "add", kind: "map", name: mapName, obj: newVerdictMapObject(mapName, nil)}, newNftCommand("delete", "map", mapName))
+		}
+	}
+
+	if err := nftMgr.runCommands(cmds); err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to delete AZURE-NPM chains: %v", err)
+		return err
+	}
+
+	nftMgr.chains = make(map[string][]*iptm.IptEntry)
+	nftMgr.chainsInitialized = false
+	nftMgr.applied.chains = make(map[string]*nftObject)
+	nftMgr.applied.maps = make(map[string]*nftObject)
+
+	return nil
+}
+
+// CheckAndAddForwardChain makes sure the forward base chain exists and jumps to AZURE-NPM.
+func (nftMgr *NftablesManager) CheckAndAddForwardChain() error {
+	forwardRule, _ := parseEntry(getForwardEntry())
+	cmds := []*nftCommand{
+		newNftCommand("add", "table", ""),
+		{verb: "add", kind: "chain", name: util.NftForwardChain, obj: newChainObject(util.NftForwardChain, nil)},
+		{verb: "add", kind: "chain", name: util.IptablesAzureChain, obj: newChainObject(util.IptablesAzureChain, nil)},
+		newNftCommand("flush", "chain", util.NftForwardChain),
+		{verb: "add", kind: "rule", name: util.NftForwardChain, rule: forwardRule},
+	}
+
+	return nftMgr.runCommands(cmds)
+}
+
+// AddBatch appends jump entries to their chain and inserts all other entries at the top, like iptables, and applies them at once.
+func (nftMgr *NftablesManager) AddBatch(entries []*iptm.IptEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for _, entry := range entries {
+		if _, err := parseEntry(entry); err != nil {
+			metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to translate iptables entry to nftables: %v", err)
+			return err
+		}
+	}
+
+	timer := metrics.StartNewTimer()
+	for _, entry := range entries {
+		if entry.IsJumpEntry {
+			nftMgr.chains[entry.Chain] = append(nftMgr.chains[entry.Chain], entry)
+			continue
+		}
+		nftMgr.chains[entry.Chain] = append([]*iptm.IptEntry{entry}, nftMgr.chains[entry.Chain]...)
+	}
+
+	if err := nftMgr.apply(); err != nil {
+		return err
+	}
+
+	metrics.NumIPTableRules.Add(float64(len(entries)))
+	timer.StopAndRecord(metrics.AddIPTablesBatchExecTime)
+
+	return nil
+}
+
+// DeleteBatch removes the first rule of the chain with the specs of each entry and applies them at once.
+func (nftMgr *NftablesManager) DeleteBatch(entries []*iptm.IptEntry) error {
+	deleted := 0
+	for _, entry := range entries {
+		rules := nftMgr.chains[entry.Chain]
+		for i, rule := range rules {
+			if equalSpecs(rule.Specs, entry.Specs) {
+				nftMgr.chains[entry.Chain] = append(rules[:i:i], rules[i+1:]...)
+				deleted++
+				break
+			}
+		}
+	}
+
+	if deleted == 0 {
+		return nil
+	}
+
+	if err := nftMgr.apply(); err != nil {
+		return err
+	}
+
+	metrics.NumIPTableRules.Sub(float64(deleted))
+
+	return nil
+}
+
+// ReconcileSets replaces the sets and lists NftablesManager knows about with the ones of expected and rewrites them
+// with a single netlink transaction, packets are matched against either the previous sets or the expected ones.
+// Sets of a previous run which expected doesn't have stay until RemoveStaleSets.
+func (nftMgr *NftablesManager) ReconcileSets(expected *dataplane.State) error {
+	nftMgr.sets = make(map[string]*nftSet)
+	metrics.NumIPSets.Set(0)
+
+	for setName, elements := range expected.Sets {
+		kind := expected.SetTypes[setName]
+		nftMgr.createSet(setName, kind)
+		for ip, podUID := range elements {
+			nftMgr.addToSet(setName, ip, kind, podUID)
+		}
+	}
+	for listName, setNames := range expected.Lists {
+		list := nftMgr.createSet(listName, util.IpsetSetListFlag)
+		for setName := range setNames {
+			list.elements[setName] = &nftElement{}
+		}
+	}
+
+	// the kernel may hold anything, every set is rewritten.
+	nftMgr.applied.sets = make(map[string]*nftObject)
+
+	return nftMgr.apply()
+}
+
+// RemoveStaleSets deletes the sets, verdict maps and chains of the NPM table NftablesManager didn't apply,
+// like the ones of a previous run.
+func (nftMgr *NftablesManager) RemoveStaleSets(expected *dataplane.State) error {
+	if err := nftMgr.runCommands([]*nftCommand{newNftCommand("add", "table", "")}); err != nil {
+		return err
+	}
+
+	table, err := nftMgr.listTable()
+	if err != nil {
+		return err
+	}
+
+	// chains are emptied first and deleted before the maps and sets their rules refer to.
+	stale := map[string][]string{}
+	applied := map[string]map[string]*nftObject{
+		"set":   nftMgr.applied.sets,
+		"map":   nftMgr.applied.maps,
+		"chain": nftMgr.applied.chains,
+	}
+	for kind, names := range table {
+		for _, name := range names {
+			if _, exists := applied[kind][name]; !exists {
+				stale[kind] = append(stale[kind], name)
+			}
+		}
+	}
+
+	var cmds []*nftCommand
+	for _, chain := range stale["chain"] {
+		cmds = append(cmds, newNftCommand("flush", "chain", chain))
+	}
+	for _, kind := range []string{"chain", "map", "set"} {
+		for _, name := range stale[kind] {
+			log.Logf("Deleting stale nftables %s %s.", kind, name)
+			cmds = append(cmds, newNftCommand("delete", kind, name))
+		}
+	}
+
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	if err := nftMgr.runCommands(cmds); err != nil {
+		metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to delete stale nftables objects: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ReconcileRules replaces the chains NftablesManager knows about with the ones of expected and rewrites them
+// with a single netlink transaction, so enforcement never stops while the chains are repaired.
+func (nftMgr *NftablesManager) ReconcileRules(expected *dataplane.State) error {
+	if !expected.ChainsInitialized {
+		return nftMgr.UninitNpmChains()
+	}
+
+	nftMgr.chains = make(map[string][]*iptm.IptEntry)
+	for _, chain := range iptm.IptablesAzureChainList {
+		nftMgr.chains[chain] = nil
+	}
+	nftMgr.chains[util.NftForwardChain] = []*iptm.IptEntry{getForwardEntry()}
+
+	numRules := 0
+	for chain, rules := range expected.Chains {
+		for _, rule := range rules {
+			if _, err := parseEntry(rule); err != nil {
+				metrics.SendErrorLogAndMetric(util.NftmID, "Error: failed to translate iptables entry to nftables: %v", err)
+				continue
+			}
+			nftMgr.chains[chain] = append(nftMgr.chains[chain], rule)
+			numRules++
+		}
+	}
+	nftMgr.chainsInitialized = true
+
+	// the kernel may hold anything, every chain and verdict map is rewritten.
+	nftMgr.applied.chains = make(map[string]*nftObject)
+	nftMgr.applied.maps = make(map[string]*nftObject)
+
+	if err := nftMgr.apply(); err != nil {
+		return err
+	}
+	metrics.NumIPTableRules.Set(float64(numRules))
+
+	return nil
+}
+
+// apply renders the desired state and sends the differences with the applied one to the kernel.
+// On failure the kernel keeps the previous state, the next apply retries the differences.
+func (nftMgr *NftablesManager) apply() error {
+	desired := nftMgr.render()
+	cmds := getUpdateCommands(nftMgr.applied, desired)
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	if err := nftMgr.runCommands(cmds); err != nil {
+		return err
+	}
+
+	nftMgr.applied = desired
+
+	return nil
+}
+
+// render returns the nftables objects of the current sets and chains.
+func (nftMgr *NftablesManager) render() *nftState {
+	state := newNftState()
+
+	if nftMgr.chainsInitialized {
+		for chain, rules := range nftMgr.chains {
+			state.chains[chain] = newChainObject(chain, nftMgr.renderChain(chain, rules, state))
+		}
+	}
+
+	for hashedName, set := range nftMgr.sets {
+		if set.kind == util.IpsetIPPortHashFlag {
+			state.sets[hashedName] = newSetObject(hashedName, set.kind, nil, nftMgr.getIPPortElements(hashedName))
+		} else {
+			state.sets[hashedName] = newSetObject(hashedName, set.kind, nftMgr.getAddressRanges(hashedName), nil)
+		}
+	}
+
+	// sets deleted by NPM stay until no rule refers to them, like ipset refuses to destroy sets in use.
+	for _, rules := range nftMgr.chains {
+		if !nftMgr.chainsInitialized {
+			break
+		}
+		for _, rule := range rules {
+			for hashedName, kind := range getReferencedSets(rule) {
+				if _, exists := state.sets[hashedName]; !exists {
+					state.sets[hashedName] = newSetObject(hashedName, kind, nil, nil)
+				}
+			}
+		}
+	}
+
+	return state
+}
+
+// renderChain translates the rules of a chain. Jumps to the policy chains are replaced by verdict map lookups.
+func (nftMgr *NftablesManager) renderChain(chain string, rules []*iptm.IptEntry, state *nftState) []*nftRule {
+	var (
+		nftRules []*nftRule
+		jumps    = make(map[string][]*verdictMapJump)
+	)
+
+	for _, rule := range rules {
+		if jump, ok := getVerdictMapJump(rule); ok {
+			mapName := getVerdictMapName(chain, jump.direction)
+			if _, exists := jumps[mapName]; !exists {
+				nftRules = append(nftRules, &nftRule{vmap: mapName, vmapDirection: jump.direction})
+			}
+			jumps[mapName] = append(jumps[mapName], jump)
+			continue
+		}
+
+		// entries are validated by AddBatch.
+		if parsed, err := parseEntry(rule); err == nil {
+			nftRules = append(nftRules, parsed)
+		}
+	}
+
+	for mapName, mapJumps := range jumps {
+		state.maps[mapName] = newVerdictMapObject(mapName, nftMgr.getVerdictMapElements(mapJumps))
+	}
+
+	return nftRules
+}
+
+// getIPPortElements returns the nftables elements of an ip,port set, sorted.
+func (nftMgr *NftablesManager) getIPPortElements(hashedName string) []*ipPortElement {
+	var elements []*ipPortElement
+	for ip := range nftMgr.sets[hashedName].elements {
+		if element, err := getIPPortElement(ip); err == nil {
+			elements = append(elements, element)
+		}
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].String() < elements[j].String()
+	})
+
+	return elements
+}
+
+// getAddressRanges returns the addresses of a set or a list. As in ipset, the most specific
+// cidr wins, so a nomatch /32 excludes the address from a wider cidr of the same set.
+func (nftMgr *NftablesManager) getAddressRanges(hashedName string) ipRanges {
+	set, exists := nftMgr.sets[hashedName]
+	if !exists {
+		return nil
+	}
+
+	if set.kind == util.IpsetSetListFlag {
+		var ranges ipRanges
+		for member := range set.elements {
+			ranges = ranges.union(nftMgr.getAddressRanges(util.GetHashedName(member)))
+		}
+		return ranges
+	}
+
+	if set.kind != util.IpsetNetHashFlag {
+		return nil
+	}
+
+	type cidr struct {
+		ipRange
+		nomatch bool
+	}
+
+	var cidrs []cidr
+	for ip, element := range set.elements {
+		if r, err := parseIPRange(ip); err == nil {
+			cidrs = append(cidrs, cidr{r, element.nomatch})
+		}
+	}
+	sort.Slice(cidrs, func(i, j int) bool {
+		return cidrs[i].last-cidrs[i].first > cidrs[j].last-cidrs[j].first
+	})
+
+	var ranges ipRanges
+	for _, c := range cidrs {
+		if c.nomatch {
+			ranges = ranges.subtract(ipRanges{c.ipRange})
+		} else {
+			ranges = ranges.union(ipRanges{c.ipRange})
+		}
+	}
+
+	return ranges
+}
+
+// ipPortElement is an element of an ip,port set, "10.0.0.1 . udp . 53" in nft syntax.
+type ipPortElement struct {
+	ip       uint32
+	protocol string
+	port     uint16
+}
+
+func (element *ipPortElement) String() string {
+	return fmt.Sprintf("%s . %s . %d", uint32ToIP(element.ip), element.protocol, element.port)
+}
+
+// getIPPortElement parses a hash:ip,port entry like "10.0.0.1,udp:53".
+// ipset defaults to tcp when the entry has no protocol.
+func getIPPortElement(entry string) (*ipPortElement, error) {
+	fields := strings.SplitN(entry, ",", 2)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid ip,port entry %s", entry)
+	}
+
+	r, err := parseIPRange(fields[0])
+	if err != nil || r.first != r.last {
+		return nil, fmt.Errorf("invalid ip of ip,port entry %s", entry)
+	}
+
+	protocol, port := "tcp", fields[1]
+	if protoPort := strings.SplitN(fields[1], ":", 2); len(protoPort) == 2 {
+		protocol, port = strings.ToLower(protoPort[0]), protoPort[1]
+	}
+
+	if _, exists := protocolNumbers[protocol]; !exists {
+		return nil, fmt.Errorf("invalid protocol of ip,port entry %s", entry)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port of ip,port entry %s", entry)
+	}
+
+	return &ipPortElement{ip: r.first, protocol: protocol, port: uint16(p)}, nil
+}
+
+// getReferencedSets returns the sets matched by an entry with their kind.
+func getReferencedSets(entry *iptm.IptEntry) map[string]string {
+	sets := make(map[string]string)
+	for i := 0; i+2 < len(entry.Specs); i++ {
+		if entry.Specs[i] != util.IptablesMatchSetFlag {
+			continue
+		}
+
+		kind := util.IpsetNetHashFlag
+		if strings.Contains(entry.Specs[i+2], ",") {
+			kind = util.IpsetIPPortHashFlag
+		}
+		sets[entry.Specs[i+1]] = kind
+	}
+
+	return sets
+}
+
+func getForwardEntry() *iptm.IptEntry {
+	return &iptm.IptEntry{
+		Chain: util.NftForwardChain,
+		Specs: []string{util.IptablesJumpFlag, util.IptablesAzureChain},
+	}
+}
+
+func equalSpecs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
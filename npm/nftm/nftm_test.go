@@ -0,0 +1,270 @@
+package nftm
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// newTestNftablesManager returns an NftablesManager recording its transactions as nft scripts instead of applying them.
+func newTestNftablesManager(scripts *[]string) *NftablesManager {
+	nftMgr := NewNftablesManager()
+	nftMgr.runCommands = func(cmds []*nftCommand) error {
+		*scripts = append(*scripts, renderScript(cmds))
+		return nil
+	}
+
+	return nftMgr
+}
+
+func TestAddToSetWithNomatch(t *testing.T) {
+	var scripts []string
+	nftMgr := newTestNftablesManager(&scripts)
+
+	tx := nftMgr.NewTransaction()
+	tx.AddToSet("test-set", "10.0.0.0/24", util.IpsetNetHashFlag, "")
+	tx.AddToSet("test-set", "10.0.0.5 "+util.IpsetNomatch, util.IpsetNetHashFlag, "")
+	tx.AddToSet("test-set", "10.0.0.256", util.IpsetNetHashFlag, "")
+	if errs := tx.Commit(); len(errs) != 1 {
+		t.Errorf("TestAddToSetWithNomatch failed @ tx.Commit, expected one error for the invalid ip. Actual: %v", errs)
+	}
+
+	if len(scripts) != 1 {
+		t.Fatalf("TestAddToSetWithNomatch failed @ tx.Commit, expected one nftables transaction. Actual: %d", len(scripts))
+	}
+
+	hashedName := util.GetHashedName("test-set")
+	expected := fmt.Sprintf("add element ip azure-npm %s { 10.0.0.0-10.0.0.4, 10.0.0.6-10.0.0.255 }", hashedName)
+	if !strings.Contains(scripts[0], expected) {
+		t.Errorf("TestAddToSetWithNomatch failed @ tx.Commit. Expected: %s, Actual:\n%s", expected, scripts[0])
+	}
+
+	if !nftMgr.Exists("test-set", "10.0.0.5", util.IpsetNetHashFlag) {
+		t.Errorf("TestAddToSetWithNomatch failed @ nftMgr.Exists")
+	}
+}
+
+func TestApplyOnlyChanges(t *testing.T) {
+	var scripts []string
+	nftMgr := newTestNftablesManager(&scripts)
+
+	if err := nftMgr.AddToSet("test-set", "10.0.0.1", util.IpsetNetHashFlag, "pod-1"); err != nil {
+		t.Errorf("TestApplyOnlyChanges failed @ nftMgr.AddToSet: %v", err)
+	}
+
+	if err := nftMgr.AddToList("test-list", "test-set"); err != nil {
+		t.Errorf("TestApplyOnlyChanges failed @ nftMgr.AddToList: %v", err)
+	}
+
+	// the list holds the addresses of its sets, it changes while the set is unchanged.
+	expected := fmt.Sprintf("add table ip azure-npm\n"+
+		"add set ip azure-npm %[1]s { type ipv4_addr ; flags interval ; }\n"+
+		"flush set ip azure-npm %[1]s\n"+
+		"add element ip azure-npm %[1]s { 10.0.0.1 }\n", util.GetHashedName("test-list"))
+	if len(scripts) != 2 || scripts[1] != expected {
+		t.Errorf("TestApplyOnlyChanges failed @ nftMgr.AddToList. Expected:\n%s\nActual:\n%v", expected, scripts)
+	}
+
+	if err := nftMgr.AddToList("test-list", "test-set"); err != nil || len(scripts) != 2 {
+		t.Errorf("TestApplyOnlyChanges failed @ nftMgr.AddToList, expected no nftables transaction for an existing member")
+	}
+
+	// a stale delete of an ip now owned by another pod is ignored.
+	if err := nftMgr.DeleteFromSet("test-set", "10.0.0.1", "pod-2"); err != nil || len(scripts) != 2 {
+		t.Errorf("TestApplyOnlyChanges failed @ nftMgr.DeleteFromSet, expected no nftables transaction for a stale delete")
+	}
+
+	if err := nftMgr.DeleteFromList("test-list", "test-set"); err != nil {
+		t.Errorf("TestApplyOnlyChanges failed @ nftMgr.DeleteFromList: %v", err)
+	}
+
+	expected = fmt.Sprintf("add table ip azure-npm\ndelete set ip azure-npm %s\n", util.GetHashedName("test-list"))
+	if len(scripts) != 3 || scripts[2] != expected {
+		t.Errorf("TestApplyOnlyChanges failed @ nftMgr.DeleteFromList. Expected:\n%s\nActual:\n%v", expected, scripts)
+	}
+}
+
+func TestTransactionListOperations(t *testing.T) {
+	var scripts []string
+	nftMgr := newTestNftablesManager(&scripts)
+
+	tx := nftMgr.NewTransaction()
+	tx.CreateSet("ns-test", []string{util.IpsetNetHashFlag})
+	tx.AddToList(util.KubeAllNamespacesFlag, "ns-test")
+	tx.AddToList("ns-app:frontend", "ns-test")
+	tx.CreateSet("test-unsupported", []string{util.IpsetSetListFlag})
+	if errs := tx.Commit(); len(errs) != 1 {
+		t.Errorf("TestTransactionListOperations failed @ tx.Commit, expected one error for the unsupported set type. Actual: %v", errs)
+	}
+
+	if len(scripts) != 1 {
+		t.Fatalf("TestTransactionListOperations failed @ tx.Commit, expected one nftables transaction. Actual: %d", len(scripts))
+	}
+
+	if !nftMgr.Exists(util.KubeAllNamespacesFlag, "ns-test", util.IpsetSetListFlag) || !nftMgr.Exists("ns-app:frontend", "ns-test", util.IpsetSetListFlag) {
+		t.Errorf("TestTransactionListOperations failed @ nftMgr.Exists, expected ns-test in both lists")
+	}
+
+	tx = nftMgr.NewTransaction()
+	tx.DeleteFromList(util.KubeAllNamespacesFlag, "ns-test")
+	tx.DeleteFromList("ns-app:frontend", "ns-test")
+	if errs := tx.Commit(); len(errs) != 0 || len(scripts) != 2 {
+		t.Errorf("TestTransactionListOperations failed @ tx.Commit, expected one more nftables transaction. Actual errors: %v, transactions: %d", errs, len(scripts))
+	}
+
+	if _, exists := nftMgr.GetSet("ns-app:frontend"); exists {
+		t.Errorf("TestTransactionListOperations failed @ tx.DeleteFromList, expected the emptied list to be deleted")
+	}
+}
+
+func TestApplyRetriesAfterFailure(t *testing.T) {
+	var scripts []string
+	nftMgr := newTestNftablesManager(&scripts)
+	nftMgr.runCommands = func(cmds []*nftCommand) error {
+		return fmt.Errorf("nftables transaction failed")
+	}
+
+	if err := nftMgr.AddToSet("test-set", "10.0.0.1", util.IpsetNetHashFlag, ""); err == nil {
+		t.Errorf("TestApplyRetriesAfterFailure failed @ nftMgr.AddToSet, expected the nftables error")
+	}
+
+	nftMgr.runCommands = func(cmds []*nftCommand) error {
+		scripts = append(scripts, renderScript(cmds))
+		return nil
+	}
+
+	if err := nftMgr.AddToSet("test-set", "10.0.0.2", util.IpsetNetHashFlag, ""); err != nil {
+		t.Errorf("TestApplyRetriesAfterFailure failed @ nftMgr.AddToSet: %v", err)
+	}
+
+	expected := fmt.Sprintf("add element ip azure-npm %s { 10.0.0.1-10.0.0.2 }", util.GetHashedName("test-set"))
+	if len(scripts) != 1 || !strings.Contains(scripts[0], expected) {
+		t.Errorf("TestApplyRetriesAfterFailure failed @ nftMgr.AddToSet. Expected: %s, Actual:\n%v", expected, scripts)
+	}
+}
+
+func TestVerdictMap(t *testing.T) {
+	var scripts []string
+	nftMgr := newTestNftablesManager(&scripts)
+
+	if err := nftMgr.InitNpmChains(); err != nil {
+		t.Errorf("TestVerdictMap failed @ nftMgr.InitNpmChains: %v", err)
+	}
+
+	nftMgr.AddToSet("ns-test", "10.0.0.1", util.IpsetNetHashFlag, "")
+	nftMgr.AddToSet("ns-test", "10.0.0.2", util.IpsetNetHashFlag, "")
+	nftMgr.AddToSet("app:test", "10.0.0.2", util.IpsetNetHashFlag, "")
+
+	jump := func(target string, setNames ...string) *iptm.IptEntry {
+		entry := &iptm.IptEntry{Chain: util.IptablesAzureIngressPortChain, IsJumpEntry: true}
+		for _, setName := range setNames {
+			entry.Specs = append(entry.Specs,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName(setName),
+				util.IptablesDstFlag,
+			)
+		}
+		entry.Specs = append(entry.Specs, util.IptablesJumpFlag, target)
+		return entry
+	}
+
+	entries := []*iptm.IptEntry{
+		jump(util.IptablesAzureIngressDropsChain, "ns-test"),
+		jump(util.IptablesAzureIngressFromChain, "ns-test", "app:test"),
+	}
+	if err := nftMgr.AddBatch(entries); err != nil {
+		t.Errorf("TestVerdictMap failed @ nftMgr.AddBatch: %v", err)
+	}
+
+	mapName := getVerdictMapName(util.IptablesAzureIngressPortChain, util.IptablesDstFlag)
+	expectedMap := []string{
+		"10.0.0.2 : jump AZURE-NPM-INGRESS-FROM",
+		"10.0.0.1 : jump AZURE-NPM-INGRESS-DROPS",
+	}
+	if actual := nftMgr.applied.maps[mapName]; actual == nil || !reflect.DeepEqual(actual.contents, expectedMap) {
+		t.Errorf("TestVerdictMap failed @ nftMgr.AddBatch. Expected map: %v, Actual: %+v", expectedMap, actual)
+	}
+
+	expectedChain := []string{
+		"meta mark 0x2000 return comment \"RETURN-on-INGRESS-mark-0x2000\"",
+		"ip daddr vmap @" + mapName,
+	}
+	if actual := nftMgr.applied.chains[util.IptablesAzureIngressPortChain].contents; !reflect.DeepEqual(actual, expectedChain) {
+		t.Errorf("TestVerdictMap failed @ nftMgr.AddBatch. Expected chain: %v, Actual: %v", expectedChain, actual)
+	}
+
+	// sets stay while a rule refers to them.
+	nftMgr.DeleteFromSet("app:test", "10.0.0.2", "")
+	if _, exists := nftMgr.applied.sets[util.GetHashedName("app:test")]; !exists {
+		t.Errorf("TestVerdictMap failed @ nftMgr.DeleteFromSet, expected the referenced set to be kept")
+	}
+
+	if err := nftMgr.DeleteBatch(entries); err != nil {
+		t.Errorf("TestVerdictMap failed @ nftMgr.DeleteBatch: %v", err)
+	}
+
+	if _, exists := nftMgr.applied.maps[mapName]; exists {
+		t.Errorf("TestVerdictMap failed @ nftMgr.DeleteBatch, expected the verdict map to be deleted")
+	}
+
+	if _, exists := nftMgr.applied.sets[util.GetHashedName("app:test")]; exists {
+		t.Errorf("TestVerdictMap failed @ nftMgr.DeleteBatch, expected the unreferenced set to be deleted")
+	}
+}
+
+func TestMain(m *testing.M) {
+	metrics.InitializeAll()
+
+	os.Exit(m.Run())
+}
+
+func TestReconcileAndRemoveStaleSets(t *testing.T) {
+	var scripts []string
+	nftMgr := newTestNftablesManager(&scripts)
+
+	expected := dataplane.NewState()
+	expected.AddToSet("test-set", "10.0.0.1", util.IpsetNetHashFlag, "pod-1")
+	expected.InitNpmChains()
+
+	if err := nftMgr.ReconcileSets(expected); err != nil {
+		t.Errorf("TestReconcileAndRemoveStaleSets failed @ nftMgr.ReconcileSets: %v", err)
+	}
+
+	if err := nftMgr.ReconcileRules(expected); err != nil {
+		t.Errorf("TestReconcileAndRemoveStaleSets failed @ nftMgr.ReconcileRules: %v", err)
+	}
+
+	// chains of a previous run may hold rules, they are flushed before they are filled.
+	if len(scripts) != 2 || !strings.Contains(scripts[1], "flush chain ip azure-npm "+util.IptablesAzureChain+"\n") {
+		t.Fatalf("TestReconcileAndRemoveStaleSets failed @ nftMgr.ReconcileRules, expected AZURE-NPM to be flushed. Actual: %v", scripts)
+	}
+
+	hashedName := util.GetHashedName("test-set")
+	nftMgr.listTable = func() (map[string][]string, error) {
+		return map[string][]string{
+			"set":   {hashedName, "azure-npm-stale"},
+			"chain": {"AZURE-NPM-OLD", util.IptablesAzureChain},
+		}, nil
+	}
+
+	scripts = nil
+	if err := nftMgr.RemoveStaleSets(expected); err != nil {
+		t.Errorf("TestReconcileAndRemoveStaleSets failed @ nftMgr.RemoveStaleSets: %v", err)
+	}
+
+	expectedScript := "flush chain ip azure-npm AZURE-NPM-OLD\n" +
+		"delete chain ip azure-npm AZURE-NPM-OLD\n" +
+		"delete set ip azure-npm azure-npm-stale\n"
+	if len(scripts) != 2 || scripts[1] != expectedScript {
+		t.Errorf("TestReconcileAndRemoveStaleSets failed @ nftMgr.RemoveStaleSets. Expected:\n%s\nActual:\n%v", expectedScript, scripts)
+	}
+}
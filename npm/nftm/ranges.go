@@ -0,0 +1,141 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package nftm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ipRange is an inclusive range of IPv4 addresses.
+type ipRange struct {
+	first uint32
+	last  uint32
+}
+
+// ipRanges is a sorted list of disjoint, non adjacent ranges, the form nftables interval sets hold.
+type ipRanges []ipRange
+
+// parseIPRange parses an ip or a cidr.
+func parseIPRange(s string) (ipRange, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return ipRange{}, fmt.Errorf("invalid IPv4 address %s", s)
+		}
+		addr := binary.BigEndian.Uint32(ip)
+		return ipRange{addr, addr}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil || ipNet.IP.To4() == nil {
+		return ipRange{}, fmt.Errorf("invalid IPv4 cidr %s", s)
+	}
+
+	first := binary.BigEndian.Uint32(ipNet.IP.To4())
+	return ipRange{first, first | ^binary.BigEndian.Uint32(ipNet.Mask)}, nil
+}
+
+func (r ipRange) String() string {
+	if r.first == r.last {
+		return uint32ToIP(r.first).String()
+	}
+
+	return uint32ToIP(r.first).String() + "-" + uint32ToIP(r.last).String()
+}
+
+func uint32ToIP(addr uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, addr)
+	return ip
+}
+
+// normalize sorts the ranges and merges the overlapping and adjacent ones.
+func normalize(ranges []ipRange) ipRanges {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	sorted := append([]ipRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].first < sorted[j].first })
+
+	result := ipRanges{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &result[len(result)-1]
+		if last.last == ^uint32(0) || r.first <= last.last+1 {
+			if r.last > last.last {
+				last.last = r.last
+			}
+			continue
+		}
+		result = append(result, r)
+	}
+
+	return result
+}
+
+func (rs ipRanges) union(other ipRanges) ipRanges {
+	return normalize(append(append([]ipRange(nil), rs...), other...))
+}
+
+func (rs ipRanges) intersect(other ipRanges) ipRanges {
+	var result []ipRange
+	for i, j := 0, 0; i < len(rs) && j < len(other); {
+		first, last := rs[i].first, rs[i].last
+		if other[j].first > first {
+			first = other[j].first
+		}
+		if other[j].last < last {
+			last = other[j].last
+		}
+		if first <= last {
+			result = append(result, ipRange{first, last})
+		}
+
+		if rs[i].last < other[j].last {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return normalize(result)
+}
+
+func (rs ipRanges) subtract(other ipRanges) ipRanges {
+	var result []ipRange
+	for _, r := range rs {
+		remaining := []ipRange{r}
+		for _, o := range other {
+			var next []ipRange
+			for _, cur := range remaining {
+				if o.last < cur.first || o.first > cur.last {
+					next = append(next, cur)
+					continue
+				}
+				if o.first > cur.first {
+					next = append(next, ipRange{cur.first, o.first - 1})
+				}
+				if o.last < cur.last {
+					next = append(next, ipRange{o.last + 1, cur.last})
+				}
+			}
+			remaining = next
+		}
+		result = append(result, remaining...)
+	}
+
+	return normalize(result)
+}
+
+func (rs ipRanges) strings() []string {
+	elements := make([]string, len(rs))
+	for i, r := range rs {
+		elements[i] = r.String()
+	}
+
+	return elements
+}
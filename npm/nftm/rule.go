@@ -0,0 +1,468 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package nftm
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+const (
+	matchL4Proto = "l4proto"
+	matchDport   = "dport"
+	matchSet     = "set"
+	matchMark    = "mark"
+	matchState   = "state"
+	matchSaddr   = "saddr"
+	matchDaddr   = "daddr"
+)
+
+// protocolNumbers holds the protocols network policies match, kubernetes only has these.
+var protocolNumbers = map[string]byte{
+	"tcp":  6,
+	"udp":  17,
+	"sctp": 132,
+}
+
+// ctStateBits holds the conntrack states matched by the iptables state module.
+var ctStateBits = map[string]uint32{
+	"invalid":     1,
+	"established": 2,
+	"related":     4,
+	"new":         8,
+	"untracked":   64,
+}
+
+// limitUnits holds the units of the iptables limit module, in seconds.
+var limitUnits = map[string]uint64{
+	"s":      1,
+	"sec":    1,
+	"second": 1,
+	"m":      60,
+	"min":    60,
+	"minute": 60,
+	"h":      60 * 60,
+	"hour":   60 * 60,
+	"d":      60 * 60 * 24,
+	"day":    60 * 60 * 24,
+}
+
+// nftRule is an nftables rule, translated from an iptables entry or looking up a verdict map.
+// It is rendered as nft syntax to compare and log it, and as netlink expressions to program it.
+type nftRule struct {
+	matches []*nftMatch
+	limit   *nftLimit
+	target  *nftTarget
+	comment string
+
+	// vmap is the verdict map the rule looks up the address of vmapDirection in, instead of matching the packet.
+	vmap          string
+	vmapDirection string
+}
+
+// nftMatch is a match of a rule. value holds the iptables value, the other fields its parsed form.
+type nftMatch struct {
+	kind   string
+	negate bool
+	value  string
+
+	protocol  string      // matchL4Proto and matchDport
+	ports     []portRange // matchDport
+	direction string      // matchSet
+	mark      uint32      // matchMark
+	markMask  uint32      // matchMark, 0 without a mask
+	maskValue string      // matchMark
+	states    uint32      // matchState
+	addr      *net.IPNet  // matchSaddr and matchDaddr
+}
+
+type portRange struct {
+	first uint16
+	last  uint16
+}
+
+type nftLimit struct {
+	value string
+	rate  uint64
+	unit  uint64 // seconds
+}
+
+// nftTarget is the statement of an iptables target, with its parsed options.
+type nftTarget struct {
+	name      string
+	mark      uint32
+	markMask  uint32 // 0 without a mask
+	markValue string
+	maskValue string
+	logPrefix *string
+	logGroup  *uint16
+}
+
+// parseEntry translates the iptables specs of an entry into an nftables rule,
+// e.g. "-p TCP --dport 80 -m set --match-set azure-npm-1 dst -j MARK --set-mark 0x2000"
+// becomes "meta l4proto tcp tcp dport 80 ip daddr @azure-npm-1 meta mark set 0x2000".
+func parseEntry(entry *iptm.IptEntry) (*nftRule, error) {
+	var (
+		rule       = &nftRule{}
+		target     string
+		targetOpts = make(map[string]string)
+		protocol   string
+		negate     bool
+		specs      = util.DropEmptyFields(entry.Specs)
+	)
+
+	for i := 0; i < len(specs); i++ {
+		flag := specs[i]
+		value := func() (string, error) {
+			if i+1 >= len(specs) {
+				return "", fmt.Errorf("missing value of %s in %v", flag, specs)
+			}
+			i++
+			return specs[i], nil
+		}
+
+		var (
+			match *nftMatch
+			err   error
+			v     string
+		)
+		switch flag {
+		case util.IptablesNotFlag:
+			negate = true
+			continue
+		case util.IptablesModuleFlag:
+			_, err = value()
+		case util.IptablesProtFlag:
+			if v, err = value(); err == nil {
+				protocol = strings.ToLower(v)
+				match, err = newProtocolMatch(protocol)
+			}
+		case util.IptablesDstPortFlag, util.IptablesMultiDestportFlag:
+			if v, err = value(); err == nil {
+				if protocol == "" {
+					// policies read from yaml may skip the protocol, which kubernetes defaults to TCP.
+					protocol = "tcp"
+					protocolMatch, _ := newProtocolMatch(protocol)
+					rule.matches = append(rule.matches, protocolMatch)
+				}
+				match, err = newPortMatch(protocol, v)
+			}
+		case util.IptablesMatchSetFlag:
+			var setName, direction string
+			if setName, err = value(); err == nil {
+				if direction, err = value(); err == nil {
+					match = &nftMatch{kind: matchSet, value: setName, direction: direction}
+					_, err = getSetLookupKey(direction)
+				}
+			}
+		case util.IptablesMarkFlag:
+			if v, err = value(); err == nil {
+				match, err = newMarkMatch(v)
+			}
+		case util.IptablesStateFlag:
+			if v, err = value(); err == nil {
+				match, err = newStateMatch(v)
+			}
+		case util.IptablesSFlag, util.IptablesDFlag:
+			if v, err = value(); err == nil {
+				kind := matchSaddr
+				if flag == util.IptablesDFlag {
+					kind = matchDaddr
+				}
+				match, err = newAddrMatch(kind, v)
+			}
+		case util.IptablesLimitFlag:
+			if v, err = value(); err == nil {
+				rule.limit, err = parseLimit(v)
+			}
+		case util.IptablesCommentFlag:
+			rule.comment, err = value()
+		case util.IptablesJumpFlag:
+			target, err = value()
+		case util.IptablesSetMarkFlag, util.IptablesNflogGroupFlag, util.IptablesNflogPrefixFlag, util.IptablesLogPrefixFlag:
+			targetOpts[flag], err = value()
+		default:
+			err = fmt.Errorf("unsupported iptables spec %s in %v", flag, specs)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if match != nil {
+			match.negate = negate
+			rule.matches = append(rule.matches, match)
+		}
+		negate = false
+	}
+
+	var err error
+	if rule.target, err = parseTarget(target, targetOpts); err != nil {
+		return nil, fmt.Errorf("%v in %v", err, specs)
+	}
+
+	return rule, nil
+}
+
+func newProtocolMatch(protocol string) (*nftMatch, error) {
+	if _, exists := protocolNumbers[protocol]; !exists {
+		return nil, fmt.Errorf("unsupported protocol %s", protocol)
+	}
+
+	return &nftMatch{kind: matchL4Proto, value: protocol, protocol: protocol}, nil
+}
+
+// newPortMatch parses iptables port ranges and multiport lists, like "80:90,443".
+func newPortMatch(protocol, ports string) (*nftMatch, error) {
+	match := &nftMatch{kind: matchDport, value: ports, protocol: protocol}
+	for _, field := range strings.Split(ports, ",") {
+		bounds := strings.SplitN(field, ":", 2)
+		first, err := strconv.ParseUint(bounds[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", field)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.ParseUint(bounds[1], 10, 16); err != nil || last < first {
+				return nil, fmt.Errorf("invalid port range %s", field)
+			}
+		}
+		match.ports = append(match.ports, portRange{uint16(first), uint16(last)})
+	}
+
+	return match, nil
+}
+
+// newMarkMatch parses an iptables mark, like "0x2000" or "0x2000/0x3000".
+func newMarkMatch(value string) (*nftMatch, error) {
+	match := &nftMatch{kind: matchMark, value: value}
+	mark := strings.SplitN(value, "/", 2)
+	v, err := strconv.ParseUint(mark[0], 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mark %s", value)
+	}
+	match.mark = uint32(v)
+
+	if len(mark) == 2 {
+		m, err := strconv.ParseUint(mark[1], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mark mask %s", value)
+		}
+		match.value, match.maskValue, match.markMask = mark[0], mark[1], uint32(m)
+	}
+
+	return match, nil
+}
+
+// newStateMatch parses iptables conntrack states, like "RELATED,ESTABLISHED".
+func newStateMatch(value string) (*nftMatch, error) {
+	match := &nftMatch{kind: matchState, value: strings.ToLower(value)}
+	for _, state := range strings.Split(match.value, ",") {
+		bit, exists := ctStateBits[state]
+		if !exists {
+			return nil, fmt.Errorf("unsupported conntrack state %s", state)
+		}
+		match.states |= bit
+	}
+
+	return match, nil
+}
+
+// newAddrMatch parses an iptables source or destination, an ip or a cidr.
+func newAddrMatch(kind, value string) (*nftMatch, error) {
+	cidr := value
+	if !strings.Contains(cidr, "/") {
+		cidr += "/32"
+	}
+
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 address %s", value)
+	}
+
+	return &nftMatch{kind: kind, value: value, addr: ipNet}, nil
+}
+
+// parseLimit parses an iptables limit, like "10/second".
+func parseLimit(value string) (*nftLimit, error) {
+	limit := strings.SplitN(value, "/", 2)
+	rate, err := strconv.ParseUint(limit[0], 10, 64)
+	if err != nil || len(limit) != 2 {
+		return nil, fmt.Errorf("invalid limit %s", value)
+	}
+
+	unit, exists := limitUnits[limit[1]]
+	if !exists {
+		return nil, fmt.Errorf("invalid limit unit %s", value)
+	}
+
+	return &nftLimit{value: value, rate: rate, unit: unit}, nil
+}
+
+// parseTarget parses an iptables target with its options, nil for an entry without target.
+func parseTarget(name string, opts map[string]string) (*nftTarget, error) {
+	target := &nftTarget{name: name}
+	switch name {
+	case "":
+		return nil, nil
+	case util.IptablesAccept, util.IptablesDrop, util.IptablesReject, util.IptablesReturn:
+	case util.IptablesMark:
+		v, exists := opts[util.IptablesSetMarkFlag]
+		if !exists {
+			return nil, fmt.Errorf("%s target without %s", name, util.IptablesSetMarkFlag)
+		}
+		match, err := newMarkMatch(v)
+		if err != nil {
+			return nil, err
+		}
+		target.mark, target.markMask, target.markValue, target.maskValue = match.mark, match.markMask, match.value, match.maskValue
+	case util.IptablesNflog:
+		if prefix, exists := opts[util.IptablesNflogPrefixFlag]; exists {
+			target.logPrefix = &prefix
+		}
+		if v, exists := opts[util.IptablesNflogGroupFlag]; exists {
+			group, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid nflog group %s", v)
+			}
+			g := uint16(group)
+			target.logGroup = &g
+		}
+	case util.IptablesLog:
+		if prefix, exists := opts[util.IptablesLogPrefixFlag]; exists {
+			target.logPrefix = &prefix
+		}
+	default:
+		if !strings.HasPrefix(name, util.IptablesAzureChain) {
+			return nil, fmt.Errorf("unsupported iptables target %s", name)
+		}
+	}
+
+	return target, nil
+}
+
+// String returns the rule in nft syntax.
+func (rule *nftRule) String() string {
+	if rule.vmap != "" {
+		key, _ := getSetLookupKey(rule.vmapDirection)
+		return fmt.Sprintf("%s vmap @%s", key, rule.vmap)
+	}
+
+	var fields []string
+	for _, match := range rule.matches {
+		fields = append(fields, match.String())
+	}
+	if rule.limit != nil {
+		fields = append(fields, "limit rate "+rule.limit.value)
+	}
+	if rule.target != nil {
+		fields = append(fields, rule.target.String())
+	}
+	if rule.comment != "" {
+		fields = append(fields, "comment "+quote(rule.comment, util.NftMaxCommentLength))
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// String returns the match in nft syntax.
+func (match *nftMatch) String() string {
+	op := ""
+	if match.negate {
+		op = "!= "
+	}
+
+	switch match.kind {
+	case matchL4Proto:
+		return "meta l4proto " + op + match.protocol
+	case matchDport:
+		return fmt.Sprintf("%s dport %s%s", match.protocol, op, translatePorts(match.value))
+	case matchSet:
+		key, _ := getSetLookupKey(match.direction)
+		return fmt.Sprintf("%s %s@%s", key, op, match.value)
+	case matchMark:
+		if match.maskValue != "" {
+			return fmt.Sprintf("meta mark & %s %s%s", match.maskValue, op, match.value)
+		}
+		return "meta mark " + op + match.value
+	case matchState:
+		return "ct state " + op + match.value
+	case matchSaddr:
+		return "ip saddr " + op + match.value
+	case matchDaddr:
+		return "ip daddr " + op + match.value
+	}
+
+	return ""
+}
+
+// String returns the statement of the target in nft syntax.
+func (target *nftTarget) String() string {
+	switch target.name {
+	case util.IptablesAccept:
+		return "accept"
+	case util.IptablesDrop:
+		return "drop"
+	case util.IptablesReject:
+		return "reject"
+	case util.IptablesReturn:
+		return "return"
+	case util.IptablesMark:
+		// --set-mark value/mask zeroes the bits of the mask and ORs the value.
+		if target.maskValue != "" {
+			return fmt.Sprintf("meta mark set meta mark & ~%s | %s", target.maskValue, target.markValue)
+		}
+		return "meta mark set " + target.markValue
+	case util.IptablesNflog, util.IptablesLog:
+		statement := "log"
+		if target.logPrefix != nil {
+			statement += " prefix " + quote(*target.logPrefix, 0)
+		}
+		if target.logGroup != nil {
+			statement += fmt.Sprintf(" group %d", *target.logGroup)
+		}
+		return statement
+	}
+
+	return "jump " + target.name
+}
+
+// getSetLookupKey returns the packet fields an ipset match with the direction flags looks up.
+func getSetLookupKey(direction string) (string, error) {
+	switch direction {
+	case util.IptablesDstFlag:
+		return "ip daddr", nil
+	case util.IptablesSrcFlag:
+		return "ip saddr", nil
+	case util.IptablesDstFlag + "," + util.IptablesDstFlag:
+		// hash:ip,port sets hold destination ip, protocol and port.
+		return "ip daddr . meta l4proto . th dport", nil
+	}
+
+	return "", fmt.Errorf("unsupported ipset match direction %s", direction)
+}
+
+// translatePorts turns iptables port ranges and multiport lists into nftables syntax, "80:90,443" becomes "{ 80-90, 443 }".
+func translatePorts(ports string) string {
+	ports = strings.Replace(ports, ":", "-", -1)
+	if !strings.Contains(ports, ",") {
+		return ports
+	}
+
+	return "{ " + strings.Join(strings.Split(ports, ","), ", ") + " }"
+}
+
+// quote returns s as an nftables string, cut to maxLen bytes when maxLen is positive.
+func quote(s string, maxLen int) string {
+	s = strings.Replace(s, `"`, `'`, -1)
+	if maxLen > 0 && len(s) > maxLen {
+		s = s[:maxLen]
+	}
+
+	return `"` + s + `"`
+}
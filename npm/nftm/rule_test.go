@@ -0,0 +1,159 @@
+package nftm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestParseEntry(t *testing.T) {
+	testCases := []struct {
+		specs    []string
+		expected string
+	}{
+		{
+			specs: []string{
+				util.IptablesProtFlag,
+				"TCP",
+				util.IptablesDstPortFlag,
+				"8000:8080",
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				"azure-npm-1",
+				util.IptablesDstFlag,
+				util.IptablesJumpFlag,
+				util.IptablesMark,
+				util.IptablesSetMarkFlag,
+				util.IptablesAzureIngressMarkHex,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-ALL-TCP-PORT-8000",
+			},
+			expected: `meta l4proto tcp tcp dport 8000-8080 ip daddr @azure-npm-1 meta mark set 0x2000 comment "ALLOW-ALL-TCP-PORT-8000"`,
+		},
+		{
+			specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesNotFlag,
+				util.IptablesMatchSetFlag,
+				"azure-npm-2",
+				util.IptablesSrcFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				"azure-npm-3",
+				util.IptablesDstFlag + "," + util.IptablesDstFlag,
+				util.IptablesJumpFlag,
+				util.IptablesDrop,
+			},
+			expected: "ip saddr != @azure-npm-2 ip daddr . meta l4proto . th dport @azure-npm-3 drop",
+		},
+		{
+			specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesMarkVerb,
+				util.IptablesMarkFlag,
+				util.IptablesAzureIngressMarkHex,
+				util.IptablesJumpFlag,
+				util.IptablesReturn,
+			},
+			expected: "meta mark 0x2000 return",
+		},
+		{
+			specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesStateModuleFlag,
+				util.IptablesStateFlag,
+				util.IptablesRelatedState + "," + util.IptablesEstablishedState,
+				util.IptablesJumpFlag,
+				util.IptablesAccept,
+			},
+			expected: "ct state related,established accept",
+		},
+		{
+			specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesLimitModuleFlag,
+				util.IptablesLimitFlag,
+				util.DropLoggingLimit,
+				util.IptablesJumpFlag,
+				util.IptablesNflog,
+				util.IptablesNflogGroupFlag,
+				"100",
+				util.IptablesNflogPrefixFlag,
+				util.DropLoggingIngressPrefix,
+			},
+			expected: `limit rate 10/second log prefix "AZURE-NPM-INGRESS-DROP: " group 100`,
+		},
+		{
+			specs: []string{
+				util.IptablesJumpFlag,
+				util.IptablesAzureIngressFromChain,
+			},
+			expected: "jump AZURE-NPM-INGRESS-FROM",
+		},
+	}
+
+	for _, tc := range testCases {
+		rule, err := parseEntry(&iptm.IptEntry{Specs: tc.specs})
+		if err != nil {
+			t.Errorf("TestParseEntry failed @ parseEntry of %v: %v", tc.specs, err)
+			continue
+		}
+
+		if actual := rule.String(); actual != tc.expected {
+			t.Errorf("TestParseEntry failed @ parseEntry. Expected: %s, Actual: %s", tc.expected, actual)
+		}
+	}
+
+	invalidSpecs := [][]string{
+		{util.IptablesJumpFlag, "KUBE-FORWARD"},
+		{util.IptablesProtFlag, "icmp", util.IptablesJumpFlag, util.IptablesAccept},
+		{util.IptablesDstPortFlag, "80:http", util.IptablesJumpFlag, util.IptablesAccept},
+		{util.IptablesModuleFlag, util.IptablesMarkVerb, util.IptablesMarkFlag, "mark", util.IptablesJumpFlag, util.IptablesReturn},
+		{util.IptablesSFlag, "fe80::1", util.IptablesJumpFlag, util.IptablesDrop},
+	}
+	for _, specs := range invalidSpecs {
+		if _, err := parseEntry(&iptm.IptEntry{Specs: specs}); err == nil {
+			t.Errorf("TestParseEntry failed @ parseEntry of %v, expected an error", specs)
+		}
+	}
+}
+
+func TestIPRanges(t *testing.T) {
+	parse := func(cidrs ...string) ipRanges {
+		var ranges []ipRange
+		for _, cidr := range cidrs {
+			r, err := parseIPRange(cidr)
+			if err != nil {
+				t.Fatalf("TestIPRanges failed @ parseIPRange: %v", err)
+			}
+			ranges = append(ranges, r)
+		}
+		return normalize(ranges)
+	}
+
+	union := parse("10.0.0.0/25", "10.0.0.128/25", "10.0.1.1")
+	if expected := []string{"10.0.0.0-10.0.0.255", "10.0.1.1"}; !reflect.DeepEqual(union.strings(), expected) {
+		t.Errorf("TestIPRanges failed @ normalize. Expected: %v, Actual: %v", expected, union.strings())
+	}
+
+	intersection := union.intersect(parse("10.0.0.128/26"))
+	if expected := []string{"10.0.0.128-10.0.0.191"}; !reflect.DeepEqual(intersection.strings(), expected) {
+		t.Errorf("TestIPRanges failed @ intersect. Expected: %v, Actual: %v", expected, intersection.strings())
+	}
+
+	difference := parse("0.0.0.0/0").subtract(parse("128.0.0.0/1", "0.0.0.0"))
+	if expected := []string{"0.0.0.1-127.255.255.255"}; !reflect.DeepEqual(difference.strings(), expected) {
+		t.Errorf("TestIPRanges failed @ subtract. Expected: %v, Actual: %v", expected, difference.strings())
+	}
+
+	if _, err := parseIPRange("fe80::1"); err == nil {
+		t.Errorf("TestIPRanges failed @ parseIPRange of an IPv6 address")
+	}
+}
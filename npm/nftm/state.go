@@ -0,0 +1,330 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package nftm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// nftState holds the rendered sets, verdict maps and chains of the NPM table, by name.
+type nftState struct {
+	sets   map[string]*nftObject
+	maps   map[string]*nftObject
+	chains map[string]*nftObject
+}
+
+// nftObject is a rendered nftables object: its declaration and its elements or rules in nft syntax,
+// which are compared and logged, and the parsed elements or rules which are programmed.
+type nftObject struct {
+	declaration string
+	contents    []string
+
+	setKind string               // sets, util.IpsetNetHashFlag or util.IpsetIPPortHashFlag
+	ranges  ipRanges             // nethash sets
+	tuples  []*ipPortElement     // ip,port sets
+	jumps   []*verdictMapElement // verdict maps
+	rules   []*nftRule           // chains
+}
+
+// nftCommand is an update of the NPM table, like "add set" or "flush chain".
+type nftCommand struct {
+	verb string // "add", "flush" or "delete"
+	kind string // "table", "chain", "set", "map", "element" or "rule"
+	name string // chain, set or map, empty for the table
+	obj  *nftObject
+	rule *nftRule
+}
+
+func newNftState() *nftState {
+	return &nftState{
+		sets:   make(map[string]*nftObject),
+		maps:   make(map[string]*nftObject),
+		chains: make(map[string]*nftObject),
+	}
+}
+
+func (obj *nftObject) equal(other *nftObject) bool {
+	if obj.declaration != other.declaration || len(obj.contents) != len(other.contents) {
+		return false
+	}
+
+	for i := range obj.contents {
+		if obj.contents[i] != other.contents[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getChangedObjects returns the sorted names of the objects of desired which differ from applied,
+// and the sorted names of the objects of applied which are gone from desired.
+func getChangedObjects(applied, desired map[string]*nftObject) ([]string, []string) {
+	var changed, removed []string
+	for name, obj := range desired {
+		if cur, exists := applied[name]; !exists || !cur.equal(obj) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range applied {
+		if _, exists := desired[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	return changed, removed
+}
+
+// getUpdateCommands returns the commands turning the applied state into the desired one, none when they are the same.
+// Sets and maps are filled before the chains referring to them, chains are created before the rules jumping to them,
+// and removed chains are emptied before the chains, maps and sets they refer to are deleted.
+func getUpdateCommands(applied, desired *nftState) []*nftCommand {
+	changedSets, removedSets := getChangedObjects(applied.sets, desired.sets)
+	changedMaps, removedMaps := getChangedObjects(applied.maps, desired.maps)
+	changedChains, removedChains := getChangedObjects(applied.chains, desired.chains)
+
+	if len(changedSets)+len(removedSets)+len(changedMaps)+len(removedMaps)+len(changedChains)+len(removedChains) == 0 {
+		return nil
+	}
+
+	cmds := []*nftCommand{newNftCommand("add", "table", "")}
+
+	fill := func(kind string, objs map[string]*nftObject, names []string) {
+		for _, name := range names {
+			obj := objs[name]
+			cmds = append(cmds, &nftCommand{verb: "add", kind: kind, name: name, obj: obj}, newNftCommand("flush", kind, name))
+			if len(obj.contents) > 0 {
+				cmds = append(cmds, &nftCommand{verb: "add", kind: "element", name: name, obj: obj})
+			}
+		}
+	}
+	fill("set", desired.sets, changedSets)
+	fill("map", desired.maps, changedMaps)
+
+	for _, name := range changedChains {
+		cmds = append(cmds, &nftCommand{verb: "add", kind: "chain", name: name, obj: desired.chains[name]})
+	}
+	// a chain missing from applied may still hold rules the kernel kept from a previous run.
+	for _, name := range append(append([]string(nil), changedChains...), removedChains...) {
+		cmds = append(cmds, newNftCommand("flush", "chain", name))
+	}
+	for _, name := range changedChains {
+		for _, rule := range desired.chains[name].rules {
+			cmds = append(cmds, &nftCommand{verb: "add", kind: "rule", name: name, rule: rule})
+		}
+	}
+
+	for _, name := range removedChains {
+		cmds = append(cmds, newNftCommand("delete", "chain", name))
+	}
+	for _, name := range removedMaps {
+		cmds = append(cmds, newNftCommand("delete", "map", name))
+	}
+	for _, name := range removedSets {
+		cmds = append(cmds, newNftCommand("delete", "set", name))
+	}
+
+	return cmds
+}
+
+func newNftCommand(verb, kind, name string) *nftCommand {
+	return &nftCommand{verb: verb, kind: kind, name: name}
+}
+
+// String returns the command in nft syntax.
+func (cmd *nftCommand) String() string {
+	switch {
+	case cmd.kind == "table":
+		return cmd.verb + " table " + getTableSpec()
+	case cmd.verb == "add" && cmd.kind == "element":
+		return fmt.Sprintf("add element %s %s { %s }", getTableSpec(), cmd.name, strings.Join(cmd.obj.contents, ", "))
+	case cmd.verb == "add" && cmd.kind == "rule":
+		return fmt.Sprintf("add rule %s %s %s", getTableSpec(), cmd.name, cmd.rule)
+	case cmd.verb == "add":
+		return "add " + cmd.obj.declaration
+	}
+
+	return fmt.Sprintf("%s %s %s %s", cmd.verb, cmd.kind, getTableSpec(), cmd.name)
+}
+
+// renderScript returns the commands as an nft script, to log them.
+func renderScript(cmds []*nftCommand) string {
+	var script strings.Builder
+	for _, cmd := range cmds {
+		script.WriteString(cmd.String() + "\n")
+	}
+
+	return script.String()
+}
+
+func getTableSpec() string {
+	return util.NftFamily + " " + util.NftTable
+}
+
+func newChainObject(chain string, rules []*nftRule) *nftObject {
+	obj := &nftObject{declaration: getChainDeclaration(chain), rules: rules}
+	for _, rule := range rules {
+		obj.contents = append(obj.contents, rule.String())
+	}
+
+	return obj
+}
+
+func newSetObject(name, kind string, ranges ipRanges, tuples []*ipPortElement) *nftObject {
+	obj := &nftObject{declaration: getSetDeclaration(name, kind), setKind: kind, ranges: ranges, tuples: tuples}
+	obj.contents = ranges.strings()
+	for _, tuple := range tuples {
+		obj.contents = append(obj.contents, tuple.String())
+	}
+
+	return obj
+}
+
+func newVerdictMapObject(name string, jumps []*verdictMapElement) *nftObject {
+	obj := &nftObject{declaration: getVerdictMapDeclaration(name), jumps: jumps}
+	for _, jump := range jumps {
+		obj.contents = append(obj.contents, jump.String())
+	}
+
+	return obj
+}
+
+func getChainDeclaration(chain string) string {
+	if chain == util.NftForwardChain {
+		return fmt.Sprintf("chain %s %s { type filter hook forward priority %d ; policy accept ; }", getTableSpec(), chain, util.NftForwardChainPriority)
+	}
+
+	return fmt.Sprintf("chain %s %s", getTableSpec(), chain)
+}
+
+func getSetDeclaration(name, kind string) string {
+	if kind == util.IpsetIPPortHashFlag {
+		return fmt.Sprintf("set %s %s { type ipv4_addr . inet_proto . inet_service ; }", getTableSpec(), name)
+	}
+
+	return fmt.Sprintf("set %s %s { type ipv4_addr ; flags interval ; }", getTableSpec(), name)
+}
+
+func getVerdictMapDeclaration(name string) string {
+	return fmt.Sprintf("map %s %s { type ipv4_addr : verdict ; flags interval ; }", getTableSpec(), name)
+}
+
+func getVerdictMapName(chain, direction string) string {
+	return chain + "-" + direction
+}
+
+// verdictMapJump is a jump entry which only matches the addresses of sets, so it can be looked up in a verdict map.
+type verdictMapJump struct {
+	direction string
+	includes  []string
+	excludes  []string
+	target    string
+}
+
+// getVerdictMapJump returns the verdict map jump of an entry jumping to an NPM chain based on
+// the sets of either the source or the destination address, like the jumps to the policy chains.
+func getVerdictMapJump(entry *iptm.IptEntry) (*verdictMapJump, bool) {
+	if !entry.IsJumpEntry {
+		return nil, false
+	}
+
+	jump := &verdictMapJump{}
+	specs := util.DropEmptyFields(entry.Specs)
+	negate := false
+	for i := 0; i < len(specs); i++ {
+		switch specs[i] {
+		case util.IptablesNotFlag:
+			negate = true
+			continue
+		case util.IptablesModuleFlag, util.IptablesCommentFlag:
+			i++
+		case util.IptablesJumpFlag:
+			if i+1 >= len(specs) {
+				return nil, false
+			}
+			i++
+			jump.target = specs[i]
+		case util.IptablesMatchSetFlag:
+			if i+2 >= len(specs) {
+				return nil, false
+			}
+			setName, direction := specs[i+1], specs[i+2]
+			i += 2
+			if direction != util.IptablesDstFlag && direction != util.IptablesSrcFlag ||
+				jump.direction != "" && jump.direction != direction {
+				return nil, false
+			}
+			jump.direction = direction
+			if negate {
+				jump.excludes = append(jump.excludes, setName)
+			} else {
+				jump.includes = append(jump.includes, setName)
+			}
+		default:
+			return nil, false
+		}
+
+		negate = false
+	}
+
+	if len(jump.includes) == 0 || !strings.HasPrefix(jump.target, util.IptablesAzureChain+"-") {
+		return nil, false
+	}
+
+	return jump, true
+}
+
+// verdictMapElement is an element of a verdict map, the range of addresses jumping to the target chain.
+type verdictMapElement struct {
+	addrs  ipRange
+	target string
+}
+
+func (element *verdictMapElement) String() string {
+	return fmt.Sprintf("%s : jump %s", element.addrs, element.target)
+}
+
+// getVerdictMapElements returns the elements of the verdict map holding jumps. iptables evaluates every
+// jump an address matches while a verdict map takes a single one, so the jumps to the drops chains,
+// which AZURE-NPM-INGRESS and AZURE-NPM-EGRESS take anyway for the packets not allowed by a policy,
+// only apply to the addresses without another jump. Otherwise the first jump of an address wins.
+func (nftMgr *NftablesManager) getVerdictMapElements(jumps []*verdictMapJump) []*verdictMapElement {
+	isDrops := func(target string) bool {
+		return target == util.IptablesAzureIngressDropsChain || target == util.IptablesAzureEgressDropsChain
+	}
+
+	var (
+		elements []*verdictMapElement
+		covered  ipRanges
+	)
+	for _, drops := range []bool{false, true} {
+		for _, jump := range jumps {
+			if isDrops(jump.target) != drops {
+				continue
+			}
+
+			ranges := nftMgr.getAddressRanges(jump.includes[0])
+			for _, setName := range jump.includes[1:] {
+				ranges = ranges.intersect(nftMgr.getAddressRanges(setName))
+			}
+			for _, setName := range jump.excludes {
+				ranges = ranges.subtract(nftMgr.getAddressRanges(setName))
+			}
+			ranges = ranges.subtract(covered)
+
+			for _, r := range ranges {
+				elements = append(elements, &verdictMapElement{addrs: r, target: jump.target})
+			}
+			covered = covered.union(ranges)
+		}
+	}
+
+	return elements
+}
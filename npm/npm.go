@@ -3,7 +3,6 @@
 package npm
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"sync"
@@ -11,36 +10,46 @@ import (
 
 	"github.com/Azure/azure-container-networking/aitelemetry"
 	"github.com/Azure/azure-container-networking/log"
-	"github.com/Azure/azure-container-networking/npm/ipsm"
-	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/dataplane"
 	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
 	"github.com/Azure/azure-container-networking/telemetry"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/informers"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	networkinginformers "k8s.io/client-go/informers/networking/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 var aiMetadata string
 
 const (
-	restoreRetryWaitTimeInSeconds = 5
-	restoreMaxRetries             = 10
-	backupWaitTimeInSeconds       = 60
-	telemetryRetryTimeInSeconds   = 60
-	heartbeatIntervalInMinutes    = 30
-	reconcileChainTimeInMinutes   = 5
+	telemetryRetryTimeInSeconds = 60
+	heartbeatIntervalInMinutes  = 30
+	reconcileChainTimeInMinutes = 5
+	clusterStateTimeInSeconds   = 60
+	policyCountersTimeInSeconds = 60
+
+	// the reader of dropped packets waits longer after each failure, and subscribes again after dropLogMaxErrors.
+	dropLogMinBackoff = 100 * time.Millisecond
+	dropLogMaxBackoff = 30 * time.Second
+	dropLogMaxErrors  = 5
 )
 
 // NetworkPolicyManager contains informers for pod, namespace and networkpolicy.
 type NetworkPolicyManager struct {
-	sync.Mutex
+	// the event worker of each kind holds its lock while it syncs an object, see Lock for what needs all of them.
+	nsLock  sync.Mutex
+	podLock sync.Mutex
+	npLock  sync.Mutex
+	// nsMapLock guards NsMap, the pod and network policy workers add the namespaces they find too.
+	nsMapLock sync.RWMutex
+
 	clientset *kubernetes.Clientset
 
 	informerFactory informers.SharedInformerFactory
@@ -48,14 +57,27 @@ type NetworkPolicyManager struct {
 	nsInformer      coreinformers.NamespaceInformer
 	npInformer      networkinginformers.NetworkPolicyInformer
 
+	// the informer events are processed from rate limited queues of object keys, see queue.go
+	podQueue workqueue.RateLimitingInterface
+	nsQueue  workqueue.RateLimitingInterface
+	npQueue  workqueue.RateLimitingInterface
+	// eventWorkers are the workers processing the queues, they are done once the queues are shut down and drained.
+	eventWorkers sync.WaitGroup
+
 	NodeName                     string
 	NsMap                        map[string]*Namespace
 	PodMap                       map[string]*NpmPod                     // Key is ns-<nsname>/<podname>/<poduuid>
+	podKeysByIP                  map[string]string                      // Key is the ip of a pod, value is its key in PodMap
 	RawNpMap                     map[string]*networkingv1.NetworkPolicy // Key is ns-<nsname>/<policyname>
 	ProcessedNpMap               map[string]*networkingv1.NetworkPolicy // Key is ns-<nsname>/<podSelectorHash>
+	policyRules                  map[metrics.PolicyRef][]string         // Keys of the iptables rules of each policy, see metrics.GetRuleKey
 	isAzureNpmChainCreated       bool
 	isSafeToCleanUpAzureNpmChain bool
 
+	// stopCh is the channel Start was given, dropLogStopCh stops the reader of dropped packets. Both are guarded by npLock.
+	stopCh        <-chan struct{}
+	dropLogStopCh chan struct{}
+
 	clusterState telemetry.ClusterState
 	version      string
 
@@ -63,26 +85,40 @@ type NetworkPolicyManager struct {
 	TelemetryEnabled bool
 }
 
-// GetClusterState returns current cluster state.
+// Lock stops the event workers of every kind, for what reads or replaces the state of all of them.
+func (npMgr *NetworkPolicyManager) Lock() {
+	npMgr.nsLock.Lock()
+	npMgr.podLock.Lock()
+	npMgr.npLock.Lock()
+}
+
+// Unlock lets the event workers stopped by Lock run again.
+func (npMgr *NetworkPolicyManager) Unlock() {
+	npMgr.npLock.Unlock()
+	npMgr.podLock.Unlock()
+	npMgr.nsLock.Unlock()
+}
+
+// GetClusterState returns current cluster state, counted from the informer caches.
 func (npMgr *NetworkPolicyManager) GetClusterState() telemetry.ClusterState {
-	pods, err := npMgr.clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	pods, err := npMgr.podInformer.Lister().List(labels.Everything())
 	if err != nil {
 		log.Logf("Error: Failed to list pods in GetClusterState")
 	}
 
-	namespaces, err := npMgr.clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	namespaces, err := npMgr.nsInformer.Lister().List(labels.Everything())
 	if err != nil {
 		log.Logf("Error: Failed to list namespaces in GetClusterState")
 	}
 
-	networkpolicies, err := npMgr.clientset.NetworkingV1().NetworkPolicies("").List(context.TODO(), metav1.ListOptions{})
+	networkpolicies, err := npMgr.npInformer.Lister().List(labels.Everything())
 	if err != nil {
 		log.Logf("Error: Failed to list networkpolicies in GetClusterState")
 	}
 
-	npMgr.clusterState.PodCount = len(pods.Items)
-	npMgr.clusterState.NsCount = len(namespaces.Items)
-	npMgr.clusterState.NwPolicyCount = len(networkpolicies.Items)
+	npMgr.clusterState.PodCount = len(pods)
+	npMgr.clusterState.NsCount = len(namespaces)
+	npMgr.clusterState.NwPolicyCount = len(networkpolicies)
 
 	return npMgr.clusterState
 }
@@ -97,10 +133,12 @@ func GetAIMetadata() string {
 	return aiMetadata
 }
 
-// SendClusterMetrics :- send NPM cluster metrics using AppInsights
-func (npMgr *NetworkPolicyManager) SendClusterMetrics() {
+// SendClusterMetrics publishes the cluster state to the cluster metrics, and sends it using AppInsights, until stopCh is closed.
+// Only the NPM elected to publish the cluster metrics runs it, see clustermetrics.go.
+func (npMgr *NetworkPolicyManager) SendClusterMetrics(stopCh <-chan struct{}) {
 	var (
-		heartbeat        = time.NewTicker(time.Minute * heartbeatIntervalInMinutes).C
+		heartbeat        = time.NewTicker(time.Minute * heartbeatIntervalInMinutes)
+		refresh          = time.NewTicker(time.Second * clusterStateTimeInSeconds)
 		customDimensions = map[string]string{"ClusterID": util.GetClusterID(npMgr.NodeName),
 			"APIServer": npMgr.serverVersion.String()}
 		podCount = aitelemetry.Metric{
@@ -116,50 +154,59 @@ func (npMgr *NetworkPolicyManager) SendClusterMetrics() {
 			CustomDimensions: customDimensions,
 		}
 	)
+	defer heartbeat.Stop()
+	defer refresh.Stop()
+
+	clusterState := npMgr.GetClusterState()
+	metrics.SetClusterState(clusterState.PodCount, clusterState.NsCount, clusterState.NwPolicyCount)
 
 	for {
-		<-heartbeat
-		npMgr.Lock()
-		podCount.Value = 0
-		//Reducing one to remove all-namespaces ns obj
-		nsCount.Value = float64(len(npMgr.NsMap) - 1)
-		nwPolicyCount.Value += float64(len(npMgr.RawNpMap))
-		podCount.Value += float64(len(npMgr.PodMap))
-		npMgr.Unlock()
-
-		metrics.SendMetric(podCount)
-		metrics.SendMetric(nsCount)
-		metrics.SendMetric(nwPolicyCount)
+		select {
+		case <-stopCh:
+			return
+		case <-refresh.C:
+			clusterState = npMgr.GetClusterState()
+			metrics.SetClusterState(clusterState.PodCount, clusterState.NsCount, clusterState.NwPolicyCount)
+		case <-heartbeat.C:
+			podCount.Value = float64(clusterState.PodCount)
+			nsCount.Value = float64(clusterState.NsCount)
+			nwPolicyCount.Value = float64(clusterState.NwPolicyCount)
+
+			metrics.SendMetric(podCount)
+			metrics.SendMetric(nsCount)
+			metrics.SendMetric(nwPolicyCount)
+		}
 	}
 }
 
-// restore restores iptables from backup file
-func (npMgr *NetworkPolicyManager) restore() {
-	iptMgr := iptm.NewIptablesManager()
-	var err error
-	for i := 0; i < restoreMaxRetries; i++ {
-		if err = iptMgr.Restore(util.IptablesConfigFile); err == nil {
+// collectPolicyCounters exports the iptables counters of the rules of every network policy periodically.
+func (npMgr *NetworkPolicyManager) collectPolicyCounters(stopCh <-chan struct{}) {
+	policyCounters := metrics.NewPolicyCounters()
+	for {
+		select {
+		case <-stopCh:
 			return
+		case <-time.After(policyCountersTimeInSeconds * time.Second):
 		}
 
-		time.Sleep(restoreRetryWaitTimeInSeconds * time.Second)
+		// errors are logged by Update, the counters are read again on the next tick.
+		policyCounters.Update(npMgr.getRuleOwners())
 	}
-
-	metrics.SendErrorLogAndMetric(util.NpmID, "Error: timeout restoring Azure-NPM states")
-	panic(err.Error)
 }
 
-// backup takes snapshots of iptables filter table and saves it periodically.
-func (npMgr *NetworkPolicyManager) backup() {
-	iptMgr := iptm.NewIptablesManager()
-	var err error
-	for {
-		time.Sleep(backupWaitTimeInSeconds * time.Second)
+// getRuleOwners returns the network policies each iptables rule was translated from, by rule key.
+func (npMgr *NetworkPolicyManager) getRuleOwners() map[string][]metrics.PolicyRef {
+	npMgr.npLock.Lock()
+	defer npMgr.npLock.Unlock()
 
-		if err = iptMgr.Save(util.IptablesConfigFile); err != nil {
-			metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to back up Azure-NPM states")
+	ruleOwners := make(map[string][]metrics.PolicyRef)
+	for policy, rules := range npMgr.policyRules {
+		for _, rule := range rules {
+			ruleOwners[rule] = append(ruleOwners[rule], policy)
 		}
 	}
+
+	return ruleOwners
 }
 
 // Start starts shared informers and waits for the shared informer cache to sync.
@@ -183,22 +230,40 @@ func (npMgr *NetworkPolicyManager) Start(stopCh <-chan struct{}) error {
 		return fmt.Errorf("Network policy informer failed to sync")
 	}
 
-	go npMgr.reconcileChains()
-	go npMgr.backup()
+	if err := npMgr.reconcileDataplane(); err != nil {
+		return err
+	}
+
+	// the events queued until now are programmed onto the reconciled dataplane.
+	npMgr.runEventWorkers(stopCh)
+
+	// the cluster metrics are only published by the NPM holding the cluster metrics lease.
+	if npMgr.clientset != nil && npMgr.NodeName != "" {
+		lock, err := npMgr.newClusterMetricsLock()
+		if err != nil {
+			metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to create the cluster metrics lock: %v", err)
+		} else {
+			go npMgr.runClusterMetricsElection(lock, stopCh)
+		}
+	}
+
+	go npMgr.reconcileChains(stopCh)
+
+	// the policy counters are read from the comments of the iptables rules.
+	if util.DataplaneMode == util.DataplaneIptables {
+		go npMgr.collectPolicyCounters(stopCh)
+	}
+
+	npMgr.npLock.Lock()
+	npMgr.stopCh = stopCh
+	npMgr.updateDropLogReader()
+	npMgr.npLock.Unlock()
 
 	return nil
 }
 
 // NewNetworkPolicyManager creates a NetworkPolicyManager
 func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory, npmVersion string) *NetworkPolicyManager {
-	// Clear out left over iptables states
-	log.Logf("Azure-NPM creating, cleaning iptables")
-	iptMgr := iptm.NewIptablesManager()
-	iptMgr.UninitNpmChains()
-
-	log.Logf("Azure-NPM creating, cleaning existing Azure NPM IPSets")
-	ipsm.NewIpsetManager().DestroyNpmIpsets()
-
 	var (
 		podInformer   = informerFactory.Core().V1().Pods()
 		nsInformer    = informerFactory.Core().V1().Namespaces()
@@ -231,6 +296,9 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 		podInformer:                  podInformer,
 		nsInformer:                   nsInformer,
 		npInformer:                   npInformer,
+		podQueue:                     newEventQueue(podQueueName),
+		nsQueue:                      newEventQueue(namespaceQueueName),
+		npQueue:                      newEventQueue(networkPolicyQueueName),
 		NodeName:                     os.Getenv("HOSTNAME"),
 		NsMap:                        make(map[string]*Namespace),
 		PodMap:                       make(map[string]*NpmPod),
@@ -248,7 +316,10 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 		TelemetryEnabled: true,
 	}
 
+	// the kernel keeps the sets and rules of a previous run until Start reconciles them with the informer caches.
 	allNs, _ := newNs(util.KubeAllNamespacesFlag)
+	expected := dataplane.NewState()
+	allNs.IpsMgr, allNs.iptMgr = expected, expected
 	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
 
 	// Create ipset for the namespace.
@@ -257,6 +328,7 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 		metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to create ipset for namespace %s.", kubeSystemNs)
 	}
 
+	// the handlers only queue the keys of the changed objects, the event workers started by Start program them.
 	podInformer.Informer().AddEventHandler(
 		// Pod event handlers
 		cache.ResourceEventHandlerFuncs{
@@ -266,9 +338,7 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 					metrics.SendErrorLogAndMetric(util.NpmID, "ADD Pod: Received unexpected object type: %v", obj)
 					return
 				}
-				npMgr.Lock()
-				npMgr.AddPod(podObj)
-				npMgr.Unlock()
+				npMgr.enqueuePod(podObj)
 			},
 			UpdateFunc: func(_, new interface{}) {
 				newPodObj, ok := new.(*corev1.Pod)
@@ -276,9 +346,7 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 					metrics.SendErrorLogAndMetric(util.NpmID, "UPDATE Pod: Received unexpected new object type: %v", newPodObj)
 					return
 				}
-				npMgr.Lock()
-				npMgr.UpdatePod(newPodObj)
-				npMgr.Unlock()
+				npMgr.enqueuePod(newPodObj)
 			},
 			DeleteFunc: func(obj interface{}) {
 				// DeleteFunc gets the final state of the resource (if it is known).
@@ -297,9 +365,7 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 						return
 					}
 				}
-				npMgr.Lock()
-				npMgr.DeletePod(podObj)
-				npMgr.Unlock()
+				npMgr.enqueuePod(podObj)
 			},
 		},
 	)
@@ -313,24 +379,15 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 					metrics.SendErrorLogAndMetric(util.NpmID, "ADD NameSpace: Received unexpected object type: %v", obj)
 					return
 				}
-				npMgr.Lock()
-				npMgr.AddNamespace(nameSpaceObj)
-				npMgr.Unlock()
+				npMgr.enqueueNamespace(nameSpaceObj)
 			},
-			UpdateFunc: func(old, new interface{}) {
-				oldNameSpaceObj, ok := old.(*corev1.Namespace)
-				if !ok {
-					metrics.SendErrorLogAndMetric(util.NpmID, "UPDATE NameSpace: Received unexpected old object type: %v", oldNameSpaceObj)
-					return
-				}
+			UpdateFunc: func(_, new interface{}) {
 				newNameSpaceObj, ok := new.(*corev1.Namespace)
 				if !ok {
 					metrics.SendErrorLogAndMetric(util.NpmID, "UPDATE NameSpace: Received unexpected new object type: %v", newNameSpaceObj)
 					return
 				}
-				npMgr.Lock()
-				npMgr.UpdateNamespace(oldNameSpaceObj, newNameSpaceObj)
-				npMgr.Unlock()
+				npMgr.enqueueNamespace(newNameSpaceObj)
 			},
 			DeleteFunc: func(obj interface{}) {
 				nameSpaceObj, ok := obj.(*corev1.Namespace)
@@ -345,9 +402,7 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 						return
 					}
 				}
-				npMgr.Lock()
-				npMgr.DeleteNamespace(nameSpaceObj)
-				npMgr.Unlock()
+				npMgr.enqueueNamespace(nameSpaceObj)
 			},
 		},
 	)
@@ -361,24 +416,15 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 					metrics.SendErrorLogAndMetric(util.NpmID, "ADD Network Policy: Received unexpected object type: %v", obj)
 					return
 				}
-				npMgr.Lock()
-				npMgr.AddNetworkPolicy(networkPolicyObj)
-				npMgr.Unlock()
+				npMgr.enqueueNetworkPolicy(networkPolicyObj)
 			},
-			UpdateFunc: func(old, new interface{}) {
-				oldNetworkPolicyObj, ok := old.(*networkingv1.NetworkPolicy)
-				if !ok {
-					metrics.SendErrorLogAndMetric(util.NpmID, "UPDATE Network Policy: Received unexpected old object type: %v", oldNetworkPolicyObj)
-					return
-				}
+			UpdateFunc: func(_, new interface{}) {
 				newNetworkPolicyObj, ok := new.(*networkingv1.NetworkPolicy)
 				if !ok {
 					metrics.SendErrorLogAndMetric(util.NpmID, "UPDATE Network Policy: Received unexpected new object type: %v", newNetworkPolicyObj)
 					return
 				}
-				npMgr.Lock()
-				npMgr.UpdateNetworkPolicy(oldNetworkPolicyObj, newNetworkPolicyObj)
-				npMgr.Unlock()
+				npMgr.enqueueNetworkPolicy(newNetworkPolicyObj)
 			},
 			DeleteFunc: func(obj interface{}) {
 				networkPolicyObj, ok := obj.(*networkingv1.NetworkPolicy)
@@ -393,9 +439,7 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 						return
 					}
 				}
-				npMgr.Lock()
-				npMgr.DeleteNetworkPolicy(networkPolicyObj)
-				npMgr.Unlock()
+				npMgr.enqueueNetworkPolicy(networkPolicyObj)
 			},
 		},
 	)
@@ -404,9 +448,10 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 }
 
 // reconcileChains checks for ordering of AZURE-NPM chain in FORWARD chain periodically.
-func (npMgr *NetworkPolicyManager) reconcileChains() error {
-	iptMgr := iptm.NewIptablesManager()
+func (npMgr *NetworkPolicyManager) reconcileChains(stopCh <-chan struct{}) error {
+	_, iptMgr := newDataplane()
 	select {
+	case <-stopCh:
 	case <-time.After(reconcileChainTimeInMinutes * time.Minute):
 		if err := iptMgr.CheckAndAddForwardChain(); err != nil {
 			return err
@@ -5,9 +5,10 @@ package npm
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
-	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/dataplane"
 	"github.com/Azure/azure-container-networking/npm/iptm"
 	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
@@ -37,6 +38,26 @@ func GetProcessedNPKey(npObj *networkingv1.NetworkPolicy, hashSelector string) s
 	return util.GetNSNameWithPrefix(netpolKey)
 }
 
+// policyApplyError is the error of a network policy of namespace ns which failed to be added or updated.
+// processNextEvent counts it in PolicyApplyFailures with its reason once the event is dropped, not on every retry.
+type policyApplyError struct {
+	ns     string
+	reason string
+	err    error
+}
+
+func newPolicyApplyError(ns, reason string, err error) error {
+	return &policyApplyError{ns: ns, reason: reason, err: err}
+}
+
+func (e *policyApplyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *policyApplyError) Unwrap() error {
+	return e.err
+}
+
 func (npMgr *NetworkPolicyManager) canCleanUpNpmChains() bool {
 	if !npMgr.isSafeToCleanUpAzureNpmChain {
 		return false
@@ -80,11 +101,9 @@ func (npMgr *NetworkPolicyManager) policyExists(npObj *networkingv1.NetworkPolic
 func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkPolicy) error {
 	var (
 		err            error
-		ns             *Namespace
-		exists         bool
 		npNs           = util.GetNSNameWithPrefix(npObj.ObjectMeta.Namespace)
 		npName         = npObj.ObjectMeta.Name
-		allNs          = npMgr.NsMap[util.KubeAllNamespacesFlag]
+		allNs          = npMgr.getAllNs()
 		timer          = metrics.StartNewTimer()
 		hashedSelector = HashSelector(&npObj.Spec.PodSelector)
 		npKey          = GetNetworkPolicyKey(npObj)
@@ -96,16 +115,12 @@ func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkP
 	if npKey == "" {
 		err = fmt.Errorf("[AddNetworkPolicy] Error: npKey is empty for %s network policy in %s", npName, npNs)
 		metrics.SendErrorLogAndMetric(util.NetpolID, err.Error())
-		return err
+		return newPolicyApplyError(npObj.ObjectMeta.Namespace, metrics.InvalidPolicyReason, err)
 	}
 
-	if ns, exists = npMgr.NsMap[npNs]; !exists {
-		ns, err = newNs(npNs)
-		if err != nil {
-			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: creating namespace %s with err: %v", npNs, err)
-			return err
-		}
-		npMgr.NsMap[npNs] = ns
+	if _, err = npMgr.getOrCreateNs(npNs); err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: creating namespace %s with err: %v", npNs, err)
+		return err
 	}
 
 	if npMgr.policyExists(npObj) {
@@ -115,12 +130,12 @@ func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkP
 	if !npMgr.isAzureNpmChainCreated {
 		if err = allNs.IpsMgr.CreateSet(util.KubeSystemFlag, append([]string{util.IpsetNetHashFlag})); err != nil {
 			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: failed to initialize kube-system ipset with err %s", err)
-			return err
+			return newPolicyApplyError(npObj.ObjectMeta.Namespace, metrics.IPSetReason, err)
 		}
 
 		if err = allNs.iptMgr.InitNpmChains(); err != nil {
 			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: failed to initialize azure-npm chains with err %s", err)
-			return err
+			return newPolicyApplyError(npObj.ObjectMeta.Namespace, metrics.ChainsReason, err)
 		}
 
 		npMgr.isAzureNpmChainCreated = true
@@ -146,7 +161,7 @@ func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkP
 		addedPolicy, err = addPolicy(oldPolicy, npObj)
 		if err != nil {
 			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: adding policy %s to %s with err: %v", npName, oldPolicy.ObjectMeta.Name, err)
-			return err
+			return newPolicyApplyError(npObj.ObjectMeta.Namespace, metrics.MergePolicyReason, err)
 		}
 	}
 
@@ -157,41 +172,28 @@ func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkP
 	}
 
 	sets, namedPorts, lists, ingressIPCidrs, egressIPCidrs, iptEntries = translatePolicy(npObj)
-	for _, set := range sets {
-		log.Logf("Creating set: %v, hashedSet: %v", set, util.GetHashedName(set))
-		if err = ipsMgr.CreateSet(set, append([]string{util.IpsetNetHashFlag})); err != nil {
-			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: creating ipset %s with err: %v", set, err)
-			return err
-		}
-	}
-	for _, set := range namedPorts {
-		log.Logf("Creating set: %v, hashedSet: %v", set, util.GetHashedName(set))
-		if err = ipsMgr.CreateSet(set, append([]string{util.IpsetIPPortHashFlag})); err != nil {
-			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: creating ipset named port %s with err: %v", set, err)
-			return err
-		}
-	}
-	for _, list := range lists {
-		if err = ipsMgr.CreateList(list); err != nil {
-			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: creating ipset list %s with err: %v", list, err)
-			return err
-		}
+	if failures := createPolicyIpsets(ipsMgr, sets, namedPorts, lists); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: failed to create %d ipsets, first err: %v", len(failures), failures[0])
+		return newPolicyApplyError(npObj.ObjectMeta.Namespace, metrics.IPSetReason, failures[0])
 	}
 	if err = npMgr.InitAllNsList(); err != nil {
 		metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: initializing all-namespace ipset list with err: %v", err)
-		return err
+		return newPolicyApplyError(npObj.ObjectMeta.Namespace, metrics.IPSetReason, err)
 	}
 	createCidrsRule("in", npObj.ObjectMeta.Name, npObj.ObjectMeta.Namespace, ingressIPCidrs, ipsMgr)
 	createCidrsRule("out", npObj.ObjectMeta.Name, npObj.ObjectMeta.Namespace, egressIPCidrs, ipsMgr)
 	iptMgr := allNs.iptMgr
-	for _, iptEntry := range iptEntries {
-		if err = iptMgr.Add(iptEntry); err != nil {
-			metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: failed to apply iptables rule. Rule: %+v with err: %v", iptEntry, err)
-			return err
-		}
+	if err = iptMgr.AddBatch(iptEntries); err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[AddNetworkPolicy] Error: failed to apply %d iptables rules with err: %v", len(iptEntries), err)
+		return newPolicyApplyError(npObj.ObjectMeta.Namespace, metrics.IPTablesReason, err)
 	}
 	npMgr.RawNpMap[npKey] = npObj
+	npMgr.setPolicyRules(npObj, iptEntries)
 
+	// policies selecting the same pods are merged in ProcessedNpMap under the name of the first one, but each is
+	// translated and programmed on its own. So the footprint is the one of npObj under its own name, the merged policy has none.
+	cidrIpsets := getCidrIpsets(npObj.ObjectMeta.Name, npObj.ObjectMeta.Namespace, ingressIPCidrs, egressIPCidrs)
+	metrics.SetPolicyFootprint(npObj.ObjectMeta.Namespace, npName, getPolicyFootprint(sets, namedPorts, lists, cidrIpsets, iptEntries))
 	metrics.NumPolicies.Inc()
 	timer.StopAndRecord(metrics.AddPolicyExecTime)
 
@@ -199,20 +201,185 @@ func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkP
 }
 
 // UpdateNetworkPolicy handles updateing network policy in iptables.
+// Only the ipsets and iptables rules which differ between the programmed policy and newNpObj are applied,
+// so traffic allowed by both versions is never dropped while the update is in progress.
 func (npMgr *NetworkPolicyManager) UpdateNetworkPolicy(oldNpObj *networkingv1.NetworkPolicy, newNpObj *networkingv1.NetworkPolicy) error {
-	if newNpObj.ObjectMeta.DeletionTimestamp == nil && newNpObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
-		log.Logf("NETWORK POLICY UPDATING")
+	if newNpObj.ObjectMeta.DeletionTimestamp != nil || newNpObj.ObjectMeta.DeletionGracePeriodSeconds != nil {
+		return nil
+	}
+
+	log.Logf("NETWORK POLICY UPDATING")
+
+	// the cached policy is what is programmed, oldNpObj may be stale.
+	programmedNpObj, exists := npMgr.RawNpMap[GetNetworkPolicyKey(newNpObj)]
+	if !exists || !npMgr.isAzureNpmChainCreated {
 		return npMgr.AddNetworkPolicy(newNpObj)
 	}
 
+	if npMgr.policyExists(newNpObj) {
+		return nil
+	}
+
+	return npMgr.applyNetworkPolicyDiff(programmedNpObj, newNpObj)
+}
+
+// applyNetworkPolicyDiff replaces the programmed oldNpObj with newNpObj by applying the differences of their translations.
+// Additions are applied before removals.
+func (npMgr *NetworkPolicyManager) applyNetworkPolicyDiff(oldNpObj *networkingv1.NetworkPolicy, newNpObj *networkingv1.NetworkPolicy) error {
+	var (
+		err             error
+		allNs           = npMgr.getAllNs()
+		ipsMgr          = allNs.IpsMgr
+		iptMgr          = allNs.iptMgr
+		npNs, npName    = newNpObj.ObjectMeta.Namespace, newNpObj.ObjectMeta.Name
+		oldProcessedKey = GetProcessedNPKey(oldNpObj, HashSelector(&oldNpObj.Spec.PodSelector))
+		newProcessedKey = GetProcessedNPKey(newNpObj, HashSelector(&newNpObj.Spec.PodSelector))
+	)
+
+	log.Logf("NETWORK POLICY DIFFING: Namespace: %s, Name:%s", npNs, npName)
+
+	_, _, _, oldIngressIPCidrs, oldEgressIPCidrs, oldIptEntries := translatePolicy(oldNpObj)
+	sets, namedPorts, lists, newIngressIPCidrs, newEgressIPCidrs, newIptEntries := translatePolicy(newNpObj)
+
+	// ipsets and lists are shared with pods and namespaces and are never removed by policies.
+	if failures := createPolicyIpsets(ipsMgr, sets, namedPorts, lists); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[UpdateNetworkPolicy] Error: failed to create %d ipsets, first err: %v", len(failures), failures[0])
+		return newPolicyApplyError(npNs, metrics.IPSetReason, failures[0])
+	}
+	if err = npMgr.InitAllNsList(); err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[UpdateNetworkPolicy] Error: initializing all-namespace ipset list with err: %v", err)
+		return newPolicyApplyError(npNs, metrics.IPSetReason, err)
+	}
+
+	oldCidrIpsets := getCidrIpsets(oldNpObj.ObjectMeta.Name, oldNpObj.ObjectMeta.Namespace, oldIngressIPCidrs, oldEgressIPCidrs)
+	newCidrIpsets := getCidrIpsets(npName, npNs, newIngressIPCidrs, newEgressIPCidrs)
+	updateCidrIpsets(oldCidrIpsets, newCidrIpsets, ipsMgr)
+
+	addedEntries, removedEntries := diffIptEntries(oldIptEntries, newIptEntries)
+	log.Logf("Updating network policy %s/%s: adding %d and removing %d iptables rules.", npNs, npName, len(addedEntries), len(removedEntries))
+	if err = iptMgr.AddBatch(addedEntries); err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[UpdateNetworkPolicy] Error: failed to apply %d iptables rules with err: %v", len(addedEntries), err)
+		return newPolicyApplyError(npNs, metrics.IPTablesReason, err)
+	}
+	if err = iptMgr.DeleteBatch(removedEntries); err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[UpdateNetworkPolicy] Error: failed to delete %d iptables rules with err: %v", len(removedEntries), err)
+		return newPolicyApplyError(npNs, metrics.IPTablesReason, err)
+	}
+
+	for cidrIpset := range oldCidrIpsets {
+		if _, exists := newCidrIpsets[cidrIpset]; !exists {
+			if err := ipsMgr.DeleteSet(cidrIpset); err != nil {
+				metrics.SendErrorLogAndMetric(util.NetpolID, "[UpdateNetworkPolicy] deleting ipset %s with err: %v", cidrIpset, err)
+			}
+		}
+	}
+
+	// Replace the old policy with the new one in the processed (merged) network policy map.
+	if processedPolicy, exists := npMgr.ProcessedNpMap[oldProcessedKey]; exists {
+		deductedPolicy, err := deductPolicy(processedPolicy, oldNpObj)
+		if err != nil {
+			metrics.SendErrorLogAndMetric(util.NetpolID, "[UpdateNetworkPolicy] Error: deducting policy %s from %s with err: %v", npName, processedPolicy.ObjectMeta.Name, err)
+			return newPolicyApplyError(npNs, metrics.MergePolicyReason, err)
+		}
+
+		if deductedPolicy == nil {
+			delete(npMgr.ProcessedNpMap, oldProcessedKey)
+		} else {
+			npMgr.ProcessedNpMap[oldProcessedKey] = deductedPolicy
+		}
+	}
+
+	var addedPolicy *networkingv1.NetworkPolicy
+	if processedPolicy, exists := npMgr.ProcessedNpMap[newProcessedKey]; exists {
+		addedPolicy, err = addPolicy(processedPolicy, newNpObj)
+		if err != nil {
+			metrics.SendErrorLogAndMetric(util.NetpolID, "[UpdateNetworkPolicy] Error: adding policy %s to %s with err: %v", npName, processedPolicy.ObjectMeta.Name, err)
+			return newPolicyApplyError(npNs, metrics.MergePolicyReason, err)
+		}
+	}
+
+	if addedPolicy != nil {
+		npMgr.ProcessedNpMap[newProcessedKey] = addedPolicy
+	} else {
+		npMgr.ProcessedNpMap[newProcessedKey] = newNpObj
+	}
+
+	npMgr.RawNpMap[GetNetworkPolicyKey(newNpObj)] = newNpObj
+	npMgr.setPolicyRules(newNpObj, newIptEntries)
+	metrics.SetPolicyFootprint(npNs, npName, getPolicyFootprint(sets, namedPorts, lists, newCidrIpsets, newIptEntries))
+
 	return nil
 }
 
+// setPolicyRules records the keys of the iptables rules of a policy, which identify the policy in the counters of the rules.
+// A rule the policy programs twice is recorded twice, each copy counts on its own.
+func (npMgr *NetworkPolicyManager) setPolicyRules(npObj *networkingv1.NetworkPolicy, iptEntries []*iptm.IptEntry) {
+	if npMgr.policyRules == nil {
+		npMgr.policyRules = make(map[metrics.PolicyRef][]string)
+	}
+
+	rules := make([]string, 0, len(iptEntries))
+	for _, entry := range iptEntries {
+		rules = append(rules, metrics.GetRuleKey(entry.Chain, entry.Specs))
+	}
+
+	npMgr.policyRules[getPolicyRef(npObj)] = rules
+}
+
+// getPolicyRef returns the reference of a policy in the policy counters.
+func getPolicyRef(npObj *networkingv1.NetworkPolicy) metrics.PolicyRef {
+	return metrics.PolicyRef{Namespace: npObj.ObjectMeta.Namespace, Name: npObj.ObjectMeta.Name}
+}
+
+// getPolicyFootprint returns what a translated policy programs: its iptables rules, the ipsets they refer to
+// and the entries of its cidr ipsets. The other ipsets hold the pods and namespaces the policy selects.
+func getPolicyFootprint(sets, namedPorts, lists []string, cidrIpsets map[string][]string, iptEntries []*iptm.IptEntry) metrics.PolicyFootprint {
+	footprint := metrics.PolicyFootprint{
+		IPTableRules: len(iptEntries),
+		IPSets:       len(sets) + len(namedPorts) + len(lists) + len(cidrIpsets),
+	}
+	for _, entries := range cidrIpsets {
+		footprint.IPSetEntries += len(entries)
+	}
+
+	return footprint
+}
+
+// diffIptEntries returns the entries only in newEntries and the entries only in oldEntries.
+func diffIptEntries(oldEntries, newEntries []*iptm.IptEntry) ([]*iptm.IptEntry, []*iptm.IptEntry) {
+	getKey := func(entry *iptm.IptEntry) string {
+		return fmt.Sprintf("%s %t %s", entry.Chain, entry.IsJumpEntry, strings.Join(entry.Specs, " "))
+	}
+
+	oldKeys := make(map[string]bool)
+	for _, entry := range oldEntries {
+		oldKeys[getKey(entry)] = true
+	}
+
+	newKeys := make(map[string]bool)
+	var addedEntries, removedEntries []*iptm.IptEntry
+	for _, entry := range newEntries {
+		key := getKey(entry)
+		newKeys[key] = true
+		if !oldKeys[key] {
+			addedEntries = append(addedEntries, entry)
+		}
+	}
+
+	for _, entry := range oldEntries {
+		if !newKeys[getKey(entry)] {
+			removedEntries = append(removedEntries, entry)
+		}
+	}
+
+	return addedEntries, removedEntries
+}
+
 // DeleteNetworkPolicy handles deleting network policy from iptables.
 func (npMgr *NetworkPolicyManager) DeleteNetworkPolicy(npObj *networkingv1.NetworkPolicy) error {
 	var (
 		err            error
-		allNs          = npMgr.NsMap[util.KubeAllNamespacesFlag]
+		allNs          = npMgr.getAllNs()
 		hashedSelector = HashSelector(&npObj.Spec.PodSelector)
 		npKey          = GetNetworkPolicyKey(npObj)
 		npProcessedKey = GetProcessedNPKey(npObj, hashedSelector)
@@ -230,11 +397,9 @@ func (npMgr *NetworkPolicyManager) DeleteNetworkPolicy(npObj *networkingv1.Netwo
 	_, _, _, ingressIPCidrs, egressIPCidrs, iptEntries := translatePolicy(npObj)
 
 	iptMgr := allNs.iptMgr
-	for _, iptEntry := range iptEntries {
-		if err = iptMgr.Delete(iptEntry); err != nil {
-			metrics.SendErrorLogAndMetric(util.NetpolID, "[DeleteNetworkPolicy] Error: failed to apply iptables rule. Rule: %+v with err: %v", iptEntry, err)
-			return err
-		}
+	if err = iptMgr.DeleteBatch(iptEntries); err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[DeleteNetworkPolicy] Error: failed to delete %d iptables rules with err: %v", len(iptEntries), err)
+		return err
 	}
 
 	removeCidrsRule("in", npObj.ObjectMeta.Name, npObj.ObjectMeta.Namespace, ingressIPCidrs, allNs.IpsMgr)
@@ -262,48 +427,173 @@ func (npMgr *NetworkPolicyManager) DeleteNetworkPolicy(npObj *networkingv1.Netwo
 		}
 	}
 	delete(npMgr.RawNpMap, npKey)
+	delete(npMgr.policyRules, getPolicyRef(npObj))
 
+	metrics.DeletePolicyFootprint(npObj.ObjectMeta.Namespace, npName)
 	metrics.NumPolicies.Dec()
 
 	return nil
 }
 
-func createCidrsRule(ingressOrEgress, policyName, ns string, ipsetEntries [][]string, ipsMgr *ipsm.IpsetManager) {
+// getCidrIpsetName returns the name of the ipset holding the ipBlock cidrs of the i-th rule of a policy.
+func getCidrIpsetName(policyName, ns string, i int, ingressOrEgress string) string {
+	return policyName + "-in-ns-" + ns + "-" + strconv.Itoa(i) + ingressOrEgress
+}
+
+// getCidrIpsetEntries returns the ipset entries to program for the cidrs of a rule, the IPv4 ones first.
+// The IPv6 cidrs end up in the inet6 twin of the ipset, they are skipped unless IPv6 is enabled.
+func getCidrIpsetEntries(ipCidrSet []string) []string {
+	var (
+		entries []string
+		// DropEmptyFields compacts the slice it gets in place, the translated cidrs are read again.
+		ipv4CidrSet, ipv6CidrSet = splitCidrsByFamily(util.DropEmptyFields(append([]string(nil), ipCidrSet...)))
+	)
+
+	for _, ipCidrEntry := range ipv4CidrSet {
+		// Ipset doesn't allow 0.0.0.0/0 to be added. A general solution is split 0.0.0.0/1 in half which convert to
+		// 1.0.0.0/1 and 128.0.0.0/1
+		if ipCidrEntry == "0.0.0.0/0" {
+			entries = append(entries, "1.0.0.0/1", "128.0.0.0/1")
+		} else {
+			entries = append(entries, ipCidrEntry)
+		}
+	}
+
+	if !util.IPv6Enabled {
+		if len(ipv6CidrSet) > 0 {
+			log.Logf("Ignoring IPv6 cidrs %v, IPv6 is disabled.", ipv6CidrSet)
+		}
+		return entries
+	}
+
+	for _, ipCidrEntry := range ipv6CidrSet {
+		// ::/0 is split the same way.
+		if ipCidrEntry == "::/0" {
+			entries = append(entries, "::/1", "8000::/1")
+		} else {
+			entries = append(entries, ipCidrEntry)
+		}
+	}
+
+	return entries
+}
+
+// splitCidrsByFamily returns the IPv4 and the IPv6 cidrs of an ipBlock, excepted cidrs included.
+func splitCidrsByFamily(ipCidrSet []string) ([]string, []string) {
+	var ipv4CidrSet, ipv6CidrSet []string
+	for _, ipCidrEntry := range ipCidrSet {
+		if util.IsIPv6(ipCidrEntry) {
+			ipv6CidrSet = append(ipv6CidrSet, ipCidrEntry)
+		} else {
+			ipv4CidrSet = append(ipv4CidrSet, ipCidrEntry)
+		}
+	}
+
+	return ipv4CidrSet, ipv6CidrSet
+}
+
+// createPolicyIpsets creates the ipsets and lists a policy refers to with a single transaction.
+// It returns one error per ipset or list which failed to be created.
+func createPolicyIpsets(ipsMgr dataplane.Ipsets, sets, namedPorts, lists []string) []error {
+	tx := ipsMgr.NewTransaction()
+	for _, set := range sets {
+		log.Logf("Creating set: %v, hashedSet: %v", set, util.GetHashedName(set))
+		tx.CreateSet(set, []string{util.IpsetNetHashFlag})
+	}
+	for _, set := range namedPorts {
+		log.Logf("Creating set: %v, hashedSet: %v", set, util.GetHashedName(set))
+		tx.CreateSet(set, []string{util.IpsetIPPortHashFlag})
+	}
+	for _, list := range lists {
+		tx.CreateList(list)
+	}
+
+	return tx.Commit()
+}
+
+// getCidrIpsets returns the entries of the cidr ipsets of a policy keyed by ipset name.
+func getCidrIpsets(policyName, ns string, ingressIPCidrs, egressIPCidrs [][]string) map[string][]string {
+	cidrIpsets := make(map[string][]string)
+	for ingressOrEgress, ipCidrs := range map[string][][]string{"in": ingressIPCidrs, "out": egressIPCidrs} {
+		for i, ipCidrSet := range ipCidrs {
+			if len(ipCidrSet) == 0 {
+				continue
+			}
+			cidrIpsets[getCidrIpsetName(policyName, ns, i, ingressOrEgress)] = getCidrIpsetEntries(ipCidrSet)
+		}
+	}
+
+	return cidrIpsets
+}
+
+// updateCidrIpsets creates the cidr ipsets only in newCidrIpsets and updates the entries of the ones in both.
+// Entries are added before removals so an ipset is never emptied, ipsets only in oldCidrIpsets are left to the caller.
+func updateCidrIpsets(oldCidrIpsets, newCidrIpsets map[string][]string, ipsMgr dataplane.Ipsets) {
+	spec := []string{util.IpsetNetHashFlag, util.IpsetMaxelemName, util.IpsetMaxelemNum}
+	tx := ipsMgr.NewTransaction()
+	for setName, newEntries := range newCidrIpsets {
+		oldEntries, exists := oldCidrIpsets[setName]
+		if !exists {
+			log.Logf("Creating set: %v, hashedSet: %v", setName, util.GetHashedName(setName))
+			tx.CreateSet(setName, spec)
+		}
+
+		// an entry switching between match and nomatch has to be removed before it is added back.
+		oldCidrs := make(map[string]bool)
+		for _, entry := range oldEntries {
+			oldCidrs[strings.TrimSuffix(entry, util.IpsetNomatch)] = true
+		}
+
+		addedEntries, removedEntries := util.CompareStrSlices(oldEntries, newEntries)
+		var switchedEntries []string
+		for _, entry := range addedEntries {
+			if oldCidrs[strings.TrimSuffix(entry, util.IpsetNomatch)] {
+				switchedEntries = append(switchedEntries, entry)
+				continue
+			}
+			tx.AddToSet(setName, entry, util.IpsetNetHashFlag, "")
+		}
+
+		for _, entry := range removedEntries {
+			tx.DeleteFromSet(setName, strings.TrimSuffix(entry, util.IpsetNomatch), "")
+		}
+
+		for _, entry := range switchedEntries {
+			tx.AddToSet(setName, entry, util.IpsetNetHashFlag, "")
+		}
+	}
+
+	if failures := tx.Commit(); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[updateCidrIpsets] Error: failed to update %d cidr ipset entries, first err: %v", len(failures), failures[0])
+	}
+}
+
+func createCidrsRule(ingressOrEgress, policyName, ns string, ipsetEntries [][]string, ipsMgr dataplane.Ipsets) {
 	spec := append([]string{util.IpsetNetHashFlag, util.IpsetMaxelemName, util.IpsetMaxelemNum})
+	tx := ipsMgr.NewTransaction()
 	for i, ipCidrSet := range ipsetEntries {
 		if ipCidrSet == nil || len(ipCidrSet) == 0 {
 			continue
 		}
-		setName := policyName + "-in-ns-" + ns + "-" + strconv.Itoa(i) + ingressOrEgress
+		setName := getCidrIpsetName(policyName, ns, i, ingressOrEgress)
 		log.Logf("Creating set: %v, hashedSet: %v", setName, util.GetHashedName(setName))
-		if err := ipsMgr.CreateSet(setName, spec); err != nil {
-			metrics.SendErrorLogAndMetric(util.NetpolID, "[createCidrsRule] Error: creating ipset %s with err: %v", ipCidrSet, err)
-		}
-		for _, ipCidrEntry := range util.DropEmptyFields(ipCidrSet) {
-			// Ipset doesn't allow 0.0.0.0/0 to be added. A general solution is split 0.0.0.0/1 in half which convert to
-			// 1.0.0.0/1 and 128.0.0.0/1
-			if ipCidrEntry == "0.0.0.0/0" {
-				splitEntry := [2]string{"1.0.0.0/1", "128.0.0.0/1"}
-				for _, entry := range splitEntry {
-					if err := ipsMgr.AddToSet(setName, entry, util.IpsetNetHashFlag, ""); err != nil {
-						metrics.SendErrorLogAndMetric(util.NetpolID, "[createCidrsRule] adding ip cidrs %s into ipset %s with err: %v", entry, ipCidrSet, err)
-					}
-				}
-			} else {
-				if err := ipsMgr.AddToSet(setName, ipCidrEntry, util.IpsetNetHashFlag, ""); err != nil {
-					metrics.SendErrorLogAndMetric(util.NetpolID, "[createCidrsRule] adding ip cidrs %s into ipset %s with err: %v", ipCidrEntry, ipCidrSet, err)
-				}
-			}
+		tx.CreateSet(setName, spec)
+		for _, entry := range getCidrIpsetEntries(ipCidrSet) {
+			tx.AddToSet(setName, entry, util.IpsetNetHashFlag, "")
 		}
 	}
+
+	if failures := tx.Commit(); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[createCidrsRule] Error: failed to apply %d cidr ipset operations, first err: %v", len(failures), failures[0])
+	}
 }
 
-func removeCidrsRule(ingressOrEgress, policyName, ns string, ipsetEntries [][]string, ipsMgr *ipsm.IpsetManager) {
+func removeCidrsRule(ingressOrEgress, policyName, ns string, ipsetEntries [][]string, ipsMgr dataplane.Ipsets) {
 	for i, ipCidrSet := range ipsetEntries {
 		if ipCidrSet == nil || len(ipCidrSet) == 0 {
 			continue
 		}
-		setName := policyName + "-in-ns-" + ns + "-" + strconv.Itoa(i) + ingressOrEgress
+		setName := getCidrIpsetName(policyName, ns, i, ingressOrEgress)
 		log.Logf("Delete set: %v, hashedSet: %v", setName, util.GetHashedName(setName))
 		if err := ipsMgr.DeleteSet(setName); err != nil {
 			metrics.SendErrorLogAndMetric(util.NetpolID, "[removeCidrsRule] deleting ipset %s with err: %v", ipCidrSet, err)
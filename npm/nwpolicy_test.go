@@ -3,6 +3,9 @@
 package npm
 
 import (
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/npm/ipsm"
@@ -113,14 +116,14 @@ func TestAddNetworkPolicy(t *testing.T) {
 	}
 	npMgr.Unlock()
 
-	ipsMgr = npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
+	allNsIpsMgr := npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
 
 	// Check whether 0.0.0.0/0 got translated to 1.0.0.0/1 and 128.0.0.0/1
-	if !ipsMgr.Exists("allow-ingress-in-ns-test-nwpolicy-0in", "1.0.0.0/1", util.IpsetNetHashFlag) {
+	if !allNsIpsMgr.Exists("allow-ingress-in-ns-test-nwpolicy-0in", "1.0.0.0/1", util.IpsetNetHashFlag) {
 		t.Errorf("TestDeleteFromSet failed @ ipsMgr.AddToSet")
 	}
 
-	if !ipsMgr.Exists("allow-ingress-in-ns-test-nwpolicy-0in", "128.0.0.0/1", util.IpsetNetHashFlag) {
+	if !allNsIpsMgr.Exists("allow-ingress-in-ns-test-nwpolicy-0in", "128.0.0.0/1", util.IpsetNetHashFlag) {
 		t.Errorf("TestDeleteFromSet failed @ ipsMgr.AddToSet")
 	}
 
@@ -276,9 +279,121 @@ func TestUpdateNetworkPolicy(t *testing.T) {
 	if err := npMgr.UpdateNetworkPolicy(allowIngress, allowEgress); err != nil {
 		t.Errorf("TestUpdateNetworkPolicy failed @ UpdateNetworkPolicy")
 	}
+
+	updatedAllowIngress := allowIngress.DeepCopy()
+	updatedAllowIngress.ObjectMeta.ResourceVersion = "2"
+	updatedAllowIngress.Spec.Ingress[0].Ports[0].Port = &intstr.IntOrString{StrVal: "8080"}
+	if err := npMgr.UpdateNetworkPolicy(allowIngress, updatedAllowIngress); err != nil {
+		t.Errorf("TestUpdateNetworkPolicy failed @ UpdateNetworkPolicy with a diff")
+	}
+
+	if cached := npMgr.RawNpMap[GetNetworkPolicyKey(allowIngress)]; cached != updatedAllowIngress {
+		t.Errorf("TestUpdateNetworkPolicy failed @ RawNpMap. Expected the updated policy to be cached")
+	}
 	npMgr.Unlock()
 }
 
+func TestDiffIptEntries(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	oldPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "allow-ingress",
+			Namespace: "test-nwpolicy",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{{
+						PodSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": "frontend"},
+						},
+					}},
+					Ports: []networkingv1.NetworkPolicyPort{{
+						Protocol: &tcp,
+						Port:     &intstr.IntOrString{IntVal: 8000},
+					}},
+				},
+			},
+		},
+	}
+
+	newPolicy := oldPolicy.DeepCopy()
+	newPolicy.Spec.Ingress[0].Ports[0].Port = &intstr.IntOrString{IntVal: 8080}
+
+	_, _, _, _, _, oldEntries := translatePolicy(oldPolicy)
+	_, _, _, _, _, newEntries := translatePolicy(newPolicy)
+
+	addedEntries, removedEntries := diffIptEntries(oldEntries, newEntries)
+	if len(addedEntries) == 0 || len(addedEntries) != len(removedEntries) {
+		t.Fatalf("TestDiffIptEntries failed @ diffIptEntries. Expected the port rules to be replaced, added: %d, removed: %d", len(addedEntries), len(removedEntries))
+	}
+
+	// the default drop and jump rules are the same in both versions and must stay in place.
+	if len(oldEntries)-len(removedEntries) == 0 {
+		t.Errorf("TestDiffIptEntries failed @ diffIptEntries. Rules in both versions must not be removed")
+	}
+
+	for _, entry := range addedEntries {
+		if !strings.Contains(strings.Join(entry.Specs, " "), "8080") {
+			t.Errorf("TestDiffIptEntries failed @ diffIptEntries. Unexpected added entry %+v", entry)
+		}
+	}
+
+	for _, entry := range removedEntries {
+		if !strings.Contains(strings.Join(entry.Specs, " "), "8000") {
+			t.Errorf("TestDiffIptEntries failed @ diffIptEntries. Unexpected removed entry %+v", entry)
+		}
+	}
+
+	if addedEntries, removedEntries := diffIptEntries(oldEntries, oldEntries); len(addedEntries) != 0 || len(removedEntries) != 0 {
+		t.Errorf("TestDiffIptEntries failed @ diffIptEntries. Expected no difference for the same policy")
+	}
+}
+
+func TestGetCidrIpsets(t *testing.T) {
+	ingressIPCidrs := [][]string{{"10.0.0.0/8", "10.1.0.0/16nomatch"}, nil, {"0.0.0.0/0"}}
+	cidrIpsets := getCidrIpsets("allow-cidr", "test-nwpolicy", ingressIPCidrs, nil)
+
+	expectedCidrIpsets := map[string][]string{
+		"allow-cidr-in-ns-test-nwpolicy-0in": {"10.0.0.0/8", "10.1.0.0/16nomatch"},
+		"allow-cidr-in-ns-test-nwpolicy-2in": {"1.0.0.0/1", "128.0.0.0/1"},
+	}
+	if !reflect.DeepEqual(cidrIpsets, expectedCidrIpsets) {
+		t.Errorf("TestGetCidrIpsets failed @ getCidrIpsets. Expected: %v, Actual: %v", expectedCidrIpsets, cidrIpsets)
+	}
+}
+
+func TestGetCidrIpsetsMixedFamilies(t *testing.T) {
+	defer func(ipv6Enabled bool) { util.IPv6Enabled = ipv6Enabled }(util.IPv6Enabled)
+
+	ingressIPCidrs := [][]string{{"fd00::/48", "10.0.0.0/8", "fd00:0:0:1::/64nomatch", "10.1.0.0/16nomatch"}, {"::/0"}, {"0.0.0.0/0", "::/0"}}
+
+	util.IPv6Enabled = false
+	cidrIpsets := getCidrIpsets("allow-cidr", "test-nwpolicy", ingressIPCidrs, nil)
+	expectedCidrIpsets := map[string][]string{
+		"allow-cidr-in-ns-test-nwpolicy-0in": {"10.0.0.0/8", "10.1.0.0/16nomatch"},
+		"allow-cidr-in-ns-test-nwpolicy-1in": nil,
+		"allow-cidr-in-ns-test-nwpolicy-2in": {"1.0.0.0/1", "128.0.0.0/1"},
+	}
+	if !reflect.DeepEqual(cidrIpsets, expectedCidrIpsets) {
+		t.Errorf("TestGetCidrIpsetsMixedFamilies failed @ getCidrIpsets with IPv6 disabled. Expected: %v, Actual: %v", expectedCidrIpsets, cidrIpsets)
+	}
+
+	util.IPv6Enabled = true
+	cidrIpsets = getCidrIpsets("allow-cidr", "test-nwpolicy", ingressIPCidrs, nil)
+	expectedCidrIpsets = map[string][]string{
+		"allow-cidr-in-ns-test-nwpolicy-0in": {"10.0.0.0/8", "10.1.0.0/16nomatch", "fd00::/48", "fd00:0:0:1::/64nomatch"},
+		"allow-cidr-in-ns-test-nwpolicy-1in": {"::/1", "8000::/1"},
+		"allow-cidr-in-ns-test-nwpolicy-2in": {"1.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"},
+	}
+	if !reflect.DeepEqual(cidrIpsets, expectedCidrIpsets) {
+		t.Errorf("TestGetCidrIpsetsMixedFamilies failed @ getCidrIpsets with IPv6 enabled. Expected: %v, Actual: %v", expectedCidrIpsets, cidrIpsets)
+	}
+}
+
 func TestDeleteNetworkPolicy(t *testing.T) {
 	npMgr := &NetworkPolicyManager{
 		NsMap:            make(map[string]*Namespace),
@@ -407,3 +522,59 @@ func TestGetNetworkPolicyKey(t *testing.T) {
 		t.Errorf("TestGetNetworkPolicyKey failed @ netpolKey did not match expected value %s", netpolKey)
 	}
 }
+
+func TestGetRuleOwners(t *testing.T) {
+	npMgr := &NetworkPolicyManager{}
+
+	denyAll := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deny-all",
+			Namespace: "test-nwpolicy",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+	allowFrontend := denyAll.DeepCopy()
+	allowFrontend.ObjectMeta.Name = "allow-frontend"
+	allowFrontend.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{{
+		From: []networkingv1.NetworkPolicyPeer{{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "frontend"},
+			},
+		}},
+	}}
+
+	// the keys of the rules of the policies, by comment.
+	ruleKeys := make(map[string]string)
+	for _, npObj := range []*networkingv1.NetworkPolicy{denyAll, allowFrontend} {
+		_, _, _, _, _, iptEntries := translatePolicy(npObj)
+		npMgr.setPolicyRules(npObj, iptEntries)
+		for _, entry := range iptEntries {
+			for i := 0; i+1 < len(entry.Specs); i++ {
+				if entry.Specs[i] == util.IptablesCommentFlag {
+					ruleKeys[entry.Specs[i+1]] = metrics.GetRuleKey(entry.Chain, entry.Specs)
+				}
+			}
+		}
+	}
+
+	ruleOwners := npMgr.getRuleOwners()
+	dropOwners := ruleOwners[ruleKeys["DROP-ALL-TO-ns-test-nwpolicy"]]
+	sort.Slice(dropOwners, func(i, j int) bool { return dropOwners[i].Name < dropOwners[j].Name })
+	expectedDropOwners := []metrics.PolicyRef{{Namespace: "test-nwpolicy", Name: "allow-frontend"}, {Namespace: "test-nwpolicy", Name: "deny-all"}}
+	if !reflect.DeepEqual(dropOwners, expectedDropOwners) {
+		t.Errorf("TestGetRuleOwners failed @ getRuleOwners. Expected the drop rule to be owned by both policies, got %v", ruleOwners)
+	}
+
+	allowKey := ruleKeys["ALLOW-app:frontend-IN-ns-test-nwpolicy-TO-ns-test-nwpolicy"]
+	allowOwners := ruleOwners[allowKey]
+	if !reflect.DeepEqual(allowOwners, []metrics.PolicyRef{{Namespace: "test-nwpolicy", Name: "allow-frontend"}}) {
+		t.Errorf("TestGetRuleOwners failed @ getRuleOwners. Expected the allow rule to be owned by allow-frontend, got %v", ruleOwners)
+	}
+
+	delete(npMgr.policyRules, getPolicyRef(allowFrontend))
+	if _, exists := npMgr.getRuleOwners()[allowKey]; exists {
+		t.Errorf("TestGetRuleOwners failed @ getRuleOwners. Rules of deleted policies must not have owners")
+	}
+}
@@ -3,14 +3,18 @@
 package main
 
 import (
+	"flag"
 	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/npm"
 	restserver "github.com/Azure/azure-container-networking/npm/http/server"
 	"github.com/Azure/azure-container-networking/npm/metrics"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"github.com/Azure/azure-container-networking/npm/util"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -19,11 +23,19 @@ import (
 const (
 	waitForTelemetryInSeconds = 60
 	resyncPeriodInMinutes     = 15
+	// the default termination grace period of a pod is 30 seconds.
+	shutdownTimeoutInSeconds = 20
 )
 
 // Version is populated by make during build.
 var version string
 
+var dataplaneMode = flag.String("dataplane", util.DataplaneIptables, "Dataplane programming network policies: iptables or nftables")
+
+var enableIPv6 = flag.Bool("ipv6", false, "Enforce network policies on IPv6 pod addresses and ipBlocks with ip6tables")
+
+var uninstall = flag.Bool("uninstall", false, "Remove the NPM chains, ipsets and nftables table from the node, then exit. NPM must not run on the node")
+
 func initLogging() error {
 	log.SetName("azure-npm")
 	log.SetLevel(log.LevelInfo)
@@ -44,12 +56,33 @@ func main() {
 		}
 	}()
 
+	flag.Parse()
+
 	if err = initLogging(); err != nil {
 		panic(err.Error())
 	}
 
 	metrics.InitializeAll()
 
+	if err = util.SetDataplaneMode(*dataplaneMode); err != nil {
+		log.Logf("Invalid dataplane, err:%v.", err)
+		panic(err.Error())
+	}
+
+	if err = util.SetIPv6Mode(*enableIPv6); err != nil {
+		log.Logf("Invalid IPv6 mode, err:%v.", err)
+		panic(err.Error())
+	}
+
+	if *uninstall {
+		report := npm.Uninstall()
+		log.Logf("[INFO] Uninstalled NPM: %s", report)
+		if !report.Clean() {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Creates the in-cluster config
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -76,13 +109,32 @@ func main() {
 	npMgr := npm.NewNetworkPolicyManager(clientset, factory, version)
 	metrics.CreateTelemetryHandle(npMgr.GetAppVersion(), npm.GetAIMetadata())
 
+	if err = npMgr.SetDropLoggingMode(os.Getenv(util.DropLoggingEnv)); err != nil {
+		log.Logf("Dropped packets logging is disabled, err:%v.", err)
+	}
+
 	restserver := restserver.NewNpmRestServer(restserver.DefaultHTTPListeningAddress)
 	go restserver.NPMRestServerListenAndServe(npMgr)
 
-	if err = npMgr.Start(wait.NeverStop); err != nil {
+	// the signals are caught before Start, so the informers never run without a way to stop them.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	stopCh := make(chan struct{})
+	if err = npMgr.Start(stopCh); err != nil {
 		log.Logf("npm failed with error %v.", err)
 		panic(err.Error)
 	}
 
-	select {}
+	sig := <-signals
+	log.Logf("[INFO] Received %v, stopping the informers and draining the event queues.", sig)
+	close(stopCh)
+
+	// the chains and ipsets are left programmed, the next NPM reconciles them.
+	if err = npMgr.WaitForEventWorkers(shutdownTimeoutInSeconds * time.Second); err != nil {
+		log.Logf("[INFO] Shutting down with pending events, err:%v.", err)
+	}
+
+	log.Logf("[INFO] NPM stopped.")
+	log.Close()
 }
@@ -7,7 +7,7 @@ import (
 	"reflect"
 
 	"github.com/Azure/azure-container-networking/log"
-	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/dataplane"
 	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
 
@@ -50,6 +50,49 @@ func newNpmPod(podObj *corev1.Pod) (*NpmPod, error) {
 	return pod, nil
 }
 
+// getNpmPodIPs returns the ips of a cached pod, the ones of dual-stack pods included.
+func getNpmPodIPs(npmPodObj *NpmPod) []string {
+	ips := []string{npmPodObj.PodIP}
+	for _, podIP := range npmPodObj.PodIPs {
+		if podIP.IP != npmPodObj.PodIP {
+			ips = append(ips, podIP.IP)
+		}
+	}
+
+	return ips
+}
+
+// setNpmPod caches a pod under podKey and indexes it by its ips. npMgr.podLock must be held.
+func (npMgr *NetworkPolicyManager) setNpmPod(podKey string, npmPodObj *NpmPod) {
+	npMgr.deleteNpmPod(podKey)
+
+	if npMgr.podKeysByIP == nil {
+		npMgr.podKeysByIP = make(map[string]string)
+	}
+
+	npMgr.PodMap[podKey] = npmPodObj
+	for _, podIP := range getNpmPodIPs(npmPodObj) {
+		if podIP != "" {
+			npMgr.podKeysByIP[podIP] = podKey
+		}
+	}
+}
+
+// deleteNpmPod removes a pod from the cache and its ips from the index, unless a newer pod has them. npMgr.podLock must be held.
+func (npMgr *NetworkPolicyManager) deleteNpmPod(podKey string) {
+	npmPodObj, exists := npMgr.PodMap[podKey]
+	if !exists {
+		return
+	}
+
+	for _, podIP := range getNpmPodIPs(npmPodObj) {
+		if npMgr.podKeysByIP[podIP] == podKey {
+			delete(npMgr.podKeysByIP, podIP)
+		}
+	}
+	delete(npMgr.PodMap, podKey)
+}
+
 func getPodObjFromNpmObj(npmPodObj *NpmPod) *corev1.Pod {
 	return &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -111,47 +154,57 @@ func getContainerPortList(podObj *corev1.Pod) []v1.ContainerPort {
 	return portList
 }
 
-// appendNamedPortIpsets helps with adding or deleting Pod namedPort IPsets
-func appendNamedPortIpsets(ipsMgr *ipsm.IpsetManager, portList []v1.ContainerPort, podUID string, podIP string, delete bool) error {
+// getPodIPs returns the ips of a pod to program, from Status.PodIPs of dual-stack pods or Status.PodIP.
+// IPv6 ips are skipped unless IPv6 is enabled.
+func getPodIPs(podObj *corev1.Pod) []string {
+	var podIPs []string
+	for _, podIP := range podObj.Status.PodIPs {
+		if podIP.IP != "" && (util.IPv6Enabled || !util.IsIPv6(podIP.IP)) {
+			podIPs = append(podIPs, podIP.IP)
+		}
+	}
+
+	if len(podIPs) == 0 && podObj.Status.PodIP != "" && (util.IPv6Enabled || !util.IsIPv6(podObj.Status.PodIP)) {
+		podIPs = append(podIPs, podObj.Status.PodIP)
+	}
+
+	return podIPs
+}
+
+// getNamedPortIpsetEntry returns the named port ipset of a container port and the pod's entry in it.
+func getNamedPortIpsetEntry(podIP string, port v1.ContainerPort) (string, string) {
+	protocol := ""
+
+	switch port.Protocol {
+	case v1.ProtocolUDP:
+		protocol = util.IpsetUDPFlag
+	case v1.ProtocolSCTP:
+		protocol = util.IpsetSCTPFlag
+	case v1.ProtocolTCP:
+		protocol = util.IpsetTCPFlag
+	}
+
+	return util.NamedPortIPSetPrefix + port.Name, fmt.Sprintf("%s,%s%d", podIP, protocol, port.ContainerPort)
+}
+
+// appendNamedPortIpsets helps with staging the addition or deletion of Pod namedPort IPsets
+func appendNamedPortIpsets(tx dataplane.IpsetTransaction, portList []v1.ContainerPort, podUID string, podIP string, delete bool) {
 
 	for _, port := range portList {
 		if port.Name == "" {
 			continue
 		}
 
-		protocol := ""
-
-		switch port.Protocol {
-		case v1.ProtocolUDP:
-			protocol = util.IpsetUDPFlag
-		case v1.ProtocolSCTP:
-			protocol = util.IpsetSCTPFlag
-		case v1.ProtocolTCP:
-			protocol = util.IpsetTCPFlag
-		}
-
-		namedPortname := util.NamedPortIPSetPrefix + port.Name
+		namedPortname, entry := getNamedPortIpsetEntry(podIP, port)
 
 		if delete {
 			// Delete the pod's named ports from its ipset.
-			ipsMgr.DeleteFromSet(
-				namedPortname,
-				fmt.Sprintf("%s,%s%d", podIP, protocol, port.ContainerPort),
-				podUID,
-			)
+			tx.DeleteFromSet(namedPortname, entry, podUID)
 			continue
 		}
 		// Add the pod's named ports to its ipset.
-		ipsMgr.AddToSet(
-			namedPortname,
-			fmt.Sprintf("%s,%s%d", podIP, protocol, port.ContainerPort),
-			util.IpsetIPPortHashFlag,
-			podUID,
-		)
-
+		tx.AddToSet(namedPortname, entry, util.IpsetIPPortHashFlag, podUID)
 	}
-
-	return nil
 }
 
 // GetPodKey will return podKey
@@ -198,8 +251,9 @@ func (npMgr *NetworkPolicyManager) AddPod(podObj *corev1.Pod) error {
 		podNodeName       = npmPodObj.NodeName
 		podLabels         = npmPodObj.Labels
 		podIP             = npmPodObj.PodIP
+		podIPs            = getPodIPs(podObj)
 		podContainerPorts = npmPodObj.ContainerPorts
-		ipsMgr            = npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
+		ipsMgr            = npMgr.getAllNs().IpsMgr
 	)
 
 	log.Logf("POD CREATING: [%s%s/%s/%s%+v%s]", podUID, podNs, podName, podNodeName, podLabels, podIP)
@@ -211,50 +265,40 @@ func (npMgr *NetworkPolicyManager) AddPod(podObj *corev1.Pod) error {
 	}
 
 	// Add pod namespace if it doesn't exist
-	if _, exists := npMgr.NsMap[podNs]; !exists {
-		npMgr.NsMap[podNs], err = newNs(podNs)
-		if err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: failed to create namespace %s with err: %v", podNs, err)
-			return err
-		}
-		log.Logf("Creating set: %v, hashedSet: %v", podNs, util.GetHashedName(podNs))
-		if err = ipsMgr.CreateSet(podNs, append([]string{util.IpsetNetHashFlag})); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: creating ipset %s with err: %v", podNs, err)
-			return err
-		}
-	}
-
-	// Add the pod to its namespace's ipset.
-	log.Logf("Adding pod %s to ipset %s", podIP, podNs)
-	if err = ipsMgr.AddToSet(podNs, podIP, util.IpsetNetHashFlag, podUID); err != nil {
-		metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: failed to add pod to namespace ipset with err: %v", err)
+	if _, err = npMgr.getOrCreateNs(podNs); err != nil {
+		metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: failed to create namespace %s with err: %v", podNs, err)
 		return err
 	}
 
-	// Add the pod to its label's ipset.
-	for podLabelKey, podLabelVal := range podLabels {
-		log.Logf("Adding pod %s to ipset %s", podIP, podLabelKey)
-		if err = ipsMgr.AddToSet(podLabelKey, podIP, util.IpsetNetHashFlag, podUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: failed to add pod to label ipset with err: %v", err)
-			return err
-		}
+	// All the pod's ipset operations are applied with a single ipset restore.
+	tx := ipsMgr.NewTransaction()
 
-		label := podLabelKey + ":" + podLabelVal
-		log.Logf("Adding pod %s to ipset %s", podIP, label)
-		if err = ipsMgr.AddToSet(label, podIP, util.IpsetNetHashFlag, podUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: failed to add pod to label ipset with err: %v", err)
-			return err
+	for _, podIP := range podIPs {
+		// Add the pod to its namespace's ipset.
+		log.Logf("Adding pod %s to ipset %s", podIP, podNs)
+		tx.AddToSet(podNs, podIP, util.IpsetNetHashFlag, podUID)
+
+		// Add the pod to its label's ipset.
+		for podLabelKey, podLabelVal := range podLabels {
+			log.Logf("Adding pod %s to ipset %s", podIP, podLabelKey)
+			tx.AddToSet(podLabelKey, podIP, util.IpsetNetHashFlag, podUID)
+
+			label := podLabelKey + ":" + podLabelVal
+			log.Logf("Adding pod %s to ipset %s", podIP, label)
+			tx.AddToSet(label, podIP, util.IpsetNetHashFlag, podUID)
 		}
+
+		// Add pod's named ports from its ipset.
+		appendNamedPortIpsets(tx, podContainerPorts, podUID, podIP, false)
 	}
 
-	// Add pod's named ports from its ipset.
-	if err = appendNamedPortIpsets(ipsMgr, podContainerPorts, podUID, podIP, false); err != nil {
-		metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: failed to add pod to namespace ipset with err: %v", err)
-		return err
+	if failures := tx.Commit(); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.PodID, "[AddPod] Error: failed to add pod to %d ipsets, first err: %v", len(failures), failures[0])
+		return failures[0]
 	}
 
 	// add the Pod info to the podMap
-	npMgr.PodMap[podKey] = npmPodObj
+	npMgr.setNpmPod(podKey, npmPodObj)
 
 	return nil
 }
@@ -283,7 +327,7 @@ func (npMgr *NetworkPolicyManager) UpdatePod(newPodObj *corev1.Pod) error {
 		newPodObjLabel = newPodObj.ObjectMeta.Labels
 		newPodObjPhase = newPodObj.Status.Phase
 		newPodObjIP    = newPodObj.Status.PodIP
-		ipsMgr         = npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
+		ipsMgr         = npMgr.getAllNs().IpsMgr
 	)
 
 	if podKey == "" {
@@ -293,9 +337,8 @@ func (npMgr *NetworkPolicyManager) UpdatePod(newPodObj *corev1.Pod) error {
 	}
 
 	// Add pod namespace if it doesn't exist
-	if _, exists := npMgr.NsMap[newPodObjNs]; !exists {
-		npMgr.NsMap[newPodObjNs], err = newNs(newPodObjNs)
-		if err != nil {
+	if _, exists := npMgr.getNs(newPodObjNs); !exists {
+		if _, err = npMgr.getOrCreateNs(newPodObjNs); err != nil {
 			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to create namespace %s with err: %v", newPodObjNs, err)
 			return err
 		}
@@ -344,7 +387,8 @@ func (npMgr *NetworkPolicyManager) UpdatePod(newPodObj *corev1.Pod) error {
 	}
 
 	var (
-		cachedPodIP  = cachedPodObj.PodIP
+		cachedPodIPs = getPodIPs(getPodObjFromNpmObj(cachedPodObj))
+		newPodIPs    = getPodIPs(newPodObj)
 		cachedLabels = cachedPodObj.Labels
 	)
 
@@ -358,9 +402,9 @@ func (npMgr *NetworkPolicyManager) UpdatePod(newPodObj *corev1.Pod) error {
 	addToIPSets := []string{}
 
 	// if the podIp exists, it must match the cachedIp
-	if cachedPodIP != newPodObjIP {
-		metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Info: Unexpected state. Pod (Namespace:%s, Name:%s, uid:%s, has cachedPodIp:%s which is different from PodIp:%s",
-			newPodObjNs, newPodObjName, cachedPodObj.PodUID, cachedPodIP, newPodObjIP)
+	if !reflect.DeepEqual(cachedPodIPs, newPodIPs) {
+		metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Info: Unexpected state. Pod (Namespace:%s, Name:%s, uid:%s, has cachedPodIps:%v which are different from PodIps:%v",
+			newPodObjNs, newPodObjName, cachedPodObj.PodUID, cachedPodIPs, newPodIPs)
 		// cached PodIP needs to be cleaned up from all the cached labels
 		deleteFromIPSets = util.GetIPSetListFromLabels(cachedLabels)
 
@@ -370,21 +414,25 @@ func (npMgr *NetworkPolicyManager) UpdatePod(newPodObj *corev1.Pod) error {
 		addToIPSets = util.GetIPSetListFromLabels(newPodObjLabel)
 
 		// Delete the pod from its namespace's ipset.
-		log.Logf("Deleting pod %s %s from ipset %s and adding pod %s to ipset %s",
+		log.Logf("Deleting pod %s %v from ipset %s and adding pod %v to ipset %s",
 			cachedPodObj.PodUID,
-			cachedPodIP,
+			cachedPodIPs,
 			cachedPodObj.Namespace,
-			newPodObjIP,
+			newPodIPs,
 			newPodObjNs,
 		)
-		if err = ipsMgr.DeleteFromSet(cachedPodObj.Namespace, cachedPodIP, cachedPodObj.PodUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to delete pod from namespace ipset with err: %v", err)
-			return err
+		for _, cachedPodIP := range cachedPodIPs {
+			if err = ipsMgr.DeleteFromSet(cachedPodObj.Namespace, cachedPodIP, cachedPodObj.PodUID); err != nil {
+				metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to delete pod from namespace ipset with err: %v", err)
+				return err
+			}
 		}
 		// Add the pod to its namespace's ipset.
-		if err = ipsMgr.AddToSet(newPodObjNs, newPodObjIP, util.IpsetNetHashFlag, cachedPodObj.PodUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to add pod to namespace ipset with err: %v", err)
-			return err
+		for _, newPodIP := range newPodIPs {
+			if err = ipsMgr.AddToSet(newPodObjNs, newPodIP, util.IpsetNetHashFlag, cachedPodObj.PodUID); err != nil {
+				metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to add pod to namespace ipset with err: %v", err)
+				return err
+			}
 		}
 	} else {
 		//if no change in labels then return
@@ -401,19 +449,23 @@ func (npMgr *NetworkPolicyManager) UpdatePod(newPodObj *corev1.Pod) error {
 
 	// Delete the pod from its label's ipset.
 	for _, podIPSetName := range deleteFromIPSets {
-		log.Logf("Deleting pod %s from ipset %s", cachedPodIP, podIPSetName)
-		if err = ipsMgr.DeleteFromSet(podIPSetName, cachedPodIP, cachedPodObj.PodUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to delete pod from label ipset with err: %v", err)
-			return err
+		for _, cachedPodIP := range cachedPodIPs {
+			log.Logf("Deleting pod %s from ipset %s", cachedPodIP, podIPSetName)
+			if err = ipsMgr.DeleteFromSet(podIPSetName, cachedPodIP, cachedPodObj.PodUID); err != nil {
+				metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to delete pod from label ipset with err: %v", err)
+				return err
+			}
 		}
 	}
 
 	// Add the pod to its label's ipset.
 	for _, addIPSetName := range addToIPSets {
-		log.Logf("Adding pod %s to ipset %s", newPodObjIP, addIPSetName)
-		if err = ipsMgr.AddToSet(addIPSetName, newPodObjIP, util.IpsetNetHashFlag, cachedPodObj.PodUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to add pod to label ipset with err: %v", err)
-			return err
+		for _, newPodIP := range newPodIPs {
+			log.Logf("Adding pod %s to ipset %s", newPodIP, addIPSetName)
+			if err = ipsMgr.AddToSet(addIPSetName, newPodIP, util.IpsetNetHashFlag, cachedPodObj.PodUID); err != nil {
+				metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to add pod to label ipset with err: %v", err)
+				return err
+			}
 		}
 	}
 
@@ -422,23 +474,27 @@ func (npMgr *NetworkPolicyManager) UpdatePod(newPodObj *corev1.Pod) error {
 	// so keeping this simple by deleting all and re-adding
 	newPodPorts := getContainerPortList(newPodObj)
 	if !reflect.DeepEqual(cachedPodObj.ContainerPorts, newPodPorts) {
+		tx := ipsMgr.NewTransaction()
 		// Delete cached pod's named ports from its ipset.
-		if err = appendNamedPortIpsets(ipsMgr, cachedPodObj.ContainerPorts, cachedPodObj.PodUID, cachedPodIP, true); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to delete pod from namespace ipset with err: %v", err)
-			return err
+		for _, cachedPodIP := range cachedPodIPs {
+			appendNamedPortIpsets(tx, cachedPodObj.ContainerPorts, cachedPodObj.PodUID, cachedPodIP, true)
 		}
 		// Add new pod's named ports from its ipset.
-		if err = appendNamedPortIpsets(ipsMgr, newPodPorts, cachedPodObj.PodUID, newPodObjIP, false); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to add pod to namespace ipset with err: %v", err)
-			return err
+		for _, newPodIP := range newPodIPs {
+			appendNamedPortIpsets(tx, newPodPorts, cachedPodObj.PodUID, newPodIP, false)
+		}
+		if failures := tx.Commit(); len(failures) > 0 {
+			metrics.SendErrorLogAndMetric(util.PodID, "[UpdatePod] Error: failed to update pod named port ipsets, first err: %v", failures[0])
+			return failures[0]
 		}
 	}
 
 	// Updating pod cache with new information
-	npMgr.PodMap[podKey], err = newNpmPod(newPodObj)
+	newNpmPodObj, err := newNpmPod(newPodObj)
 	if err != nil {
 		return err
 	}
+	npMgr.setNpmPod(podKey, newNpmPodObj)
 
 	return nil
 }
@@ -455,7 +511,7 @@ func (npMgr *NetworkPolicyManager) DeletePod(podObj *corev1.Pod) error {
 		podKey      = GetPodKey(podObj)
 		podName     = podObj.ObjectMeta.Name
 		podNodeName = podObj.Spec.NodeName
-		ipsMgr      = npMgr.NsMap[util.KubeAllNamespacesFlag].IpsMgr
+		ipsMgr      = npMgr.getAllNs().IpsMgr
 		podUID      = string(podObj.ObjectMeta.UID)
 	)
 
@@ -471,6 +527,7 @@ func (npMgr *NetworkPolicyManager) DeletePod(podObj *corev1.Pod) error {
 	}
 	var (
 		cachedPodIP    = cachedPodObj.PodIP
+		cachedPodIPs   = getPodIPs(getPodObjFromNpmObj(cachedPodObj))
 		podLabels      = cachedPodObj.Labels
 		containerPorts = cachedPodObj.ContainerPorts
 	)
@@ -483,35 +540,33 @@ func (npMgr *NetworkPolicyManager) DeletePod(podObj *corev1.Pod) error {
 
 	log.Logf("POD DELETING: [%s/%s%s/%s%+v%s%+v]", podNs, podName, podUID, podNodeName, podLabels, cachedPodIP, podLabels)
 
-	// Delete the pod from its namespace's ipset.
-	if err = ipsMgr.DeleteFromSet(podNs, cachedPodIP, podUID); err != nil {
-		metrics.SendErrorLogAndMetric(util.PodID, "[DeletePod] Error: failed to delete pod from namespace ipset with err: %v", err)
-		return err
-	}
+	// All the pod's ipset operations are applied with a single ipset restore.
+	tx := ipsMgr.NewTransaction()
 
-	// Delete the pod from its label's ipset.
-	for podLabelKey, podLabelVal := range podLabels {
-		log.Logf("Deleting pod %s from ipset %s", cachedPodIP, podLabelKey)
-		if err = ipsMgr.DeleteFromSet(podLabelKey, cachedPodIP, podUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[DeletePod] Error: failed to delete pod from label ipset with err: %v", err)
-			return err
-		}
+	for _, cachedPodIP := range cachedPodIPs {
+		// Delete the pod from its namespace's ipset.
+		tx.DeleteFromSet(podNs, cachedPodIP, podUID)
 
-		label := podLabelKey + ":" + podLabelVal
-		log.Logf("Deleting pod %s from ipset %s", cachedPodIP, label)
-		if err = ipsMgr.DeleteFromSet(label, cachedPodIP, podUID); err != nil {
-			metrics.SendErrorLogAndMetric(util.PodID, "[DeletePod] Error: failed to delete pod from label ipset with err: %v", err)
-			return err
+		// Delete the pod from its label's ipset.
+		for podLabelKey, podLabelVal := range podLabels {
+			log.Logf("Deleting pod %s from ipset %s", cachedPodIP, podLabelKey)
+			tx.DeleteFromSet(podLabelKey, cachedPodIP, podUID)
+
+			label := podLabelKey + ":" + podLabelVal
+			log.Logf("Deleting pod %s from ipset %s", cachedPodIP, label)
+			tx.DeleteFromSet(label, cachedPodIP, podUID)
 		}
+
+		// Delete pod's named ports from its ipset. Delete is TRUE
+		appendNamedPortIpsets(tx, containerPorts, podUID, cachedPodIP, true)
 	}
 
-	// Delete pod's named ports from its ipset. Delete is TRUE
-	if err = appendNamedPortIpsets(ipsMgr, containerPorts, podUID, cachedPodIP, true); err != nil {
-		metrics.SendErrorLogAndMetric(util.PodID, "[DeletePod] Error: failed to delete pod from namespace ipset with err: %v", err)
-		return err
+	if failures := tx.Commit(); len(failures) > 0 {
+		metrics.SendErrorLogAndMetric(util.PodID, "[DeletePod] Error: failed to delete pod from %d ipsets, first err: %v", len(failures), failures[0])
+		return failures[0]
 	}
 
-	delete(npMgr.PodMap, podKey)
+	npMgr.deleteNpmPod(podKey)
 
 	return nil
 }
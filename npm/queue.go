@@ -0,0 +1,230 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	podQueueName           = "pod"
+	namespaceQueueName     = "namespace"
+	networkPolicyQueueName = "networkpolicy"
+
+	// an event failing more often is dropped until the object changes again.
+	maxEventRetries = 5
+)
+
+// newEventQueue creates a rate limited queue of object keys. A key added again before a worker gets it is only processed once,
+// and failed keys are added back with an exponential backoff.
+func newEventQueue(name string) workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name)
+}
+
+// runEventWorkers processes the event queues until stopCh is closed. Every queue has its own worker and lock,
+// so the informers never wait for the dataplane and a failing or slow object only delays the events of its kind.
+// Once stopCh is closed, the workers still process the events already queued, see WaitForEventWorkers.
+func (npMgr *NetworkPolicyManager) runEventWorkers(stopCh <-chan struct{}) {
+	npMgr.eventWorkers.Add(3)
+	go npMgr.runEventWorker(npMgr.nsQueue, &npMgr.nsLock, npMgr.syncNamespace)
+	go npMgr.runEventWorker(npMgr.podQueue, &npMgr.podLock, npMgr.syncPod)
+	go npMgr.runEventWorker(npMgr.npQueue, &npMgr.npLock, npMgr.syncNetworkPolicy)
+
+	go func() {
+		<-stopCh
+		npMgr.nsQueue.ShutDown()
+		npMgr.podQueue.ShutDown()
+		npMgr.npQueue.ShutDown()
+	}()
+}
+
+// runEventWorker processes the keys of queue with sync holding lock until the queue is shut down.
+func (npMgr *NetworkPolicyManager) runEventWorker(queue workqueue.RateLimitingInterface, lock sync.Locker, sync func(key string) error) {
+	defer npMgr.eventWorkers.Done()
+	for npMgr.processNextEvent(queue, lock, sync) {
+	}
+}
+
+// WaitForEventWorkers waits up to timeout for the event workers to drain their queues once the stopCh given to Start is closed.
+// Failed events aren't retried anymore, the next NPM reconciles the dataplane with the informer caches anyway.
+func (npMgr *NetworkPolicyManager) WaitForEventWorkers(timeout time.Duration) error {
+	drained := make(chan struct{})
+	go func() {
+		npMgr.eventWorkers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("event queues not drained after %v, %d namespace, %d pod and %d network policy events left",
+			timeout, npMgr.nsQueue.Len(), npMgr.podQueue.Len(), npMgr.npQueue.Len())
+	}
+}
+
+// processNextEvent waits for the next key of queue and syncs it holding the lock of its kind, it returns false once the queue is shut down.
+func (npMgr *NetworkPolicyManager) processNextEvent(queue workqueue.RateLimitingInterface, lock sync.Locker, sync func(key string) error) bool {
+	key, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(key)
+
+	lock.Lock()
+	err := sync(key.(string))
+	lock.Unlock()
+
+	if err == nil {
+		queue.Forget(key)
+		return true
+	}
+
+	if queue.NumRequeues(key) < maxEventRetries {
+		log.Logf("Retrying %s after err: %v", key, err)
+		queue.AddRateLimited(key)
+		return true
+	}
+
+	queue.Forget(key)
+	metrics.SendErrorLogAndMetric(util.NpmID, "Error: dropping %s after %d retries with err: %v", key, maxEventRetries, err)
+
+	var applyErr *policyApplyError
+	if errors.As(err, &applyErr) {
+		metrics.IncPolicyApplyFailures(applyErr.ns, applyErr.reason)
+	}
+
+	return true
+}
+
+// enqueuePod adds the key of podObj to the pod queue.
+func (npMgr *NetworkPolicyManager) enqueuePod(podObj *corev1.Pod) {
+	if podKey := GetPodKey(podObj); podKey != "" {
+		npMgr.podQueue.Add(podKey)
+	}
+}
+
+// enqueueNamespace adds the name of nsObj to the namespace queue.
+func (npMgr *NetworkPolicyManager) enqueueNamespace(nsObj *corev1.Namespace) {
+	npMgr.nsQueue.Add(nsObj.ObjectMeta.Name)
+}
+
+// enqueueNetworkPolicy adds the <nsname>/<policyname> key of npObj to the network policy queue.
+func (npMgr *NetworkPolicyManager) enqueueNetworkPolicy(obj interface{}) {
+	npKey, err := util.GetObjKeyFunc(obj)
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[enqueueNetworkPolicy] Error: while running MetaNamespaceKeyFunc err: %s", err)
+		return
+	}
+	npMgr.npQueue.Add(npKey)
+}
+
+// syncPod programs the pod of podKey like it is in the pod informer cache, or deletes it when the cache doesn't hold it anymore.
+// A pod recreated with the same name has another UID, so it has its own key. npMgr.podLock must be held.
+func (npMgr *NetworkPolicyManager) syncPod(podKey string) error {
+	ns, name, uid, err := splitPodKey(podKey)
+	if err != nil {
+		// retrying doesn't fix the key.
+		metrics.SendErrorLogAndMetric(util.PodID, "[syncPod] Error: %v", err)
+		return nil
+	}
+
+	podObj, err := npMgr.podInformer.Lister().Pods(ns).Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	if err == nil && string(podObj.ObjectMeta.UID) == uid {
+		return npMgr.UpdatePod(podObj)
+	}
+
+	cachedPodObj, exists := npMgr.PodMap[podKey]
+	if !exists {
+		return nil
+	}
+
+	return npMgr.DeletePod(getPodObjFromNpmObj(cachedPodObj))
+}
+
+// syncNamespace programs the namespace like it is in the namespace informer cache, or deletes it when the cache doesn't hold it anymore.
+// npMgr.nsLock must be held.
+func (npMgr *NetworkPolicyManager) syncNamespace(name string) error {
+	nsObj, err := npMgr.nsInformer.Lister().Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	cachedNsObj, exists := npMgr.getNs(util.GetNSNameWithPrefix(name))
+	if err != nil {
+		if !exists {
+			return nil
+		}
+		return npMgr.DeleteNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+
+	if !exists {
+		return npMgr.AddNamespace(nsObj)
+	}
+
+	// the cached labels are what is programmed.
+	programmedNsObj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: cachedNsObj.LabelsMap,
+		},
+	}
+
+	return npMgr.UpdateNamespace(programmedNsObj, nsObj)
+}
+
+// syncNetworkPolicy programs the network policy of npKey like it is in the network policy informer cache,
+// or deletes it when the cache doesn't hold it anymore. npMgr.npLock must be held.
+func (npMgr *NetworkPolicyManager) syncNetworkPolicy(npKey string) error {
+	ns, name, err := cache.SplitMetaNamespaceKey(npKey)
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NetpolID, "[syncNetworkPolicy] Error: %v", err)
+		return nil
+	}
+
+	npObj, err := npMgr.npInformer.Lister().NetworkPolicies(ns).Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	cachedNpObj, exists := npMgr.RawNpMap[util.GetNSNameWithPrefix(npKey)]
+	if err != nil {
+		if !exists {
+			return nil
+		}
+		return npMgr.DeleteNetworkPolicy(cachedNpObj)
+	}
+
+	if !exists {
+		return npMgr.AddNetworkPolicy(npObj)
+	}
+
+	return npMgr.UpdateNetworkPolicy(cachedNpObj, npObj)
+}
+
+// splitPodKey returns the namespace, name and UID of a ns-<nsname>/<podname>/<poduuid> key.
+func splitPodKey(podKey string) (string, string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(podKey, util.NamespacePrefix), "/")
+	if !strings.HasPrefix(podKey, util.NamespacePrefix) || len(parts) != 3 {
+		return "", "", "", fmt.Errorf("unexpected pod key format: %q", podKey)
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
@@ -0,0 +1,299 @@
+package npm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/fakes"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/metrics/promutil"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestEventQueues(t *testing.T) {
+	fake := fakes.NewDataplaneFake()
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return fake, fake
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(nil, 0)
+	npMgr := &NetworkPolicyManager{
+		podInformer:                  informerFactory.Core().V1().Pods(),
+		nsInformer:                   informerFactory.Core().V1().Namespaces(),
+		npInformer:                   informerFactory.Networking().V1().NetworkPolicies(),
+		podQueue:                     newEventQueue(podQueueName),
+		nsQueue:                      newEventQueue(namespaceQueueName),
+		npQueue:                      newEventQueue(networkPolicyQueueName),
+		NsMap:                        make(map[string]*Namespace),
+		PodMap:                       make(map[string]*NpmPod),
+		RawNpMap:                     make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap:               make(map[string]*networkingv1.NetworkPolicy),
+		isSafeToCleanUpAzureNpmChain: true,
+	}
+	defer npMgr.podQueue.ShutDown()
+	defer npMgr.nsQueue.ShutDown()
+	defer npMgr.npQueue.ShutDown()
+
+	allNs, _ := newNs(util.KubeAllNamespacesFlag)
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	nsObj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-ns",
+			Labels:          map[string]string{"app": "test"},
+			ResourceVersion: "1",
+		},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-pod",
+			Namespace:       "test-ns",
+			UID:             "12345",
+			Labels:          map[string]string{"app": "frontend"},
+			ResourceVersion: "1",
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: "10.0.0.1",
+		},
+	}
+	npObj, err := readPolicyYaml("testpolicies/deny-all-policy.yaml")
+	if err != nil {
+		t.Fatalf("TestEventQueues failed @ readPolicyYaml: %v", err)
+	}
+
+	npMgr.nsInformer.Informer().GetIndexer().Add(nsObj)
+	npMgr.podInformer.Informer().GetIndexer().Add(podObj)
+	npMgr.npInformer.Informer().GetIndexer().Add(npObj)
+
+	// events of the same object are deduplicated until a worker gets its key.
+	npMgr.enqueueNamespace(nsObj)
+	npMgr.enqueueNamespace(nsObj)
+	npMgr.enqueuePod(podObj)
+	npMgr.enqueuePod(podObj)
+	npMgr.enqueueNetworkPolicy(npObj)
+	if npMgr.nsQueue.Len() != 1 || npMgr.podQueue.Len() != 1 || npMgr.npQueue.Len() != 1 {
+		t.Fatalf("TestEventQueues failed @ enqueue, expected one key per object")
+	}
+
+	npMgr.processNextEvent(npMgr.nsQueue, &npMgr.nsLock, npMgr.syncNamespace)
+	npMgr.processNextEvent(npMgr.podQueue, &npMgr.podLock, npMgr.syncPod)
+	npMgr.processNextEvent(npMgr.npQueue, &npMgr.npLock, npMgr.syncNetworkPolicy)
+
+	if !fake.Exists(util.KubeAllNamespacesFlag, "ns-test-ns", util.IpsetSetListFlag) || !fake.Exists("ns-app:test", "ns-test-ns", util.IpsetSetListFlag) {
+		t.Errorf("TestEventQueues failed @ syncNamespace, expected the namespace in its lists. Lists: %v", fake.Lists)
+	}
+
+	if !fake.Exists("ns-test-ns", "10.0.0.1", util.IpsetNetHashFlag) || !fake.Exists("app:frontend", "10.0.0.1", util.IpsetNetHashFlag) {
+		t.Errorf("TestEventQueues failed @ syncPod, expected the pod in its sets. Sets: %v", fake.Sets)
+	}
+
+	if _, exists := npMgr.RawNpMap[GetNetworkPolicyKey(npObj)]; !exists || !fake.ChainsInitialized {
+		t.Errorf("TestEventQueues failed @ syncNetworkPolicy, expected the network policy in the chains")
+	}
+
+	// an update is read from the informer cache when its key is processed.
+	newNsObj := nsObj.DeepCopy()
+	newNsObj.ObjectMeta.Labels = map[string]string{"app": "new"}
+	newNsObj.ObjectMeta.ResourceVersion = "2"
+	npMgr.nsInformer.Informer().GetIndexer().Update(newNsObj)
+	npMgr.enqueueNamespace(newNsObj)
+	npMgr.processNextEvent(npMgr.nsQueue, &npMgr.nsLock, npMgr.syncNamespace)
+
+	if fake.Exists("ns-app:test", "ns-test-ns", util.IpsetSetListFlag) || !fake.Exists("ns-app:new", "ns-test-ns", util.IpsetSetListFlag) {
+		t.Errorf("TestEventQueues failed @ syncNamespace, expected the namespace to move to its new label list. Lists: %v", fake.Lists)
+	}
+
+	// a pod recreated with the same name has another key.
+	newPodObj := podObj.DeepCopy()
+	newPodObj.ObjectMeta.UID = "67890"
+	newPodObj.Status.PodIP = "10.0.0.2"
+	npMgr.podInformer.Informer().GetIndexer().Update(newPodObj)
+	npMgr.enqueuePod(podObj)
+	npMgr.enqueuePod(newPodObj)
+	npMgr.processNextEvent(npMgr.podQueue, &npMgr.podLock, npMgr.syncPod)
+	npMgr.processNextEvent(npMgr.podQueue, &npMgr.podLock, npMgr.syncPod)
+
+	if fake.Exists("app:frontend", "10.0.0.1", util.IpsetNetHashFlag) || !fake.Exists("app:frontend", "10.0.0.2", util.IpsetNetHashFlag) {
+		t.Errorf("TestEventQueues failed @ syncPod, expected the old pod to be replaced by the new one. Sets: %v", fake.Sets)
+	}
+
+	if _, exists := npMgr.PodMap[GetPodKey(podObj)]; exists {
+		t.Errorf("TestEventQueues failed @ syncPod, expected the old pod to be deleted from the cache")
+	}
+
+	npMgr.npInformer.Informer().GetIndexer().Delete(npObj)
+	npMgr.enqueueNetworkPolicy(npObj)
+	npMgr.processNextEvent(npMgr.npQueue, &npMgr.npLock, npMgr.syncNetworkPolicy)
+
+	if _, exists := npMgr.RawNpMap[GetNetworkPolicyKey(npObj)]; exists {
+		t.Errorf("TestEventQueues failed @ syncNetworkPolicy, expected the deleted network policy to be removed")
+	}
+}
+
+func TestProcessNextEventRetries(t *testing.T) {
+	metrics.InitializeAll()
+	npMgr := &NetworkPolicyManager{}
+	queue := newEventQueue("test")
+	defer queue.ShutDown()
+
+	syncs := 0
+	failingSync := func(key string) error {
+		syncs++
+		return newPolicyApplyError("test", metrics.IPTablesReason, fmt.Errorf("failed to sync %s", key))
+	}
+	failureLabels := prometheus.Labels{metrics.NamespaceLabel: "test", metrics.ReasonLabel: metrics.IPTablesReason}
+	failures, err := promutil.GetCounterVecValue(metrics.PolicyApplyFailures, failureLabels)
+	promutil.NotifyIfErrors(t, err)
+
+	queue.Add("test-key")
+	for i := 0; i <= maxEventRetries; i++ {
+		if !npMgr.processNextEvent(queue, &npMgr.npLock, failingSync) {
+			t.Fatalf("TestProcessNextEventRetries failed @ processNextEvent, expected the queue to be running")
+		}
+	}
+
+	if syncs != maxEventRetries+1 {
+		t.Errorf("TestProcessNextEventRetries failed @ processNextEvent, expected %d syncs. Actual: %d", maxEventRetries+1, syncs)
+	}
+
+	if queue.Len() != 0 || queue.NumRequeues("test-key") != 0 {
+		t.Errorf("TestProcessNextEventRetries failed @ processNextEvent, expected the key to be dropped after %d retries", maxEventRetries)
+	}
+
+	newFailures, err := promutil.GetCounterVecValue(metrics.PolicyApplyFailures, failureLabels)
+	promutil.NotifyIfErrors(t, err)
+	if newFailures != failures+1 {
+		t.Errorf("TestProcessNextEventRetries failed @ processNextEvent, expected the dropped event to be counted once. Actual: %d", newFailures-failures)
+	}
+
+	queue.ShutDown()
+	if npMgr.processNextEvent(queue, &npMgr.npLock, failingSync) {
+		t.Errorf("TestProcessNextEventRetries failed @ processNextEvent, expected false once the queue is shut down")
+	}
+}
+
+func TestWaitForEventWorkers(t *testing.T) {
+	informerFactory := informers.NewSharedInformerFactory(nil, 0)
+	npMgr := &NetworkPolicyManager{
+		podInformer: informerFactory.Core().V1().Pods(),
+		nsInformer:  informerFactory.Core().V1().Namespaces(),
+		npInformer:  informerFactory.Networking().V1().NetworkPolicies(),
+		podQueue:    newEventQueue(podQueueName),
+		nsQueue:     newEventQueue(namespaceQueueName),
+		npQueue:     newEventQueue(networkPolicyQueueName),
+		NsMap:       make(map[string]*Namespace),
+		PodMap:      make(map[string]*NpmPod),
+		RawNpMap:    make(map[string]*networkingv1.NetworkPolicy),
+	}
+
+	// the workers are busy until the lock is released, the keys queued meanwhile are drained after stopCh is closed.
+	npMgr.Lock()
+	for i := 0; i < 10; i++ {
+		npMgr.nsQueue.Add(fmt.Sprintf("test-ns-%d", i))
+		npMgr.npQueue.Add(fmt.Sprintf("test-ns-%d/test-policy", i))
+	}
+	stopCh := make(chan struct{})
+	npMgr.runEventWorkers(stopCh)
+	close(stopCh)
+	npMgr.Unlock()
+
+	if err := npMgr.WaitForEventWorkers(10 * time.Second); err != nil {
+		t.Fatalf("TestWaitForEventWorkers failed @ WaitForEventWorkers: %v", err)
+	}
+
+	if npMgr.nsQueue.Len() != 0 || npMgr.npQueue.Len() != 0 {
+		t.Errorf("TestWaitForEventWorkers failed @ WaitForEventWorkers, expected the queues to be drained")
+	}
+}
+
+func TestEventWorkersDontWaitForOtherKinds(t *testing.T) {
+	fake := fakes.NewDataplaneFake()
+	ipsets, rules := dataplane.Synchronize(fake, fake)
+	defer func(restore func() (dataplane.Ipsets, dataplane.Rules)) { newDataplane = restore }(newDataplane)
+	newDataplane = func() (dataplane.Ipsets, dataplane.Rules) {
+		return ipsets, rules
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(nil, 0)
+	npMgr := &NetworkPolicyManager{
+		podInformer: informerFactory.Core().V1().Pods(),
+		podQueue:    newEventQueue(podQueueName),
+		nsQueue:     newEventQueue(namespaceQueueName),
+		npQueue:     newEventQueue(networkPolicyQueueName),
+		NsMap:       make(map[string]*Namespace),
+		PodMap:      make(map[string]*NpmPod),
+	}
+
+	allNs, _ := newNs(util.KubeAllNamespacesFlag)
+	npMgr.NsMap[util.KubeAllNamespacesFlag] = allNs
+
+	// the network policy worker is stuck in its sync until released.
+	blocked, release := make(chan struct{}), make(chan struct{})
+	blockingSync := func(key string) error {
+		close(blocked)
+		<-release
+		return nil
+	}
+
+	npMgr.eventWorkers.Add(2)
+	go npMgr.runEventWorker(npMgr.npQueue, &npMgr.npLock, blockingSync)
+	go npMgr.runEventWorker(npMgr.podQueue, &npMgr.podLock, npMgr.syncPod)
+	defer npMgr.WaitForEventWorkers(10 * time.Second)
+	defer npMgr.npQueue.ShutDown()
+	defer npMgr.podQueue.ShutDown()
+	defer close(release)
+
+	npMgr.npQueue.Add("test-ns/test-policy")
+	<-blocked
+
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-pod",
+			Namespace:       "test-ns",
+			UID:             "12345",
+			Labels:          map[string]string{"app": "frontend"},
+			ResourceVersion: "1",
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: "10.0.0.1",
+		},
+	}
+	npMgr.podInformer.Informer().GetIndexer().Add(podObj)
+	npMgr.enqueuePod(podObj)
+
+	for deadline := time.Now().Add(10 * time.Second); !ipsets.Exists("app:frontend", "10.0.0.1", util.IpsetNetHashFlag); {
+		if time.Now().After(deadline) {
+			t.Fatalf("TestEventWorkersDontWaitForOtherKinds failed @ syncPod, expected the pod to be programmed while a network policy sync is blocked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSplitPodKey(t *testing.T) {
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-ns",
+			UID:       "12345",
+		},
+	}
+
+	ns, name, uid, err := splitPodKey(GetPodKey(podObj))
+	if err != nil || ns != "test-ns" || name != "test-pod" || uid != "12345" {
+		t.Errorf("TestSplitPodKey failed @ splitPodKey. Actual: %s, %s, %s, %v", ns, name, uid, err)
+	}
+
+	if _, _, _, err := splitPodKey("test-ns/test-pod"); err == nil {
+		t.Errorf("TestSplitPodKey failed @ splitPodKey, expected an error for a key without UID")
+	}
+}
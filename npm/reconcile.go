@@ -0,0 +1,93 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/dataplane"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// reconcileDataplane programs the state of the synced informer caches into the kernel without flushing it first.
+// Until then, NPM records the sets and rules it expects in a dataplane.State while the kernel keeps enforcing
+// the ones of the previous run. Only the sets and chains which differ are then repaired, and the sets nothing
+// refers to anymore are removed last, so a restart of NPM doesn't interrupt network policy enforcement.
+func (npMgr *NetworkPolicyManager) reconcileDataplane() error {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	allNs := npMgr.getAllNs()
+	expected, ok := allNs.IpsMgr.(*dataplane.State)
+	if !ok {
+		return nil
+	}
+
+	// the informer event handlers may not have processed every cached object yet.
+	if err := npMgr.replayInformerCaches(); err != nil {
+		return err
+	}
+
+	log.Logf("Reconciling dataplane: %d ipsets, %d ipset lists, %d iptables chains expected.", len(expected.Sets), len(expected.Lists), len(expected.Chains))
+
+	ipsMgr, iptMgr := newDataplane()
+	if err := ipsMgr.ReconcileSets(expected); err != nil {
+		metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to reconcile ipsets with err: %v", err)
+	}
+
+	if err := iptMgr.ReconcileRules(expected); err != nil {
+		metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to reconcile iptables with err: %v", err)
+	}
+
+	// rules no longer refer to stale sets once they are reconciled.
+	if err := ipsMgr.RemoveStaleSets(expected); err != nil {
+		metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to remove stale ipsets with err: %v", err)
+	}
+
+	allNs.IpsMgr, allNs.iptMgr = ipsMgr, iptMgr
+
+	return nil
+}
+
+// replayInformerCaches adds the namespaces, pods and network policies of the informer caches which NPM doesn't know about yet.
+// Their queued events find them programmed already.
+func (npMgr *NetworkPolicyManager) replayInformerCaches() error {
+	namespaces, err := npMgr.nsInformer.Lister().List(labels.Everything())
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to list namespaces with err: %v", err)
+		return err
+	}
+
+	for _, nsObj := range namespaces {
+		if _, exists := npMgr.getNs(util.GetNSNameWithPrefix(nsObj.ObjectMeta.Name)); !exists {
+			npMgr.AddNamespace(nsObj)
+		}
+	}
+
+	pods, err := npMgr.podInformer.Lister().List(labels.Everything())
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to list pods with err: %v", err)
+		return err
+	}
+
+	for _, podObj := range pods {
+		if _, exists := npMgr.PodMap[GetPodKey(podObj)]; !exists {
+			npMgr.AddPod(podObj)
+		}
+	}
+
+	policies, err := npMgr.npInformer.Lister().List(labels.Everything())
+	if err != nil {
+		metrics.SendErrorLogAndMetric(util.NpmID, "Error: failed to list network policies with err: %v", err)
+		return err
+	}
+
+	for _, npObj := range policies {
+		if _, exists := npMgr.RawNpMap[GetNetworkPolicyKey(npObj)]; !exists {
+			npMgr.AddNetworkPolicy(npObj)
+		}
+	}
+
+	return nil
+}
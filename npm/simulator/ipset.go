@@ -0,0 +1,124 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package simulator
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// ipset is the simulated content of an ipset, keyed by the hashed name iptables rules refer to.
+type ipset struct {
+	name    string
+	setType string
+	members map[string]bool
+}
+
+func (s *Simulator) addIpset(set *npm.DryRunIpset) {
+	cached, exists := s.ipsets[set.HashedName]
+	if !exists {
+		cached = &ipset{
+			name:    set.Name,
+			setType: set.Type,
+			members: make(map[string]bool),
+		}
+		s.ipsets[set.HashedName] = cached
+	}
+
+	for _, member := range set.Members {
+		cached.members[member] = true
+	}
+}
+
+// matchSet returns whether the packet matches an ipset the way `-m set --match-set <set> <flags>` does.
+// depth guards against lists referring to each other.
+func (s *Simulator) matchSet(hashedName string, flags []string, packet *Packet, depth int) bool {
+	set, exists := s.ipsets[hashedName]
+	if !exists || depth > maxDepth || len(flags) == 0 {
+		return false
+	}
+
+	ip := packet.SrcIP
+	if flags[0] == util.IptablesDstFlag {
+		ip = packet.DstIP
+	}
+
+	switch set.setType {
+	case util.IpsetSetListFlag:
+		for member := range set.members {
+			if s.matchSet(util.GetHashedName(member), flags, packet, depth+1) {
+				return true
+			}
+		}
+		return false
+	case util.IpsetIPPortHashFlag:
+		// only destination ports are known to the simulator.
+		if len(flags) < 2 || flags[1] != util.IptablesDstFlag {
+			return false
+		}
+		for member := range set.members {
+			if matchIPPort(member, ip, packet.Protocol, packet.DstPort) {
+				return true
+			}
+		}
+		return false
+	default:
+		return matchNet(set.members, ip)
+	}
+}
+
+// matchNet returns whether ip is in a hash:net ipset.
+// Like the kernel, the most specific entry containing ip wins, so a more specific nomatch entry excludes it.
+func matchNet(members map[string]bool, ip string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+
+	bestPrefix, matched := -1, false
+	for member := range members {
+		nomatch := strings.HasSuffix(member, util.IpsetNomatch)
+		cidr := strings.TrimSpace(strings.TrimSuffix(member, util.IpsetNomatch))
+		if !strings.Contains(cidr, "/") {
+			if parsedIP.Equal(net.ParseIP(cidr)) {
+				return !nomatch
+			}
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || !ipNet.Contains(parsedIP) {
+			continue
+		}
+
+		if prefix, _ := ipNet.Mask.Size(); prefix > bestPrefix {
+			bestPrefix, matched = prefix, !nomatch
+		}
+	}
+
+	return matched
+}
+
+// matchIPPort returns whether a hash:ip,port member, e.g. "10.0.0.1,tcp:80", matches the destination.
+// ipset treats a port without protocol as TCP.
+func matchIPPort(member, ip, protocol string, port int32) bool {
+	fields := strings.SplitN(member, ",", 2)
+	if len(fields) != 2 || fields[0] != ip {
+		return false
+	}
+
+	memberProtocol, memberPort := util.IpsetTCPFlag, fields[1]
+	if i := strings.Index(fields[1], ":"); i >= 0 {
+		memberProtocol, memberPort = fields[1][:i+1], fields[1][i+1:]
+	}
+
+	if !strings.EqualFold(memberProtocol, strings.ToLower(protocol)+":") {
+		return false
+	}
+
+	return memberPort == strconv.Itoa(int(port))
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package simulator
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// matcher is one match of an iptables rule, e.g. `-m set --match-set <set> src`.
+type matcher func(s *Simulator, packet *Packet, mark uint32) bool
+
+// rule is an iptables entry parsed for evaluation.
+type rule struct {
+	policy   string
+	chain    string
+	comment  string
+	matchers []matcher
+	target   string
+	setMark  string
+}
+
+// newRule parses the specs of an iptables entry NPM programs.
+// Options the simulator doesn't know about, e.g. logging options, are skipped.
+func newRule(entry *iptm.IptEntry, policy string) *rule {
+	r := &rule{
+		policy: policy,
+		chain:  entry.Chain,
+	}
+
+	specs := entry.Specs
+	negate := false
+	for i := 0; i < len(specs); i++ {
+		value := func() string {
+			if i+1 >= len(specs) {
+				return ""
+			}
+			i++
+			return specs[i]
+		}
+
+		switch specs[i] {
+		case util.IptablesNotFlag:
+			negate = true
+			continue
+		case util.IptablesModuleFlag:
+			value()
+		case util.IptablesProtFlag:
+			r.addMatcher(negate, matchProtocol(value()))
+		case util.IptablesDstPortFlag, util.IptablesMultiDestportFlag:
+			r.addMatcher(negate, matchDstPort(value()))
+		case util.IptablesMatchSetFlag:
+			setName := value()
+			r.addMatcher(negate, matchIpset(setName, strings.Split(value(), ",")))
+		case util.IptablesMarkFlag:
+			r.addMatcher(negate, matchMark(value()))
+		case util.IptablesStateFlag:
+			// the simulated packet opens a new connection.
+			value()
+			r.addMatcher(negate, func(*Simulator, *Packet, uint32) bool { return false })
+		case util.IptablesSFlag:
+			r.addMatcher(negate, matchCidr(value(), func(packet *Packet) string { return packet.SrcIP }))
+		case util.IptablesDFlag:
+			r.addMatcher(negate, matchCidr(value(), func(packet *Packet) string { return packet.DstIP }))
+		case util.IptablesJumpFlag:
+			r.target = value()
+		case util.IptablesSetMarkFlag:
+			r.setMark = value()
+		case util.IptablesCommentFlag:
+			r.comment = value()
+		default:
+			if strings.HasPrefix(specs[i], "-") && i+1 < len(specs) && !strings.HasPrefix(specs[i+1], "-") {
+				value()
+			}
+		}
+		negate = false
+	}
+
+	return r
+}
+
+func (r *rule) addMatcher(negate bool, m matcher) {
+	if negate {
+		r.matchers = append(r.matchers, func(s *Simulator, packet *Packet, mark uint32) bool {
+			return !m(s, packet, mark)
+		})
+		return
+	}
+
+	r.matchers = append(r.matchers, m)
+}
+
+func (r *rule) matches(s *Simulator, packet *Packet, mark uint32) bool {
+	for _, m := range r.matchers {
+		if !m(s, packet, mark) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchProtocol(protocol string) matcher {
+	return func(_ *Simulator, packet *Packet, _ uint32) bool {
+		return strings.EqualFold(packet.Protocol, protocol)
+	}
+}
+
+// matchDstPort matches a port, a port range "start:end" or a multiport list of them.
+func matchDstPort(ports string) matcher {
+	return func(_ *Simulator, packet *Packet, _ uint32) bool {
+		for _, port := range strings.Split(ports, ",") {
+			bounds := strings.SplitN(port, ":", 2)
+			start, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				continue
+			}
+
+			end := start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					continue
+				}
+			}
+
+			if int(packet.DstPort) >= start && int(packet.DstPort) <= end {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func matchIpset(hashedName string, flags []string) matcher {
+	return func(s *Simulator, packet *Packet, _ uint32) bool {
+		return s.matchSet(hashedName, flags, packet, 0)
+	}
+}
+
+// matchMark matches "value" or "value/mask" against the packet mark.
+func matchMark(mark string) matcher {
+	value, mask := parseMark(mark)
+	return func(_ *Simulator, _ *Packet, packetMark uint32) bool {
+		return packetMark&mask == value
+	}
+}
+
+func matchCidr(cidr string, ip func(*Packet) string) matcher {
+	return func(_ *Simulator, packet *Packet, _ uint32) bool {
+		return matchNet(map[string]bool{cidr: true}, ip(packet))
+	}
+}
+
+// applyMark returns the packet mark after `-j MARK --set-mark value[/mask]`.
+func applyMark(packetMark uint32, mark string) uint32 {
+	value, mask := parseMark(mark)
+	return packetMark&^mask | value
+}
+
+func parseMark(mark string) (uint32, uint32) {
+	fields := strings.SplitN(mark, "/", 2)
+	value, _ := strconv.ParseUint(fields[0], 0, 32)
+	mask := uint64(0xffffffff)
+	if len(fields) == 2 {
+		mask, _ = strconv.ParseUint(fields[1], 0, 32)
+	}
+
+	return uint32(value), uint32(mask)
+}
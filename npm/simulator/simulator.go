@@ -0,0 +1,349 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// Package simulator evaluates offline whether NPM lets a packet through.
+// It rebuilds the ipsets and AZURE-NPM iptables chains NPM programs from its in-memory state,
+// so it works both with a running NetworkPolicyManager and with a dump of /npm/v1/debug/snapshot.
+package simulator
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/http/api"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// maxDepth bounds chain jumps and nested ipset lists.
+const maxDepth = 16
+
+// Packet is the first packet of a connection between two endpoints.
+type Packet struct {
+	SrcIP    string `json:"srcIP"`
+	DstIP    string `json:"dstIP"`
+	Protocol string `json:"protocol"`
+	DstPort  int32  `json:"dstPort"`
+}
+
+// DecidingRule is an iptables rule which decided a verdict.
+type DecidingRule struct {
+	// Policy is <namespace>/<name> of the network policy the rule was translated from.
+	Policy string `json:"policy"`
+	Chain  string `json:"chain"`
+	Rule   string `json:"rule"`
+}
+
+// Verdict is the result of evaluating a packet against the AZURE-NPM chains.
+type Verdict struct {
+	Packet  *Packet `json:"packet"`
+	Allowed bool    `json:"allowed"`
+	// Rules are the DROP rule which denied the packet, or the rules which marked it as allowed.
+	// There are none when no network policy selects the pods.
+	Rules []*DecidingRule `json:"rules,omitempty"`
+	// Trace lists the comments of all matching rules in evaluation order.
+	Trace []string `json:"trace,omitempty"`
+}
+
+// Simulator holds the ipsets and iptables chains NPM programs for a NetworkPolicyManager state.
+type Simulator struct {
+	pods   []*corev1.Pod
+	ipsets map[string]*ipset
+	chains map[string][]*rule
+}
+
+// NewSimulator translates the network policies of npMgr with the ipset members of its pods and namespaces.
+// NPM programs the rules of every policy in RawNpMap, so ProcessedNpMap is only used when RawNpMap is empty.
+func NewSimulator(npMgr *npm.NetworkPolicyManager) *Simulator {
+	s := &Simulator{
+		ipsets: make(map[string]*ipset),
+		chains: make(map[string][]*rule),
+	}
+
+	for _, npmPod := range npMgr.PodMap {
+		s.pods = append(s.pods, newPod(npmPod))
+	}
+
+	var namespaces []*corev1.Namespace
+	for nsName, ns := range npMgr.NsMap {
+		if nsName == util.KubeAllNamespacesFlag {
+			continue
+		}
+
+		namespaces = append(namespaces, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   strings.TrimPrefix(nsName, util.NamespacePrefix),
+				Labels: ns.LabelsMap,
+			},
+		})
+	}
+
+	policies := npMgr.RawNpMap
+	if len(policies) == 0 {
+		policies = npMgr.ProcessedNpMap
+	}
+
+	// InitNpmChains appends the default rules before any policy is applied.
+	for _, entry := range iptm.GetDefaultChainEntries() {
+		s.addRule(entry, "")
+	}
+
+	// policies are applied in an order the simulator can't know, which doesn't change the verdicts
+	// since allow rules only mark packets and drops are evaluated after all of them.
+	keys := make([]string, 0, len(policies))
+	for key := range policies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		npObj := policies[key]
+		result := npm.DryRunPolicy(npObj, s.pods, namespaces)
+		for _, sets := range [][]*npm.DryRunIpset{result.Sets, result.NamedPorts, result.CidrSets, result.Lists} {
+			for _, set := range sets {
+				s.addIpset(set)
+			}
+		}
+
+		policyName := npObj.ObjectMeta.Namespace + "/" + npObj.ObjectMeta.Name
+		for _, entry := range result.IptEntries {
+			s.addRule(entry, policyName)
+		}
+	}
+
+	return s
+}
+
+// NewSimulatorFromPolicies is a NewSimulator for pods, namespaces and policies which aren't in a NetworkPolicyManager.
+func NewSimulatorFromPolicies(pods []*corev1.Pod, namespaces []*corev1.Namespace, policies []*networkingv1.NetworkPolicy) *Simulator {
+	npMgr := &npm.NetworkPolicyManager{
+		NsMap:    make(map[string]*npm.Namespace),
+		PodMap:   make(map[string]*npm.NpmPod),
+		RawNpMap: make(map[string]*networkingv1.NetworkPolicy),
+	}
+
+	for _, nsObj := range namespaces {
+		npMgr.NsMap[util.GetNSNameWithPrefix(nsObj.ObjectMeta.Name)] = &npm.Namespace{LabelsMap: nsObj.ObjectMeta.Labels}
+	}
+
+	for _, podObj := range pods {
+		npMgr.PodMap[podObj.ObjectMeta.Namespace+"/"+podObj.ObjectMeta.Name] = &npm.NpmPod{
+			Name:           podObj.ObjectMeta.Name,
+			Namespace:      podObj.ObjectMeta.Namespace,
+			PodUID:         string(podObj.ObjectMeta.UID),
+			PodIP:          podObj.Status.PodIP,
+			IsHostNetwork:  podObj.Spec.HostNetwork,
+			Labels:         podObj.ObjectMeta.Labels,
+			ContainerPorts: containerPorts(podObj),
+			Phase:          podObj.Status.Phase,
+		}
+	}
+
+	for _, npObj := range policies {
+		npMgr.RawNpMap[npm.GetNetworkPolicyKey(npObj)] = npObj
+	}
+
+	return NewSimulator(npMgr)
+}
+
+// NewSimulatorFromSnapshot is a NewSimulator for the debug snapshot of a running NPM, with the pods of all its pages.
+func NewSimulatorFromSnapshot(snapshot *api.DebugSnapshot) *Simulator {
+	npMgr := &npm.NetworkPolicyManager{
+		NsMap:          make(map[string]*npm.Namespace),
+		PodMap:         make(map[string]*npm.NpmPod),
+		RawNpMap:       make(map[string]*networkingv1.NetworkPolicy),
+		ProcessedNpMap: make(map[string]*networkingv1.NetworkPolicy),
+	}
+
+	for _, ns := range snapshot.Namespaces {
+		npMgr.NsMap[util.GetNSNameWithPrefix(ns.Name)] = &npm.Namespace{LabelsMap: ns.Labels}
+	}
+
+	for _, pod := range snapshot.Pods {
+		npMgr.PodMap[pod.Namespace+"/"+pod.Name] = &npm.NpmPod{
+			Name:           pod.Name,
+			Namespace:      pod.Namespace,
+			NodeName:       pod.NodeName,
+			PodUID:         pod.UID,
+			PodIP:          pod.IP,
+			IsHostNetwork:  pod.HostNetwork,
+			Labels:         pod.Labels,
+			ContainerPorts: pod.ContainerPorts,
+			Phase:          pod.Phase,
+		}
+	}
+
+	for _, npObj := range snapshot.Policies {
+		npMgr.RawNpMap[npm.GetNetworkPolicyKey(npObj)] = npObj
+	}
+
+	for _, npObj := range snapshot.ProcessedPolicies {
+		npMgr.ProcessedNpMap[npObj.Namespace+"/"+npObj.Name] = npObj
+	}
+
+	return NewSimulator(npMgr)
+}
+
+// newPod rebuilds the parts of a pod NPM translates into ipset members.
+func newPod(npmPod *npm.NpmPod) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      npmPod.Name,
+			Namespace: npmPod.Namespace,
+			UID:       types.UID(npmPod.PodUID),
+			Labels:    npmPod.Labels,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:    npmPod.NodeName,
+			HostNetwork: npmPod.IsHostNetwork,
+			Containers: []corev1.Container{
+				{
+					Ports: npmPod.ContainerPorts,
+				},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:  npmPod.Phase,
+			PodIP:  npmPod.PodIP,
+			PodIPs: npmPod.PodIPs,
+		},
+	}
+}
+
+func containerPorts(podObj *corev1.Pod) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for _, container := range podObj.Spec.Containers {
+		ports = append(ports, container.Ports...)
+	}
+
+	return ports
+}
+
+// addRule adds an entry to its chain the way iptables-restore does: jump entries are appended, others inserted at the top.
+func (s *Simulator) addRule(entry *iptm.IptEntry, policy string) {
+	r := newRule(entry, policy)
+	if entry.IsJumpEntry {
+		s.chains[entry.Chain] = append(s.chains[entry.Chain], r)
+		return
+	}
+
+	s.chains[entry.Chain] = append([]*rule{r}, s.chains[entry.Chain]...)
+}
+
+// ResolveIP returns the ip of a pod given as <namespace>/<name>, or endpoint itself if it is an ip.
+func (s *Simulator) ResolveIP(endpoint string) (string, error) {
+	if net.ParseIP(endpoint) != nil {
+		return endpoint, nil
+	}
+
+	fields := strings.SplitN(endpoint, "/", 2)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("%s is neither an ip nor a pod in the <namespace>/<name> format", endpoint)
+	}
+
+	for _, podObj := range s.pods {
+		if podObj.ObjectMeta.Namespace == fields[0] && podObj.ObjectMeta.Name == fields[1] {
+			if podObj.Status.PodIP == "" {
+				return "", fmt.Errorf("pod %s has no ip", endpoint)
+			}
+			return podObj.Status.PodIP, nil
+		}
+	}
+
+	return "", fmt.Errorf("pod %s not found", endpoint)
+}
+
+// Evaluate runs the packet through the AZURE-NPM chain.
+// Packets which leave the chain without verdict are allowed, as NPM doesn't filter them.
+func (s *Simulator) Evaluate(packet *Packet) *Verdict {
+	e := &evaluation{
+		simulator: s,
+		packet:    packet,
+		verdict: &Verdict{
+			Packet:  packet,
+			Allowed: true,
+		},
+	}
+
+	target, decidingRule := e.evaluateChain(util.IptablesAzureChain, 0)
+	switch target {
+	case util.IptablesDrop, util.IptablesReject:
+		e.verdict.Allowed = false
+		e.verdict.Rules = []*DecidingRule{newDecidingRule(decidingRule)}
+	case util.IptablesAccept:
+		for _, r := range e.markRules {
+			e.verdict.Rules = append(e.verdict.Rules, newDecidingRule(r))
+		}
+	}
+
+	return e.verdict
+}
+
+// evaluation is the state of a packet going through the chains.
+type evaluation struct {
+	simulator *Simulator
+	packet    *Packet
+	mark      uint32
+	// markRules are the policy rules which marked the packet, they decide why it is accepted.
+	markRules []*rule
+	verdict   *Verdict
+}
+
+// evaluateChain returns the terminating target the packet reached with the rule that sent it there,
+// or an empty target if the packet returned from the chain.
+func (e *evaluation) evaluateChain(chain string, depth int) (string, *rule) {
+	if depth > maxDepth {
+		return "", nil
+	}
+
+	for _, r := range e.simulator.chains[chain] {
+		if !r.matches(e.simulator, e.packet, e.mark) {
+			continue
+		}
+
+		description := r.comment
+		if description == "" {
+			description = util.IptablesJumpFlag + " " + r.target
+		}
+		e.verdict.Trace = append(e.verdict.Trace, fmt.Sprintf("%s: %s", r.chain, description))
+
+		switch r.target {
+		case util.IptablesAccept, util.IptablesDrop, util.IptablesReject:
+			return r.target, r
+		case util.IptablesReturn:
+			return "", nil
+		case util.IptablesMark:
+			e.mark = applyMark(e.mark, r.setMark)
+			if r.policy != "" {
+				e.markRules = append(e.markRules, r)
+			}
+		case "":
+		default:
+			if _, isChain := e.simulator.chains[r.target]; !isChain {
+				// non-terminating targets like LOG.
+				continue
+			}
+
+			if target, decidingRule := e.evaluateChain(r.target, depth+1); target != "" {
+				return target, decidingRule
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func newDecidingRule(r *rule) *DecidingRule {
+	return &DecidingRule{
+		Policy: r.policy,
+		Chain:  r.chain,
+		Rule:   r.comment,
+	}
+}
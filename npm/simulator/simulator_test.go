@@ -0,0 +1,179 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package simulator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/fakes"
+	"github.com/Azure/azure-container-networking/npm/http/api"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newTestPod(ns, name, ip string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    labels,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: ip,
+		},
+	}
+}
+
+func newTestSimulator() *Simulator {
+	tcp := corev1.ProtocolTCP
+	port80 := intstr.FromInt(80)
+
+	pods := []*corev1.Pod{
+		newTestPod("x", "frontend", "10.0.0.1", map[string]string{"app": "frontend"}),
+		newTestPod("x", "backend", "10.0.0.2", map[string]string{"app": "backend"}),
+		newTestPod("y", "client", "10.0.0.3", map[string]string{"app": "backend"}),
+		newTestPod("x", "db", "10.0.0.4", map[string]string{"app": "db"}),
+	}
+
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "x", Labels: map[string]string{"team": "x"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "y", Labels: map[string]string{"team": "y"}}},
+	}
+
+	policies := []*networkingv1.NetworkPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "allow-backend-to-frontend", Namespace: "x"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "backend"}}},
+						},
+						Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port80}},
+					},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "allow-cidr-to-db", Namespace: "x"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/24", Except: []string{"10.0.0.2/32"}}},
+						},
+					},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		},
+	}
+
+	return NewSimulatorFromPolicies(pods, namespaces, policies)
+}
+
+func TestEvaluate(t *testing.T) {
+	s := newTestSimulator()
+
+	testCases := []struct {
+		src, dst        string
+		protocol        string
+		port            int32
+		allowed         bool
+		policy          string
+		expectRuleChain string
+	}{
+		// selected by allow-backend-to-frontend, allowed on port 80 only.
+		{"x/backend", "x/frontend", "TCP", 80, true, "x/allow-backend-to-frontend", util.IptablesAzureIngressPortChain},
+		{"x/backend", "x/frontend", "TCP", 81, false, "x/allow-backend-to-frontend", util.IptablesAzureIngressDropsChain},
+		{"x/backend", "x/frontend", "UDP", 80, false, "x/allow-backend-to-frontend", util.IptablesAzureIngressDropsChain},
+		// the pod selector only matches pods of the policy namespace.
+		{"y/client", "x/frontend", "TCP", 80, false, "x/allow-backend-to-frontend", util.IptablesAzureIngressDropsChain},
+		// the except cidr is excluded from the ipBlock.
+		{"y/client", "x/db", "TCP", 5432, true, "x/allow-cidr-to-db", util.IptablesAzureIngressFromChain},
+		{"x/backend", "x/db", "TCP", 5432, false, "x/allow-cidr-to-db", util.IptablesAzureIngressDropsChain},
+		{"192.168.0.1", "x/db", "TCP", 5432, false, "x/allow-cidr-to-db", util.IptablesAzureIngressDropsChain},
+		// no policy selects backend.
+		{"x/frontend", "x/backend", "TCP", 80, true, "", ""},
+	}
+
+	for _, tc := range testCases {
+		srcIP, err := s.ResolveIP(tc.src)
+		if err != nil {
+			t.Fatalf("TestEvaluate failed @ s.ResolveIP(%s) with err %v", tc.src, err)
+		}
+
+		dstIP, err := s.ResolveIP(tc.dst)
+		if err != nil {
+			t.Fatalf("TestEvaluate failed @ s.ResolveIP(%s) with err %v", tc.dst, err)
+		}
+
+		verdict := s.Evaluate(&Packet{SrcIP: srcIP, DstIP: dstIP, Protocol: tc.protocol, DstPort: tc.port})
+		if verdict.Allowed != tc.allowed {
+			t.Errorf("TestEvaluate failed @ %s -> %s %s/%d. Expected allowed %v, trace: %v", tc.src, tc.dst, tc.protocol, tc.port, tc.allowed, verdict.Trace)
+			continue
+		}
+
+		if tc.policy == "" {
+			if len(verdict.Rules) != 0 {
+				t.Errorf("TestEvaluate failed @ %s -> %s %s/%d. Expected no deciding rule, got %+v", tc.src, tc.dst, tc.protocol, tc.port, verdict.Rules[0])
+			}
+			continue
+		}
+
+		if len(verdict.Rules) != 1 || verdict.Rules[0].Policy != tc.policy || verdict.Rules[0].Chain != tc.expectRuleChain {
+			t.Errorf("TestEvaluate failed @ %s -> %s %s/%d. Expected rule of %s in %s, got %+v", tc.src, tc.dst, tc.protocol, tc.port, tc.policy, tc.expectRuleChain, verdict.Rules)
+		}
+	}
+}
+
+func TestNewSimulatorFromSnapshot(t *testing.T) {
+	npMgr := &npm.NetworkPolicyManager{
+		NsMap: map[string]*npm.Namespace{
+			util.KubeAllNamespacesFlag: {IpsMgr: fakes.NewDataplaneFake()},
+			"ns-x":                     {LabelsMap: map[string]string{}},
+		},
+		PodMap: map[string]*npm.NpmPod{
+			"ns-x/a/uid-a": {Name: "a", Namespace: "x", PodIP: "10.0.0.1", Labels: map[string]string{"app": "a"}, Phase: corev1.PodRunning},
+			"ns-x/b/uid-b": {Name: "b", Namespace: "x", PodIP: "10.0.0.2", Labels: map[string]string{"app": "b"}, Phase: corev1.PodRunning},
+		},
+		RawNpMap: map[string]*networkingv1.NetworkPolicy{
+			"x/deny-all": {
+				ObjectMeta: metav1.ObjectMeta{Name: "deny-all", Namespace: "x"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				},
+			},
+		},
+	}
+
+	snapshot, err := npMgr.GetDebugSnapshot("", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the simulator must work with what /npm/v1/debug/snapshot returns.
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dump api.DebugSnapshot
+	if err := json.Unmarshal(b, &dump); err != nil {
+		t.Fatal(err)
+	}
+
+	verdict := NewSimulatorFromSnapshot(&dump).Evaluate(&Packet{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", Protocol: "TCP", DstPort: 80})
+	if verdict.Allowed || len(verdict.Rules) != 1 || verdict.Rules[0].Policy != "x/deny-all" {
+		t.Errorf("TestNewSimulatorFromSnapshot failed @ Evaluate. Expected drop by x/deny-all, got %+v", verdict)
+	}
+}
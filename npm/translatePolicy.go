@@ -17,6 +17,9 @@ type portsInfo struct {
 	port     string
 }
 
+// craftPartialIptEntrySpecFromPort matches the protocol and port of a port rule.
+// A port range from Port to EndPort is matched with the multiport module, e.g. "-m multiport --dports 80:90",
+// or "--sports" when sPortOrDPortFlag is util.IptablesSrcPortFlag.
 func craftPartialIptEntrySpecFromPort(portRule networkingv1.NetworkPolicyPort, sPortOrDPortFlag string) []string {
 	partialSpec := []string{}
 	if portRule.Protocol != nil {
@@ -27,7 +30,15 @@ func craftPartialIptEntrySpecFromPort(portRule networkingv1.NetworkPolicyPort, s
 		)
 	}
 
-	if portRule.Port != nil {
+	if portRule.Port != nil && portRule.EndPort != nil {
+		partialSpec = append(
+			partialSpec,
+			util.IptablesModuleFlag,
+			util.IptablesMultiportFlag,
+			getMultiportFlag(sPortOrDPortFlag),
+			getPortRange(portRule),
+		)
+	} else if portRule.Port != nil {
 		partialSpec = append(
 			partialSpec,
 			sPortOrDPortFlag,
@@ -38,6 +49,20 @@ func craftPartialIptEntrySpecFromPort(portRule networkingv1.NetworkPolicyPort, s
 	return partialSpec
 }
 
+// getMultiportFlag returns the multiport flag matching the same ports as sPortOrDPortFlag.
+func getMultiportFlag(sPortOrDPortFlag string) string {
+	if sPortOrDPortFlag == util.IptablesSrcPortFlag {
+		return util.IptablesMultiSrcportFlag
+	}
+
+	return util.IptablesMultiDestportFlag
+}
+
+// getPortRange returns the port range of a port rule with an EndPort in iptables syntax, e.g. "80:90".
+func getPortRange(portRule networkingv1.NetworkPolicyPort) string {
+	return portRule.Port.String() + ":" + strconv.Itoa(int(*portRule.EndPort))
+}
+
 func getPortType(portRule networkingv1.NetworkPolicyPort) string {
 	if portRule.Port == nil || portRule.Port.IntValue() != 0 {
 		return "validport"
@@ -56,7 +81,10 @@ func craftPartialIptablesCommentFromPort(portRule networkingv1.NetworkPolicyPort
 		}
 	}
 
-	if portRule.Port != nil {
+	if portRule.Port != nil && portRule.EndPort != nil {
+		partialComment += "PORT-"
+		partialComment += getPortRange(portRule)
+	} else if portRule.Port != nil {
 		partialComment += "PORT-"
 		partialComment += portRule.Port.String()
 	}
@@ -302,7 +330,7 @@ func translateIngress(ns string, policyName string, targetSelector metav1.LabelS
 			if fromRule.IPBlock != nil {
 				if len(fromRule.IPBlock.CIDR) > 0 {
 					ipCidrs[i] = append(ipCidrs[i], fromRule.IPBlock.CIDR)
-					cidrIpsetName := policyName + "-in-ns-" + ns + "-" + strconv.Itoa(i) + "in"
+					cidrIpsetName := getCidrIpsetName(policyName, ns, i, "in")
 					if len(fromRule.IPBlock.Except) > 0 {
 						for _, except := range fromRule.IPBlock.Except {
 							// TODO move IP cidrs rule to allow based only
@@ -987,7 +1015,7 @@ func translateEgress(ns string, policyName string, targetSelector metav1.LabelSe
 			if toRule.IPBlock != nil {
 				if len(toRule.IPBlock.CIDR) > 0 {
 					ipCidrs[i] = append(ipCidrs[i], toRule.IPBlock.CIDR)
-					cidrIpsetName := policyName + "-in-ns-" + ns + "-" + strconv.Itoa(i) + "out"
+					cidrIpsetName := getCidrIpsetName(policyName, ns, i, "out")
 					if len(toRule.IPBlock.Except) > 0 {
 						for _, except := range toRule.IPBlock.Except {
 							// TODO move IP cidrs rule to allow based only
@@ -1567,6 +1595,11 @@ func getDefaultDropEntries(ns string, targetSelector metav1.LabelSelector, hasIn
 			"DROP-ALL-TO-"+targetSelectorComment,
 		)
 		entries = append(entries, entry)
+
+		// entries are inserted at the top of the chain, the log entry goes before the drop.
+		if logEntry := getDropLoggingEntry(entry.Chain, targetSelectorIngressIptEntrySpec, util.DropLoggingIngressPrefix, "LOG-DROP-ALL-TO-"+targetSelectorComment); logEntry != nil {
+			entries = append(entries, logEntry)
+		}
 	}
 
 	if hasEgress {
@@ -1584,11 +1617,64 @@ func getDefaultDropEntries(ns string, targetSelector metav1.LabelSelector, hasIn
 			"DROP-ALL-FROM-"+targetSelectorComment,
 		)
 		entries = append(entries, entry)
+
+		if logEntry := getDropLoggingEntry(entry.Chain, targetSelectorEgressIptEntrySpec, util.DropLoggingEgressPrefix, "LOG-DROP-ALL-FROM-"+targetSelectorComment); logEntry != nil {
+			entries = append(entries, logEntry)
+		}
 	}
 
 	return entries
 }
 
+// getDropLoggingEntry returns the entry logging the packets a drop entry with selectorSpecs drops,
+// or nil when drop logging is disabled.
+func getDropLoggingEntry(chain string, selectorSpecs []string, prefix, comment string) *iptm.IptEntry {
+	entry := &iptm.IptEntry{
+		Chain: chain,
+		Specs: append([]string(nil), selectorSpecs...),
+	}
+	entry.Specs = append(
+		entry.Specs,
+		util.IptablesModuleFlag,
+		util.IptablesLimitModuleFlag,
+		util.IptablesLimitFlag,
+		util.DropLoggingLimit,
+	)
+
+	switch util.DropLoggingMode {
+	case util.DropLoggingNflogMode:
+		entry.Specs = append(
+			entry.Specs,
+			util.IptablesJumpFlag,
+			util.IptablesNflog,
+			util.IptablesNflogGroupFlag,
+			strconv.Itoa(int(util.DropLoggingNflogGroup)),
+			util.IptablesNflogPrefixFlag,
+			prefix,
+		)
+	case util.DropLoggingLogMode:
+		entry.Specs = append(
+			entry.Specs,
+			util.IptablesJumpFlag,
+			util.IptablesLog,
+			util.IptablesLogPrefixFlag,
+			prefix,
+		)
+	default:
+		return nil
+	}
+
+	entry.Specs = append(
+		entry.Specs,
+		util.IptablesModuleFlag,
+		util.IptablesCommentModuleFlag,
+		util.IptablesCommentFlag,
+		comment,
+	)
+
+	return entry
+}
+
 // translatePolicy translates network policy object into a set of iptables rules.
 // input:
 // kubernetes network policy project
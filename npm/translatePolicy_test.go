@@ -80,6 +80,45 @@ func TestCraftPartialIptEntrySpecFromPort(t *testing.T) {
 		t.Errorf("iptEntrySpec:\n%v", iptEntrySpec)
 		t.Errorf("expectedIptEntrySpec:\n%v", expectedIptEntrySpec)
 	}
+
+	endPort8080 := int32(8080)
+	portRule = networkingv1.NetworkPolicyPort{
+		Protocol: &tcp,
+		Port:     &port8000,
+		EndPort:  &endPort8080,
+	}
+
+	iptEntrySpec = craftPartialIptEntrySpecFromPort(portRule, util.IptablesDstPortFlag)
+	expectedIptEntrySpec = []string{
+		util.IptablesProtFlag,
+		"TCP",
+		util.IptablesModuleFlag,
+		util.IptablesMultiportFlag,
+		util.IptablesMultiDestportFlag,
+		"8000:8080",
+	}
+
+	if !reflect.DeepEqual(iptEntrySpec, expectedIptEntrySpec) {
+		t.Errorf("TestCraftPartialIptEntrySpecFromPort failed @ tcp port 8000 to 8080 iptEntrySpec comparison")
+		t.Errorf("iptEntrySpec:\n%v", iptEntrySpec)
+		t.Errorf("expectedIptEntrySpec:\n%v", expectedIptEntrySpec)
+	}
+
+	iptEntrySpec = craftPartialIptEntrySpecFromPort(portRule, util.IptablesSrcPortFlag)
+	expectedIptEntrySpec = []string{
+		util.IptablesProtFlag,
+		"TCP",
+		util.IptablesModuleFlag,
+		util.IptablesMultiportFlag,
+		util.IptablesMultiSrcportFlag,
+		"8000:8080",
+	}
+
+	if !reflect.DeepEqual(iptEntrySpec, expectedIptEntrySpec) {
+		t.Errorf("TestCraftPartialIptEntrySpecFromPort failed @ tcp source port 8000 to 8080 iptEntrySpec comparison")
+		t.Errorf("iptEntrySpec:\n%v", iptEntrySpec)
+		t.Errorf("expectedIptEntrySpec:\n%v", expectedIptEntrySpec)
+	}
 }
 
 func TestCraftPartialIptablesCommentFromPort(t *testing.T) {
@@ -135,6 +174,22 @@ func TestCraftPartialIptablesCommentFromPort(t *testing.T) {
 		t.Errorf("comment:\n%v", comment)
 		t.Errorf("expectedIptEntrySpec:\n%v", expectedComment)
 	}
+
+	endPort8080 := int32(8080)
+	portRule = networkingv1.NetworkPolicyPort{
+		Protocol: &tcp,
+		Port:     &port8000,
+		EndPort:  &endPort8080,
+	}
+
+	comment = craftPartialIptablesCommentFromPort(portRule, util.IptablesDstPortFlag)
+	expectedComment = "TCP-PORT-8000:8080"
+
+	if !reflect.DeepEqual(comment, expectedComment) {
+		t.Errorf("TestCraftPartialIptablesCommentFromPort failed @ tcp port 8000 to 8080 comment comparison")
+		t.Errorf("comment:\n%v", comment)
+		t.Errorf("expectedIptEntrySpec:\n%v", expectedComment)
+	}
 }
 
 func TestCraftPartialIptEntrySpecFromOpAndLabel(t *testing.T) {
@@ -546,6 +601,68 @@ func TestGetDefaultDropEntries(t *testing.T) {
 	}
 }
 
+func TestGetDefaultDropEntriesWithDropLogging(t *testing.T) {
+	defer util.SetDropLoggingMode("")
+
+	targetSelector := metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app": "frontend",
+		},
+	}
+	selectorSpecs := []string{
+		util.IptablesModuleFlag,
+		util.IptablesSetModuleFlag,
+		util.IptablesMatchSetFlag,
+		util.GetHashedName("ns-testnamespace"),
+		util.IptablesDstFlag,
+		util.IptablesModuleFlag,
+		util.IptablesSetModuleFlag,
+		util.IptablesMatchSetFlag,
+		util.GetHashedName("app:frontend"),
+		util.IptablesDstFlag,
+		util.IptablesModuleFlag,
+		util.IptablesLimitModuleFlag,
+		util.IptablesLimitFlag,
+		util.DropLoggingLimit,
+	}
+
+	util.SetDropLoggingMode(util.DropLoggingNflogMode)
+	entries := getDefaultDropEntries("testnamespace", targetSelector, true, false)
+	expectedLogEntry := &iptm.IptEntry{
+		Chain: util.IptablesAzureIngressDropsChain,
+		Specs: append(append([]string(nil), selectorSpecs...),
+			util.IptablesJumpFlag,
+			util.IptablesNflog,
+			util.IptablesNflogGroupFlag,
+			"100",
+			util.IptablesNflogPrefixFlag,
+			util.DropLoggingIngressPrefix,
+			util.IptablesModuleFlag,
+			util.IptablesCommentModuleFlag,
+			util.IptablesCommentFlag,
+			"LOG-DROP-ALL-TO-app:frontend-IN-ns-testnamespace",
+		),
+	}
+
+	// the log entry is inserted after the drop entry, which puts it in front of it in the chain.
+	if len(entries) != 2 || !reflect.DeepEqual(entries[1], expectedLogEntry) {
+		marshalledIptEntries, _ := json.Marshal(entries)
+		t.Errorf("TestGetDefaultDropEntriesWithDropLogging failed @ nflog entry, iptEntries: %s", marshalledIptEntries)
+	}
+
+	util.SetDropLoggingMode(util.DropLoggingLogMode)
+	entries = getDefaultDropEntries("testnamespace", targetSelector, false, true)
+	if len(entries) != 2 || entries[1].Chain != util.IptablesAzureEgressDropsChain ||
+		!reflect.DeepEqual(entries[1].Specs[len(selectorSpecs):len(selectorSpecs)+4], []string{util.IptablesJumpFlag, util.IptablesLog, util.IptablesLogPrefixFlag, util.DropLoggingEgressPrefix}) {
+		marshalledIptEntries, _ := json.Marshal(entries)
+		t.Errorf("TestGetDefaultDropEntriesWithDropLogging failed @ log entry, iptEntries: %s", marshalledIptEntries)
+	}
+
+	if err := util.SetDropLoggingMode("tcpdump"); err == nil {
+		t.Errorf("TestGetDefaultDropEntriesWithDropLogging failed @ util.SetDropLoggingMode, expected an error for an unknown mode")
+	}
+}
+
 func TestTranslateIngress(t *testing.T) {
 	ns := "testnamespace"
 	name := "testnetworkpolicyname"
@@ -3972,3 +4089,347 @@ func TestNamedPorts(t *testing.T) {
 		t.Errorf("expectedIptEntries: %s", marshalledExpectedIptEntries)
 	}
 }
+
+func TestAllowPortRange(t *testing.T) {
+	portRangePolicy, err := readPolicyYaml("testpolicies/allow-port-range.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sets, _, lists, ingressIPCidrs, egressIPCidrs, iptEntries := translatePolicy(portRangePolicy)
+
+	expectedSets := []string{
+		"pod:a",
+		"ns-netpol-x",
+		"pod:b",
+		"pod:c",
+	}
+	if !reflect.DeepEqual(sets, expectedSets) {
+		t.Errorf("translatedPolicy failed @ allow-port-range-policy sets comparison")
+		t.Errorf("sets: %v", sets)
+		t.Errorf("expectedSets: %v", expectedSets)
+	}
+
+	expectedLists := []string{
+		"ns-ns:y",
+	}
+	if !reflect.DeepEqual(lists, expectedLists) {
+		t.Errorf("translatedPolicy failed @ allow-port-range-policy lists comparison")
+		t.Errorf("lists: %v", lists)
+		t.Errorf("expectedLists: %v", expectedLists)
+	}
+
+	expectedIngressIPCidrs := []string{"10.0.0.0/8", "10.240.0.0/16nomatch"}
+	if len(ingressIPCidrs) != 1 || !reflect.DeepEqual(util.DropEmptyFields(ingressIPCidrs[0]), expectedIngressIPCidrs) {
+		t.Errorf("translatedPolicy failed @ allow-port-range-policy ingress cidrs comparison")
+		t.Errorf("ingressIPCidrs: %v", ingressIPCidrs)
+		t.Errorf("expectedIngressIPCidrs: %v", expectedIngressIPCidrs)
+	}
+
+	expectedEgressIPCidrs := []string{"192.168.0.0/16"}
+	if len(egressIPCidrs) != 1 || !reflect.DeepEqual(util.DropEmptyFields(egressIPCidrs[0]), expectedEgressIPCidrs) {
+		t.Errorf("translatedPolicy failed @ allow-port-range-policy egress cidrs comparison")
+		t.Errorf("egressIPCidrs: %v", egressIPCidrs)
+		t.Errorf("expectedEgressIPCidrs: %v", expectedEgressIPCidrs)
+	}
+
+	// the ranges are matched with multiport for the ipBlock, namespace and pod peers alike
+	expectedIptEntries := []*iptm.IptEntry{
+		&iptm.IptEntry{
+			Chain: util.IptablesAzureIngressPortChain,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("allow-port-range-in-ns-netpol-x-0in"),
+				util.IptablesSrcFlag,
+				util.IptablesProtFlag,
+				"TCP",
+				util.IptablesModuleFlag,
+				util.IptablesMultiportFlag,
+				util.IptablesMultiDestportFlag,
+				"8000:8080",
+				util.IptablesJumpFlag,
+				util.IptablesMark,
+				util.IptablesSetMarkFlag,
+				util.IptablesAzureIngressMarkHex,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-allow-port-range-in-ns-netpol-x-0in-AND-TCP-PORT-8000:8080-TO-pod:a-IN-ns-netpol-x",
+			},
+		},
+		&iptm.IptEntry{
+			Chain: util.IptablesAzureIngressPortChain,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-ns:y"),
+				util.IptablesSrcFlag,
+				util.IptablesProtFlag,
+				"TCP",
+				util.IptablesModuleFlag,
+				util.IptablesMultiportFlag,
+				util.IptablesMultiDestportFlag,
+				"8000:8080",
+				util.IptablesJumpFlag,
+				util.IptablesMark,
+				util.IptablesSetMarkFlag,
+				util.IptablesAzureIngressMarkHex,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-ns-ns:y-AND-TCP-PORT-8000:8080-TO-pod:a-IN-ns-netpol-x",
+			},
+		},
+		&iptm.IptEntry{
+			Chain: util.IptablesAzureIngressPortChain,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesSrcFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:b"),
+				util.IptablesSrcFlag,
+				util.IptablesProtFlag,
+				"TCP",
+				util.IptablesModuleFlag,
+				util.IptablesMultiportFlag,
+				util.IptablesMultiDestportFlag,
+				"8000:8080",
+				util.IptablesJumpFlag,
+				util.IptablesMark,
+				util.IptablesSetMarkFlag,
+				util.IptablesAzureIngressMarkHex,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-pod:b-IN-ns-netpol-x-AND-TCP-PORT-8000:8080-TO-pod:a-IN-ns-netpol-x",
+			},
+		},
+		&iptm.IptEntry{
+			Chain:       util.IptablesAzureIngressPortChain,
+			IsJumpEntry: true,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesDstFlag,
+				util.IptablesJumpFlag,
+				util.IptablesAzureIngressFromChain,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-ALL-TO-pod:a-IN-ns-netpol-x-TO-JUMP-TO-" + util.IptablesAzureIngressFromChain,
+			},
+		},
+		&iptm.IptEntry{
+			Chain:       util.IptablesAzureIngressFromChain,
+			IsJumpEntry: true,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesDstFlag,
+				util.IptablesJumpFlag,
+				util.IptablesAzureIngressDropsChain,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-ALL-TO-pod:a-IN-ns-netpol-x-TO-JUMP-TO-" + util.IptablesAzureIngressDropsChain,
+			},
+		},
+		&iptm.IptEntry{
+			Chain: util.IptablesAzureEgressPortChain,
+			Specs: []string{
+				util.IptablesProtFlag,
+				"UDP",
+				util.IptablesModuleFlag,
+				util.IptablesMultiportFlag,
+				util.IptablesMultiDestportFlag,
+				"30000:32767",
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesSrcFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesSrcFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("allow-port-range-in-ns-netpol-x-0out"),
+				util.IptablesDstFlag,
+				util.IptablesJumpFlag,
+				util.IptablesMark,
+				util.IptablesSetMarkFlag,
+				util.IptablesAzureEgressXMarkHex,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-allow-port-range-in-ns-netpol-x-0out-AND-UDP-PORT-30000:32767-FROM-pod:a-IN-ns-netpol-x",
+			},
+		},
+		&iptm.IptEntry{
+			Chain: util.IptablesAzureEgressPortChain,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:c"),
+				util.IptablesDstFlag,
+				util.IptablesProtFlag,
+				"UDP",
+				util.IptablesModuleFlag,
+				util.IptablesMultiportFlag,
+				util.IptablesMultiDestportFlag,
+				"30000:32767",
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesSrcFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesSrcFlag,
+				util.IptablesJumpFlag,
+				util.IptablesMark,
+				util.IptablesSetMarkFlag,
+				util.IptablesAzureEgressXMarkHex,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-pod:c-IN-ns-netpol-x-AND-UDP-PORT-30000:32767-FROM-pod:a-IN-ns-netpol-x",
+			},
+		},
+		&iptm.IptEntry{
+			Chain:       util.IptablesAzureEgressPortChain,
+			IsJumpEntry: true,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesSrcFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesSrcFlag,
+				util.IptablesJumpFlag,
+				util.IptablesAzureEgressDropsChain,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"ALLOW-ALL-FROM-pod:a-IN-ns-netpol-x-TO-JUMP-TO-" + util.IptablesAzureEgressDropsChain,
+			},
+		},
+		&iptm.IptEntry{
+			Chain: util.IptablesAzureIngressDropsChain,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesDstFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesDstFlag,
+				util.IptablesJumpFlag,
+				util.IptablesDrop,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"DROP-ALL-TO-pod:a-IN-ns-netpol-x",
+			},
+		},
+		&iptm.IptEntry{
+			Chain: util.IptablesAzureEgressDropsChain,
+			Specs: []string{
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("ns-netpol-x"),
+				util.IptablesSrcFlag,
+				util.IptablesModuleFlag,
+				util.IptablesSetModuleFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("pod:a"),
+				util.IptablesSrcFlag,
+				util.IptablesJumpFlag,
+				util.IptablesDrop,
+				util.IptablesModuleFlag,
+				util.IptablesCommentModuleFlag,
+				util.IptablesCommentFlag,
+				"DROP-ALL-FROM-pod:a-IN-ns-netpol-x",
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(iptEntries, expectedIptEntries) {
+		t.Errorf("translatedPolicy failed @ allow-port-range-policy policy comparison")
+		marshalledIptEntries, _ := json.Marshal(iptEntries)
+		marshalledExpectedIptEntries, _ := json.Marshal(expectedIptEntries)
+		t.Errorf("iptEntries: %s", marshalledIptEntries)
+		t.Errorf("expectedIptEntries: %s", marshalledExpectedIptEntries)
+	}
+}
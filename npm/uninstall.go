@@ -0,0 +1,150 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/nftm"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// maxForwardJumpRemovals bounds how often Uninstall removes a FORWARD jump to AZURE-NPM, in case one can't be removed.
+const maxForwardJumpRemovals = 8
+
+// UninstallReport is what Uninstall left behind on the node. The node is clean when it is empty.
+type UninstallReport struct {
+	// Errors are the removals and checks which failed.
+	Errors []string `json:"errors,omitempty"`
+	// Chains are the NPM chains left, as <iptables command> <chain>.
+	Chains []string `json:"chains,omitempty"`
+	// ForwardJumps are the iptables commands whose FORWARD chain still jumps to AZURE-NPM.
+	ForwardJumps []string `json:"forwardJumps,omitempty"`
+	// Ipsets are the hashed names of the azure-npm-* sets and lists left.
+	Ipsets []string `json:"ipsets,omitempty"`
+	// NftTable is the NPM nftables table, as <family> <table>, when it is left.
+	NftTable string `json:"nftTable,omitempty"`
+}
+
+// Clean reports whether Uninstall removed everything.
+func (report *UninstallReport) Clean() bool {
+	return len(report.Errors) == 0 && len(report.Chains) == 0 && len(report.ForwardJumps) == 0 && len(report.Ipsets) == 0 &&
+		report.NftTable == ""
+}
+
+func (report *UninstallReport) String() string {
+	if report.Clean() {
+		return "no NPM chains, ipsets nor nftables table left"
+	}
+
+	var leftovers []string
+	for _, message := range report.Errors {
+		leftovers = append(leftovers, "error: "+message)
+	}
+	for _, chain := range report.Chains {
+		leftovers = append(leftovers, "chain: "+chain)
+	}
+	for _, cmd := range report.ForwardJumps {
+		leftovers = append(leftovers, "jump: "+cmd+" "+util.IptablesForwardChain+" -> "+util.IptablesAzureChain)
+	}
+	for _, set := range report.Ipsets {
+		leftovers = append(leftovers, "ipset: "+set)
+	}
+	if report.NftTable != "" {
+		leftovers = append(leftovers, "nftables table: "+report.NftTable)
+	}
+
+	return strings.Join(leftovers, "\n")
+}
+
+// Uninstall removes what any NPM version programmed on the node: the AZURE-NPM chains of iptables and ip6tables,
+// the jumps of their FORWARD chains to AZURE-NPM, all azure-npm-* ipsets and the azure-npm nftables table of the
+// nftables dataplane. It then reads the kernel state again and reports what is left. NPM must be stopped first,
+// or it programs the node again.
+func Uninstall() *UninstallReport {
+	var (
+		report  = &UninstallReport{}
+		iptMgrs = map[string]*iptm.IptablesManager{util.Iptables: iptm.NewIptablesManager()}
+	)
+
+	// ip6tables is only programmed with IPv6 enabled, it may not even be installed.
+	if _, err := exec.LookPath(util.Ip6tables); err == nil {
+		iptMgrs[util.Ip6tables] = iptm.NewIp6tablesManager()
+	}
+
+	// the rules refer to the ipsets, they are removed first.
+	for _, cmd := range []string{util.Iptables, util.Ip6tables} {
+		iptMgr, exists := iptMgrs[cmd]
+		if !exists {
+			continue
+		}
+
+		log.Logf("[Uninstall] Removing the NPM chains of %s.", cmd)
+		// UninitNpmChains removes one jump from FORWARD, NPM versions racing each other may have added more.
+		for i := 0; i < maxForwardJumpRemovals; i++ {
+			if err := iptMgr.UninitNpmChains(); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("failed to remove the NPM chains of %s: %v", cmd, err))
+				break
+			}
+
+			if _, jumps, err := iptMgr.GetNpmLeftovers(); err != nil || jumps == 0 {
+				break
+			}
+		}
+	}
+
+	log.Logf("[Uninstall] Destroying the azure-npm-* ipsets.")
+	if err := ipsm.NewIpsetManager().DestroyNpmIpsets(); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to destroy the NPM ipsets: %v", err))
+	}
+
+	// the table holds the chains and sets of the nftables dataplane, they go with it.
+	if tableExists, err := nftm.NpmTableExists(); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	} else if tableExists {
+		log.Logf("[Uninstall] Deleting the %s nftables table.", util.NftTable)
+		if err := nftm.NewNftablesManager().DestroyNpmIpsets(); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to delete the NPM nftables table: %v", err))
+		}
+	}
+
+	for _, cmd := range []string{util.Iptables, util.Ip6tables} {
+		iptMgr, exists := iptMgrs[cmd]
+		if !exists {
+			continue
+		}
+
+		chains, jumps, err := iptMgr.GetNpmLeftovers()
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		for _, chain := range chains {
+			report.Chains = append(report.Chains, cmd+" "+chain)
+		}
+		if jumps > 0 {
+			report.ForwardJumps = append(report.ForwardJumps, cmd)
+		}
+	}
+
+	sets, err := ipsm.GetNpmIpsets()
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	report.Ipsets = sets
+
+	tableExists, err := nftm.NpmTableExists()
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	} else if tableExists {
+		report.NftTable = util.NftFamily + " " + util.NftTable
+	}
+
+	return report
+}
@@ -0,0 +1,32 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestUninstallReport(t *testing.T) {
+	report := &UninstallReport{}
+	if !report.Clean() {
+		t.Errorf("TestUninstallReport failed @ report.Clean, expected an empty report to be clean")
+	}
+
+	report.NftTable = util.NftFamily + " " + util.NftTable
+	if report.Clean() {
+		t.Errorf("TestUninstallReport failed @ report.Clean, expected a report with the nftables table left not to be clean")
+	}
+
+	expected := "nftables table: ip azure-npm"
+	if actual := report.String(); actual != expected {
+		t.Errorf("TestUninstallReport failed @ report.String. Expected: %s, Actual: %s", expected, actual)
+	}
+
+	report.Ipsets = []string{"azure-npm-1"}
+	expected = "ipset: azure-npm-1\nnftables table: ip azure-npm"
+	if actual := report.String(); actual != expected {
+		t.Errorf("TestUninstallReport failed @ report.String. Expected: %s, Actual: %s", expected, actual)
+	}
+}
@@ -24,7 +24,13 @@ const (
 	Iptables                  string = "iptables"
 	Ip6tables                 string = "ip6tables"
 	IptablesSave              string = "iptables-save"
+	Ip6tablesSave             string = "ip6tables-save"
 	IptablesRestore           string = "iptables-restore"
+	Ip6tablesRestore          string = "ip6tables-restore"
+	IptablesRestoreNoFlush    string = "--noflush"
+	IptablesRestoreCommit     string = "COMMIT"
+	IptablesSaveCountersFlag  string = "-c"
+	IptablesTableFlag         string = "-t"
 	IptablesConfigFile        string = "/var/log/iptables.conf"
 	IptablesTestConfigFile    string = "/var/log/iptables-test.conf"
 	IptablesLockFile          string = "/run/xtables.lock"
@@ -42,12 +48,15 @@ const (
 	IptablesDrop              string = "DROP"
 	IptablesReturn            string = "RETURN"
 	IptablesMark              string = "MARK"
+	IptablesLog               string = "LOG"
+	IptablesNflog             string = "NFLOG"
 	IptablesSrcFlag           string = "src"
 	IptablesDstFlag           string = "dst"
 	IptablesNotFlag           string = "!"
 	IptablesProtFlag          string = "-p"
 	IptablesSFlag             string = "-s"
 	IptablesDFlag             string = "-d"
+	IptablesSrcPortFlag       string = "--sport"
 	IptablesDstPortFlag       string = "--dport"
 	IptablesModuleFlag        string = "-m"
 	IptablesSetModuleFlag     string = "set"
@@ -58,12 +67,18 @@ const (
 	IptablesStateModuleFlag   string = "state"
 	IptablesStateFlag         string = "--state"
 	IptablesMultiportFlag     string = "multiport"
+	IptablesMultiSrcportFlag  string = "--sports"
 	IptablesMultiDestportFlag string = "--dports"
 	IptablesRelatedState      string = "RELATED"
 	IptablesEstablishedState  string = "ESTABLISHED"
 	IptablesFilterTable       string = "filter"
 	IptablesCommentModuleFlag string = "comment"
 	IptablesCommentFlag       string = "--comment"
+	IptablesLimitModuleFlag   string = "limit"
+	IptablesLimitFlag         string = "--limit"
+	IptablesLogPrefixFlag     string = "--log-prefix"
+	IptablesNflogGroupFlag    string = "--nflog-group"
+	IptablesNflogPrefixFlag   string = "--nflog-prefix"
 	IptablesAddCommentFlag
 	IptablesAzureChain             string = "AZURE-NPM"
 	IptablesAzureAcceptChain       string = "AZURE-NPM-ACCEPT"
@@ -102,6 +117,34 @@ const (
 	IptablesAzureClearMarkHex  string = "0x0"
 )
 
+//nftables related constants.
+const (
+	DataplaneIptables string = "iptables"
+	DataplaneNftables string = "nftables"
+
+	NftFamily       string = "ip"
+	NftTable        string = "azure-npm"
+	NftForwardChain string = "AZURE-NPM-FORWARD"
+	// The forward base chain runs after the iptables filter table, like AZURE-NPM runs after KUBE-SERVICES.
+	NftForwardChainPriority int32 = 1
+	// nftables comments can't be longer than 128 bytes, including the terminating null.
+	NftMaxCommentLength int = 127
+)
+
+//dropped packets logging related constants.
+const (
+	DropLoggingEnv       string = "AZURE_NPM_DROP_LOGGING"
+	DropLoggingNflogMode string = "nflog"
+	DropLoggingLogMode   string = "log"
+	// NFLOG group the nflog reader of NPM subscribes to.
+	DropLoggingNflogGroup uint16 = 100
+	// Logged packets are rate limited to not flood the kernel log and AppInsights.
+	DropLoggingLimit string = "10/second"
+	// LOG prefixes can't be longer than 29 characters.
+	DropLoggingIngressPrefix string = "AZURE-NPM-INGRESS-DROP: "
+	DropLoggingEgressPrefix  string = "AZURE-NPM-EGRESS-DROP: "
+)
+
 //ipset related constants.
 const (
 	Ipset               string = "ipset"
@@ -136,6 +179,11 @@ const (
 
 	IpsetNomatch string = "nomatch"
 
+	// With IPv6 enabled, every ipset has an inet6 twin holding the IPv6 elements.
+	IpsetFamilyFlag  string = "family"
+	IpsetINet6Family string = "inet6"
+	IPv6SetSuffix    string = "-inet6"
+
 	//Prefixes for ipsets
 	NamedPortIPSetPrefix string = "namedport:"
 
@@ -187,4 +235,5 @@ const (
 	PodID
 	NetpolID
 	UtilID
+	NftmID
 )
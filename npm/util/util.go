@@ -5,6 +5,7 @@ package util
 import (
 	"fmt"
 	"hash/fnv"
+	"net"
 	"os"
 	"regexp"
 	"sort"
@@ -20,6 +21,15 @@ import (
 // IsNewNwPolicyVerFlag indicates if the current kubernetes version is newer than 1.11 or not
 var IsNewNwPolicyVerFlag = false
 
+// DataplaneMode is the kernel interface NPM programs network policies with, iptables and ipset or nftables.
+var DataplaneMode = DataplaneIptables
+
+// IPv6Enabled makes NPM program the IPv6 addresses of dual-stack clusters with inet6 ipsets and ip6tables, next to the IPv4 ones.
+var IPv6Enabled = false
+
+// DropLoggingMode is the kind of rules logging the packets dropped by network policies, empty when disabled.
+var DropLoggingMode = ""
+
 // regex to get minor version
 var re = regexp.MustCompile("[0-9]+")
 
@@ -125,6 +135,32 @@ func UniqueStrSlice(s []string) []string {
 	return unique
 }
 
+// CompareStrSlices compares string slices and
+// returns the elements only in new and the elements only in orig.
+func CompareStrSlices(orig []string, new []string) ([]string, []string) {
+	origMap, newMap := map[string]bool{}, map[string]bool{}
+	for _, elem := range orig {
+		origMap[elem] = true
+	}
+	for _, elem := range new {
+		newMap[elem] = true
+	}
+
+	notInOrig, notInNew := []string{}, []string{}
+	for _, elem := range UniqueStrSlice(new) {
+		if !origMap[elem] {
+			notInOrig = append(notInOrig, elem)
+		}
+	}
+	for _, elem := range UniqueStrSlice(orig) {
+		if !newMap[elem] {
+			notInNew = append(notInNew, elem)
+		}
+	}
+
+	return notInOrig, notInNew
+}
+
 // ClearAndAppendMap clears base and appends new to base.
 func ClearAndAppendMap(base, new map[string]string) map[string]string {
 	base = make(map[string]string)
@@ -149,6 +185,22 @@ func GetHashedName(name string) string {
 	return AzureNpmPrefix + Hash(name)
 }
 
+// GetIPv6HashedName returns the name of the inet6 twin of the ipset named hashedName.
+func GetIPv6HashedName(hashedName string) string {
+	return GetHashedName(hashedName + IPv6SetSuffix)
+}
+
+// IsIPv6 checks whether an ip, a cidr or an ipset element like "fd00::/64 nomatch" or "fd00::1,tcp:80" is IPv6.
+func IsIPv6(element string) bool {
+	ip := strings.TrimSpace(strings.TrimSuffix(element, IpsetNomatch))
+	if i := strings.IndexAny(ip, "/,"); i >= 0 {
+		ip = ip[:i]
+	}
+
+	parsedIP := net.ParseIP(ip)
+	return parsedIP != nil && parsedIP.To4() == nil
+}
+
 // CompareK8sVer compares two k8s versions.
 // returns -1, 0, 1 if firstVer smaller, equals, bigger than secondVer respectively.
 // returns -2 for error.
@@ -206,6 +258,38 @@ func SetIsNewNwPolicyVerFlag(ver *version.Info) error {
 	return nil
 }
 
+// SetDataplaneMode selects the kernel interface NPM programs network policies with.
+func SetDataplaneMode(mode string) error {
+	switch mode {
+	case DataplaneIptables, DataplaneNftables:
+		DataplaneMode = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown dataplane %s, expected %s or %s", mode, DataplaneIptables, DataplaneNftables)
+	}
+}
+
+// SetIPv6Mode enables programming IPv6 network policies. Only the iptables dataplane supports it.
+func SetIPv6Mode(enabled bool) error {
+	if enabled && DataplaneMode != DataplaneIptables {
+		return fmt.Errorf("IPv6 is only supported by the %s dataplane", DataplaneIptables)
+	}
+
+	IPv6Enabled = enabled
+	return nil
+}
+
+// SetDropLoggingMode enables logging the packets dropped by network policies with NFLOG or LOG rules.
+func SetDropLoggingMode(mode string) error {
+	switch mode {
+	case "", DropLoggingNflogMode, DropLoggingLogMode:
+		DropLoggingMode = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown drop logging mode %s, expected %s or %s", mode, DropLoggingNflogMode, DropLoggingLogMode)
+	}
+}
+
 // GetOperatorAndLabel returns the operator associated with the label and the label without operator.
 func GetOperatorAndLabel(label string) (string, string) {
 	if len(label) == 0 {
@@ -297,3 +381,81 @@ func ParseResourceVersion(rv string) uint64 {
 func GetObjKeyFunc(obj interface{}) (string, error) {
 	return cache.MetaNamespaceKeyFunc(obj)
 }
+
+// NormalizeRuleSpecs returns a form of rule specs which is the same for the specs NPM programs and
+// the ones iptables-save prints for them. iptables-save loads matches with -m, moves the target after
+// the matches and prints values in canonical form, e.g. "-p TCP --dport 80" becomes "-p tcp -m tcp --dport 80".
+func NormalizeRuleSpecs(specs []string) string {
+	var (
+		options []string
+		option  []string
+	)
+
+	flush := func() {
+		if len(option) > 0 && option[0] != IptablesModuleFlag {
+			options = append(options, strings.Join(option, " "))
+		}
+		option = nil
+	}
+
+	for _, spec := range DropEmptyFields(specs) {
+		if strings.HasPrefix(spec, "-") && (len(option) == 0 || option[len(option)-1] != IptablesNotFlag) || spec == IptablesNotFlag {
+			flush()
+		}
+
+		if len(option) > 0 {
+			switch flag := option[len(option)-1]; flag {
+			case IptablesProtFlag:
+				spec = strings.ToLower(spec)
+			case IptablesSFlag, IptablesDFlag:
+				spec = strings.TrimSuffix(spec, "/32")
+			case IptablesSetMarkFlag, "--set-xmark":
+				option[len(option)-1] = IptablesSetMarkFlag
+				spec = strings.TrimSuffix(spec, "/0xffffffff")
+			case IptablesLimitFlag:
+				spec = strings.Replace(spec, "/second", "/sec", 1)
+				spec = strings.Replace(spec, "/minute", "/min", 1)
+			}
+		}
+
+		option = append(option, spec)
+	}
+	flush()
+
+	sort.Strings(options)
+
+	return strings.Join(options, " ")
+}
+
+// SplitRuleSpecs splits iptables-save specs on spaces, keeping double quoted values like comments with spaces together.
+func SplitRuleSpecs(specs string) []string {
+	var (
+		fields []string
+		field  strings.Builder
+		quoted bool
+	)
+
+	for i := 0; i < len(specs); i++ {
+		c := specs[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(specs):
+			i++
+			field.WriteByte(specs[i])
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"k8s.io/apimachinery/pkg/version"
@@ -229,6 +230,20 @@ func TestDropEmptyFields(t *testing.T) {
 	}
 }
 
+func TestCompareStrSlices(t *testing.T) {
+	orig := []string{"a", "b", "c", "c"}
+	new := []string{"b", "c", "d", "d"}
+
+	notInOrig, notInNew := CompareStrSlices(orig, new)
+	if !reflect.DeepEqual(notInOrig, []string{"d"}) {
+		t.Errorf("TestCompareStrSlices failed @ notInOrig comparison, got %v", notInOrig)
+	}
+
+	if !reflect.DeepEqual(notInNew, []string{"a"}) {
+		t.Errorf("TestCompareStrSlices failed @ notInNew comparison, got %v", notInNew)
+	}
+}
+
 func TestCompareResourceVersions(t *testing.T) {
 	oldRv := "12345"
 	newRV := "23456"
@@ -270,3 +285,51 @@ func TestParseResourceVersion(t *testing.T) {
 		t.Errorf("TestParseResourceVersion failed @ inavlid RV gave no error")
 	}
 }
+
+func TestSplitRuleSpecs(t *testing.T) {
+	specs := `-m set --match-set azure-npm-1 dst -m comment --comment "ALLOW \"ALL\" TO" -j MARK`
+	expected := []string{"-m", "set", "--match-set", "azure-npm-1", "dst", "-m", "comment", "--comment", `ALLOW "ALL" TO`, "-j", "MARK"}
+	if actual := SplitRuleSpecs(specs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("TestSplitRuleSpecs failed @ SplitRuleSpecs. Expected: %q, Actual: %q", expected, actual)
+	}
+}
+
+func TestIsIPv6(t *testing.T) {
+	elements := map[string]bool{
+		"10.0.0.1":                  false,
+		"10.0.0.0/24nomatch":        false,
+		"10.0.0.1,tcp:80":           false,
+		"fd00::1":                   true,
+		"fd00::/64":                 true,
+		"ac00::/8nomatch":           true,
+		"fd00::1,tcp:80":            true,
+		"::ffff:10.0.0.1":           false,
+		"azure-npm-1234567890":      false,
+		"fd00::/64 " + IpsetNomatch: true,
+	}
+
+	for element, expected := range elements {
+		if actual := IsIPv6(element); actual != expected {
+			t.Errorf("TestIsIPv6 failed @ IsIPv6 %s. Expected: %t, Actual: %t", element, expected, actual)
+		}
+	}
+}
+
+func TestSetIPv6Mode(t *testing.T) {
+	defer func(mode string, enabled bool) { DataplaneMode, IPv6Enabled = mode, enabled }(DataplaneMode, IPv6Enabled)
+
+	DataplaneMode = DataplaneNftables
+	if err := SetIPv6Mode(true); err == nil || IPv6Enabled {
+		t.Errorf("TestSetIPv6Mode failed @ SetIPv6Mode, expected an error for the %s dataplane", DataplaneNftables)
+	}
+
+	DataplaneMode = DataplaneIptables
+	if err := SetIPv6Mode(true); err != nil || !IPv6Enabled {
+		t.Errorf("TestSetIPv6Mode failed @ SetIPv6Mode, expected IPv6 to be enabled. Err: %v", err)
+	}
+
+	if GetIPv6HashedName(GetHashedName("app:frontend")) == GetHashedName("app:frontend") ||
+		!strings.HasPrefix(GetIPv6HashedName(GetHashedName("app:frontend")), AzureNpmPrefix) {
+		t.Errorf("TestSetIPv6Mode failed @ GetIPv6HashedName, expected a distinct NPM ipset name")
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultMaxIpsetEntries is the default maxelem of an ipset, what a cidr ipset of a policy is checked against.
+const DefaultMaxIpsetEntries = 65536
+
+// PolicyValidation is what NPM can't enforce of a network policy.
+// Each message starts with the path of the field it is about, e.g. spec.ingress[0].from[1].ipBlock.except[0].
+type PolicyValidation struct {
+	// Errors are fields NPM would enforce differently than the policy says, the policy should be rejected.
+	Errors []string `json:"errors,omitempty"`
+	// Warnings are fields NPM only enforces partly or ignores.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Allowed reports whether NPM can enforce the policy, maybe partly.
+func (validation *PolicyValidation) Allowed() bool {
+	return len(validation.Errors) == 0
+}
+
+func (validation *PolicyValidation) addError(field, format string, args ...interface{}) {
+	validation.Errors = append(validation.Errors, field+": "+fmt.Sprintf(format, args...))
+}
+
+func (validation *PolicyValidation) addWarning(field, format string, args ...interface{}) {
+	validation.Warnings = append(validation.Warnings, field+": "+fmt.Sprintf(format, args...))
+}
+
+// ValidatePolicy checks a network policy against what NPM can enforce, without touching the kernel.
+// The cidr ipsets translatePolicy creates for the policy must not hold more than maxIpsetEntries entries.
+func ValidatePolicy(npObj *networkingv1.NetworkPolicy, maxIpsetEntries int) *PolicyValidation {
+	validation := &PolicyValidation{}
+
+	validateSelector(validation, "spec.podSelector", &npObj.Spec.PodSelector)
+
+	for i, rule := range npObj.Spec.Ingress {
+		for j, peer := range rule.From {
+			validatePeer(validation, fmt.Sprintf("spec.ingress[%d].from[%d]", i, j), peer)
+		}
+	}
+
+	for i, rule := range npObj.Spec.Egress {
+		for j, peer := range rule.To {
+			validatePeer(validation, fmt.Sprintf("spec.egress[%d].to[%d]", i, j), peer)
+		}
+	}
+
+	// the cidr ipsets are only checked once the cidrs are known to be valid, translatePolicy takes them as is.
+	if !validation.Allowed() {
+		return validation
+	}
+
+	_, _, _, ingressIPCidrs, egressIPCidrs, _ := translatePolicy(npObj)
+	for _, ingressOrEgress := range []string{"in", "out"} {
+		ipCidrs, field := ingressIPCidrs, "spec.ingress[%d].from"
+		if ingressOrEgress == "out" {
+			ipCidrs, field = egressIPCidrs, "spec.egress[%d].to"
+		}
+
+		for i, ipCidrSet := range ipCidrs {
+			if len(ipCidrSet) == 0 {
+				continue
+			}
+
+			setName := getCidrIpsetName(npObj.ObjectMeta.Name, npObj.ObjectMeta.Namespace, i, ingressOrEgress)
+			if entries := getCidrIpsetEntries(ipCidrSet); len(entries) > maxIpsetEntries {
+				validation.addError(fmt.Sprintf(field, i), "the ipBlocks need %d entries in ipset %s (%s), more than the limit of %d",
+					len(entries), setName, util.GetHashedName(setName), maxIpsetEntries)
+			}
+		}
+	}
+
+	return validation
+}
+
+// validatePeer checks the selectors and ipBlock of a NetworkPolicyPeer.
+func validatePeer(validation *PolicyValidation, field string, peer networkingv1.NetworkPolicyPeer) {
+	if peer.PodSelector != nil {
+		validateSelector(validation, field+".podSelector", peer.PodSelector)
+	}
+
+	if peer.NamespaceSelector != nil {
+		validateSelector(validation, field+".namespaceSelector", peer.NamespaceSelector)
+	}
+
+	if peer.PodSelector != nil && peer.NamespaceSelector != nil && !util.IsNewNwPolicyVerFlag {
+		validation.addWarning(field, "a peer with both podSelector and namespaceSelector is ignored before Kubernetes 1.11")
+	}
+
+	if peer.IPBlock != nil {
+		validateIPBlock(validation, field+".ipBlock", peer.IPBlock)
+	}
+}
+
+// validateSelector checks a label selector is translated by parseSelector to the ipsets selecting the same pods or namespaces.
+func validateSelector(validation *PolicyValidation, field string, selector *metav1.LabelSelector) {
+	for i, req := range selector.MatchExpressions {
+		reqField := fmt.Sprintf("%s.matchExpressions[%d]", field, i)
+		switch req.Operator {
+		case metav1.LabelSelectorOpIn:
+			// every value is an ipset the pods must all be in, while a label only has one value.
+			if len(req.Values) > 1 {
+				validation.addWarning(reqField+".values", "operator In with %d values only matches labels having all of them, "+
+					"so it matches nothing. Use one value per policy", len(req.Values))
+			}
+		case metav1.LabelSelectorOpNotIn, metav1.LabelSelectorOpExists, metav1.LabelSelectorOpDoesNotExist:
+		default:
+			validation.addError(reqField+".operator", "unsupported operator %s", req.Operator)
+		}
+	}
+
+	// a selector parseSelector translates to no label at all selects everything.
+	if len(selector.MatchLabels) > 0 || len(selector.MatchExpressions) > 0 {
+		selectorCopy := selector.DeepCopy()
+		if labels, _, _ := parseSelector(selectorCopy); len(labels) == 0 {
+			validation.addError(field, "no requirement of the selector is supported, it would select everything")
+		}
+	}
+}
+
+// validateIPBlock checks the cidr and excepts of an ipBlock are valid, and that the excepts are in the cidr.
+func validateIPBlock(validation *PolicyValidation, field string, ipBlock *networkingv1.IPBlock) {
+	_, cidr, err := net.ParseCIDR(ipBlock.CIDR)
+	if err != nil {
+		validation.addError(field+".cidr", "invalid cidr %s", ipBlock.CIDR)
+		return
+	}
+
+	cidrOnes, _ := cidr.Mask.Size()
+	isIPv6 := util.IsIPv6(ipBlock.CIDR)
+	if isIPv6 && !util.IPv6Enabled {
+		validation.addWarning(field+".cidr", "IPv6 cidr %s is ignored, NPM runs without IPv6", ipBlock.CIDR)
+	}
+
+	for i, exceptCidr := range ipBlock.Except {
+		exceptField := field + ".except[" + strconv.Itoa(i) + "]"
+		_, except, err := net.ParseCIDR(exceptCidr)
+		if err != nil {
+			validation.addError(exceptField, "invalid cidr %s", exceptCidr)
+			continue
+		}
+
+		// ipset only holds the except as a nomatch entry of the same family, outside of the cidr it excepts nothing.
+		exceptOnes, _ := except.Mask.Size()
+		if util.IsIPv6(exceptCidr) != isIPv6 || !cidr.Contains(except.IP) || exceptOnes < cidrOnes {
+			validation.addError(exceptField, "%s is outside of cidr %s", exceptCidr, ipBlock.CIDR)
+		}
+	}
+}
+
+// String lists the errors then the warnings, for logs.
+func (validation *PolicyValidation) String() string {
+	var messages []string
+	for _, message := range validation.Errors {
+		messages = append(messages, "error: "+message)
+	}
+
+	for _, message := range validation.Warnings {
+		messages = append(messages, "warning: "+message)
+	}
+
+	return strings.Join(messages, "; ")
+}
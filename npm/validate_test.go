@@ -0,0 +1,164 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidatePolicy(t *testing.T) {
+	defer func(isNew, ipv6 bool) { util.IsNewNwPolicyVerFlag, util.IPv6Enabled = isNew, ipv6 }(util.IsNewNwPolicyVerFlag, util.IPv6Enabled)
+	util.IsNewNwPolicyVerFlag = true
+	util.IPv6Enabled = false
+
+	policy, err := readPolicyYaml("testpolicies/allow-ns-y-pod-b-and-cidr.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if validation := ValidatePolicy(policy, DefaultMaxIpsetEntries); !validation.Allowed() || len(validation.Warnings) > 0 {
+		t.Errorf("TestValidatePolicy failed @ ValidatePolicy, expected no errors nor warnings. Actual: %s", validation)
+	}
+
+	policy = &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unsupported",
+			Namespace: "x",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "pod", Operator: metav1.LabelSelectorOpIn, Values: []string{"a", "b"}},
+				},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchExpressions: []metav1.LabelSelectorRequirement{
+									{Key: "pod", Operator: "Matches", Values: []string{"a"}},
+								},
+							},
+						},
+						{
+							IPBlock: &networkingv1.IPBlock{
+								CIDR:   "10.0.0.0/16",
+								Except: []string{"10.0.1.0/24", "10.1.0.0/24", "10.0.0.0/8"},
+							},
+						},
+					},
+				},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{
+					To: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "fd00::/64"}},
+					},
+				},
+			},
+		},
+	}
+
+	validation := ValidatePolicy(policy, DefaultMaxIpsetEntries)
+	expectedErrors := []string{
+		"spec.ingress[0].from[0].podSelector.matchExpressions[0].operator:",
+		"spec.ingress[0].from[0].podSelector:",
+		"spec.ingress[0].from[1].ipBlock.except[1]:",
+		"spec.ingress[0].from[1].ipBlock.except[2]:",
+	}
+	expectedWarnings := []string{
+		"spec.podSelector.matchExpressions[0].values:",
+		"spec.egress[0].to[0].ipBlock.cidr:",
+	}
+
+	if !hasFieldMessages(validation.Errors, expectedErrors) {
+		t.Errorf("TestValidatePolicy failed @ ValidatePolicy errors. Expected: %v, Actual: %v", expectedErrors, validation.Errors)
+	}
+
+	if !hasFieldMessages(validation.Warnings, expectedWarnings) {
+		t.Errorf("TestValidatePolicy failed @ ValidatePolicy warnings. Expected: %v, Actual: %v", expectedWarnings, validation.Warnings)
+	}
+
+	util.IsNewNwPolicyVerFlag = false
+	policy = &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "combined-selectors",
+			Namespace: "x",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"pod": "a"}},
+							NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"ns": "y"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if validation := ValidatePolicy(policy, DefaultMaxIpsetEntries); !validation.Allowed() ||
+		!hasFieldMessages(validation.Warnings, []string{"spec.ingress[0].from[0]:"}) {
+		t.Errorf("TestValidatePolicy failed @ ValidatePolicy, expected a warning for the combined selectors. Actual: %s", validation)
+	}
+}
+
+func TestValidatePolicyIpsetEntries(t *testing.T) {
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "many-excepts",
+			Namespace: "x",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16"}}}},
+				{To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.1.0.0/16"}}}},
+			},
+		},
+	}
+
+	for i := 0; i < 8; i++ {
+		policy.Spec.Egress[1].To[0].IPBlock.Except = append(policy.Spec.Egress[1].To[0].IPBlock.Except, fmt.Sprintf("10.1.%d.0/24", i))
+	}
+
+	validation := ValidatePolicy(policy, 8)
+	if validation.Allowed() || !hasFieldMessages(validation.Errors, []string{"spec.egress[1].to:"}) {
+		t.Errorf("TestValidatePolicyIpsetEntries failed @ ValidatePolicy, expected the second egress rule to exceed 8 entries. Actual: %s", validation)
+	}
+
+	setName := getCidrIpsetName("many-excepts", "x", 1, "out")
+	if !strings.Contains(validation.Errors[0], util.GetHashedName(setName)) {
+		t.Errorf("TestValidatePolicyIpsetEntries failed @ ValidatePolicy, expected the error to name ipset %s. Actual: %s", setName, validation)
+	}
+}
+
+// hasFieldMessages reports whether there is exactly one message per field prefix.
+func hasFieldMessages(messages, fields []string) bool {
+	if len(messages) != len(fields) {
+		return false
+	}
+
+	for _, field := range fields {
+		found := false
+		for _, message := range messages {
+			if strings.HasPrefix(message, field) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
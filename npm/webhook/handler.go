@@ -0,0 +1,132 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm"
+	"k8s.io/api/admission/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	validatePath = "/validate"
+	healthzPath  = "/healthz"
+)
+
+// admissionResponse is the AdmissionResponse of the vendored admission/v1beta1 API, plus the warnings
+// API servers since Kubernetes 1.19 return to clients. Older API servers ignore them.
+type admissionResponse struct {
+	v1beta1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// admissionReview is the AdmissionReview the webhook answers with.
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Response        *admissionResponse `json:"response,omitempty"`
+}
+
+// policyValidator answers the admission reviews of network policies.
+type policyValidator struct {
+	maxIpsetEntries int
+	// rejectWarnings rejects the policies NPM only enforces partly too.
+	rejectWarnings bool
+}
+
+func newWebhookHandler(validator *policyValidator) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(validatePath, validator.serveValidate)
+	mux.HandleFunc(healthzPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func (validator *policyValidator) serveValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read the request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var review v1beta1.AdmissionReview
+	if err = json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		log.Logf("[webhook] Error: failed to decode admission review, err:%v.", err)
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	response := validator.review(review.Request)
+	response.UID = review.Request.UID
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&admissionReview{TypeMeta: review.TypeMeta, Response: response}); err != nil {
+		log.Logf("[webhook] Error: failed to encode admission response, err:%v.", err)
+	}
+}
+
+// review validates the network policy of an admission request.
+func (validator *policyValidator) review(request *v1beta1.AdmissionRequest) *admissionResponse {
+	if request.Kind.Group != networkingv1.GroupName || request.Kind.Kind != "NetworkPolicy" {
+		return &admissionResponse{AdmissionResponse: v1beta1.AdmissionResponse{Allowed: true}}
+	}
+
+	var npObj networkingv1.NetworkPolicy
+	if err := json.Unmarshal(request.Object.Raw, &npObj); err != nil {
+		return denied(metav1.StatusReasonBadRequest, http.StatusBadRequest, fmt.Sprintf("failed to decode network policy: %v", err))
+	}
+
+	// the namespace is only set by the request on creation.
+	if npObj.ObjectMeta.Namespace == "" {
+		npObj.ObjectMeta.Namespace = request.Namespace
+	}
+
+	validation := npm.ValidatePolicy(&npObj, validator.maxIpsetEntries)
+	if len(validation.Errors) > 0 || len(validation.Warnings) > 0 {
+		log.Logf("[webhook] Network policy %s/%s: %s.", npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name, validation)
+	}
+
+	reasons := append([]string(nil), validation.Errors...)
+	if validator.rejectWarnings {
+		reasons = append(reasons, validation.Warnings...)
+	}
+
+	if len(reasons) > 0 {
+		response := denied(metav1.StatusReasonInvalid, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Azure NPM cannot enforce network policy %s: %s", npObj.ObjectMeta.Name, strings.Join(reasons, "; ")))
+		response.Warnings = validation.Warnings
+		return response
+	}
+
+	return &admissionResponse{
+		AdmissionResponse: v1beta1.AdmissionResponse{Allowed: true},
+		Warnings:          validation.Warnings,
+	}
+}
+
+func denied(reason metav1.StatusReason, code int32, message string) *admissionResponse {
+	return &admissionResponse{
+		AdmissionResponse: v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  reason,
+				Code:    code,
+				Message: message,
+			},
+		},
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm"
+	"k8s.io/api/admission/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func postPolicy(t *testing.T, url string, npObj *networkingv1.NetworkPolicy) *admissionResponse {
+	raw, err := json.Marshal(npObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	review := v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "12345",
+			Kind:      metav1.GroupVersionKind{Group: networkingv1.GroupName, Version: "v1", Kind: "NetworkPolicy"},
+			Namespace: "x",
+			Operation: v1beta1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(url+validatePath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to post admission review: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var answer admissionReview
+	if err = json.NewDecoder(resp.Body).Decode(&answer); err != nil || answer.Response == nil {
+		t.Fatalf("failed to decode admission review: %v", err)
+	}
+
+	if answer.Kind != "AdmissionReview" || answer.Response.UID != "12345" {
+		t.Fatalf("unexpected admission review %+v", answer)
+	}
+
+	return answer.Response
+}
+
+func TestServeValidate(t *testing.T) {
+	validator := &policyValidator{maxIpsetEntries: npm.DefaultMaxIpsetEntries}
+	server := httptest.NewServer(newWebhookHandler(validator))
+	defer server.Close()
+
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-cidr"},
+		Spec: networkingv1.NetworkPolicySpec{
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.1.0/24"}}},
+					},
+				},
+			},
+		},
+	}
+
+	if response := postPolicy(t, server.URL, npObj); !response.Allowed || len(response.Warnings) > 0 {
+		t.Errorf("TestServeValidate failed @ valid policy, expected it to be allowed. Actual: %+v", response)
+	}
+
+	// an In requirement with several values only warns.
+	npObj.Spec.PodSelector = metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "pod", Operator: metav1.LabelSelectorOpIn, Values: []string{"a", "b"}},
+		},
+	}
+	response := postPolicy(t, server.URL, npObj)
+	if !response.Allowed || len(response.Warnings) != 1 || !strings.HasPrefix(response.Warnings[0], "spec.podSelector.matchExpressions[0].values") {
+		t.Errorf("TestServeValidate failed @ partly enforced policy, expected it to be allowed with a warning. Actual: %+v", response)
+	}
+
+	validator.rejectWarnings = true
+	if response := postPolicy(t, server.URL, npObj); response.Allowed || response.Result == nil || response.Result.Code != http.StatusUnprocessableEntity {
+		t.Errorf("TestServeValidate failed @ reject warnings, expected the policy to be rejected. Actual: %+v", response)
+	}
+	validator.rejectWarnings = false
+
+	npObj.Spec.Ingress[0].From[0].IPBlock.Except = []string{"10.1.0.0/24"}
+	response = postPolicy(t, server.URL, npObj)
+	if response.Allowed || response.Result == nil || !strings.Contains(response.Result.Message, "spec.ingress[0].from[0].ipBlock.except[0]") {
+		t.Errorf("TestServeValidate failed @ except outside of cidr, expected the policy to be rejected. Actual: %+v", response)
+	}
+
+	resp, err := http.Get(server.URL + validatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("TestServeValidate failed @ GET, expected %d. Actual: %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
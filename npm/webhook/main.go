@@ -0,0 +1,85 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// azure-npm-webhook is an optional validating admission webhook rejecting the network policies
+// Azure NPM would not enforce as written, and warning about the ones it only enforces partly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	port            = flag.Int("port", 9443, "Port the webhook listens on")
+	tlsCertFile     = flag.String("tls-cert-file", "/etc/webhook/certs/tls.crt", "TLS certificate of the webhook")
+	tlsKeyFile      = flag.String("tls-private-key-file", "/etc/webhook/certs/tls.key", "TLS private key of the webhook")
+	maxIpsetEntries = flag.Int("max-ipset-entries", npm.DefaultMaxIpsetEntries, "Entries the ipBlocks of a rule may need in its ipset")
+	rejectWarnings  = flag.Bool("reject-warnings", false, "Reject the network policies NPM only enforces partly too")
+	enableIPv6      = flag.Bool("ipv6", false, "Validate as NPM enforcing network policies on IPv6 pod addresses and ipBlocks")
+)
+
+func initLogging() error {
+	log.SetName("azure-npm-webhook")
+	log.SetLevel(log.LevelInfo)
+	if err := log.SetTargetLogDirectory(log.TargetStdout, ""); err != nil {
+		log.Logf("Failed to configure logging, err:%v.", err)
+		return err
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	if err := initLogging(); err != nil {
+		panic(err.Error())
+	}
+
+	if err := util.SetIPv6Mode(*enableIPv6); err != nil {
+		log.Logf("Invalid IPv6 mode, err:%v.", err)
+		panic(err.Error())
+	}
+
+	// Peers with both namespace and pod selectors depend on the API server version, like in NPM.
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Logf("clientset creation failed with error %v.", err)
+		panic(err.Error())
+	}
+
+	serverVersion, err := clientset.ServerVersion()
+	if err != nil {
+		log.Logf("Failed to retrieve the kubernetes version, err:%v.", err)
+		panic(err.Error())
+	}
+
+	if err = util.SetIsNewNwPolicyVerFlag(serverVersion); err != nil {
+		log.Logf("Failed to set IsNewNwPolicyVerFlag, err:%v.", err)
+		panic(err.Error())
+	}
+
+	handler := newWebhookHandler(&policyValidator{
+		maxIpsetEntries: *maxIpsetEntries,
+		rejectWarnings:  *rejectWarnings,
+	})
+
+	log.Logf("[webhook] Listening on port %d.", *port)
+	if err = http.ListenAndServeTLS(fmt.Sprintf(":%d", *port), *tlsCertFile, *tlsKeyFile, handler); err != nil {
+		log.Logf("[webhook] Failed to serve, err:%v.", err)
+		panic(err.Error())
+	}
+}
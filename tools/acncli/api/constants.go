@@ -26,6 +26,27 @@ const (
 	FlagFollow      = "follow"
 	FlagLogFilePath = "log-file"
 
+	// NPM Translate Flags
+	FlagFile   = "file"
+	FlagOutput = "output"
+
+	// NPM Simulate Flags
+	FlagSrc          = "src"
+	FlagDst          = "dst"
+	FlagProtocol     = "protocol"
+	FlagPort         = "port"
+	FlagSnapshotFile = "snapshot-file"
+
+	// NPM Get Flags
+	FlagNamespace = "namespace"
+	FlagLimit     = "limit"
+	FlagContinue  = "continue"
+
+	// output flags
+	OutputIptables = "iptables"
+	OutputJSON     = "json"
+	OutputText     = "text"
+
 	// tenancy flags
 	Singletenancy = "singletenancy"
 	Multitenancy  = "multitenancy"
@@ -88,6 +109,8 @@ var (
 		FlagConflistDirectory:        DefaultConflistDirLinux,
 		FlagVersion:                  Packaged,
 		FlagLogFilePath:              DefaultLogFile,
+		FlagOutput:                   OutputIptables,
+		FlagProtocol:                 "TCP",
 		EnvCNILogFile:                EnvCNILogFile,
 		EnvCNISourceDir:              DefaultSrcDirLinux,
 		EnvCNIDestinationBinDir:      DefaultBinDirLinux,
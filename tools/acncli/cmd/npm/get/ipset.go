@@ -0,0 +1,29 @@
+package get
+
+import (
+	npm "github.com/Azure/azure-container-networking/npm/http/client"
+	"github.com/Azure/azure-container-networking/tools/acncli/api"
+	"github.com/spf13/cobra"
+)
+
+// GetIPSetCmd describes an ipset of the local NPM, by its hashed name or its NPM name.
+func GetIPSetCmd(npmClient *npm.NPMHttpClient) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "ipset <name>",
+		Short: "Describe an Azure NPM ipset",
+		Long: "Describe an ipset by its hashed name, e.g. azure-npm-123456, or its NPM name, e.g. app:frontend. " +
+			"It prints the label or selector the set stands for, its members, and the network policies which match packets with it.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ipset, err := npmClient.DescribeIPSet(args[0])
+			if err != nil {
+				return err
+			}
+
+			api.PrettyPrint(ipset)
+			return nil
+		},
+	}
+
+	return cmd
+}
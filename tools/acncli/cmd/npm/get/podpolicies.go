@@ -0,0 +1,37 @@
+package get
+
+import (
+	"fmt"
+	"strings"
+
+	npm "github.com/Azure/azure-container-networking/npm/http/client"
+	"github.com/Azure/azure-container-networking/tools/acncli/api"
+	"github.com/spf13/cobra"
+)
+
+// GetPodPoliciesCmd lists the network policies of the local NPM which apply to a pod.
+func GetPodPoliciesCmd(npmClient *npm.NPMHttpClient) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "pod-policies <namespace>/<pod>",
+		Short: "List the Azure NPM network policies applying to a pod",
+		Long: "List the network policies whose podSelector targets the pod, and the ones whose ingress or egress peers include it, " +
+			"with the ipsets holding the pod and the chains of the rules matching them.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fields := strings.Split(args[0], "/")
+			if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+				return fmt.Errorf("pod must be given as <namespace>/<pod>, got %s", args[0])
+			}
+
+			policies, err := npmClient.GetPodPolicies(fields[0], fields[1])
+			if err != nil {
+				return err
+			}
+
+			api.PrettyPrint(policies)
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,34 @@
+package get
+
+import (
+	npm "github.com/Azure/azure-container-networking/npm/http/client"
+	"github.com/Azure/azure-container-networking/tools/acncli/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// GetSnapshotCmd prints the debug snapshot of the local NPM.
+func GetSnapshotCmd(npmClient *npm.NPMHttpClient) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "snapshot",
+		Aliases: []string{"npmgr"},
+		Short:   "Get the namespaces, pods, network policies and ipsets of Azure NPM",
+		Long: "Get the debug snapshot of Azure NPM. Pods are paginated with --limit, " +
+			"the continue token of a page gets the next one and only holds pods.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshot, err := npmClient.GetDebugSnapshot(viper.GetString(api.FlagNamespace), viper.GetInt(api.FlagLimit), viper.GetString(api.FlagContinue))
+			if err != nil {
+				return err
+			}
+
+			api.PrettyPrint(snapshot)
+			return nil
+		},
+	}
+
+	cmd.Flags().String(api.FlagNamespace, "", "Only get the objects of this namespace")
+	cmd.Flags().Int(api.FlagLimit, 0, "Maximum number of pods to get, 0 for all of them")
+	cmd.Flags().String(api.FlagContinue, "", "Continue token of the previous page")
+
+	return cmd
+}
@@ -8,6 +8,9 @@ import (
 	npm "github.com/Azure/azure-container-networking/npm/http/client"
 	c "github.com/Azure/azure-container-networking/tools/acncli/api"
 	"github.com/Azure/azure-container-networking/tools/acncli/cmd/npm/get"
+	"github.com/Azure/azure-container-networking/tools/acncli/cmd/npm/simulate"
+	"github.com/Azure/azure-container-networking/tools/acncli/cmd/npm/translate"
+	"github.com/Azure/azure-container-networking/tools/acncli/cmd/npm/uninstall"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -27,6 +30,9 @@ func NPMRootCmd() *cobra.Command {
 	npmClient := npm.NewNPMHttpClient(npmEndpoint)
 
 	cmd.AddCommand(GetCmd(npmClient))
+	cmd.AddCommand(translate.TranslateCmd())
+	cmd.AddCommand(simulate.SimulateCmd(npmClient))
+	cmd.AddCommand(uninstall.UninstallCmd())
 	return cmd
 }
 
@@ -36,6 +42,8 @@ func GetCmd(npmClient *npm.NPMHttpClient) *cobra.Command {
 		Short: "Get in-memory maps from Azure NPM",
 	}
 
-	cmd.AddCommand(get.GetManagerCmd(npmClient))
+	cmd.AddCommand(get.GetSnapshotCmd(npmClient))
+	cmd.AddCommand(get.GetIPSetCmd(npmClient))
+	cmd.AddCommand(get.GetPodPoliciesCmd(npmClient))
 	return cmd
 }
@@ -0,0 +1,117 @@
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	npmapi "github.com/Azure/azure-container-networking/npm/http/api"
+	npmclient "github.com/Azure/azure-container-networking/npm/http/client"
+	"github.com/Azure/azure-container-networking/npm/simulator"
+	"github.com/Azure/azure-container-networking/tools/acncli/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// SimulateCmd evaluates whether NPM allows a connection, using the state of a running NPM or a dump of it.
+func SimulateCmd(npmClient *npmclient.NPMHttpClient) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "simulate",
+		Short: "Evaluate whether Azure NPM allows a connection between two pods",
+		Long: "The simulate command runs a packet through the AZURE-NPM iptables chains rebuilt from the NPM state, " +
+			"and reports whether it is allowed along with the network policy rules which decided it. " +
+			fmt.Sprintf("The state is fetched from the local NPM unless --%s points to a dump of its debug snapshot endpoint.", api.FlagSnapshotFile),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, dst := viper.GetString(api.FlagSrc), viper.GetString(api.FlagDst)
+			if src == "" || dst == "" {
+				return fmt.Errorf("--%s and --%s are required", api.FlagSrc, api.FlagDst)
+			}
+
+			port := viper.GetInt(api.FlagPort)
+			if port <= 0 || port > 65535 {
+				return fmt.Errorf("--%s must be a port between 1 and 65535", api.FlagPort)
+			}
+
+			snapshot, err := getSnapshot(npmClient, viper.GetString(api.FlagSnapshotFile))
+			if err != nil {
+				return err
+			}
+
+			s := simulator.NewSimulatorFromSnapshot(snapshot)
+			srcIP, err := s.ResolveIP(src)
+			if err != nil {
+				return err
+			}
+
+			dstIP, err := s.ResolveIP(dst)
+			if err != nil {
+				return err
+			}
+
+			verdict := s.Evaluate(&simulator.Packet{
+				SrcIP:    srcIP,
+				DstIP:    dstIP,
+				Protocol: strings.ToUpper(viper.GetString(api.FlagProtocol)),
+				DstPort:  int32(port),
+			})
+
+			printVerdict(src, dst, verdict)
+			return nil
+		},
+	}
+
+	cmd.Flags().String(api.FlagSrc, "", "Source pod as <namespace>/<name>, or source ip")
+	cmd.Flags().String(api.FlagDst, "", "Destination pod as <namespace>/<name>, or destination ip")
+	cmd.Flags().String(api.FlagProtocol, api.Defaults[api.FlagProtocol], "Protocol of the connection, TCP, UDP or SCTP")
+	cmd.Flags().Int(api.FlagPort, 0, "Destination port of the connection")
+	cmd.Flags().String(api.FlagSnapshotFile, "", "Path of a json dump of the NPM debug snapshot endpoint, with the pods of all pages")
+
+	return cmd
+}
+
+func getSnapshot(npmClient *npmclient.NPMHttpClient, file string) (*npmapi.DebugSnapshot, error) {
+	if file == "" {
+		return npmClient.GetFullDebugSnapshot("")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snapshot npmapi.DebugSnapshot
+	if err := json.NewDecoder(f).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", file, err)
+	}
+
+	if snapshot.Version != npmapi.DebugSnapshotVersion {
+		return nil, fmt.Errorf("unsupported debug snapshot version %s in %s, expected %s", snapshot.Version, file, npmapi.DebugSnapshotVersion)
+	}
+
+	return &snapshot, nil
+}
+
+func printVerdict(src, dst string, verdict *simulator.Verdict) {
+	result := "ALLOWED"
+	if !verdict.Allowed {
+		result = "DENIED"
+	}
+
+	packet := verdict.Packet
+	fmt.Printf("%s: %s (%s) -> %s (%s) %s/%d\n", result, src, packet.SrcIP, dst, packet.DstIP, packet.Protocol, packet.DstPort)
+
+	if len(verdict.Rules) == 0 {
+		fmt.Println("No network policy applies to this connection.")
+	}
+
+	for _, rule := range verdict.Rules {
+		fmt.Printf("  policy %s, chain %s, rule %s\n", rule.Policy, rule.Chain, rule.Rule)
+	}
+
+	fmt.Println("Matched rules:")
+	for _, trace := range verdict.Trace {
+		fmt.Printf("  %s\n", trace)
+	}
+}
@@ -0,0 +1,140 @@
+package translate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/tools/acncli/api"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// TranslateCmd prints the ipsets and iptables rules NPM would program for network policies, without applying them.
+func TranslateCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "translate",
+		Short: "Dry-run the translation of network policies into ipsets and iptables rules",
+		Long: "The translate command reads network policies from a yaml or json file and prints what Azure NPM would program for them. " +
+			"Pods and namespaces in the same file are used to resolve the members of the translated ipsets.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// acncli binds the flags of every command to one viper key each, other commands have an output flag too,
+			// read the flags of this command
+			output, err := cmd.Flags().GetString(api.FlagOutput)
+			if err != nil {
+				return err
+			}
+			if output != api.OutputIptables && output != api.OutputJSON {
+				return fmt.Errorf("unsupported output %s, expected %s or %s", output, api.OutputIptables, api.OutputJSON)
+			}
+
+			file, err := cmd.Flags().GetString(api.FlagFile)
+			if err != nil {
+				return err
+			}
+			if file == "" {
+				return fmt.Errorf("a file with network policies is required, use --%s", api.FlagFile)
+			}
+
+			policies, pods, namespaces, err := readObjects(file)
+			if err != nil {
+				return err
+			}
+
+			if len(policies) == 0 {
+				return fmt.Errorf("no network policies found in %s", file)
+			}
+
+			results := make([]*npm.DryRunResult, len(policies))
+			for i, policy := range policies {
+				results[i] = npm.DryRunPolicy(policy, pods, namespaces)
+			}
+
+			if output == api.OutputJSON {
+				api.PrettyPrint(results)
+				return nil
+			}
+
+			for _, result := range results {
+				fmt.Print(result.IptablesSave())
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP(api.FlagFile, "f", "", "Path of a yaml or json file with network policies, and optionally pods and namespaces. Use - for stdin")
+	cmd.Flags().StringP(api.FlagOutput, "o", api.Defaults[api.FlagOutput], fmt.Sprintf("Output format, %s or %s", api.OutputIptables, api.OutputJSON))
+
+	return cmd
+}
+
+// readObjects decodes the network policies, pods and namespaces of a multi-document yaml or json file.
+// Items of lists are flattened and other kinds are ignored.
+func readObjects(file string) ([]*networkingv1.NetworkPolicy, []*corev1.Pod, []*corev1.Namespace, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var (
+		policies   []*networkingv1.NetworkPolicy
+		pods       []*corev1.Pod
+		namespaces []*corev1.Namespace
+	)
+
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, _, err := decode(doc, nil, nil)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decode %s: %v", file, err)
+		}
+
+		switch o := obj.(type) {
+		case *networkingv1.NetworkPolicy:
+			policies = append(policies, o)
+		case *networkingv1.NetworkPolicyList:
+			for i := range o.Items {
+				policies = append(policies, &o.Items[i])
+			}
+		case *corev1.Pod:
+			pods = append(pods, o)
+		case *corev1.PodList:
+			for i := range o.Items {
+				pods = append(pods, &o.Items[i])
+			}
+		case *corev1.Namespace:
+			namespaces = append(namespaces, o)
+		case *corev1.NamespaceList:
+			for i := range o.Items {
+				namespaces = append(namespaces, &o.Items[i])
+			}
+		}
+	}
+
+	return policies, pods, namespaces, nil
+}
@@ -0,0 +1,47 @@
+package uninstall
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/tools/acncli/api"
+	"github.com/spf13/cobra"
+)
+
+// UninstallCmd removes the iptables chains, ipsets and nftables table of Azure NPM from the node it runs on.
+func UninstallCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the Azure NPM chains, ipsets and nftables table from this node",
+		Long: "The uninstall command removes the AZURE-NPM chains of iptables and ip6tables, the jumps of the FORWARD chains to them, " +
+			"all azure-npm-* ipsets and the azure-npm nftables table, then checks the node is clean and reports anything left behind. " +
+			"Azure NPM must be removed from the node first, or it programs the node again.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// translate has an output flag too, viper binds the key to one of them, read the flag of this command
+			output, err := cmd.Flags().GetString(api.FlagOutput)
+			if err != nil {
+				return err
+			}
+			if output != api.OutputText && output != api.OutputJSON {
+				return fmt.Errorf("unsupported output %s, expected %s or %s", output, api.OutputText, api.OutputJSON)
+			}
+
+			report := npm.Uninstall()
+			if output == api.OutputJSON {
+				api.PrettyPrint(report)
+			} else {
+				fmt.Println(report)
+			}
+
+			if !report.Clean() {
+				return fmt.Errorf("the node still has Azure NPM chains, ipsets or nftables table")
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP(api.FlagOutput, "o", api.OutputText, fmt.Sprintf("Output format, %s or %s", api.OutputText, api.OutputJSON))
+
+	return cmd
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+const testPolicyFile = "../../npm/testpolicies/allow-ns-y-pod-b-and-cidr.yaml"
+
+// uninstall registers an output flag too, translate must keep its own default and value
+func TestTranslateWithUninstallRegistered(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "default output",
+			args: []string{"npm", "translate", "-f", testPolicyFile},
+		},
+		{
+			name: "iptables output",
+			args: []string{"npm", "translate", "-f", testPolicyFile, "-o", "iptables"},
+		},
+		{
+			name: "long file flag",
+			args: []string{"npm", "translate", "--file", testPolicyFile},
+		},
+		{
+			name: "json output",
+			args: []string{"npm", "translate", "-f", testPolicyFile, "-o", "json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootCmd.SetArgs(tt.args)
+			if err := rootCmd.Execute(); err != nil {
+				t.Fatalf("Expected translate to succeed, err: %v", err)
+			}
+		})
+	}
+}